@@ -1,10 +1,12 @@
 package wsApi
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Bitspark/go-bitnode/bitnode"
+	"github.com/Bitspark/go-bitnode/util"
 	"log"
 	"sync"
 	"time"
@@ -27,8 +29,22 @@ type Client struct {
 	incomingIDs map[string]bool
 	incomingMux sync.Mutex
 
+	// lastSeqs contains the sequence number of the last value received per hub from the server.
+	lastSeqs map[string]uint64
+
+	// replay contains the sequence numbers the client has last received per hub, as sent during connection.
+	replay map[string]uint64
+
 	handleMux sync.Mutex
 
+	// invocations contains cancel functions of running invocations by their ID.
+	invocations    map[string]context.CancelFunc
+	invocationsMux sync.Mutex
+
+	// streams contains open streams by their ID.
+	streams    map[string]*wsStream
+	streamsMux sync.Mutex
+
 	creds       bitnode.Credentials
 	middlewares bitnode.Middlewares
 
@@ -65,24 +81,24 @@ func (cl *Client) RemoteID() bitnode.SystemID {
 	return cl.remoteID
 }
 
-func (cl *Client) Stop(timeout float64) {
-	cl.NativeSystem.Stop(cl.creds, timeout)
+func (cl *Client) Stop(timeout float64) error {
+	return cl.NativeSystem.Stop(cl.creds, timeout)
 }
 
-func (cl *Client) Start() {
-	cl.NativeSystem.Start(cl.creds)
+func (cl *Client) Start() error {
+	return cl.NativeSystem.Start(cl.creds)
 }
 
-func (cl *Client) Delete() {
-	cl.NativeSystem.Delete(cl.creds)
+func (cl *Client) Delete() error {
+	return cl.NativeSystem.Delete(cl.creds)
 }
 
-func (cl *Client) SetName(name string) {
-	cl.NativeSystem.SetName(cl.creds, name)
+func (cl *Client) SetName(name string) error {
+	return cl.NativeSystem.SetName(cl.creds, name)
 }
 
-func (cl *Client) SetStatus(status int) {
-	cl.NativeSystem.SetStatus(cl.creds, status)
+func (cl *Client) SetStatus(status int) error {
+	return cl.NativeSystem.SetStatus(cl.creds, status)
 }
 
 func (cl *Client) Hubs() []bitnode.Hub {
@@ -112,6 +128,14 @@ func (cl *Client) GetHub(name string) bitnode.Hub {
 	return cl.NativeSystem.GetHub(cl.creds, cl.middlewares, name)
 }
 
+// authorizeRemote checks whether the remote end may perform an action on the served system.
+func (cl *Client) authorizeRemote(permission string, action string) error {
+	if !cl.server || cl.NativeSystem == nil {
+		return nil
+	}
+	return cl.NativeSystem.Authorize(permission, cl.creds, action)
+}
+
 func (cl *Client) Disconnect() error {
 	panic("implement me")
 }
@@ -151,7 +175,7 @@ func (cl *Client) send(cmd string, m SystemMessage, reference string, returns bo
 	}
 	log.Printf("client %s -> %s {%v}", cl.cid, cmd, m)
 	chSent := make(chan bool)
-	chRef := &ClientRefChan{cmd: cmd, ch: make(chan any)}
+	chRef := &ClientRefChan{cmd: cmd, ch: make(chan any, 1)}
 	go func(c *Client, nconn *Conn, chSent chan bool, ch *ClientRefChan, reference string, returns bool) {
 		defer close(ch.ch)
 		ref := nconn.Send("client", &NodePayloadClient{
@@ -275,7 +299,7 @@ func (cl *Client) attachClientHub(hub bitnode.Hub) error {
 	}
 	switch interf.Type {
 	case bitnode.HubTypePipe:
-		_ = hub.Handle(bitnode.NewNativeFunction(func(creds bitnode.Credentials, vals ...bitnode.HubItem) ([]bitnode.HubItem, error) {
+		_ = hub.Handle(bitnode.NewNativeContextFunction(func(ctx context.Context, creds bitnode.Credentials, vals ...bitnode.HubItem) ([]bitnode.HubItem, error) {
 			if !cl.Active() {
 				return nil, fmt.Errorf("client inactive: %s %v", cl.cid, cl.conn)
 			}
@@ -284,11 +308,19 @@ func (cl *Client) attachClientHub(hub bitnode.Hub) error {
 				cl.LogError(err)
 				return nil, err
 			}
-			invoke := cl.send("invoke", &SystemMessageInvoke{
+			msg := &SystemMessageInvoke{
 				Hub:   hub.Name(),
 				Value: wrappedVals,
-			}, "", true)
-			if wrappedRets, err := invoke.await(); err != nil {
+				ID:    util.RandomString(util.CharsAlphaNum, 8),
+			}
+			if deadline, ok := ctx.Deadline(); ok {
+				msg.Deadline = float64(deadline.UnixMicro()) / 1000000
+			}
+			invoke := cl.send("invoke", msg, "", true)
+			if wrappedRets, err := invoke.awaitContext(ctx); err != nil {
+				if ctx.Err() != nil {
+					cl.send("cancel", &SystemMessageCancel{ID: msg.ID}, "", false)
+				}
 				cl.LogError(err)
 				return nil, err
 			} else {
@@ -302,6 +334,41 @@ func (cl *Client) attachClientHub(hub bitnode.Hub) error {
 			}
 		}))
 
+	case bitnode.HubTypeStream:
+		_ = hub.HandleStream(bitnode.NewNativeStreamFunction(func(ctx context.Context, creds bitnode.Credentials, stream bitnode.Stream, vals ...bitnode.HubItem) error {
+			if !cl.Active() {
+				return fmt.Errorf("client inactive: %s %v", cl.cid, cl.conn)
+			}
+			wrappedVals, err := cl.wrapValues(interf.Input, vals...)
+			if err != nil {
+				cl.LogError(err)
+				return err
+			}
+			id := util.RandomString(util.CharsAlphaNum, 8)
+			ws := cl.openStream(ctx, id, bitnode.StreamWindow)
+			defer cl.closeStream(id)
+			open := cl.send("stream_open", &SystemMessageStreamOpen{
+				Hub:    hub.Name(),
+				ID:     id,
+				Value:  wrappedVals,
+				Window: bitnode.StreamWindow,
+			}, "", true)
+			if _, err := open.awaitContext(ctx); err != nil {
+				cl.LogError(err)
+				return err
+			}
+			if interf.Upstream != nil {
+				go func() {
+					_ = ws.pipeFrom(stream, *interf.Upstream)
+				}()
+			}
+			if err := ws.pipeTo(stream, *interf.Value); err != nil {
+				cl.send("cancel", &SystemMessageCancel{ID: id}, "", false)
+				return err
+			}
+			return nil
+		}))
+
 	case bitnode.HubTypeValue:
 		_, _ = hub.Subscribe(bitnode.NewNativeSubscription(func(id string, creds bitnode.Credentials, val bitnode.HubItem) {
 			if !cl.Active() {
@@ -355,7 +422,11 @@ func (cl *Client) attachServerHub(hub bitnode.Hub) error {
 	}
 	switch interf.Type {
 	case bitnode.HubTypeChannel:
-		_, _ = hub.Subscribe(bitnode.NewNativeSubscription(func(id string, creds bitnode.Credentials, val bitnode.HubItem) {
+		var from bitnode.ReplayFrom
+		if seq, ok := cl.replay[hub.Name()]; ok {
+			from.Seq = seq + 1
+		}
+		_, err := hub.SubscribeFrom(bitnode.NewNativeSeqSubscription(func(seq uint64, id string, creds bitnode.Credentials, val bitnode.HubItem) {
 			if !cl.Active() {
 				return
 			}
@@ -367,12 +438,16 @@ func (cl *Client) attachServerHub(hub bitnode.Hub) error {
 			cl.send("push", &SystemMessagePush{
 				Hub:   hub.Name(),
 				ID:    id,
+				Seq:   seq,
 				Value: wrappedVals,
 			}, "", false)
-		}))
+		}), from)
+		if err != nil {
+			cl.LogError(err)
+		}
 
 	case bitnode.HubTypeValue:
-		_, _ = hub.Subscribe(bitnode.NewNativeSubscription(func(id string, creds bitnode.Credentials, val bitnode.HubItem) {
+		_, err := hub.Subscribe(bitnode.NewNativeSubscription(func(id string, creds bitnode.Credentials, val bitnode.HubItem) {
 			if !cl.Active() {
 				return
 			}
@@ -393,6 +468,9 @@ func (cl *Client) attachServerHub(hub bitnode.Hub) error {
 				Value: wrappedVal,
 			}, "", false)
 		}))
+		if err != nil {
+			cl.LogError(err)
+		}
 	}
 	return nil
 }
@@ -406,7 +484,14 @@ func (cl *Client) wrapValues(interf bitnode.HubItemsInterface, unwrappedVals ...
 	wrappers.PushBack(&typeWrapper{c: cl})
 	wrappers.PushBack(&credsWrapper{c: cl})
 	wrappers.PushBack(&idWrapper{c: cl})
-	return interf.ApplyMiddlewares(*wrappers, true, unwrappedVals...)
+	vals, err := interf.ApplyMiddlewares(*wrappers, true, unwrappedVals...)
+	if err != nil {
+		return nil, err
+	}
+	for i, val := range vals {
+		vals[i] = bitnode.EncodeDurations(val)
+	}
+	return vals, nil
 }
 
 // wrapValue transforms a native value into values that can be transferred via websocket.
@@ -418,7 +503,11 @@ func (cl *Client) wrapValue(interf bitnode.HubItemInterface, unwrappedVal bitnod
 	wrappers.PushBack(&typeWrapper{c: cl})
 	wrappers.PushBack(&credsWrapper{c: cl})
 	wrappers.PushBack(&idWrapper{c: cl})
-	return interf.ApplyMiddlewares(*wrappers, unwrappedVal, true)
+	val, err := interf.ApplyMiddlewares(*wrappers, unwrappedVal, true)
+	if err != nil {
+		return nil, err
+	}
+	return bitnode.EncodeDurations(val), nil
 }
 
 // unwrapValues transforms websocket values into native values.
@@ -477,9 +566,16 @@ func (cl *Client) connect() error {
 	if cl.server {
 		panic("server clients cannot connect to a server")
 	}
+	replay := map[string]uint64{}
+	cl.incomingMux.Lock()
+	for hub, seq := range cl.lastSeqs {
+		replay[hub] = seq
+	}
+	cl.incomingMux.Unlock()
 	ret := cl.send("conn", &SystemMessageConn{
 		ID:          cl.remoteID.Hex(),
 		Credentials: cl.creds,
+		Replay:      replay,
 	}, "", true)
 	if _, err := ret.await(); err != nil {
 		return err
@@ -500,6 +596,19 @@ func (ch ClientRefChan) await() (any, error) {
 	return ret, nil
 }
 
+// awaitContext waits for the response like await, but gives up as soon as ctx is done.
+func (ch ClientRefChan) awaitContext(ctx context.Context) (any, error) {
+	select {
+	case ret := <-ch.ch:
+		if err, ok := ret.(error); ok {
+			return nil, err
+		}
+		return ret, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func (ch ClientRefChan) close() {
 	close(ch.ch)
 }
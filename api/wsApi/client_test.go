@@ -1 +1,543 @@
 package wsApi
+
+import (
+	"context"
+	"github.com/Bitspark/go-bitnode/bitnode"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_Stream1(t *testing.T) {
+	node1 := bitnode.NewNode()
+	node2 := bitnode.NewNode()
+
+	conns1 := NewWSFactory(node1, "ws://127.0.0.1:12350")
+	conns2 := NewWSFactory(node2, "")
+
+	server1 := NewServer(conns1, "0.0.0.0:12350")
+	defer server1.Shutdown(context.Background())
+	go server1.Listen()
+
+	time.Sleep(200 * time.Millisecond)
+
+	intType := &bitnode.Type{RawType: bitnode.RawType{Leaf: bitnode.LeafInteger}}
+	spk := bitnode.Sparkable{RawSparkable: bitnode.RawSparkable{
+		Name: "Counter",
+		Interface: &bitnode.Interface{RawInterface: bitnode.RawInterface{
+			Hubs: &bitnode.HubInterfaces{
+				{
+					Name:      "count",
+					Type:      bitnode.HubTypeStream,
+					Direction: bitnode.HubDirectionIn,
+					Input:     bitnode.HubItemsInterface{{Value: intType}},
+					Value:     &bitnode.HubItemInterface{Value: intType},
+				},
+			},
+		}},
+	}}
+	if err := spk.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	sys, err := node1.PrepareSystem(bitnode.Credentials{}, spk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sys.GetHub("count").HandleStream(bitnode.NewNativeStreamFunction(func(ctx context.Context, creds bitnode.Credentials, stream bitnode.Stream, vals ...bitnode.HubItem) error {
+		for i := int64(0); i < vals[0].(int64); i++ {
+			if err := stream.Send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	conn2, err := conns2.ConnectNode("ws://127.0.0.1:12350")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Admin credentials cannot be verified without a keyring.
+	admin, _ := conn2.AddClient()
+	if err := admin.Connect(sys.ID(), bitnode.Credentials{Admin: true}); err == nil {
+		t.Fatal("must reject unverified admin credentials")
+	}
+
+	cl, err := conn2.AddClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Connect(sys.ID(), bitnode.Credentials{}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	hub := cl.GetHub("count")
+	if hub == nil {
+		t.Fatal("hub not found")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := hub.Stream(ctx, nil, 3*bitnode.StreamWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 3*bitnode.StreamWindow; i++ {
+		if val, err := stream.Receive(); err != nil || val != i {
+			t.Fatal(i, val, err)
+		}
+	}
+	if val, err := stream.Receive(); err != io.EOF {
+		t.Fatal(val, err)
+	}
+}
+
+func TestClient_Credentials1(t *testing.T) {
+	node1 := bitnode.NewNode()
+	node2 := bitnode.NewNode()
+
+	keyring := bitnode.NewKeyring("test")
+	_ = keyring.AddKey("k1", bitnode.NewHMACKey([]byte("secret")))
+
+	conns1 := NewWSFactory(node1, "ws://127.0.0.1:12351")
+	conns1.SetKeyring(keyring)
+	conns2 := NewWSFactory(node2, "")
+
+	server1 := NewServer(conns1, "0.0.0.0:12351")
+	defer server1.Shutdown(context.Background())
+	go server1.Listen()
+
+	time.Sleep(200 * time.Millisecond)
+
+	sys, err := node1.PrepareSystem(bitnode.Credentials{}, bitnode.Sparkable{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn2, err := conns2.ConnectNode("ws://127.0.0.1:12351")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cl1, _ := conn2.AddClient()
+	if err := cl1.Connect(sys.ID(), bitnode.Credentials{}); err == nil {
+		t.Fatal("must reject unsigned credentials")
+	}
+
+	expired := bitnode.Credentials{Authority: "test", Expires: time.Now().Add(-time.Hour).Unix()}
+	_ = keyring.Sign(&expired)
+	cl2, _ := conn2.AddClient()
+	if err := cl2.Connect(sys.ID(), expired); err == nil {
+		t.Fatal("must reject expired credentials")
+	}
+
+	creds, _ := keyring.Issue(bitnode.Credentials{})
+	cl3, _ := conn2.AddClient()
+	if err := cl3.Connect(sys.ID(), creds); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Dictionary1(t *testing.T) {
+	node1 := bitnode.NewNode()
+	node2 := bitnode.NewNode()
+
+	conns1 := NewWSFactory(node1, "ws://127.0.0.1:12352")
+	conns2 := NewWSFactory(node2, "")
+
+	server1 := NewServer(conns1, "0.0.0.0:12352")
+	defer server1.Shutdown(context.Background())
+	go server1.Listen()
+
+	time.Sleep(200 * time.Millisecond)
+
+	dictType := &bitnode.Type{RawType: bitnode.RawType{DictOf: &bitnode.RawType{Leaf: bitnode.LeafInteger}}}
+	spk := bitnode.Sparkable{RawSparkable: bitnode.RawSparkable{
+		Name: "Counters",
+		Interface: &bitnode.Interface{RawInterface: bitnode.RawInterface{
+			Hubs: &bitnode.HubInterfaces{
+				{
+					Name:      "double",
+					Type:      bitnode.HubTypePipe,
+					Direction: bitnode.HubDirectionIn,
+					Input:     bitnode.HubItemsInterface{{Value: dictType}},
+					Output:    bitnode.HubItemsInterface{{Value: dictType}},
+				},
+			},
+		}},
+	}}
+	if err := spk.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	sys, err := node1.PrepareSystem(bitnode.Credentials{}, spk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sys.GetHub("double").Handle(bitnode.NewNativeFunction(func(creds bitnode.Credentials, vals ...bitnode.HubItem) ([]bitnode.HubItem, error) {
+		doubled := map[string]bitnode.HubItem{}
+		for k, v := range vals[0].(map[string]bitnode.HubItem) {
+			doubled[k] = 2 * v.(int64)
+		}
+		return []bitnode.HubItem{doubled}, nil
+	}))
+
+	conn2, err := conns2.ConnectNode("ws://127.0.0.1:12352")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := conn2.AddClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Connect(sys.ID(), bitnode.Credentials{}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	rets, err := cl.GetHub("double").Invoke(nil, map[string]bitnode.HubItem{"user-1": int64(1), "user 2": int64(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret := rets[0].(map[string]bitnode.HubItem); len(ret) != 2 || ret["user-1"] != int64(2) || ret["user 2"] != int64(4) {
+		t.Fatal(rets)
+	}
+}
+
+func TestClient_Leaves1(t *testing.T) {
+	node1 := bitnode.NewNode()
+	node2 := bitnode.NewNode()
+
+	conns1 := NewWSFactory(node1, "ws://127.0.0.1:12353")
+	conns2 := NewWSFactory(node2, "")
+
+	server1 := NewServer(conns1, "0.0.0.0:12353")
+	defer server1.Shutdown(context.Background())
+	go server1.Listen()
+
+	time.Sleep(200 * time.Millisecond)
+
+	timeType := &bitnode.Type{RawType: bitnode.RawType{Leaf: bitnode.LeafTime}}
+	spk := bitnode.Sparkable{RawSparkable: bitnode.RawSparkable{
+		Name: "Clock",
+		Interface: &bitnode.Interface{RawInterface: bitnode.RawInterface{
+			Hubs: &bitnode.HubInterfaces{
+				{
+					Name:      "add",
+					Type:      bitnode.HubTypePipe,
+					Direction: bitnode.HubDirectionIn,
+					Input: bitnode.HubItemsInterface{
+						{Value: timeType},
+						{Value: &bitnode.Type{RawType: bitnode.RawType{Leaf: bitnode.LeafDuration}}},
+						{Value: &bitnode.Type{RawType: bitnode.RawType{Leaf: bitnode.LeafID}}},
+					},
+					Output: bitnode.HubItemsInterface{
+						{Value: timeType},
+						{Value: &bitnode.Type{RawType: bitnode.RawType{Leaf: bitnode.LeafSystemID}}},
+					},
+				},
+			},
+		}},
+	}}
+	if err := spk.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	sys, err := node1.PrepareSystem(bitnode.Credentials{}, spk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sys.GetHub("add").Handle(bitnode.NewNativeFunction(func(creds bitnode.Credentials, vals ...bitnode.HubItem) ([]bitnode.HubItem, error) {
+		return []bitnode.HubItem{vals[0].(time.Time).Add(vals[1].(time.Duration)), vals[2].(bitnode.ID).System()}, nil
+	}))
+
+	conn2, err := conns2.ConnectNode("ws://127.0.0.1:12353")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := conn2.AddClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Connect(sys.ID(), bitnode.Credentials{}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	id := bitnode.ComposeIDs(bitnode.GenerateSystemID(), bitnode.GenerateObjectID())
+	rets, err := cl.GetHub("add").Invoke(nil, start, 90*time.Minute, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end, ok := rets[0].(time.Time); !ok || !end.Equal(start.Add(90*time.Minute)) {
+		t.Fatal(rets)
+	}
+	if rets[1] != id.System() {
+		t.Fatal(rets)
+	}
+}
+
+func TestClient_Replay1(t *testing.T) {
+	node1 := bitnode.NewNode()
+	node2 := bitnode.NewNode()
+
+	conns1 := NewWSFactory(node1, "ws://127.0.0.1:12354")
+	conns2 := NewWSFactory(node2, "")
+
+	server1 := NewServer(conns1, "0.0.0.0:12354")
+	go server1.Listen()
+
+	time.Sleep(200 * time.Millisecond)
+
+	spk := bitnode.Sparkable{RawSparkable: bitnode.RawSparkable{
+		Name: "Events",
+		Interface: &bitnode.Interface{RawInterface: bitnode.RawInterface{
+			Hubs: &bitnode.HubInterfaces{
+				{
+					Name:      "events",
+					Type:      bitnode.HubTypeChannel,
+					Direction: bitnode.HubDirectionOut,
+					Value:     &bitnode.HubItemInterface{Value: &bitnode.Type{RawType: bitnode.RawType{Leaf: bitnode.LeafInteger}}},
+					History:   &bitnode.HubHistory{Size: 10},
+				},
+			},
+		}},
+	}}
+	if err := spk.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	sys, err := node1.PrepareSystem(bitnode.Credentials{}, spk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn2, err := conns2.ConnectNode("ws://127.0.0.1:12354")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := conn2.AddClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Connect(sys.ID(), bitnode.Credentials{}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	received := make(chan bitnode.HubItem, 10)
+	if _, err := cl.GetHub("events").Subscribe(bitnode.NewNativeSubscription(func(id string, creds bitnode.Credentials, val bitnode.HubItem) {
+		received <- val
+	})); err != nil {
+		t.Fatal(err)
+	}
+	// Messages are handled concurrently, so that values may arrive in any order.
+	expect := func(vals ...int64) {
+		missing := map[bitnode.HubItem]bool{}
+		for _, val := range vals {
+			missing[val] = true
+		}
+		for len(missing) > 0 {
+			select {
+			case v := <-received:
+				if !missing[v] {
+					t.Fatal("unexpected", v)
+				}
+				delete(missing, v)
+			case <-time.After(5 * time.Second):
+				t.Fatal("missing", missing)
+			}
+		}
+	}
+
+	_ = sys.GetHub("events").Emit("", int64(1))
+	expect(1)
+
+	// Values emitted while the client is disconnected are replayed when it has reconnected.
+	_ = server1.Shutdown(context.Background())
+	time.Sleep(100 * time.Millisecond)
+	_ = sys.GetHub("events").Emit("", int64(2))
+	_ = sys.GetHub("events").Emit("", int64(3))
+
+	server2 := NewServer(conns1, "0.0.0.0:12354")
+	defer server2.Shutdown(context.Background())
+	go server2.Listen()
+
+	expect(2, 3)
+	select {
+	case v := <-received:
+		t.Fatal("replayed twice", v)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestClient_Impersonation1(t *testing.T) {
+	node1 := bitnode.NewNode()
+	node2 := bitnode.NewNode()
+
+	keyring := bitnode.NewKeyring("test")
+	_ = keyring.AddKey("k1", bitnode.NewHMACKey([]byte("secret")))
+
+	conns1 := NewWSFactory(node1, "ws://127.0.0.1:12355")
+	conns1.SetKeyring(keyring)
+	conns2 := NewWSFactory(node2, "")
+
+	server1 := NewServer(conns1, "0.0.0.0:12355")
+	defer server1.Shutdown(context.Background())
+	go server1.Listen()
+
+	time.Sleep(200 * time.Millisecond)
+
+	intType := &bitnode.Type{RawType: bitnode.RawType{Leaf: bitnode.LeafInteger}}
+	spk := bitnode.Sparkable{RawSparkable: bitnode.RawSparkable{
+		Name:        "Counter",
+		Permissions: &bitnode.Permissions{View: bitnode.PermissionGroup{Public: true}},
+		Interface: &bitnode.Interface{RawInterface: bitnode.RawInterface{
+			Hubs: &bitnode.HubInterfaces{
+				{
+					Name:      "increment",
+					Type:      bitnode.HubTypePipe,
+					Direction: bitnode.HubDirectionIn,
+					Input:     bitnode.HubItemsInterface{{Value: intType}},
+					Output:    bitnode.HubItemsInterface{{Value: intType}},
+				},
+			},
+		}},
+	}}
+	if err := spk.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	owner := bitnode.Credentials{User: bitnode.User{ID: bitnode.ComposeIDs(bitnode.GenerateSystemID(), bitnode.GenerateObjectID()), Name: "owner"}}
+	sys, err := node1.PrepareSystem(owner, spk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sys.GetHub("increment").Handle(bitnode.NewNativeFunction(func(creds bitnode.Credentials, vals ...bitnode.HubItem) ([]bitnode.HubItem, error) {
+		return []bitnode.HubItem{vals[0].(int64) + 1}, nil
+	}))
+
+	conn2, err := conns2.ConnectNode("ws://127.0.0.1:12355")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger, _ := keyring.Issue(bitnode.Credentials{User: bitnode.User{ID: bitnode.ComposeIDs(bitnode.GenerateSystemID(), bitnode.GenerateObjectID()), Name: "stranger"}})
+	cl, err := conn2.AddClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Connect(sys.ID(), stranger); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := cl.GetHub("increment").Invoke(nil, int64(1)); err == nil {
+		t.Fatal("stranger must not invoke")
+	}
+
+	// The user sent along with an invocation must not replace the user of the connection.
+	ret := cl.send("invoke", &SystemMessageInvoke{
+		Hub:   "increment",
+		Value: []bitnode.HubItem{int64(1)},
+		User:  &owner.User,
+	}, "", true)
+	if _, err := ret.await(); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatal(err)
+	}
+	ret = cl.send("stream_open", &SystemMessageStreamOpen{
+		Hub:   "increment",
+		ID:    "s1",
+		Value: []bitnode.HubItem{int64(1)},
+		User:  &owner.User,
+	}, "", true)
+	if _, err := ret.await(); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Expiry1(t *testing.T) {
+	node1 := bitnode.NewNode()
+	node2 := bitnode.NewNode()
+
+	keyring := bitnode.NewKeyring("test")
+	// Validity times have a resolution of seconds, so credentials are valid for at least one second.
+	keyring.TTL = 2 * time.Second
+	_ = keyring.AddKey("k1", bitnode.NewHMACKey([]byte("secret")))
+
+	conns1 := NewWSFactory(node1, "ws://127.0.0.1:12356")
+	conns1.SetKeyring(keyring)
+	conns2 := NewWSFactory(node2, "")
+
+	server1 := NewServer(conns1, "0.0.0.0:12356")
+	defer server1.Shutdown(context.Background())
+	go server1.Listen()
+
+	time.Sleep(200 * time.Millisecond)
+
+	intType := &bitnode.Type{RawType: bitnode.RawType{Leaf: bitnode.LeafInteger}}
+	spk := bitnode.Sparkable{RawSparkable: bitnode.RawSparkable{
+		Name: "Counter",
+		Permissions: &bitnode.Permissions{
+			View:   bitnode.PermissionGroup{Public: true},
+			Extend: bitnode.PermissionGroup{Public: true},
+		},
+		Interface: &bitnode.Interface{RawInterface: bitnode.RawInterface{
+			Hubs: &bitnode.HubInterfaces{
+				{
+					Name:      "increment",
+					Type:      bitnode.HubTypePipe,
+					Direction: bitnode.HubDirectionIn,
+					Input:     bitnode.HubItemsInterface{{Value: intType}},
+					Output:    bitnode.HubItemsInterface{{Value: intType}},
+				},
+			},
+		}},
+	}}
+	if err := spk.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	sys, err := node1.PrepareSystem(bitnode.Credentials{}, spk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sys.GetHub("increment").Handle(bitnode.NewNativeFunction(func(creds bitnode.Credentials, vals ...bitnode.HubItem) ([]bitnode.HubItem, error) {
+		return []bitnode.HubItem{vals[0].(int64) + 1}, nil
+	}))
+
+	conn2, err := conns2.ConnectNode("ws://127.0.0.1:12356")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, _ := keyring.Issue(bitnode.Credentials{})
+	cl, err := conn2.AddClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Connect(sys.ID(), creds); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if rets, err := cl.GetHub("increment").Invoke(nil, int64(1)); err != nil || rets[0] != int64(2) {
+		t.Fatal(rets, err)
+	}
+
+	// Credentials are checked again on each invocation, so connections cannot outlive them.
+	time.Sleep(2100 * time.Millisecond)
+
+	if _, err := cl.GetHub("increment").Invoke(nil, int64(1)); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatal(err)
+	}
+	ret := cl.send("stream_open", &SystemMessageStreamOpen{
+		Hub:   "increment",
+		ID:    "s1",
+		Value: []bitnode.HubItem{int64(1)},
+	}, "", true)
+	if _, err := ret.await(); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatal(err)
+	}
+}
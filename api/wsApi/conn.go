@@ -1,6 +1,7 @@
 package wsApi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/Bitspark/go-bitnode/bitnode"
@@ -102,6 +103,9 @@ func (c *Conn) AddClient() (*Client, error) {
 		conn:         c,
 		server:       false,
 		incomingIDs:  map[string]bool{},
+		lastSeqs:     map[string]uint64{},
+		invocations:  map[string]context.CancelFunc{},
+		streams:      map[string]*wsStream{},
 		middlewares:  c.factory.node.Middlewares(),
 	}
 	cl.SetExtension("ws", &WSExt{Client: cl})
@@ -555,6 +559,14 @@ func (pc *NodePayloadClient) UnmarshalJSON(data []byte) error {
 		pc.Payload = &SystemMessageInit{}
 	case "invoke":
 		pc.Payload = &SystemMessageInvoke{}
+	case "cancel":
+		pc.Payload = &SystemMessageCancel{}
+	case "stream_open":
+		pc.Payload = &SystemMessageStreamOpen{}
+	case "stream":
+		pc.Payload = &SystemMessageStreamFrame{}
+	case "stream_credit":
+		pc.Payload = &SystemMessageStreamCredit{}
 	case "return":
 		pc.Payload = &SystemMessageReturn{}
 	case "push":
@@ -591,11 +603,16 @@ func (pc *NodePayloadClient) Handle(nconn *Conn, reference string) error {
 		return fmt.Errorf("client not found in %s: %s", nconn.factory.address, pc.Client)
 	} else {
 		nconn.clientsMux.Unlock()
-		client.handleMux.Lock()
-		defer client.handleMux.Unlock()
 		if pc.Payload == nil {
 			return nil
 		}
+		switch pc.Payload.(type) {
+		case *SystemMessageCancel, *SystemMessageStreamFrame, *SystemMessageStreamCredit:
+			// Cancellations and stream control must not wait for the invocation they refer to.
+			return pc.Payload.HandleClient(client, reference)
+		}
+		client.handleMux.Lock()
+		defer client.handleMux.Unlock()
 		return pc.Payload.HandleClient(client, reference)
 	}
 }
@@ -1,6 +1,7 @@
 package wsApi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/Bitspark/go-bitnode/bitnode"
@@ -24,6 +25,10 @@ type WSFactory struct {
 	queueMux      sync.Mutex
 	address       string
 	shutdown      bool
+
+	// keyring verifies the credentials of remote clients. If nil, credentials are not verified and remote clients
+	// cannot act as admins.
+	keyring *bitnode.Keyring
 }
 
 var _ bitnode.Factory = &WSFactory{}
@@ -37,6 +42,37 @@ func NewWSFactory(node bitnode.Node, address string) *WSFactory {
 	}
 }
 
+// SetKeyring makes the factory reject remote clients whose credentials are not signed by keyring or have expired.
+// Credentials are checked again before each invocation and stream.
+func (f *WSFactory) SetKeyring(keyring *bitnode.Keyring) {
+	f.keyring = keyring
+}
+
+// verifyCredentials checks credentials received from a remote client.
+func (f *WSFactory) verifyCredentials(creds bitnode.Credentials) error {
+	if f.keyring == nil {
+		if creds.Admin {
+			return fmt.Errorf("rejected credentials: admin credentials require a keyring")
+		}
+		return nil
+	}
+	if err := f.keyring.Verify(creds); err != nil {
+		return fmt.Errorf("rejected credentials: %w", err)
+	}
+	return nil
+}
+
+// checkCredentials checks that credentials accepted by verifyCredentials are still valid.
+func (f *WSFactory) checkCredentials(creds bitnode.Credentials) error {
+	if f.keyring == nil {
+		return nil
+	}
+	if err := f.keyring.CheckValidity(creds); err != nil {
+		return fmt.Errorf("rejected credentials: %w", err)
+	}
+	return nil
+}
+
 func (f *WSFactory) Name() string {
 	return "ws"
 }
@@ -223,6 +259,9 @@ func (f *WSFactory) ReconnectClient(node string, cid string, remoteID bitnode.Sy
 			created:      time.Now(),
 			server:       server,
 			incomingIDs:  map[string]bool{},
+			lastSeqs:     map[string]uint64{},
+			invocations:  map[string]context.CancelFunc{},
+			streams:      map[string]*wsStream{},
 			creds:        creds,
 			middlewares:  f.node.Middlewares(),
 			attached:     false,
@@ -267,6 +306,9 @@ func (f *WSFactory) ReconnectClient(node string, cid string, remoteID bitnode.Sy
 				created:      time.Now(),
 				server:       server,
 				incomingIDs:  map[string]bool{},
+				lastSeqs:     map[string]uint64{},
+				invocations:  map[string]context.CancelFunc{},
+				streams:      map[string]*wsStream{},
 				creds:        creds,
 				middlewares:  f.node.Middlewares(),
 				attached:     false,
@@ -312,6 +354,9 @@ func (f *WSFactory) AcceptClient(node string, cid string) (*Client, error) {
 			conn:        h,
 			server:      true,
 			incomingIDs: map[string]bool{},
+			lastSeqs:    map[string]uint64{},
+			invocations: map[string]context.CancelFunc{},
+			streams:     map[string]*wsStream{},
 			middlewares: f.node.Middlewares(),
 		}
 		//cl.setExtension("ws", &ClientExt{Client: cl})
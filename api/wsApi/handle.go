@@ -1,9 +1,11 @@
 package wsApi
 
 import (
+	"context"
 	"fmt"
 	"github.com/Bitspark/go-bitnode/bitnode"
 	"log"
+	"time"
 )
 
 const wsPath = "/ws"
@@ -49,8 +51,12 @@ func getOrigin(sys *bitnode.NativeSystem) SystemOrigin {
 func (cl *Client) attachOrigin(os SystemOrigin, node *bitnode.NativeNode, sys *bitnode.NativeSystem) error {
 	sys.SetRemoteNode(os.Node)
 	sys.SetRemoteID(os.ID)
-	sys.SetName(bitnode.Credentials{}, os.Name)
-	sys.SetStatus(bitnode.Credentials{}, os.Status)
+	if err := sys.SetName(bitnode.Credentials{}, os.Name); err != nil {
+		return err
+	}
+	if err := sys.SetStatus(bitnode.Credentials{}, os.Status); err != nil {
+		return err
+	}
 	for n, o := range os.Origin {
 		orig, err := node.BlankSystem(o.Name)
 		if err != nil {
@@ -70,18 +76,28 @@ func (cl *Client) attachOrigin(os SystemOrigin, node *bitnode.NativeNode, sys *b
 type SystemMessageConn struct {
 	ID          string              `json:"id"`
 	Credentials bitnode.Credentials `json:"credentials"`
+
+	// Replay contains the sequence number of the last value received per hub, so the server can replay missed values.
+	Replay map[string]uint64 `json:"replay,omitempty"`
 }
 
 func (msg *SystemMessageConn) HandleClient(client *Client, reference string) error {
+	if err := client.conn.factory.verifyCredentials(msg.Credentials); err != nil {
+		return err
+	}
 	if client.NativeSystem == nil {
-		sys, err := client.conn.factory.node.GetSystemByID(client.creds, bitnode.ParseSystemID(msg.ID))
+		sys, err := client.conn.factory.node.GetSystemByID(msg.Credentials, bitnode.ParseSystemID(msg.ID))
 		if err != nil {
 			return err
 		}
+		if err := sys.Native().Authorize(bitnode.PermissionView, msg.Credentials, "connect"); err != nil {
+			return err
+		}
 		client.NativeSystem = sys.Native()
 		client.SetExtension("ws", &WSExt{Client: client})
 	}
 	client.creds = msg.Credentials
+	client.replay = msg.Replay
 	client.send("init", &SystemMessageInit{
 		Interface: client.Interface(),
 		Extends:   client.Extends(),
@@ -101,6 +117,9 @@ type SystemMessageCreds struct {
 }
 
 func (msg *SystemMessageCreds) HandleClient(client *Client, reference string) error {
+	if err := client.conn.factory.verifyCredentials(msg.Credentials); err != nil {
+		return err
+	}
 	client.creds = msg.Credentials
 	client.send("", nil, reference, false)
 	return nil
@@ -121,7 +140,12 @@ func (msg *SystemMessageInit) HandleClient(client *Client, reference string) err
 		return fmt.Errorf("init: %s not a client", client.cid)
 	}
 
-	if !client.defined {
+	if client.defined {
+		// The remote system must support the interface the client has been defined with.
+		if err := msg.Interface.Supports(client.Interface()); err != nil {
+			return fmt.Errorf("init: %w", err)
+		}
+	} else {
 		if err := client.conn.factory.node.(*bitnode.NativeNode).ImplementSystem(client.Native(), msg.Interface.Blank()); err != nil {
 			return err
 		}
@@ -156,12 +180,21 @@ type SystemMessageInvoke struct {
 	Hub   string            `json:"hub"`
 	Value []bitnode.HubItem `json:"value"`
 	User  *bitnode.User     `json:"user"`
+
+	// ID identifies the invocation so that it can be cancelled.
+	ID string `json:"id,omitempty"`
+
+	// Deadline of the invocation in seconds since epoch, no deadline if 0.
+	Deadline float64 `json:"deadline,omitempty"`
 }
 
 func (msg *SystemMessageInvoke) HandleClient(client *Client, reference string) error {
 	if !client.server {
 		return fmt.Errorf("invoke: %s not a server", client.cid)
 	}
+	if err := client.conn.factory.checkCredentials(client.creds); err != nil {
+		return err
+	}
 	hub := client.GetHub(msg.Hub)
 	if hub == nil {
 		return fmt.Errorf("could not find hub: %s", msg.Hub)
@@ -170,7 +203,25 @@ func (msg *SystemMessageInvoke) HandleClient(client *Client, reference string) e
 	if err != nil {
 		return err
 	}
-	wrappedRets, err := hub.Invoke(msg.User, vals...)
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if msg.Deadline != 0 {
+		ctx, cancel = context.WithDeadline(context.Background(), time.UnixMicro(int64(msg.Deadline*1000000)))
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	if msg.ID != "" {
+		client.invocationsMux.Lock()
+		client.invocations[msg.ID] = cancel
+		client.invocationsMux.Unlock()
+		defer func() {
+			client.invocationsMux.Lock()
+			delete(client.invocations, msg.ID)
+			client.invocationsMux.Unlock()
+		}()
+	}
+	wrappedRets, err := hub.InvokeContext(ctx, msg.User, vals...)
 	if err != nil {
 		return err
 	}
@@ -183,6 +234,122 @@ func (msg *SystemMessageInvoke) HandleClient(client *Client, reference string) e
 	return nil
 }
 
+// Cancel
+
+// SystemMessageCancel is what the client sends to the server when it no longer waits for an invocation.
+type SystemMessageCancel struct {
+	ID string `json:"id"`
+}
+
+func (msg *SystemMessageCancel) HandleClient(client *Client, reference string) error {
+	if !client.server {
+		return fmt.Errorf("cancel: %s not a server", client.cid)
+	}
+	client.invocationsMux.Lock()
+	cancel := client.invocations[msg.ID]
+	client.invocationsMux.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	return nil
+}
+
+// Stream Open
+
+// SystemMessageStreamOpen is what the client sends to the server to open a stream on a stream hub.
+type SystemMessageStreamOpen struct {
+	Hub   string            `json:"hub"`
+	ID    string            `json:"id"`
+	Value []bitnode.HubItem `json:"value"`
+	User  *bitnode.User     `json:"user"`
+
+	// Window is the number of items the client accepts before granting more credit.
+	Window int `json:"window"`
+}
+
+func (msg *SystemMessageStreamOpen) HandleClient(client *Client, reference string) error {
+	if !client.server {
+		return fmt.Errorf("stream_open: %s not a server", client.cid)
+	}
+	if err := client.conn.factory.checkCredentials(client.creds); err != nil {
+		return err
+	}
+	hub := client.GetHub(msg.Hub)
+	if hub == nil {
+		return fmt.Errorf("could not find hub: %s", msg.Hub)
+	}
+	interf := hub.Interface()
+	vals, err := client.unwrapValues(interf.Input, msg.Value...)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := hub.Stream(ctx, msg.User, vals...)
+	if err != nil {
+		cancel()
+		return err
+	}
+	client.invocationsMux.Lock()
+	client.invocations[msg.ID] = cancel
+	client.invocationsMux.Unlock()
+	ws := client.openStream(ctx, msg.ID, msg.Window)
+	go func() {
+		defer func() {
+			client.invocationsMux.Lock()
+			delete(client.invocations, msg.ID)
+			client.invocationsMux.Unlock()
+			client.closeStream(msg.ID)
+			cancel()
+		}()
+		if interf.Upstream != nil {
+			go func() {
+				_ = stream.Close(ws.pipeTo(stream, *interf.Upstream))
+			}()
+		}
+		if err := ws.pipeFrom(stream, *interf.Value); err != nil {
+			client.LogError(err)
+		}
+	}()
+	client.send("", nil, reference, false)
+	return nil
+}
+
+// Stream Frame
+
+// SystemMessageStreamFrame carries an item or the end of a stream in either direction.
+type SystemMessageStreamFrame struct {
+	ID    string          `json:"id"`
+	Seq   uint64          `json:"seq"`
+	Value bitnode.HubItem `json:"value,omitempty"`
+	End   bool            `json:"end,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+func (msg *SystemMessageStreamFrame) HandleClient(client *Client, reference string) error {
+	ws := client.getStream(msg.ID)
+	if ws == nil {
+		return fmt.Errorf("stream not found: %s", msg.ID)
+	}
+	return ws.push(msg)
+}
+
+// Stream Credit
+
+// SystemMessageStreamCredit allows the receiver of a stream to send more items.
+type SystemMessageStreamCredit struct {
+	ID     string `json:"id"`
+	Credit int    `json:"credit"`
+}
+
+func (msg *SystemMessageStreamCredit) HandleClient(client *Client, reference string) error {
+	ws := client.getStream(msg.ID)
+	if ws == nil {
+		return nil
+	}
+	ws.grant(msg.Credit)
+	return nil
+}
+
 // Return
 
 type SystemMessageReturn struct {
@@ -201,6 +368,7 @@ func (msg *SystemMessageReturn) HandleClient(client *Client, reference string) e
 type SystemMessagePush struct {
 	Hub   string          `json:"hub"`
 	ID    string          `json:"id"`
+	Seq   uint64          `json:"seq,omitempty"`
 	Value bitnode.HubItem `json:"value"`
 }
 
@@ -210,6 +378,9 @@ func (msg *SystemMessagePush) HandleClient(client *Client, reference string) err
 	}
 	client.incomingMux.Lock()
 	client.incomingIDs[msg.ID] = true
+	if !client.server && msg.Seq > client.lastSeqs[msg.Hub] {
+		client.lastSeqs[msg.Hub] = msg.Seq
+	}
 	client.incomingMux.Unlock()
 	hub := client.GetHub(msg.Hub)
 	if hub == nil {
@@ -258,6 +429,9 @@ func (msg *SystemMessageLifecycleCreate) HandleClient(client *Client, reference
 	if client.NativeSystem == nil {
 		return nil
 	}
+	if err := client.authorizeRemote(bitnode.PermissionAdmin, "create"); err != nil {
+		return err
+	}
 	params, err := client.unwrapValues(msg.Types, msg.Params...)
 	if err != nil {
 		return err
@@ -275,6 +449,9 @@ type SystemMessageLifecycleLoad struct {
 }
 
 func (msg *SystemMessageLifecycleLoad) HandleClient(client *Client, reference string) error {
+	if err := client.authorizeRemote(bitnode.PermissionAdmin, "load"); err != nil {
+		return err
+	}
 	if err := client.EmitEvent(bitnode.LifecycleLoad); err != nil {
 		return err
 	}
@@ -289,6 +466,9 @@ type SystemMessageLifecycleStop struct {
 }
 
 func (msg *SystemMessageLifecycleStop) HandleClient(client *Client, reference string) error {
+	if err := client.authorizeRemote(bitnode.PermissionAdmin, "stop"); err != nil {
+		return err
+	}
 	if err := client.EmitEvent(bitnode.LifecycleStop, msg.Timeout); err != nil {
 		return err
 	}
@@ -302,6 +482,9 @@ type SystemMessageLifecycleStart struct {
 }
 
 func (msg *SystemMessageLifecycleStart) HandleClient(client *Client, reference string) error {
+	if err := client.authorizeRemote(bitnode.PermissionAdmin, "start"); err != nil {
+		return err
+	}
 	if err := client.EmitEvent(bitnode.LifecycleStart); err != nil {
 		return err
 	}
@@ -315,6 +498,9 @@ type SystemMessageLifecycleDelete struct {
 }
 
 func (msg *SystemMessageLifecycleDelete) HandleClient(client *Client, reference string) error {
+	if err := client.authorizeRemote(bitnode.PermissionAdmin, "delete"); err != nil {
+		return err
+	}
 	if err := client.EmitEvent(bitnode.LifecycleDelete); err != nil {
 		return err
 	}
@@ -341,8 +527,7 @@ func (msg *SystemMessageLifecycleName) HandleClient(client *Client, reference st
 	if orig == nil {
 		return fmt.Errorf("name: path not found: %s", path)
 	}
-	orig.SetName(msg.Name)
-	return nil
+	return orig.SetName(msg.Name)
 }
 
 // Lifecycle Status
@@ -365,6 +550,5 @@ func (msg *SystemMessageLifecycleStatus) HandleClient(client *Client, reference
 	if orig == nil {
 		return fmt.Errorf("status: path not found: %s", path)
 	}
-	orig.SetStatus(msg.Status)
-	return nil
+	return orig.SetStatus(msg.Status)
 }
@@ -1 +1,49 @@
 package wsApi
+
+import (
+	"github.com/Bitspark/go-bitnode/bitnode"
+	"strings"
+	"testing"
+)
+
+func versionedSystem(t *testing.T, version string, hubs ...string) *bitnode.NativeSystem {
+	interf := bitnode.NewInterface()
+	interf.Name = "Versioned"
+	interf.Version = version
+	for _, hub := range hubs {
+		*interf.Hubs = append(*interf.Hubs, &bitnode.HubInterface{
+			Name:      hub,
+			Type:      bitnode.HubTypePipe,
+			Direction: bitnode.HubDirectionIn,
+		})
+	}
+	spk := bitnode.Sparkable{RawSparkable: bitnode.RawSparkable{Name: "Versioned", Interface: interf}}
+	if err := spk.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	sys, err := bitnode.NewNode().PrepareSystem(bitnode.Credentials{}, spk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sys.Native()
+}
+
+func TestSystemMessageInit_Version(t *testing.T) {
+	cl := &Client{NativeSystem: versionedSystem(t, "1.2.0", "a"), defined: true}
+
+	msg := &SystemMessageInit{Interface: versionedSystem(t, "2.0.0", "a").Interface()}
+	if err := msg.HandleClient(cl, ""); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Fatal(err)
+	}
+
+	msg = &SystemMessageInit{Interface: versionedSystem(t, "1.3.0").Interface()}
+	if err := msg.HandleClient(cl, ""); err == nil || !strings.Contains(err.Error(), "breaking") {
+		t.Fatal(err)
+	}
+
+	// The remote interface is accepted, initialization fails as the client is not connected.
+	msg = &SystemMessageInit{Interface: versionedSystem(t, "1.3.0", "a", "b").Interface()}
+	if err := msg.HandleClient(cl, ""); err == nil || !strings.Contains(err.Error(), "ws origin") {
+		t.Fatal(err)
+	}
+}
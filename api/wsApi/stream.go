@@ -0,0 +1,205 @@
+package wsApi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Bitspark/go-bitnode/bitnode"
+	"io"
+	"sync"
+)
+
+// wsStream is one end of a stream across a websocket connection.
+type wsStream struct {
+	id     string
+	client *Client
+	ctx    context.Context
+	window int
+
+	// credits limits the number of items sent which the remote end has not consumed yet.
+	credits chan struct{}
+
+	// queue contains incoming frames in the order they have been sent which have not been received yet.
+	queue []*SystemMessageStreamFrame
+
+	// ready is signalled when frames have been added to queue.
+	ready chan struct{}
+
+	// pending contains incoming frames which arrived before their predecessors.
+	pending map[uint64]*SystemMessageStreamFrame
+
+	// next is the sequence number of the next incoming frame.
+	next uint64
+
+	// seq is the sequence number of the last outgoing frame.
+	seq uint64
+
+	// consumed is the number of incoming items consumed since credit has last been granted to the remote end.
+	consumed int
+
+	mux     sync.Mutex
+	sendMux sync.Mutex
+}
+
+// openStream registers a new stream with the client.
+func (cl *Client) openStream(ctx context.Context, id string, window int) *wsStream {
+	if window <= 0 {
+		window = bitnode.StreamWindow
+	}
+	s := &wsStream{
+		id:      id,
+		client:  cl,
+		ctx:     ctx,
+		window:  window,
+		credits: make(chan struct{}, window),
+		ready:   make(chan struct{}, 1),
+		pending: map[uint64]*SystemMessageStreamFrame{},
+		next:    1,
+	}
+	s.grant(window)
+	cl.streamsMux.Lock()
+	cl.streams[id] = s
+	cl.streamsMux.Unlock()
+	return s
+}
+
+// closeStream removes a stream from the client.
+func (cl *Client) closeStream(id string) {
+	cl.streamsMux.Lock()
+	delete(cl.streams, id)
+	cl.streamsMux.Unlock()
+}
+
+func (cl *Client) getStream(id string) *wsStream {
+	cl.streamsMux.Lock()
+	defer cl.streamsMux.Unlock()
+	return cl.streams[id]
+}
+
+// grant allows sending n more items to the remote end.
+func (s *wsStream) grant(n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case s.credits <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+// push adds an incoming frame and queues all frames which are in order. It does not wait for the frames to be
+// received, the remote end must not send more items than it has been granted credit for.
+func (s *wsStream) push(f *SystemMessageStreamFrame) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !f.End && len(s.pending)+len(s.queue) >= s.window {
+		return fmt.Errorf("stream %s exceeds its credit", s.id)
+	}
+	s.pending[f.Seq] = f
+	for {
+		pf, ok := s.pending[s.next]
+		if !ok {
+			break
+		}
+		delete(s.pending, s.next)
+		s.next++
+		s.queue = append(s.queue, pf)
+	}
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// receive returns the next incoming frame, waiting for it if none is queued.
+func (s *wsStream) receive() (*SystemMessageStreamFrame, error) {
+	for {
+		s.mux.Lock()
+		if len(s.queue) == 0 {
+			s.mux.Unlock()
+			select {
+			case <-s.ready:
+				continue
+			case <-s.ctx.Done():
+				return nil, s.ctx.Err()
+			}
+		}
+		f := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mux.Unlock()
+		if !f.End {
+			s.consumed++
+			if s.consumed >= s.window/2 {
+				s.client.send("stream_credit", &SystemMessageStreamCredit{
+					ID:     s.id,
+					Credit: s.consumed,
+				}, "", false)
+				s.consumed = 0
+			}
+		}
+		return f, nil
+	}
+}
+
+// sendFrame sends a frame to the remote end, waiting for credit if it is an item.
+func (s *wsStream) sendFrame(f *SystemMessageStreamFrame) error {
+	if !f.End {
+		select {
+		case <-s.credits:
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+	s.sendMux.Lock()
+	s.seq++
+	f.ID = s.id
+	f.Seq = s.seq
+	s.client.send("stream", f, "", false)
+	s.sendMux.Unlock()
+	return nil
+}
+
+// pipeFrom sends all items received from stream to the remote end, followed by the end of the stream.
+func (s *wsStream) pipeFrom(stream bitnode.Stream, interf bitnode.HubItemInterface) error {
+	for {
+		val, err := stream.Receive()
+		if err == io.EOF {
+			return s.sendFrame(&SystemMessageStreamFrame{End: true})
+		} else if err != nil {
+			_ = s.sendFrame(&SystemMessageStreamFrame{End: true, Error: err.Error()})
+			return err
+		}
+		wrappedVal, err := s.client.wrapValue(interf, val)
+		if err != nil {
+			_ = s.sendFrame(&SystemMessageStreamFrame{End: true, Error: err.Error()})
+			return err
+		}
+		if err := s.sendFrame(&SystemMessageStreamFrame{Value: wrappedVal}); err != nil {
+			return err
+		}
+	}
+}
+
+// pipeTo sends all items received from the remote end to stream. Returns the error the remote end has sent, if any.
+func (s *wsStream) pipeTo(stream bitnode.Stream, interf bitnode.HubItemInterface) error {
+	for {
+		f, err := s.receive()
+		if err != nil {
+			return err
+		}
+		if f.End {
+			if f.Error != "" {
+				return errors.New(f.Error)
+			}
+			return nil
+		}
+		val, err := s.client.unwrapValue(interf, f.Value)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(val); err != nil {
+			return err
+		}
+	}
+}
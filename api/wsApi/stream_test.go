@@ -0,0 +1,34 @@
+package wsApi
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStream_Push(t *testing.T) {
+	cl := &Client{streams: map[string]*wsStream{}}
+	s := cl.openStream(context.Background(), "s1", 4)
+
+	// Frames are queued without waiting for them to be received and passed on in order.
+	for _, seq := range []uint64{2, 1, 3, 4} {
+		if err := s.push(&SystemMessageStreamFrame{Seq: seq, Value: int64(seq)}); err != nil {
+			t.Fatal(seq, err)
+		}
+	}
+	if err := s.push(&SystemMessageStreamFrame{Seq: 5, Value: int64(5)}); err == nil {
+		t.Fatal("must reject items exceeding the credit")
+	}
+	if err := s.push(&SystemMessageStreamFrame{Seq: 5, End: true}); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := s.receive(); err != nil || f.Value != int64(1) {
+		t.Fatal(f, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s2 := cl.openStream(ctx, "s2", 4)
+	cancel()
+	if _, err := s2.receive(); err != context.Canceled {
+		t.Fatal(err)
+	}
+}
@@ -1,11 +1,14 @@
 package bitnode
 
 import (
-	"crypto/sha256"
+	"bytes"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
+	"sync"
+	"time"
 )
 
 // A User represents a client who uses a node.
@@ -25,48 +28,56 @@ type Credentials struct {
 	Groups    []ID   `json:"groups"`
 	Timestamp int64  `json:"timestamp"`
 
-	Signature string `json:"signature"`
-}
+	// KeyID identifies the key the credentials have been signed with.
+	KeyID string `json:"keyId,omitempty"`
 
-func (c *Credentials) Sign(secret string) {
-	c.Signature = c.GetSignature(secret)
-}
+	// NotBefore is the Unix time before which the credentials are not valid yet. Zero means no restriction.
+	NotBefore int64 `json:"notBefore,omitempty"`
 
-func (c *Credentials) IsValid(secret string) error {
-	if c.Signature != c.GetSignature(secret) {
-		return fmt.Errorf("invalid signature")
-	}
-	return nil
+	// Expires is the Unix time after which the credentials are not valid anymore.
+	Expires int64 `json:"expires,omitempty"`
+
+	Signature string `json:"signature"`
 }
 
-func (c *Credentials) GetSignature(secret string) string {
-	dig := sha256.New()
+// payload returns the canonical representation of all signed fields.
+func (c *Credentials) payload() []byte {
+	buf := &bytes.Buffer{}
 	var bts [8]byte
 
-	dig.Write([]byte(c.Authority))
+	writeString := func(str string) {
+		binary.BigEndian.PutUint64(bts[:], uint64(len(str)))
+		buf.Write(bts[:])
+		buf.WriteString(str)
+	}
+	writeInt := func(i int64) {
+		binary.BigEndian.PutUint64(bts[:], uint64(i))
+		buf.Write(bts[:])
+	}
+
+	writeString(c.Authority)
 
-	dig.Write(c.User.ID[:])
+	buf.Write(c.User.ID[:])
 
 	if !c.Admin {
-		dig.Write([]byte{0x00})
+		buf.WriteByte(0x00)
 	} else {
-		dig.Write([]byte{0xFF})
+		buf.WriteByte(0xFF)
 	}
 
-	dig.Write([]byte(c.User.Name))
-	dig.Write([]byte{0})
+	writeString(c.User.Name)
 
+	writeInt(int64(len(c.Groups)))
 	for _, group := range c.Groups {
-		dig.Write(group[:])
-		dig.Write([]byte{0})
+		buf.Write(group[:])
 	}
 
-	binary.PutVarint(bts[:], c.Timestamp)
-	dig.Write(bts[:])
+	writeInt(c.Timestamp)
+	writeString(c.KeyID)
+	writeInt(c.NotBefore)
+	writeInt(c.Expires)
 
-	dig.Write([]byte(secret))
-
-	return base64.StdEncoding.EncodeToString(dig.Sum(nil))
+	return buf.Bytes()
 }
 
 func (c *Credentials) Tokenize() string {
@@ -74,9 +85,118 @@ func (c *Credentials) Tokenize() string {
 	return base64.StdEncoding.EncodeToString(bts)
 }
 
+// ParseCredentials decodes a token created by Tokenize. It does not verify the credentials, see Keyring.ParseToken.
 func ParseCredentials(creds string) (Credentials, error) {
 	c := Credentials{}
-	bts, _ := base64.StdEncoding.DecodeString(creds)
-	_ = json.Unmarshal(bts, &c)
+	bts, err := base64.StdEncoding.DecodeString(creds)
+	if err != nil {
+		return c, fmt.Errorf("decoding credentials: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(bts))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return Credentials{}, fmt.Errorf("parsing credentials: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return Credentials{}, fmt.Errorf("parsing credentials: unexpected data after credentials")
+	}
 	return c, nil
 }
+
+// nodeCredentials are used by the node itself for internal operations, e.g., persistence and lifecycle transitions.
+var nodeCredentials = Credentials{Authority: "node", Admin: true}
+
+const (
+	// PermissionView allows reading the contents of an object, e.g., getting or subscribing to a hub.
+	PermissionView = "view"
+
+	// PermissionExtend allows changing the contents of an object without removing data, e.g., invoking a hub.
+	PermissionExtend = "extend"
+
+	// PermissionAdmin allows any change of an object, including deletion.
+	PermissionAdmin = "admin"
+
+	// PermissionOwner is only granted to the owner of an object.
+	PermissionOwner = "owner"
+)
+
+// A PermissionError is returned when credentials lack a permission required for an action.
+type PermissionError struct {
+	Permission string
+	Object     string
+	User       User
+}
+
+func (e *PermissionError) Error() string {
+	user := e.User.Name
+	if user == "" {
+		user = e.User.ID.Hex()
+	}
+	return fmt.Sprintf("permission denied: %s requires %s permission on %s", user, e.Permission, e.Object)
+}
+
+// An AuditEntry describes a denied access.
+type AuditEntry struct {
+	Time       time.Time
+	Permission string
+	Object     string
+	Action     string
+	Creds      Credentials
+}
+
+// An AuditImpl is notified about denied accesses.
+type AuditImpl interface {
+	Name() string
+	CB(entry AuditEntry)
+}
+
+type nativeAudit struct {
+	cb func(entry AuditEntry)
+}
+
+var _ AuditImpl = &nativeAudit{}
+
+func (n *nativeAudit) Name() string {
+	return "native"
+}
+
+func (n *nativeAudit) CB(entry AuditEntry) {
+	n.cb(entry)
+}
+
+func NewNativeAudit(cb func(entry AuditEntry)) AuditImpl {
+	return &nativeAudit{
+		cb: cb,
+	}
+}
+
+var auditors []AuditImpl
+var auditorsMux sync.Mutex
+
+// AddAuditor registers an auditor which is notified about all denied accesses. Without auditors, denials are logged.
+// Returns a function removing the auditor again.
+func AddAuditor(impl AuditImpl) func() {
+	auditorsMux.Lock()
+	auditors = append(auditors, impl)
+	auditorsMux.Unlock()
+	return func() {
+		auditorsMux.Lock()
+		defer auditorsMux.Unlock()
+		for i, aud := range auditors {
+			if aud == impl {
+				auditors = append(append([]AuditImpl{}, auditors[:i]...), auditors[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// audit records a denied access.
+func audit(entry AuditEntry) {
+	auditorsMux.Lock()
+	auds := auditors
+	auditorsMux.Unlock()
+	for _, aud := range auds {
+		aud.CB(entry)
+	}
+}
@@ -1,53 +1,59 @@
 package bitnode
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"testing"
 	"time"
 )
 
 func TestCredentials1(t *testing.T) {
+	kr := NewKeyring("")
+	_ = kr.AddKey("k1", NewHMACKey([]byte("test")))
+	other := NewKeyring("")
+	_ = other.AddKey("k1", NewHMACKey([]byte("test2")))
+
 	creds := Credentials{
 		Authority: "bitspark",
 		User:      User{},
-		Timestamp: time.Now().Unix(),
-		Signature: "",
 	}
 
-	if err := creds.IsValid(""); err == nil {
-		t.Fatal()
-	}
-	if err := creds.IsValid("test"); err == nil {
-		t.Fatal()
+	if err := kr.Verify(creds); !errors.Is(err, ErrUnsigned) {
+		t.Fatal(err)
 	}
 
-	creds.Sign("test")
+	creds, err := kr.Issue(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	if err := creds.IsValid("test"); err != nil {
+	if err := kr.Verify(creds); err != nil {
 		t.Fatal(err)
 	}
-	if err := creds.IsValid("test2"); err == nil {
-		t.Fatal()
+	if err := other.Verify(creds); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal(err)
 	}
 
 	creds.Authority = "evil"
-	if err := creds.IsValid("test"); err == nil {
-		t.Fatal()
+	if err := kr.Verify(creds); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal(err)
 	}
 }
 
 func TestParseCredentials1(t *testing.T) {
 	id := ComposeIDs(GenerateSystemID(), GenerateObjectID())
 
-	creds1 := Credentials{
-		Authority: "a",
+	kr := NewKeyring("a")
+	_ = kr.AddKey("k1", NewHMACKey([]byte("test")))
+	creds1, err := kr.Issue(Credentials{
 		User: User{
 			ID:   id,
 			Name: "u",
 		},
-		Timestamp: 123,
-		Signature: "",
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
-	creds1.Sign("test")
 
 	str := creds1.Tokenize()
 
@@ -58,7 +64,92 @@ func TestParseCredentials1(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := creds2.IsValid("test"); err != nil {
+	if err := kr.Verify(creds2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseCredentials2(t *testing.T) {
+	if _, err := ParseCredentials("not base64!"); err == nil {
+		t.Fatal()
+	}
+	if _, err := ParseCredentials("eyJ1bmtub3duIjogMX0="); err == nil {
+		t.Fatal("must reject unknown fields")
+	}
+}
+
+func TestKeyring1(t *testing.T) {
+	now := time.Now()
+	kr := NewKeyring("bitspark")
+	kr.TTL = time.Hour
+	kr.now = func() time.Time { return now }
+	if err := kr.AddKey("k1", NewHMACKey([]byte("secret1"))); err != nil {
 		t.Fatal(err)
 	}
+
+	creds, err := kr.Issue(Credentials{User: User{ID: ComposeIDs(GenerateSystemID(), GenerateObjectID())}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.KeyID != "k1" || creds.Authority != "bitspark" {
+		t.Fatal(creds)
+	}
+
+	parsed, err := kr.ParseToken(creds.Tokenize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.User.ID != creds.User.ID {
+		t.Fatal(parsed)
+	}
+
+	forged := creds
+	forged.Admin = true
+	if err := kr.Verify(forged); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatal(err)
+	}
+	if err := kr.Verify(Credentials{}); !errors.Is(err, ErrUnsigned) {
+		t.Fatal(err)
+	}
+
+	kr.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if err := kr.Verify(creds); !errors.Is(err, ErrExpired) {
+		t.Fatal(err)
+	}
+	kr.now = func() time.Time { return now.Add(-time.Minute) }
+	if err := kr.Verify(creds); !errors.Is(err, ErrNotYetValid) {
+		t.Fatal(err)
+	}
+	kr.Leeway = 2 * time.Minute
+	if err := kr.Verify(creds); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyring2(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := NewKeyring("")
+	_ = issuer.AddKey("k1", NewHMACKey([]byte("old")))
+	_ = issuer.AddKey("k2", NewEd25519Key(priv))
+	old, _ := issuer.Issue(Credentials{})
+	if err := issuer.UseKey("k2"); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := issuer.Issue(Credentials{})
+
+	verifier := NewKeyring("")
+	_ = verifier.AddKey("k2", NewEd25519PublicKey(pub))
+	if err := verifier.Verify(rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(old); !errors.Is(err, ErrUnknownKey) {
+		t.Fatal(err)
+	}
+	if err := verifier.Sign(&Credentials{}); err == nil {
+		t.Fatal("public keys cannot sign")
+	}
 }
@@ -0,0 +1,321 @@
+package bitnode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChangeKind classifies a change between two versions of an interface by its effect on clients of the old version.
+type ChangeKind int
+
+const (
+	// ChangeCompatible changes cannot be observed by clients, e.g. changed descriptions.
+	ChangeCompatible ChangeKind = iota
+
+	// ChangeAdditive changes extend the interface, e.g. by adding hubs or accepting more input values. Clients of the
+	// old version keep working.
+	ChangeAdditive
+
+	// ChangeBreaking changes may break clients of the old version, e.g. by removing hubs or requiring more inputs.
+	ChangeBreaking
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeCompatible:
+		return "compatible"
+	case ChangeAdditive:
+		return "additive"
+	case ChangeBreaking:
+		return "breaking"
+	}
+	return fmt.Sprintf("change(%d)", int(k))
+}
+
+// InterfaceChange is a change of a hub between two versions of an interface.
+type InterfaceChange struct {
+	// Hub is the name of the changed hub.
+	Hub string `json:"hub"`
+
+	// Path of the changed part of the hub, e.g. input[0].
+	Path string `json:"path,omitempty"`
+
+	Kind    ChangeKind `json:"kind"`
+	Message string     `json:"message"`
+}
+
+func (c InterfaceChange) String() string {
+	return fmt.Sprintf("%s: %s: %s", c.Kind, c.location(), c.Message)
+}
+
+// location returns the hub and path of the change.
+func (c InterfaceChange) location() string {
+	if c.Path == "" {
+		return c.Hub
+	}
+	return c.Hub + "." + c.Path
+}
+
+// InterfaceChanges are the changes between two versions of an interface.
+type InterfaceChanges []InterfaceChange
+
+// Kind returns the most severe kind of the changes, ChangeCompatible if there are none.
+func (c InterfaceChanges) Kind() ChangeKind {
+	kind := ChangeCompatible
+	for _, ch := range c {
+		if ch.Kind > kind {
+			kind = ch.Kind
+		}
+	}
+	return kind
+}
+
+// Err returns an error listing the breaking changes, nil if there are none.
+func (c InterfaceChanges) Err() error {
+	msgs := []string{}
+	for _, ch := range c {
+		if ch.Kind == ChangeBreaking {
+			msgs = append(msgs, ch.location()+": "+ch.Message)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("breaking changes: %s", strings.Join(msgs, "; "))
+}
+
+func (c *InterfaceChanges) add(hub string, path string, kind ChangeKind, format string, args ...any) {
+	*c = append(*c, InterfaceChange{
+		Hub:     hub,
+		Path:    path,
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Compare returns the changes from the compiled interface i to next, a new version of it.
+func (i *Interface) Compare(next *Interface) InterfaceChanges {
+	var hubs, nextHubs *HubInterfaces
+	if i != nil {
+		hubs = i.CompiledHubs
+	}
+	if next != nil {
+		nextHubs = next.CompiledHubs
+	}
+	return hubs.Compare(nextHubs)
+}
+
+// CheckVersion checks that the version of i is a semantic version if it is set.
+func (i *Interface) CheckVersion() error {
+	if i == nil || i.Version == "" {
+		return nil
+	}
+	if _, err := ParseVersion(i.Version); err != nil {
+		return fmt.Errorf("interface %s: %w", i.Name, err)
+	}
+	return nil
+}
+
+// CheckUpgrade checks that the version of next, a new version of i, announces the changes from i: breaking changes
+// require a new major version, additive ones a new minor version.
+func (i *Interface) CheckUpgrade(next *Interface) error {
+	v, err := ParseVersion(i.Version)
+	if err != nil {
+		return err
+	}
+	nextV, err := ParseVersion(next.Version)
+	if err != nil {
+		return err
+	}
+	if nextV.Compare(v) < 0 {
+		return fmt.Errorf("version %s is older than %s", nextV, v)
+	}
+	changes := i.Compare(next)
+	if kind := changes.Kind(); kind > v.bump(nextV) {
+		if kind == ChangeBreaking {
+			return fmt.Errorf("version %s must be a new major version of %s: %w", nextV, v, changes.Err())
+		}
+		return fmt.Errorf("version %s must be a new minor version of %s: interface has been extended", nextV, v)
+	}
+	return nil
+}
+
+// Supports checks whether clients of the interface expected can use i. If both are versioned, the version of i must
+// satisfy the expected one. In any case, i must not contain breaking changes with respect to expected.
+func (i *Interface) Supports(expected *Interface) error {
+	if i != nil && expected != nil && i.Version != "" && expected.Version != "" {
+		v, err := ParseVersion(i.Version)
+		if err != nil {
+			return err
+		}
+		req, err := ParseVersion(expected.Version)
+		if err != nil {
+			return err
+		}
+		if !v.Satisfies(req) {
+			return fmt.Errorf("interface version %s does not satisfy %s", v, req)
+		}
+	}
+	return expected.Compare(i).Err()
+}
+
+// Compare returns the changes from the hubs i to next. Removed hubs are breaking changes, added ones additive.
+func (i *HubInterfaces) Compare(next *HubInterfaces) InterfaceChanges {
+	changes := InterfaceChanges{}
+	if i != nil {
+		for _, hub := range *i {
+			var nextHub *HubInterface
+			if next != nil {
+				nextHub = next.GetHub(hub.Name)
+			}
+			if nextHub == nil {
+				changes.add(hub.Name, "", ChangeBreaking, "hub removed")
+				continue
+			}
+			changes = append(changes, hub.Compare(nextHub)...)
+		}
+	}
+	if next != nil {
+		for _, nextHub := range *next {
+			if i == nil || i.GetHub(nextHub.Name) == nil {
+				changes.add(nextHub.Name, "", ChangeAdditive, "hub added")
+			}
+		}
+	}
+	return changes
+}
+
+// Compare returns the changes from the hub i to next.
+//
+// Whether a changed value type breaks clients depends on the way values flow. Values flowing into the system (e.g.
+// inputs of pipes with direction in) may accept more values, values flowing out of it (e.g. outputs of these pipes)
+// may be restricted. Values flowing both ways must not change.
+func (i *HubInterface) Compare(next *HubInterface) InterfaceChanges {
+	changes := InterfaceChanges{}
+	if i.Type != next.Type {
+		changes.add(i.Name, "", ChangeBreaking, "type changed from %s to %s", i.Type, next.Type)
+		return changes
+	}
+	if i.Direction != next.Direction {
+		changes.add(i.Name, "", ChangeBreaking, "direction changed from %s to %s", i.Direction, next.Direction)
+		return changes
+	}
+	if i.Description != next.Description {
+		changes.add(i.Name, "", ChangeCompatible, "description changed")
+	}
+
+	// in and out are the flows of values the system receives and sends.
+	in, out := flowIn, flowOut
+	switch i.Direction {
+	case HubDirectionOut:
+		// The system is the one invoking and opening hubs.
+		in, out = flowOut, flowIn
+	case HubDirectionBoth, HubDirectionNone:
+		in, out = flowBoth, flowBoth
+	}
+
+	switch i.Type {
+	case HubTypePipe:
+		compareItems(&changes, i.Name, "input", i.Input, next.Input, in)
+		compareItems(&changes, i.Name, "output", i.Output, next.Output, out)
+	case HubTypeStream:
+		compareItem(&changes, i.Name, "upstream", i.Upstream, next.Upstream, in)
+		compareItem(&changes, i.Name, "value", i.Value, next.Value, out)
+	default:
+		// Clients set values of hubs with direction in and receive those of hubs with direction out.
+		flow := flowBoth
+		switch i.Direction {
+		case HubDirectionIn:
+			flow = flowIn
+		case HubDirectionOut:
+			flow = flowOut
+		}
+		compareItem(&changes, i.Name, "value", i.Value, next.Value, flow)
+	}
+	return changes
+}
+
+// Contains checks whether i can replace i2 without breaking its clients.
+func (i *HubInterface) Contains(i2 *HubInterface) error {
+	return i2.Compare(i).Err()
+}
+
+// flow is the direction values flow in relative to the system providing a hub.
+type flow int
+
+const (
+	flowIn flow = iota
+	flowOut
+	flowBoth
+)
+
+// compareItem adds the changes from the hub item item to next flowing as given by flow to changes.
+func compareItem(changes *InterfaceChanges, hub string, path string, item *HubItemInterface, next *HubItemInterface, flow flow) {
+	var t, nextT *RawType
+	if item != nil {
+		t = item.compiledType()
+	}
+	if next != nil {
+		nextT = next.compiledType()
+	}
+	switch {
+	case t == nil && nextT == nil:
+		return
+	case t == nil:
+		changes.add(hub, path, ChangeBreaking, "added")
+		return
+	case nextT == nil:
+		changes.add(hub, path, ChangeBreaking, "removed")
+		return
+	}
+	if item.Description != next.Description || item.Name != next.Name {
+		changes.add(hub, path, ChangeCompatible, "name or description changed")
+	}
+
+	acceptsOld, errOld := nextT.accepts(t, "")
+	acceptsNew, errNew := t.accepts(nextT, "")
+	switch {
+	case acceptsOld && acceptsNew:
+	case flow == flowIn && acceptsOld:
+		changes.add(hub, path, ChangeAdditive, "accepts more values")
+	case flow == flowOut && acceptsNew:
+		changes.add(hub, path, ChangeAdditive, "restricted values")
+	case flow == flowIn:
+		changes.add(hub, path, ChangeBreaking, "does not accept previous values: %v", errOld)
+	case flow == flowOut:
+		changes.add(hub, path, ChangeBreaking, "values not accepted by previous type: %v", errNew)
+	default:
+		changes.add(hub, path, ChangeBreaking, "type changed")
+	}
+}
+
+// compareItems adds the changes from the hub items items to next flowing as given by flow to changes. The system may
+// receive additional items if they are optional or have defaults.
+func compareItems(changes *InterfaceChanges, hub string, path string, items HubItemsInterface, next HubItemsInterface, flow flow) {
+	for idx, item := range items {
+		if idx >= len(next) {
+			changes.add(hub, indexPath(path, idx), ChangeBreaking, "removed")
+			continue
+		}
+		compareItem(changes, hub, indexPath(path, idx), item, next[idx], flow)
+	}
+	for idx := len(items); idx < len(next); idx++ {
+		nextT := next[idx].compiledType()
+		if flow == flowIn && nextT != nil && (nextT.Optional || nextT.Default != nil) {
+			changes.add(hub, indexPath(path, idx), ChangeAdditive, "optional item added")
+		} else {
+			changes.add(hub, indexPath(path, idx), ChangeBreaking, "added")
+		}
+	}
+}
+
+// compiledType returns the compiled type of i, its raw type if it has not been compiled.
+func (i *HubItemInterface) compiledType() *RawType {
+	if i.Value == nil {
+		return nil
+	}
+	if i.Value.Compiled != nil {
+		return i.Value.Compiled
+	}
+	return &i.Value.RawType
+}
@@ -0,0 +1,214 @@
+package bitnode
+
+import (
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func compatInterface(t *testing.T, interfYAML string) *Interface {
+	interf := &Interface{}
+	if err := yaml.Unmarshal([]byte(interfYAML), interf); err != nil {
+		t.Fatal(err)
+	}
+	if err := interf.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	return interf
+}
+
+const compatBaseYAML = `
+name: Store
+version: 1.2.0
+hubs:
+  - name: put
+    type: pipe
+    direction: in
+    description: Put an item.
+    input:
+      - value: string
+    output:
+      - value: integer
+  - name: count
+    type: value
+    direction: out
+    value:
+      value: integer
+`
+
+func TestInterface_Compare(t *testing.T) {
+	base := compatInterface(t, compatBaseYAML)
+
+	if changes := base.Compare(base); len(changes) != 0 {
+		t.Fatal(changes)
+	}
+
+	cases := []struct {
+		yaml string
+		kind ChangeKind
+	}{
+		// Description changed.
+		{`
+hubs:
+  - {name: put, type: pipe, direction: in, input: [{value: string}], output: [{value: integer}]}
+  - {name: count, type: value, direction: out, value: {value: integer}}
+`, ChangeCompatible},
+		// Hub added, optional input added.
+		{`
+hubs:
+  - name: put
+    type: pipe
+    direction: in
+    description: Put an item.
+    input:
+      - value: string
+      - value: {leaf: integer, optional: true}
+    output:
+      - value: integer
+  - {name: count, type: value, direction: out, value: {value: integer}}
+  - {name: clear, type: pipe, direction: in, input: [], output: []}
+`, ChangeAdditive},
+		// Input accepts more values.
+		{`
+hubs:
+  - name: put
+    type: pipe
+    direction: in
+    description: Put an item.
+    input:
+      - value: {leaf: string, optional: true}
+    output:
+      - value: integer
+  - {name: count, type: value, direction: out, value: {value: integer}}
+`, ChangeAdditive},
+		// Hub removed.
+		{`
+hubs:
+  - {name: put, type: pipe, direction: in, description: Put an item., input: [{value: string}], output: [{value: integer}]}
+`, ChangeBreaking},
+		// Required input added.
+		{`
+hubs:
+  - {name: put, type: pipe, direction: in, description: Put an item., input: [{value: string}, {value: string}], output: [{value: integer}]}
+  - {name: count, type: value, direction: out, value: {value: integer}}
+`, ChangeBreaking},
+		// Output produces more values.
+		{`
+hubs:
+  - name: put
+    type: pipe
+    direction: in
+    description: Put an item.
+    input:
+      - value: string
+    output:
+      - value: {leaf: integer, optional: true}
+  - {name: count, type: value, direction: out, value: {value: integer}}
+`, ChangeBreaking},
+		// Direction changed.
+		{`
+hubs:
+  - {name: put, type: pipe, direction: in, description: Put an item., input: [{value: string}], output: [{value: integer}]}
+  - {name: count, type: value, direction: both, value: {value: integer}}
+`, ChangeBreaking},
+	}
+	for i, c := range cases {
+		next := compatInterface(t, c.yaml)
+		if kind := base.Compare(next).Kind(); kind != c.kind {
+			t.Fatal(i, kind, base.Compare(next))
+		}
+	}
+}
+
+func TestInterface_CompareOut(t *testing.T) {
+	// Pipes with direction out are invoked by the system, so their inputs may be restricted only.
+	base := compatInterface(t, `
+hubs:
+  - {name: notify, type: pipe, direction: out, input: [{value: {leaf: string, optional: true}}], output: []}
+`)
+	narrowed := compatInterface(t, `
+hubs:
+  - {name: notify, type: pipe, direction: out, input: [{value: string}], output: []}
+`)
+	if kind := base.Compare(narrowed).Kind(); kind != ChangeAdditive {
+		t.Fatal(kind)
+	}
+	if kind := narrowed.Compare(base).Kind(); kind != ChangeBreaking {
+		t.Fatal(kind)
+	}
+}
+
+func TestInterface_CheckUpgrade(t *testing.T) {
+	base := compatInterface(t, compatBaseYAML)
+	extended := compatInterface(t, compatBaseYAML+`
+  - {name: clear, type: pipe, direction: in, input: [], output: []}
+`)
+
+	extended.Version = "1.2.1"
+	if err := base.CheckUpgrade(extended); err == nil {
+		t.Fatal("additive changes require a new minor version")
+	}
+	extended.Version = "1.3.0"
+	if err := base.CheckUpgrade(extended); err != nil {
+		t.Fatal(err)
+	}
+	extended.Version = "1.1.0"
+	if err := base.CheckUpgrade(extended); err == nil {
+		t.Fatal("versions must not decrease")
+	}
+
+	if err := extended.Supports(base); err == nil {
+		t.Fatal("1.1.0 does not satisfy 1.2.0")
+	}
+	extended.Version = "1.3.0"
+	if err := extended.Supports(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := base.Supports(extended); err == nil {
+		t.Fatal("must lack hub clear")
+	}
+	if err := base.CheckUpgrade(base); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInterface_Version(t *testing.T) {
+	interf := &Interface{RawInterface: RawInterface{Version: "1.x", Hubs: &HubInterfaces{}}}
+	if err := interf.Compile(nil, "", false); err == nil {
+		t.Fatal("must reject invalid versions")
+	}
+	if _, err := NewNode().NewSystem(Credentials{}, Sparkable{RawSparkable: RawSparkable{
+		Interface: &Interface{RawInterface: RawInterface{Version: "1.x"}},
+	}}); err == nil {
+		t.Fatal("must reject invalid versions")
+	}
+
+	sys, err := NewNode().NewSystem(Credentials{}, Sparkable{RawSparkable: RawSparkable{
+		Interface: compatInterface(t, compatBaseYAML),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := sys.Native().Interface().Version; v != "1.2.0" {
+		t.Fatal(v)
+	}
+}
+
+func TestInterface_Validate(t *testing.T) {
+	interf := compatInterface(t, compatBaseYAML)
+	vals, err := interf.Validate(map[string]any{"count": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals.(map[string]HubItem)["count"] != int64(3) {
+		t.Fatal(vals)
+	}
+	if _, err := interf.Validate(map[string]any{"count": "x"}); err == nil {
+		t.Fatal("must validate values")
+	}
+	if _, err := interf.Validate(map[string]any{"put": 1}); err == nil {
+		t.Fatal("pipes have no values")
+	}
+	if _, err := interf.Validate(map[string]any{"other": 1}); err == nil || err.Error() != "unknown hub: other" {
+		t.Fatal(err)
+	}
+}
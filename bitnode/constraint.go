@@ -0,0 +1,326 @@
+package bitnode
+
+import (
+	"fmt"
+	"golang.org/x/exp/slices"
+	"reflect"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+)
+
+// Constraints restrict the values of a type beyond its structure. Unset constraints do not apply.
+type Constraints struct {
+	// Minimum is the smallest value of an integer or float.
+	Minimum *float64 `json:"minimum,omitempty" yaml:"minimum,omitempty"`
+
+	// Maximum is the largest value of an integer or float.
+	Maximum *float64 `json:"maximum,omitempty" yaml:"maximum,omitempty"`
+
+	// MinLength is the minimum number of characters of a string or bytes of raw data.
+	MinLength *int `json:"minLength,omitempty" yaml:"minLength,omitempty"`
+
+	// MaxLength is the maximum number of characters of a string or bytes of raw data.
+	MaxLength *int `json:"maxLength,omitempty" yaml:"maxLength,omitempty"`
+
+	// Pattern is a regular expression strings must match.
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+
+	// Patterns are further regular expressions strings must match, e.g., those of referenced types.
+	Patterns []string `json:"patterns,omitempty" yaml:"patterns,omitempty"`
+
+	// MinItems is the minimum number of items of a list or entries of a dictionary.
+	MinItems *int `json:"minItems,omitempty" yaml:"minItems,omitempty"`
+
+	// MaxItems is the maximum number of items of a list or entries of a dictionary.
+	MaxItems *int `json:"maxItems,omitempty" yaml:"maxItems,omitempty"`
+}
+
+// A ValueError describes a value violating its type.
+type ValueError struct {
+	// Path of the value, e.g. input[0].address.zip. Empty for the value itself.
+	Path string
+
+	// Message describes the violation.
+	Message string
+}
+
+func (e *ValueError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// valueErrorf creates a ValueError for the value at path.
+func valueErrorf(path string, format string, args ...any) error {
+	return &ValueError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// prefixValueError prepends prefix to the path of err. Errors which are not ValueErrors are wrapped into one.
+func prefixValueError(prefix string, err error) error {
+	if prefix == "" {
+		return err
+	}
+	if ve, ok := err.(*ValueError); ok {
+		if ve.Path == "" {
+			return &ValueError{Path: prefix, Message: ve.Message}
+		}
+		if ve.Path[0] == '[' {
+			return &ValueError{Path: prefix + ve.Path, Message: ve.Message}
+		}
+		return &ValueError{Path: prefix + "." + ve.Path, Message: ve.Message}
+	}
+	return &ValueError{Path: prefix, Message: err.Error()}
+}
+
+// keyPath returns the path of the map entry key of the value at path.
+func keyPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// indexPath returns the path of the item at index of the value at path.
+func indexPath(path string, index int) string {
+	return fmt.Sprintf("%s[%d]", path, index)
+}
+
+var patterns = map[string]*regexp.Regexp{}
+var patternsMux sync.Mutex
+
+// compilePattern compiles pattern, caching the result.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patternsMux.Lock()
+	defer patternsMux.Unlock()
+	if re, ok := patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns[pattern] = re
+	return re, nil
+}
+
+// Validate checks that the constraints are consistent.
+func (c *Constraints) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Minimum != nil && c.Maximum != nil && *c.Minimum > *c.Maximum {
+		return fmt.Errorf("minimum %v exceeds maximum %v", *c.Minimum, *c.Maximum)
+	}
+	if c.MinLength != nil && c.MaxLength != nil && *c.MinLength > *c.MaxLength {
+		return fmt.Errorf("minLength %d exceeds maxLength %d", *c.MinLength, *c.MaxLength)
+	}
+	if c.MinItems != nil && c.MaxItems != nil && *c.MinItems > *c.MaxItems {
+		return fmt.Errorf("minItems %d exceeds maxItems %d", *c.MinItems, *c.MaxItems)
+	}
+	for _, pattern := range c.patterns() {
+		if _, err := compilePattern(pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	}
+	return nil
+}
+
+// patterns returns all regular expressions strings must match.
+func (c *Constraints) patterns() []string {
+	if c == nil {
+		return nil
+	}
+	if c.Pattern == "" {
+		return c.Patterns
+	}
+	return append([]string{c.Pattern}, c.Patterns...)
+}
+
+// Intersect returns the constraints satisfied by the values which satisfy both c and c2. Returns an error if no value
+// satisfies both.
+func (c *Constraints) Intersect(c2 *Constraints) (*Constraints, error) {
+	if c == nil {
+		return c2, nil
+	}
+	if c2 == nil {
+		return c, nil
+	}
+	m := *c
+	m.Minimum = greater(c.Minimum, c2.Minimum)
+	m.Maximum = less(c.Maximum, c2.Maximum)
+	m.MinLength = greater(c.MinLength, c2.MinLength)
+	m.MaxLength = less(c.MaxLength, c2.MaxLength)
+	m.MinItems = greater(c.MinItems, c2.MinItems)
+	m.MaxItems = less(c.MaxItems, c2.MaxItems)
+	m.Patterns = append([]string{}, c.Patterns...)
+	for _, pattern := range c2.patterns() {
+		if slices.Contains(m.patterns(), pattern) {
+			continue
+		}
+		if m.Pattern == "" {
+			m.Pattern = pattern
+		} else {
+			m.Patterns = append(m.Patterns, pattern)
+		}
+	}
+	if len(m.Patterns) == 0 {
+		m.Patterns = nil
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("no value satisfies the constraints: %w", err)
+	}
+	return &m, nil
+}
+
+// greater returns the greater of two bounds. Unset bounds are ignored.
+func greater[T int | float64](a *T, b *T) *T {
+	if a == nil || (b != nil && *b > *a) {
+		return b
+	}
+	return a
+}
+
+// less returns the lesser of two bounds. Unset bounds are ignored.
+func less[T int | float64](a *T, b *T) *T {
+	if a == nil || (b != nil && *b < *a) {
+		return b
+	}
+	return a
+}
+
+// checkNumber checks the range of an integer or float.
+func (c *Constraints) checkNumber(path string, val float64) error {
+	if c == nil {
+		return nil
+	}
+	if c.Minimum != nil && val < *c.Minimum {
+		return valueErrorf(path, "%v is less than minimum %v", val, *c.Minimum)
+	}
+	if c.Maximum != nil && val > *c.Maximum {
+		return valueErrorf(path, "%v is greater than maximum %v", val, *c.Maximum)
+	}
+	return nil
+}
+
+// checkString checks the length and pattern of a string.
+func (c *Constraints) checkString(path string, val string) error {
+	if c == nil {
+		return nil
+	}
+	if err := c.checkLength(path, utf8.RuneCountInString(val)); err != nil {
+		return err
+	}
+	for _, pattern := range c.patterns() {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return valueErrorf(path, "invalid pattern: %v", err)
+		}
+		if !re.MatchString(val) {
+			return valueErrorf(path, "does not match pattern")
+		}
+	}
+	return nil
+}
+
+// checkLength checks the length of a string or raw data.
+func (c *Constraints) checkLength(path string, length int) error {
+	if c == nil {
+		return nil
+	}
+	if c.MinLength != nil && length < *c.MinLength {
+		return valueErrorf(path, "length %d is less than minLength %d", length, *c.MinLength)
+	}
+	if c.MaxLength != nil && length > *c.MaxLength {
+		return valueErrorf(path, "length %d is greater than maxLength %d", length, *c.MaxLength)
+	}
+	return nil
+}
+
+// checkItems checks the number of items of a list or entries of a dictionary.
+func (c *Constraints) checkItems(path string, items int) error {
+	if c == nil {
+		return nil
+	}
+	if c.MinItems != nil && items < *c.MinItems {
+		return valueErrorf(path, "%d items are less than minItems %d", items, *c.MinItems)
+	}
+	if c.MaxItems != nil && items > *c.MaxItems {
+		return valueErrorf(path, "%d items are more than maxItems %d", items, *c.MaxItems)
+	}
+	return nil
+}
+
+// accepts determines whether all values satisfying src satisfy c as well.
+func (c *Constraints) accepts(src *Constraints, path string) error {
+	if c == nil {
+		return nil
+	}
+	if src == nil {
+		src = &Constraints{}
+	}
+	if c.Minimum != nil && (src.Minimum == nil || *src.Minimum < *c.Minimum) {
+		return fmt.Errorf("%s: source allows values below minimum %v", path, *c.Minimum)
+	}
+	if c.Maximum != nil && (src.Maximum == nil || *src.Maximum > *c.Maximum) {
+		return fmt.Errorf("%s: source allows values above maximum %v", path, *c.Maximum)
+	}
+	if c.MinLength != nil && (src.MinLength == nil || *src.MinLength < *c.MinLength) {
+		return fmt.Errorf("%s: source allows lengths below minLength %d", path, *c.MinLength)
+	}
+	if c.MaxLength != nil && (src.MaxLength == nil || *src.MaxLength > *c.MaxLength) {
+		return fmt.Errorf("%s: source allows lengths above maxLength %d", path, *c.MaxLength)
+	}
+	for _, pattern := range c.patterns() {
+		if !slices.Contains(src.patterns(), pattern) {
+			return fmt.Errorf("%s: source does not require pattern %s", path, pattern)
+		}
+	}
+	if c.MinItems != nil && (src.MinItems == nil || *src.MinItems < *c.MinItems) {
+		return fmt.Errorf("%s: source allows fewer items than minItems %d", path, *c.MinItems)
+	}
+	if c.MaxItems != nil && (src.MaxItems == nil || *src.MaxItems > *c.MaxItems) {
+		return fmt.Errorf("%s: source allows more items than maxItems %d", path, *c.MaxItems)
+	}
+	return nil
+}
+
+// checkOptions checks that val is one of the options of t. Options are converted to the leaf type of t first.
+func (t *RawType) checkOptions(path string, val HubItem) error {
+	if len(t.Options) == 0 {
+		return nil
+	}
+	for _, opt := range t.Options {
+		if t.Leaf != 0 {
+			if o, err := convertLeaf(t.Leaf, opt); err == nil {
+				opt = o
+			}
+		}
+		if reflect.DeepEqual(opt, val) {
+			return nil
+		}
+	}
+	return valueErrorf(path, "%v is not one of the options", val)
+}
+
+// acceptsOptions determines whether all options of src are options of t.
+func (t *RawType) acceptsOptions(src *RawType, path string) error {
+	if len(t.Options) == 0 {
+		return nil
+	}
+	if len(src.Options) == 0 {
+		return fmt.Errorf("%s: source allows values which are not options", path)
+	}
+	for _, opt := range src.Options {
+		if t.Leaf != 0 {
+			if o, err := convertLeaf(t.Leaf, opt); err == nil {
+				opt = o
+			}
+		}
+		if err := t.checkOptions("", opt); err != nil {
+			return fmt.Errorf("%s: source option %v is not an option", path, opt)
+		}
+	}
+	return nil
+}
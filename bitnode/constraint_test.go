@@ -0,0 +1,184 @@
+package bitnode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConstraints__Number(t *testing.T) {
+	v1 := mustParseType(`{"leaf": "integer", "constraints": {"minimum": 1, "maximum": 10}}`, nil)
+	if _, err := v1.ApplyMiddlewares(nil, 5, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, 0, true); err == nil {
+		t.Fatal("must not accept value below minimum")
+	}
+	if _, err := v1.ApplyMiddlewares(nil, 11, true); err == nil {
+		t.Fatal("must not accept value above maximum")
+	}
+}
+
+func TestConstraints__String(t *testing.T) {
+	v1 := mustParseType(`{"leaf": "string", "constraints": {"minLength": 2, "maxLength": 3, "pattern": "^[a-z]+$"}}`, nil)
+	if _, err := v1.ApplyMiddlewares(nil, "abc", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, "a", true); err == nil {
+		t.Fatal("must not accept short string")
+	}
+	if _, err := v1.ApplyMiddlewares(nil, "abcd", true); err == nil {
+		t.Fatal("must not accept long string")
+	}
+	if _, err := v1.ApplyMiddlewares(nil, "AB", true); err == nil || err.Error() != "does not match pattern" {
+		t.Fatal(err)
+	}
+}
+
+func TestConstraints__List(t *testing.T) {
+	v1 := mustParseType(`{"listOf": {"leaf": "integer"}, "constraints": {"minItems": 1, "maxItems": 2}}`, nil)
+	if _, err := v1.ApplyMiddlewares(nil, []any{1}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, []any{}, true); err == nil {
+		t.Fatal("must not accept too few items")
+	}
+	if _, err := v1.ApplyMiddlewares(nil, []any{1, 2, 3}, true); err == nil {
+		t.Fatal("must not accept too many items")
+	}
+}
+
+func TestConstraints__Options(t *testing.T) {
+	v1 := mustParseType(`{"leaf": "integer", "options": [1, 2]}`, nil)
+	if v, err := v1.ApplyMiddlewares(nil, 2.0, true); err != nil || v != int64(2) {
+		t.Fatal(v, err)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, 3, true); err == nil {
+		t.Fatal("must only accept options")
+	}
+}
+
+func TestConstraints__Invalid(t *testing.T) {
+	if _, err := parseType(`{"leaf": "integer", "constraints": {"minimum": 2, "maximum": 1}}`, nil); err == nil {
+		t.Fatal("minimum must not exceed maximum")
+	}
+	if _, err := parseType(`{"leaf": "string", "constraints": {"pattern": "("}}`, nil); err == nil {
+		t.Fatal("pattern must be valid")
+	}
+}
+
+func TestConstraints__Path(t *testing.T) {
+	vt := mustParseType(`
+mapOf:
+  address:
+    mapOf:
+      zip:
+        leaf: string
+        constraints:
+          pattern: "^[0-9]{5}$"
+  tags:
+    listOf:
+      leaf: string
+      constraints:
+        maxLength: 3
+`, nil)
+	p := NewHub(nil, &HubInterface{
+		Input: HubItemsInterface{
+			{Value: vt},
+		},
+		Output:    HubItemsInterface{},
+		Type:      HubTypePipe,
+		Direction: HubDirectionIn,
+	})
+	_ = p.Handle(NewNativeFunction(func(creds Credentials, vals ...HubItem) ([]HubItem, error) {
+		return []HubItem{}, nil
+	}))
+
+	_, err := p.Invoke(Credentials{}, nil, map[string]any{
+		"address": map[string]any{"zip": "1234a"},
+		"tags":    []any{},
+	})
+	var valErr *ValueError
+	if !errors.As(err, &valErr) || valErr.Path != "input[0].address.zip" {
+		t.Fatal(err)
+	}
+	if err.Error() != "input[0].address.zip: does not match pattern" {
+		t.Fatal(err)
+	}
+
+	_, err = p.Invoke(Credentials{}, nil, map[string]any{
+		"address": map[string]any{"zip": "12345"},
+		"tags":    []any{"abc", "abcd"},
+	})
+	if !errors.As(err, &valErr) || valErr.Path != "input[0].tags[1]" {
+		t.Fatal(err)
+	}
+}
+
+func TestConstraints__Accepts(t *testing.T) {
+	v1 := mustParseType(`{"leaf": "integer", "constraints": {"minimum": 0, "maximum": 10}}`, nil)
+	v2 := mustParseType(`{"leaf": "integer", "constraints": {"minimum": 1, "maximum": 5}}`, nil)
+	v3 := mustParseType(`{"leaf": "integer"}`, nil)
+	if ok, err := v1.Accepts(v2); !ok || err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := v2.Accepts(v1); ok || err == nil {
+		t.Fatal("must not accept wider range")
+	}
+	if ok, err := v1.Accepts(v3); ok || err == nil {
+		t.Fatal("must not accept unconstrained values")
+	}
+	if ok, err := v3.Accepts(v1); !ok || err != nil {
+		t.Fatal(err)
+	}
+
+	o1 := mustParseType(`{"leaf": "string", "options": ["a", "b"]}`, nil)
+	o2 := mustParseType(`{"leaf": "string", "options": ["a"]}`, nil)
+	if ok, err := o1.Accepts(o2); !ok || err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := o2.Accepts(o1); ok || err == nil {
+		t.Fatal("must not accept additional options")
+	}
+}
+
+func TestConstraints__Reference(t *testing.T) {
+	dom := NewDomain()
+	min, max := 0.0, 100.0
+	_ = dom.addType(&Type{RawType: RawType{Name: "Percent", Leaf: LeafInteger, Constraints: &Constraints{
+		Minimum: &min,
+		Maximum: &max,
+	}}})
+	_ = dom.addType(&Type{RawType: RawType{Name: "Code", Leaf: LeafString, Constraints: &Constraints{
+		Pattern: "^[a-z]+$",
+	}}})
+	if err := dom.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// References narrow the constraints of the referenced type, but cannot loosen them.
+	v1 := mustParseType(`{"reference": "Percent", "constraints": {"minimum": -10, "maximum": 50}}`, dom)
+	if _, err := v1.Compiled.ApplyMiddlewares(nil, 50, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v1.Compiled.ApplyMiddlewares(nil, -5, true); err == nil {
+		t.Fatal("must not accept value below minimum of referenced type")
+	}
+	if _, err := v1.Compiled.ApplyMiddlewares(nil, 60, true); err == nil {
+		t.Fatal("must not accept value above maximum of reference")
+	}
+
+	v2 := mustParseType(`{"reference": "Code", "constraints": {"pattern": "^.{2}$"}}`, dom)
+	if _, err := v2.Compiled.ApplyMiddlewares(nil, "ab", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v2.Compiled.ApplyMiddlewares(nil, "AB", true); err == nil {
+		t.Fatal("must match pattern of referenced type")
+	}
+	if _, err := v2.Compiled.ApplyMiddlewares(nil, "abc", true); err == nil {
+		t.Fatal("must match pattern of reference")
+	}
+
+	if _, err := parseType(`{"reference": "Percent", "constraints": {"minimum": 200}}`, dom); err == nil {
+		t.Fatal("no value satisfies the constraints")
+	}
+}
@@ -0,0 +1,165 @@
+package bitnode
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultValue returns a valid value of the compiled type t. This is its default if it has one, nil if it is optional
+// and a value built from the zero values of its leaves otherwise.
+func (t *RawType) DefaultValue() HubItem {
+	if t == nil {
+		return nil
+	}
+	if t.Default != nil {
+		if val, err := t.applyMiddlewares(nil, nil, false, ""); err == nil {
+			return val
+		}
+	}
+	if t.Optional {
+		return nil
+	}
+	if t.lazy != nil {
+		rt, err := t.resolved()
+		if err != nil {
+			return nil
+		}
+		return rt.DefaultValue()
+	}
+	if len(t.Options) > 0 {
+		if val, err := t.applyMiddlewares(nil, t.Options[0], false, ""); err == nil {
+			return val
+		}
+	}
+
+	switch {
+	case t.Generic != "":
+		return nil
+	case len(t.OneOf) > 0:
+		val := t.OneOf[0].DefaultValue()
+		if mval, ok := val.(map[string]HubItem); ok && t.Discriminator != "" {
+			mval[t.Discriminator] = t.OneOf[0].Name
+		}
+		return val
+	case t.Leaf != 0:
+		return t.zeroLeaf()
+	case t.TupleOf != nil:
+		vals := []HubItem{}
+		for _, tt := range t.TupleOf {
+			vals = append(vals, tt.DefaultValue())
+		}
+		return vals
+	case t.ListOf != nil:
+		vals := []HubItem{}
+		if t.Constraints != nil && t.Constraints.MinItems != nil {
+			for i := 0; i < *t.Constraints.MinItems; i++ {
+				vals = append(vals, t.ListOf.DefaultValue())
+			}
+		}
+		return vals
+	case len(t.MapOf) > 0:
+		vals := map[string]HubItem{}
+		for k, kt := range t.MapOf {
+			if kt.Optional && kt.Default == nil {
+				continue
+			}
+			vals[k] = kt.DefaultValue()
+		}
+		return vals
+	case t.DictOf != nil:
+		return map[string]HubItem{}
+	}
+	return nil
+}
+
+// zeroLeaf returns the zero value of the leaf of t, moved into the range of its constraints.
+func (t *RawType) zeroLeaf() HubItem {
+	switch t.Leaf {
+	case LeafString:
+		return ""
+	case LeafInteger:
+		return int64(t.Constraints.clamp(0))
+	case LeafFloat:
+		return t.Constraints.clamp(0)
+	case LeafBoolean:
+		return false
+	case LeafRaw:
+		return []byte{}
+	case LeafTime:
+		return time.Time{}
+	case LeafDuration:
+		return time.Duration(0)
+	case LeafID:
+		return ID{}
+	case LeafSystemID:
+		return SystemID{}
+	case LeafObjectID:
+		return ObjectID{}
+	}
+	return nil
+}
+
+// clamp returns the number closest to val within minimum and maximum.
+func (c *Constraints) clamp(val float64) float64 {
+	if c == nil {
+		return val
+	}
+	if c.Minimum != nil && val < *c.Minimum {
+		val = *c.Minimum
+	}
+	if c.Maximum != nil && val > *c.Maximum {
+		val = *c.Maximum
+	}
+	return val
+}
+
+// copyValue returns a deep copy of the maps and lists val consists of, so that defaults are not shared between values.
+func copyValue(val HubItem) HubItem {
+	switch val := val.(type) {
+	case map[string]any:
+		cpy := map[string]any{}
+		for k, v := range val {
+			cpy[k] = copyValue(v)
+		}
+		return cpy
+	case map[string]HubItem:
+		cpy := map[string]HubItem{}
+		for k, v := range val {
+			cpy[k] = copyValue(v)
+		}
+		return cpy
+	case []any:
+		cpy := make([]any, len(val))
+		for i, v := range val {
+			cpy[i] = copyValue(v)
+		}
+		return cpy
+	case []HubItem:
+		cpy := make([]HubItem, len(val))
+		for i, v := range val {
+			cpy[i] = copyValue(v)
+		}
+		return cpy
+	}
+	return val
+}
+
+// withDefaults returns vals completed by the defaults of the items of m they lack. Items lacking a value must be
+// optional or have a default.
+func (m HubItemsInterface) withDefaults(vals []HubItem) ([]HubItem, error) {
+	if len(vals) >= len(m) {
+		return vals, nil
+	}
+	completed := append([]HubItem{}, vals...)
+	for _, hi := range m[len(vals):] {
+		if hi.Value == nil || hi.Value.Compiled == nil {
+			return nil, fmt.Errorf("missing value for %s", hi.Name)
+		}
+		compiled := hi.Value.Compiled
+		if compiled.Default == nil && !compiled.Optional {
+			return nil, fmt.Errorf("missing value for %s", hi.Name)
+		}
+		completed = append(completed, compiled.DefaultValue())
+	}
+	return completed, nil
+}
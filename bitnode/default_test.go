@@ -0,0 +1,150 @@
+package bitnode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefault__Apply(t *testing.T) {
+	v1 := mustParseType(`
+mapOf:
+  name:
+    leaf: string
+  retries:
+    leaf: integer
+    default: 3
+  tags:
+    listOf:
+      leaf: string
+    default: [a]
+`, nil)
+	v, err := v1.Compiled.ApplyMiddlewares(nil, map[string]any{"name": "x"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mv := v.(map[string]HubItem)
+	if mv["retries"] != int64(3) || !reflect.DeepEqual(mv["tags"], []HubItem{"a"}) {
+		t.Fatal(v)
+	}
+	if v, _ := v1.Compiled.ApplyMiddlewares(nil, map[string]any{"name": "x", "retries": 1}, false); v.(map[string]HubItem)["retries"] != int64(1) {
+		t.Fatal(v)
+	}
+	if _, err := v1.Compiled.ApplyMiddlewares(nil, map[string]any{"name": "x"}, true); err == nil {
+		t.Fatal("defaults only apply to input")
+	}
+
+	v2 := mustParseType(`{"leaf": "float", "default": 1.5}`, nil)
+	if v, err := v2.Compiled.ApplyMiddlewares(nil, nil, false); err != nil || v != 1.5 {
+		t.Fatal(v, err)
+	}
+
+	if _, err := parseType(`{"leaf": "integer", "default": "a"}`, nil); err == nil {
+		t.Fatal("default must have the type")
+	}
+	if _, err := parseType(`{"leaf": "integer", "default": 0, "constraints": {"minimum": 1}}`, nil); err == nil {
+		t.Fatal("default must satisfy the constraints")
+	}
+}
+
+func TestDefault__Reference(t *testing.T) {
+	dom := NewDomain()
+	_ = dom.addType(&Type{RawType: RawType{Name: "Port", Leaf: LeafInteger, Default: 80}})
+	if err := dom.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	v1 := mustParseType(`{"mapOf": {"port": {"reference": "Port"}, "admin": {"reference": "Port", "default": 8080}}}`, dom)
+	v, err := v1.Compiled.ApplyMiddlewares(nil, map[string]any{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mv := v.(map[string]HubItem); mv["port"] != int64(80) || mv["admin"] != int64(8080) {
+		t.Fatal(v)
+	}
+}
+
+func TestDefault__Value(t *testing.T) {
+	v1 := mustParseType(`
+mapOf:
+  name:
+    leaf: string
+  count:
+    leaf: integer
+    constraints:
+      minimum: 1
+  mode:
+    leaf: string
+    options: [fast, slow]
+  limit:
+    leaf: float
+    default: 2.5
+  comment:
+    leaf: string
+    optional: true
+  items:
+    listOf:
+      leaf: boolean
+    constraints:
+      minItems: 2
+  pair:
+    tupleOf:
+      - leaf: integer
+      - leaf: raw
+  settings:
+    dictOf:
+      leaf: any
+`, nil)
+	v := v1.Compiled.DefaultValue()
+	expected := map[string]HubItem{
+		"name":     "",
+		"count":    int64(1),
+		"mode":     "fast",
+		"limit":    2.5,
+		"items":    []HubItem{false, false},
+		"pair":     []HubItem{int64(0), []byte{}},
+		"settings": map[string]HubItem{},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatal(v)
+	}
+	if _, err := v1.Compiled.ApplyMiddlewares(nil, v, false); err != nil {
+		t.Fatal(err)
+	}
+
+	v2 := mustParseType(`{"leaf": "integer", "optional": true}`, nil)
+	if v := v2.Compiled.DefaultValue(); v != nil {
+		t.Fatal(v)
+	}
+}
+
+func TestDefault__Constructor(t *testing.T) {
+	intType := mustParseType(`{"leaf": "integer", "default": 5}`, nil)
+	strType := mustParseType(`{"leaf": "string"}`, nil)
+	constructor := HubItemsInterface{{Name: "name", Value: strType}, {Name: "size", Value: intType}}
+
+	vals, err := constructor.withDefaults([]HubItem{"a"})
+	if err != nil || !reflect.DeepEqual(vals, []HubItem{"a", int64(5)}) {
+		t.Fatal(vals, err)
+	}
+	if _, err := constructor.withDefaults(nil); err == nil {
+		t.Fatal("name has no default")
+	}
+
+	n := NewNode()
+	spk := Sparkable{RawSparkable: RawSparkable{Name: "Sized", Interface: NewInterface(), Constructor: constructor}}
+	if _, err := n.NewSystem(Credentials{}, spk); err == nil {
+		t.Fatal("must require constructor values without defaults")
+	}
+
+	p := NewHub(nil, &HubInterface{
+		Input:     constructor,
+		Output:    HubItemsInterface{{Value: intType}},
+		Type:      HubTypePipe,
+		Direction: HubDirectionIn,
+	})
+	_ = p.Handle(NewNativeFunction(func(creds Credentials, vals ...HubItem) ([]HubItem, error) {
+		return []HubItem{vals[1]}, nil
+	}))
+	if rets, err := p.Invoke(Credentials{}, nil, "a"); err != nil || rets[0] != int64(5) {
+		t.Fatal(rets, err)
+	}
+}
@@ -0,0 +1,84 @@
+package bitnode
+
+import (
+	"fmt"
+	"sort"
+)
+
+// applyDict applies the middlewares of the value type of a dictionary to each entry of val.
+func (t *RawType) applyDict(mws Middlewares, val HubItem, out bool, path string) (any, error) {
+	entries := map[string]HubItem{}
+	switch mval := val.(type) {
+	case nil:
+	case map[string]HubItem:
+		for k, v := range mval {
+			entries[k] = v
+		}
+	case map[string]any:
+		for k, v := range mval {
+			entries[k] = v
+		}
+	default:
+		return nil, valueErrorf(path, "not a valid dictionary")
+	}
+
+	// Validate in a fixed order so that the same value always yields the same error.
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vals := map[string]HubItem{}
+	for _, k := range keys {
+		if err := t.checkKey(path, k); err != nil {
+			return nil, err
+		}
+		kv, err := t.DictOf.applyMiddlewares(mws, entries[k], out, keyPath(path, k))
+		if err != nil {
+			return nil, err
+		}
+		vals[k] = kv
+	}
+	if err := t.Constraints.checkItems(path, len(vals)); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// checkKey checks that key matches the key pattern of a dictionary.
+func (t *RawType) checkKey(path string, key string) error {
+	if t.KeyPattern == "" {
+		return nil
+	}
+	re, err := compilePattern(t.KeyPattern)
+	if err != nil {
+		return valueErrorf(path, "invalid key pattern: %v", err)
+	}
+	if !re.MatchString(key) {
+		return valueErrorf(path, "key %s does not match key pattern", key)
+	}
+	return nil
+}
+
+// acceptsDict determines whether the dictionary t accepts src. Maps are accepted if each of their entries is.
+func (t *RawType) acceptsDict(src *RawType, path string) (bool, error) {
+	if src.MapOf != nil {
+		for kSrc, vSrc := range src.MapOf {
+			if err := t.checkKey(path, kSrc); err != nil {
+				return false, fmt.Errorf("%s: source map entry %s does not match key pattern", path, kSrc)
+			}
+			if ok, err := t.DictOf.accepts(vSrc, keyPath(path, kSrc)); !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	if src.DictOf == nil {
+		return false, fmt.Errorf("%s: source should be a dictionary", path)
+	}
+	if t.KeyPattern != "" && src.KeyPattern != t.KeyPattern {
+		return false, fmt.Errorf("%s: source does not require key pattern %s", path, t.KeyPattern)
+	}
+	return t.DictOf.accepts(src.DictOf, path+".%")
+}
@@ -0,0 +1,124 @@
+package bitnode
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestDict__Apply(t *testing.T) {
+	v1 := mustParseType(`{"dictOf": {"leaf": "integer"}, "keyPattern": "^[a-z]+$", "constraints": {"maxItems": 2}}`, nil)
+	v, err := v1.ApplyMiddlewares(nil, map[string]any{"alice": 1.0, "bob": 2.0}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mv := v.(map[string]HubItem); len(mv) != 2 || mv["alice"] != int64(1) || mv["bob"] != int64(2) {
+		t.Fatal(v)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, map[string]any{"alice": "a"}, true); err == nil || err.Error() != "alice: not an integer: a" {
+		t.Fatal(err)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, map[string]any{"Alice": 1.0}, true); err == nil {
+		t.Fatal("must check key pattern")
+	}
+	if _, err := v1.ApplyMiddlewares(nil, map[string]any{"a": 1.0, "b": 2.0, "c": 3.0}, true); err == nil {
+		t.Fatal("must check number of entries")
+	}
+	if _, err := v1.ApplyMiddlewares(nil, []any{1.0}, true); err == nil {
+		t.Fatal("must only accept maps")
+	}
+
+	if _, err := parseType(`{"dictOf": {"leaf": "integer"}, "keyPattern": "("}`, nil); err == nil {
+		t.Fatal("key pattern must be valid")
+	}
+	if _, err := parseType(`{"leaf": "integer", "keyPattern": "^a$"}`, nil); err == nil {
+		t.Fatal("key pattern requires a dictionary")
+	}
+}
+
+// wrapMW wraps strings on the way out and unwraps them on the way in.
+type wrapMW struct {
+}
+
+func (w wrapMW) Name() string {
+	return "wrap"
+}
+
+func (w wrapMW) Middleware(ext any, val HubItem, out bool) (HubItem, error) {
+	if out {
+		return map[string]any{"wrapped": val}, nil
+	}
+	mval, ok := val.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("not wrapped: %v", val)
+	}
+	return mval["wrapped"], nil
+}
+
+func TestDict__Middlewares(t *testing.T) {
+	v1 := mustParseType(`{"dictOf": {"leaf": "string", "extensions": {"wrap": {}}}}`, nil)
+	wrapped, err := v1.ApplyMiddlewares(Middlewares{wrapMW{}}, map[string]HubItem{"a": "x", "b": "y"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wv := wrapped.(map[string]HubItem); fmt.Sprint(wv["a"]) != "map[wrapped:x]" {
+		t.Fatal(wrapped)
+	}
+	unwrapped, err := v1.ApplyMiddlewares(Middlewares{wrapMW{}}, wrapped, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uv := unwrapped.(map[string]HubItem); len(uv) != 2 || uv["a"] != "x" || uv["b"] != "y" {
+		t.Fatal(unwrapped)
+	}
+}
+
+func TestDict__Accepts(t *testing.T) {
+	v1 := mustParseType(`{"dictOf": {"leaf": "integer", "optional": true}}`, nil)
+	v2 := mustParseType(`{"dictOf": {"leaf": "integer"}}`, nil)
+	v3 := mustParseType(`{"dictOf": {"leaf": "string"}}`, nil)
+	v4 := mustParseType(`{"mapOf": {"a": {"leaf": "integer"}}}`, nil)
+	v5 := mustParseType(`{"dictOf": {"leaf": "integer"}, "keyPattern": "^[a-z]$"}`, nil)
+	if ok, err := v1.Accepts(v2); !ok || err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := v1.Accepts(v3); ok || err == nil {
+		t.Fatal("must not accept other value types")
+	}
+	if ok, err := v1.Accepts(v4); !ok || err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := v4.Accepts(v1); ok || err == nil {
+		t.Fatal("map must not accept dictionary")
+	}
+	if ok, err := v5.Accepts(v2); ok || err == nil {
+		t.Fatal("must not accept arbitrary keys")
+	}
+	if ok, err := v2.Accepts(v5); !ok || err != nil {
+		t.Fatal(err)
+	}
+	if err := v2.Compiled.Contains(v5.Compiled); err == nil {
+		t.Fatal("must compare key patterns")
+	}
+}
+
+func TestDict__YAML(t *testing.T) {
+	tp := Type{}
+	if err := yaml.Unmarshal([]byte("dictOf:\n  leaf: integer\nkeyPattern: ^a\n"), &tp); err != nil {
+		t.Fatal(err)
+	}
+	if tp.DictOf == nil || tp.DictOf.Leaf != LeafInteger || tp.KeyPattern != "^a" || tp.MapOf != nil {
+		t.Fatal(tp.RawType)
+	}
+	yamlBts, err := yaml.Marshal(&tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp2 := Type{}
+	if err := yaml.Unmarshal(yamlBts, &tp2); err != nil {
+		t.Fatal(err)
+	}
+	if tp2.DictOf == nil || tp2.KeyPattern != "^a" {
+		t.Fatal(string(yamlBts))
+	}
+}
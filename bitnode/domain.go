@@ -8,6 +8,8 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"time"
 )
 
 const DomSep = "."
@@ -59,7 +61,7 @@ func (pg *PermissionGroup) Contains(user ID, groups []ID) bool {
 	}
 	if pg.Groups != nil {
 		for _, group := range groups {
-			if ok := util.Contains(pg.Users, group); ok {
+			if ok := util.Contains(pg.Groups, group); ok {
 				return true
 			}
 		}
@@ -95,24 +97,41 @@ func (p *Permissions) HavePermissions(permission string, creds Credentials) bool
 	if !p.Owner.IsNull() && p.Owner == user {
 		return true
 	}
-	if permission == "owner" {
+	if permission == PermissionOwner {
 		return false
 	}
-	if !p.Owner.IsNull() && p.Owner == user {
-		return true
-	}
 	if p.Admin.Contains(user, groups) {
 		return true
 	}
 	switch permission {
-	case "extend":
+	case PermissionExtend:
 		return p.Extend.Contains(user, groups)
-	case "view":
-		return p.View.Contains(user, groups)
+	case PermissionView:
+		return p.View.Contains(user, groups) || p.Extend.Contains(user, groups)
 	}
 	return false
 }
 
+// Authorize returns a PermissionError if creds lack permission on object and audits the denial.
+// Objects without permissions are unrestricted.
+func (p *Permissions) Authorize(permission string, creds Credentials, object string, action string) error {
+	if p == nil || p.HavePermissions(permission, creds) {
+		return nil
+	}
+	audit(AuditEntry{
+		Time:       time.Now(),
+		Permission: permission,
+		Object:     object,
+		Action:     action,
+		Creds:      creds,
+	})
+	return &PermissionError{
+		Permission: permission,
+		Object:     object,
+		User:       creds.User,
+	}
+}
+
 // Domain contains definitions and hooks to the native environment. It is independent of states.
 type Domain struct {
 	compilable `json:"-" yaml:"-"`
@@ -145,6 +164,10 @@ type Domain struct {
 	Sparkables []*Sparkable `json:"blueprints" yaml:"blueprints"`
 
 	FilePath string `json:"-" yaml:"-"`
+
+	// mux serialises changes of the domain tree, e.g., reloads by a DomainWatcher, with lookups and compilation. Only
+	// the mutex of the root domain is used.
+	mux sync.RWMutex
 }
 
 func NewDomain() *Domain {
@@ -173,7 +196,7 @@ func (dom *Domain) LoadFromDir(dir string, recursive bool) error {
 			if !recursive {
 				continue
 			}
-			if strings.HasSuffix(f.Name(), "_test") {
+			if skipDomainDir(dir, f.Name()) {
 				log.Printf("Skip directory: %s", chPath)
 				continue
 			}
@@ -192,7 +215,7 @@ func (dom *Domain) LoadFromDir(dir string, recursive bool) error {
 			}
 			dom.Domains = append(dom.Domains, childDom)
 		} else {
-			if !strings.HasSuffix(f.Name(), ".yml") && !strings.HasSuffix(f.Name(), ".yaml") {
+			if !isDefinitionsFile(f.Name()) {
 				continue
 			}
 			if err := dom.LoadFromFile(chPath); err != nil {
@@ -209,6 +232,28 @@ func (dom *Domain) LoadFromDir(dir string, recursive bool) error {
 	return nil
 }
 
+// isDefinitionsFile determines whether the file named name inside a domain directory contains definitions.
+func isDefinitionsFile(name string) bool {
+	if name == PackageFile || name == PackageLockFile {
+		return false
+	}
+	return strings.HasSuffix(name, ".yml") || strings.HasSuffix(name, ".yaml")
+}
+
+// skipDomainDir determines whether the directory named name inside dir contains no child domain. These are test
+// directories, hidden ones like git metadata and the dependencies vendored into a package.
+func skipDomainDir(dir string, name string) bool {
+	if strings.HasSuffix(name, "_test") || strings.HasPrefix(name, ".") {
+		return true
+	}
+	if name == packageVendorDir {
+		if _, err := os.Stat(path.Join(dir, PackageFile)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (dom *Domain) LoadFromFile(file string) error {
 	if dom.FilePath != "" {
 		return fmt.Errorf("already have path when loading %s: %s", file, dom.FilePath)
@@ -226,7 +271,7 @@ func (dom *Domain) LoadFromFile(file string) error {
 
 	dom.Permissions = defs.Permissions
 
-	if err := dom.addDefinitions(defs); err != nil {
+	if err := dom.addDefinitions(&defs); err != nil {
 		return err
 	}
 
@@ -235,7 +280,14 @@ func (dom *Domain) LoadFromFile(file string) error {
 	return nil
 }
 
+// Compile compiles the definitions of the domain and its child domains.
 func (dom *Domain) Compile() error {
+	dom.lock()
+	defer dom.unlock()
+	return dom.compile()
+}
+
+func (dom *Domain) compile() error {
 	if dom.Parent != nil && dom.FullName == "" {
 		dom.FullName = dom.Parent.FullName + DomSep + dom.Name
 	}
@@ -255,7 +307,7 @@ func (dom *Domain) Compile() error {
 		}
 	}
 	for _, d := range dom.Domains {
-		if err := d.Compile(); err != nil {
+		if err := d.compile(); err != nil {
 			return err
 		}
 	}
@@ -277,19 +329,64 @@ func (dom *Domain) Root() *Domain {
 	return dom.Parent.Root()
 }
 
+// lock locks the domain tree of dom for changes.
+func (dom *Domain) lock() {
+	dom.Root().mux.Lock()
+}
+
+func (dom *Domain) unlock() {
+	dom.Root().mux.Unlock()
+}
+
+// rlock locks the domain tree of dom for lookups.
+func (dom *Domain) rlock() {
+	dom.Root().mux.RLock()
+}
+
+func (dom *Domain) runlock() {
+	dom.Root().mux.RUnlock()
+}
+
+// Save writes the domain to its definitions file. Only changed fields and definitions are rewritten, so that comments
+// and notations of the others are preserved.
 func (dom *Domain) Save() error {
-	if yamlBts, err := yaml.Marshal(*dom); err != nil {
-		return fmt.Errorf("parsing definitions from %s: %v", dom.FilePath, err)
-	} else {
-		if err := os.MkdirAll(path.Dir(dom.FilePath), os.ModePerm); err != nil {
-			return err
-		}
-		if err := os.WriteFile(dom.FilePath, yamlBts, os.ModePerm); err != nil {
-			return err
-		}
+	f, err := readDefinitionsFile(dom.FilePath)
+	if err != nil {
+		return err
+	}
+	if err := f.setField("name", dom.Name); err != nil {
+		return err
+	}
+	if err := f.setField("description", dom.Description); err != nil {
+		return err
+	}
+	if err := f.setField("permissions", dom.Permissions); err != nil {
+		return err
 	}
 
-	return nil
+	names, defs := []string{}, []any{}
+	for _, t := range dom.Types {
+		names, defs = append(names, t.Name), append(defs, t)
+	}
+	if err := f.setDefinitions(sectionTypes, names, defs); err != nil {
+		return err
+	}
+	names, defs = []string{}, []any{}
+	for _, i := range dom.Interfaces {
+		names, defs = append(names, i.Name), append(defs, i)
+	}
+	if err := f.setDefinitions(sectionInterfaces, names, defs); err != nil {
+		return err
+	}
+	names, defs = []string{}, []any{}
+	for _, m := range dom.Sparkables {
+		names, defs = append(names, m.Name), append(defs, m)
+	}
+	if err := f.setDefinitions(sectionSparkables, names, defs); err != nil {
+		return err
+	}
+
+	return f.write()
 }
 
 func (dom *Domain) Delete() error {
@@ -315,6 +412,12 @@ func (dom *Domain) SaveAll() error {
 }
 
 func (dom *Domain) AddFullDomain(domPath string, fullName string) error {
+	dom.lock()
+	defer dom.unlock()
+	return dom.addFullDomain(domPath, fullName)
+}
+
+func (dom *Domain) addFullDomain(domPath string, fullName string) error {
 	if domPath == "" {
 		domPath = path.Dir(dom.FilePath)
 	}
@@ -322,9 +425,9 @@ func (dom *Domain) AddFullDomain(domPath string, fullName string) error {
 	doms := strings.Split(fullName, DomSep)
 	d := doms[0]
 
-	nd, err := dom.GetDomain(dom.FullName + DomSep + d)
+	nd, err := dom.lookupDomain(dom.FullName + DomSep + d)
 	if err != nil {
-		nd, err = dom.AddDomain(d)
+		nd, err = dom.addDomain(d)
 		if err != nil {
 			return err
 		} else {
@@ -336,32 +439,49 @@ func (dom *Domain) AddFullDomain(domPath string, fullName string) error {
 		return nil
 	}
 
-	return nd.AddFullDomain(path.Join(domPath, doms[1]), strings.Join(doms[1:], DomSep))
+	return nd.addFullDomain(path.Join(domPath, doms[1]), strings.Join(doms[1:], DomSep))
 }
 
 func (dom *Domain) AddDomain(name string) (*Domain, error) {
+	dom.lock()
+	defer dom.unlock()
 	return dom.addDomain(name)
 }
 
 func (dom *Domain) GetDomain(name string) (*Domain, error) {
-	return dom.getDomain(strings.Split(name, DomSep))
+	dom.rlock()
+	defer dom.runlock()
+	return dom.lookupDomain(name)
 }
 
-func (dom *Domain) CreateDomain(name string, perms Permissions) error {
+func (dom *Domain) CreateDomain(creds Credentials, name string, perms Permissions) error {
+	dom.lock()
+	defer dom.unlock()
 	frags := strings.Split(name, DomSep)
 	d, err := dom.getDomain(frags[:len(frags)-1])
 	if err != nil {
 		return err
 	}
+	if err := d.Permissions.Authorize(PermissionExtend, creds, d.FullName, "create domain "+name); err != nil {
+		return err
+	}
+	if perms.Owner.IsNull() {
+		perms.Owner = creds.User.ID
+	}
 	return d.createDomain(frags[len(frags)-1], perms)
 }
 
-func (dom *Domain) DeleteDomain(name string) error {
+func (dom *Domain) DeleteDomain(creds Credentials, name string) error {
+	dom.lock()
+	defer dom.unlock()
 	frags := strings.Split(name, DomSep)
 	d, err := dom.getDomain(frags[:len(frags)-1])
 	if err != nil {
 		return err
 	}
+	if err := d.Permissions.Authorize(PermissionAdmin, creds, d.FullName, "delete domain "+name); err != nil {
+		return err
+	}
 	return d.deleteDomain(frags[len(frags)-1])
 }
 
@@ -374,6 +494,12 @@ func (dom *Domain) MustGetType(name string) *Type {
 }
 
 func (dom *Domain) GetType(name string) (*Type, error) {
+	dom.rlock()
+	defer dom.runlock()
+	return dom.lookupType(name)
+}
+
+func (dom *Domain) lookupType(name string) (*Type, error) {
 	frags := strings.Split(name, DomSep)
 	d, err := dom.getDomain(frags[:len(frags)-1])
 	if err != nil {
@@ -382,25 +508,73 @@ func (dom *Domain) GetType(name string) (*Type, error) {
 	return d.getType(frags[len(frags)-1])
 }
 
-func (dom *Domain) CreateType(name string, perms Permissions) (*Type, error) {
+// ViewType returns the type name if creds may view both it and its domain.
+func (dom *Domain) ViewType(creds Credentials, name string) (*Type, error) {
+	dom.rlock()
+	defer dom.runlock()
 	frags := strings.Split(name, DomSep)
 	d, err := dom.getDomain(frags[:len(frags)-1])
 	if err != nil {
 		return nil, err
 	}
+	if err := d.Permissions.Authorize(PermissionView, creds, d.FullName, "view type "+name); err != nil {
+		return nil, err
+	}
+	obj, err := d.getType(frags[len(frags)-1])
+	if err != nil {
+		return nil, err
+	}
+	if err := obj.Permissions.Authorize(PermissionView, creds, name, "view type"); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (dom *Domain) CreateType(creds Credentials, name string, perms Permissions) (*Type, error) {
+	dom.lock()
+	defer dom.unlock()
+	frags := strings.Split(name, DomSep)
+	d, err := dom.getDomain(frags[:len(frags)-1])
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Permissions.Authorize(PermissionExtend, creds, d.FullName, "create type "+name); err != nil {
+		return nil, err
+	}
+	if perms.Owner.IsNull() {
+		perms.Owner = creds.User.ID
+	}
 	return d.createType(frags[len(frags)-1], perms)
 }
 
-func (dom *Domain) DeleteType(name string) error {
+func (dom *Domain) DeleteType(creds Credentials, name string) error {
+	dom.lock()
+	defer dom.unlock()
 	frags := strings.Split(name, DomSep)
 	d, err := dom.getDomain(frags[:len(frags)-1])
 	if err != nil {
 		return err
 	}
+	if err := d.Permissions.Authorize(PermissionAdmin, creds, d.FullName, "delete type "+name); err != nil {
+		return err
+	}
+	obj, err := d.getType(frags[len(frags)-1])
+	if err != nil {
+		return err
+	}
+	if err := obj.Permissions.Authorize(PermissionAdmin, creds, name, "delete type"); err != nil {
+		return err
+	}
 	return d.deleteType(frags[len(frags)-1])
 }
 
 func (dom *Domain) GetInterface(name string) (*Interface, error) {
+	dom.rlock()
+	defer dom.runlock()
+	return dom.lookupInterface(name)
+}
+
+func (dom *Domain) lookupInterface(name string) (*Interface, error) {
 	frags := strings.Split(name, DomSep)
 	d, err := dom.getDomain(frags[:len(frags)-1])
 	if err != nil {
@@ -409,25 +583,73 @@ func (dom *Domain) GetInterface(name string) (*Interface, error) {
 	return d.getInterface(frags[len(frags)-1])
 }
 
-func (dom *Domain) CreateInterface(name string, perms Permissions) (*Interface, error) {
+// ViewInterface returns the interface name if creds may view both it and its domain.
+func (dom *Domain) ViewInterface(creds Credentials, name string) (*Interface, error) {
+	dom.rlock()
+	defer dom.runlock()
+	frags := strings.Split(name, DomSep)
+	d, err := dom.getDomain(frags[:len(frags)-1])
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Permissions.Authorize(PermissionView, creds, d.FullName, "view interface "+name); err != nil {
+		return nil, err
+	}
+	obj, err := d.getInterface(frags[len(frags)-1])
+	if err != nil {
+		return nil, err
+	}
+	if err := obj.Permissions.Authorize(PermissionView, creds, name, "view interface"); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (dom *Domain) CreateInterface(creds Credentials, name string, perms Permissions) (*Interface, error) {
+	dom.lock()
+	defer dom.unlock()
 	frags := strings.Split(name, DomSep)
 	d, err := dom.getDomain(frags[:len(frags)-1])
 	if err != nil {
 		return nil, err
 	}
+	if err := d.Permissions.Authorize(PermissionExtend, creds, d.FullName, "create interface "+name); err != nil {
+		return nil, err
+	}
+	if perms.Owner.IsNull() {
+		perms.Owner = creds.User.ID
+	}
 	return d.createInterface(frags[len(frags)-1], perms)
 }
 
-func (dom *Domain) DeleteInterface(name string) error {
+func (dom *Domain) DeleteInterface(creds Credentials, name string) error {
+	dom.lock()
+	defer dom.unlock()
 	frags := strings.Split(name, DomSep)
 	d, err := dom.getDomain(frags[:len(frags)-1])
 	if err != nil {
 		return err
 	}
+	if err := d.Permissions.Authorize(PermissionAdmin, creds, d.FullName, "delete interface "+name); err != nil {
+		return err
+	}
+	obj, err := d.getInterface(frags[len(frags)-1])
+	if err != nil {
+		return err
+	}
+	if err := obj.Permissions.Authorize(PermissionAdmin, creds, name, "delete interface"); err != nil {
+		return err
+	}
 	return d.deleteInterface(frags[len(frags)-1])
 }
 
 func (dom *Domain) GetSparkable(name string) (*Sparkable, error) {
+	dom.rlock()
+	defer dom.runlock()
+	return dom.lookupSparkable(name)
+}
+
+func (dom *Domain) lookupSparkable(name string) (*Sparkable, error) {
 	frags := strings.Split(name, DomSep)
 	d, err := dom.getDomain(frags[:len(frags)-1])
 	if err != nil {
@@ -436,27 +658,69 @@ func (dom *Domain) GetSparkable(name string) (*Sparkable, error) {
 	return d.getSparkable(frags[len(frags)-1])
 }
 
-func (dom *Domain) CreateSparkable(name string, perms Permissions) (*Sparkable, error) {
+// ViewSparkable returns the sparkable name if creds may view both it and its domain.
+func (dom *Domain) ViewSparkable(creds Credentials, name string) (*Sparkable, error) {
+	dom.rlock()
+	defer dom.runlock()
+	frags := strings.Split(name, DomSep)
+	d, err := dom.getDomain(frags[:len(frags)-1])
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Permissions.Authorize(PermissionView, creds, d.FullName, "view sparkable "+name); err != nil {
+		return nil, err
+	}
+	obj, err := d.getSparkable(frags[len(frags)-1])
+	if err != nil {
+		return nil, err
+	}
+	if err := obj.Permissions.Authorize(PermissionView, creds, name, "view sparkable"); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (dom *Domain) CreateSparkable(creds Credentials, name string, perms Permissions) (*Sparkable, error) {
+	dom.lock()
+	defer dom.unlock()
 	frags := strings.Split(name, DomSep)
 	d, err := dom.getDomain(frags[:len(frags)-1])
 	if err != nil {
 		return nil, err
 	}
+	if err := d.Permissions.Authorize(PermissionExtend, creds, d.FullName, "create sparkable "+name); err != nil {
+		return nil, err
+	}
+	if perms.Owner.IsNull() {
+		perms.Owner = creds.User.ID
+	}
 	return d.createSparkable(frags[len(frags)-1], perms)
 }
 
-func (dom *Domain) DeleteSparkable(name string) error {
+func (dom *Domain) DeleteSparkable(creds Credentials, name string) error {
+	dom.lock()
+	defer dom.unlock()
 	frags := strings.Split(name, DomSep)
 	d, err := dom.getDomain(frags[:len(frags)-1])
 	if err != nil {
 		return err
 	}
+	if err := d.Permissions.Authorize(PermissionAdmin, creds, d.FullName, "delete sparkable "+name); err != nil {
+		return err
+	}
+	obj, err := d.getSparkable(frags[len(frags)-1])
+	if err != nil {
+		return err
+	}
+	if err := obj.Permissions.Authorize(PermissionAdmin, creds, name, "delete sparkable"); err != nil {
+		return err
+	}
 	return d.deleteSparkable(frags[len(frags)-1])
 }
 
 // Private
 
-func (dom *Domain) addDefinitions(defs Domain) error {
+func (dom *Domain) addDefinitions(defs *Domain) error {
 	if defs.Name == "" {
 		return fmt.Errorf("require domain contents name")
 	}
@@ -489,10 +753,18 @@ func (dom *Domain) addDefinitions(defs Domain) error {
 
 func (dom *Domain) copy(parent *Domain) *Domain {
 	libCpy := &Domain{
-		FullName: dom.FullName,
-		Parent:   parent,
+		compilable:  dom.compilable,
+		FullName:    dom.FullName,
+		Parent:      parent,
+		Domains:     dom.Domains,
+		Name:        dom.Name,
+		Description: dom.Description,
+		Permissions: dom.Permissions,
+		Types:       dom.Types,
+		Interfaces:  dom.Interfaces,
+		Sparkables:  dom.Sparkables,
+		FilePath:    dom.FilePath,
 	}
-	*libCpy = *dom
 	for c, l := range dom.Domains {
 		l2 := l.copy(dom)
 		dom.Domains[c] = l2
@@ -500,6 +772,10 @@ func (dom *Domain) copy(parent *Domain) *Domain {
 	return libCpy
 }
 
+func (dom *Domain) lookupDomain(name string) (*Domain, error) {
+	return dom.getDomain(strings.Split(name, DomSep))
+}
+
 func (dom *Domain) getDomain(frags []string) (*Domain, error) {
 	if len(frags) == 0 {
 		return dom, nil
@@ -537,7 +813,7 @@ func (dom *Domain) addDomain(name string) (*Domain, error) {
 	if err := checkName(name, 1, 12, false); err != nil {
 		return nil, err
 	}
-	if _, err := dom.GetDomain(name); err == nil {
+	if _, err := dom.lookupDomain(name); err == nil {
 		return nil, fmt.Errorf("already have a sub-domain %s", name)
 	}
 	d := NewDomain()
@@ -704,7 +980,7 @@ func (dom *Domain) createDomain(name string, perms Permissions) error {
 		}
 		domain.FilePath = path.Join(baseDir, name+".yml")
 	}
-	if err := domain.Compile(); err != nil {
+	if err := domain.compile(); err != nil {
 		return err
 	}
 	newDomains = append(newDomains, domain)
@@ -1,6 +1,7 @@
 package bitnode
 
 import (
+	"errors"
 	"gopkg.in/yaml.v3"
 	"testing"
 )
@@ -129,3 +130,47 @@ func TestPermissions__YAML1(t *testing.T) {
 		t.Fatal(perms2.Owner)
 	}
 }
+
+func TestDomain__Permissions(t *testing.T) {
+	user := Credentials{User: User{ID: ComposeIDs(GenerateSystemID(), GenerateObjectID())}}
+
+	dom := NewDomain()
+	a, _ := dom.AddDomain("a")
+	if _, err := dom.CreateType(user, "a.test", Permissions{}); err == nil {
+		t.Fatal("require extend permission")
+	}
+
+	a.Permissions.Extend.Users = IDList{user.User.ID}
+	tp, err := dom.CreateType(user, "a.test", Permissions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp.Permissions.Owner != user.User.ID {
+		t.Fatal(tp.Permissions.Owner)
+	}
+	if err := dom.DeleteType(user, "a.test"); err == nil {
+		t.Fatal("require admin permission")
+	}
+
+	// Objects are checked in addition to their domain.
+	other := Credentials{User: User{ID: ComposeIDs(GenerateSystemID(), GenerateObjectID())}}
+	a.Permissions.Admin.Users = IDList{user.User.ID, other.User.ID}
+	if _, err := dom.ViewType(user, "a.test"); err != nil {
+		t.Fatal(err)
+	}
+	var permErr *PermissionError
+	if _, err := dom.ViewType(other, "a.test"); !errors.As(err, &permErr) || permErr.Object != "a.test" {
+		t.Fatal(err)
+	}
+	tp.Permissions.View.Public = true
+	if _, err := dom.ViewType(other, "a.test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dom.DeleteType(other, "a.test"); !errors.As(err, &permErr) || permErr.Permission != PermissionAdmin {
+		t.Fatal(err)
+	}
+
+	if err := dom.DeleteType(Credentials{Admin: true}, "a.test"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+package bitnode
+
+import (
+	"fmt"
+	"golang.org/x/exp/slices"
+)
+
+// compileExtends merges the fields of the map types t extends into compiled. The extended types are resolved in the
+// domain domName and recompile rootType when they change.
+func (t *RawType) compileExtends(compiled *RawType, dom *Domain, domName string, rootType *Type) error {
+	cdom, _ := dom.lookupDomain(domName)
+	if cdom == nil {
+		return fmt.Errorf("domain not set")
+	}
+	bases := []*Type{}
+	for _, ext := range t.Extends {
+		base, err := cdom.lookupType(ext)
+		if base == nil {
+			return fmt.Errorf("type not found: %s: %v", ext, err)
+		}
+		if base.Compiled == nil {
+			if base.compiling {
+				return fmt.Errorf("cyclic extension of %s", ext)
+			}
+			if err := base.Compile(dom, base.Domain, true); err != nil {
+				return err
+			}
+		}
+		base.references[rootType] = true
+		bases = append(bases, base)
+	}
+	return compiled.inherit(bases)
+}
+
+// inherit adds the fields of the compiled map types bases to the fields of t. Fields of t may redeclare inherited
+// fields with narrower types only, so that values remain valid for the bases.
+func (t *RawType) inherit(bases []*Type) error {
+	own := t.MapOf
+	t.MapOf = map[string]*RawType{}
+	inherited := map[string]string{}
+	for _, base := range bases {
+		if err := t.extend(base.Compiled, base.FullName, inherited); err != nil {
+			return err
+		}
+	}
+	for k, v := range own {
+		if bv, ok := t.MapOf[k]; ok {
+			if ok, err := bv.accepts(v, k); !ok {
+				return fmt.Errorf("field %s conflicts with %s: %v", k, inherited[k], err)
+			}
+		}
+		t.MapOf[k] = v
+	}
+	return nil
+}
+
+// extend adds the fields of the compiled map type base named baseName to t. inherited contains the names of the
+// types each field has already been inherited from, fields inherited from different types must be equal.
+func (t *RawType) extend(base *RawType, baseName string, inherited map[string]string) error {
+	if base.MapOf == nil {
+		return fmt.Errorf("cannot extend %s: only map types can be extended", baseName)
+	}
+	for k, bv := range base.MapOf {
+		if v, ok := t.MapOf[k]; ok && (v.Contains(bv) != nil || bv.Contains(v) != nil) {
+			return fmt.Errorf("field %s of %s conflicts with %s", k, baseName, inherited[k])
+		}
+		t.MapOf[k] = bv.Copy()
+		if _, ok := inherited[k]; !ok {
+			inherited[k] = baseName
+		}
+	}
+	for _, name := range append(base.CompiledExtends, baseName) {
+		if !slices.Contains(t.CompiledExtends, name) {
+			t.CompiledExtends = append(t.CompiledExtends, name)
+		}
+	}
+	return nil
+}
+
+// extends determines whether t has been derived from the domain type named name.
+func (t *RawType) extends(name string) bool {
+	return name != "" && slices.Contains(t.CompiledExtends, name)
+}
+
+// acceptsDerived determines whether the map type t accepts src, which has been derived from it. Fields src adds to
+// those of t are permitted.
+func (t *RawType) acceptsDerived(src *RawType, path string) (bool, error) {
+	for k, v := range t.MapOf {
+		vSrc, ok := src.MapOf[k]
+		if !ok {
+			if v.Optional {
+				continue
+			}
+			return false, fmt.Errorf("%s: source lacks map entry: %s", path, k)
+		}
+		if ok, err := v.accepts(vSrc, keyPath(path, k)); !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
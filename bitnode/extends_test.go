@@ -0,0 +1,178 @@
+package bitnode
+
+import (
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func extendsDomain(t *testing.T, typeYAMLs ...string) (*Domain, error) {
+	dom := NewDomain()
+	a, _ := dom.AddDomain("a")
+	for _, typeYAML := range typeYAMLs {
+		var tp Type
+		if err := yaml.Unmarshal([]byte(typeYAML), &tp.RawType); err != nil {
+			t.Fatal(err)
+		}
+		_ = a.addType(&tp)
+	}
+	return dom, dom.Compile()
+}
+
+const baseTypeYAML = `
+name: Entity
+mapOf:
+  id:
+    leaf: string
+  score:
+    leaf: integer
+    optional: true
+`
+
+func TestExtends__Inherit(t *testing.T) {
+	dom, err := extendsDomain(t, baseTypeYAML, `
+name: User
+extends: [Entity]
+mapOf:
+  name:
+    leaf: string
+`, `
+name: Admin
+extends: [a.User]
+mapOf:
+  score:
+    leaf: integer
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := dom.GetType("a.User")
+	if len(user.Compiled.MapOf) != 3 || user.Compiled.MapOf["id"].Leaf != LeafString {
+		t.Fatal(user.Compiled)
+	}
+	admin, _ := dom.GetType("a.Admin")
+	if len(admin.Compiled.MapOf) != 3 || admin.Compiled.MapOf["score"].Optional {
+		t.Fatal(admin.Compiled)
+	}
+	if len(admin.Compiled.CompiledExtends) != 2 {
+		t.Fatal(admin.Compiled.CompiledExtends)
+	}
+	if _, err := admin.Compiled.ApplyMiddlewares(nil, map[string]any{"id": "1", "name": "x"}, false); err == nil {
+		t.Fatal("must require inherited fields")
+	}
+}
+
+func TestExtends__Conflicts(t *testing.T) {
+	if _, err := extendsDomain(t, baseTypeYAML, `
+name: User
+extends: [Entity]
+mapOf:
+  id:
+    leaf: integer
+`); err == nil {
+		t.Fatal("must report conflicting field")
+	}
+	if _, err := extendsDomain(t, baseTypeYAML, `
+name: Other
+mapOf:
+  id:
+    leaf: integer
+`, `
+name: User
+extends: [Entity, Other]
+`); err == nil {
+		t.Fatal("must report conflicting bases")
+	}
+	if _, err := extendsDomain(t, `
+name: Name
+leaf: string
+`, `
+name: User
+extends: [Name]
+`); err == nil {
+		t.Fatal("must only extend maps")
+	}
+	if _, err := extendsDomain(t, `
+name: A
+extends: [B]
+mapOf: {}
+`, `
+name: B
+extends: [A]
+mapOf: {}
+`); err == nil {
+		t.Fatal("must detect cyclic extension")
+	}
+}
+
+func TestExtends__Accepts(t *testing.T) {
+	dom, err := extendsDomain(t, baseTypeYAML, `
+name: User
+extends: [Entity]
+mapOf:
+  name:
+    leaf: string
+`, `
+name: Lookalike
+mapOf:
+  id:
+    leaf: string
+  name:
+    leaf: string
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, _ := dom.GetType("a.Entity")
+	user, _ := dom.GetType("a.User")
+	lookalike, _ := dom.GetType("a.Lookalike")
+	if ok, err := entity.Accepts(user); !ok || err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := entity.Accepts(lookalike); ok || err == nil {
+		t.Fatal("must only accept derived types")
+	}
+
+	// References to the types keep their derivation.
+	v1 := mustParseType(`{"listOf": {"reference": "a.Entity"}}`, dom)
+	v2 := mustParseType(`{"listOf": {"reference": "a.User"}}`, dom)
+	if ok, err := v1.Accepts(v2); !ok || err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtends__Recompile(t *testing.T) {
+	dom, err := extendsDomain(t, baseTypeYAML, `
+name: User
+extends: [Entity]
+mapOf:
+  name:
+    leaf: string
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, _ := dom.GetType("a.Entity")
+	entity.MapOf["created"] = &RawType{Leaf: LeafTime}
+	if err := entity.Compile(dom, entity.Domain, true); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := dom.GetType("a.User")
+	if user.Compiled.MapOf["created"] == nil {
+		t.Fatal(user.Compiled)
+	}
+}
+
+func TestType_Extend(t *testing.T) {
+	base := mustParseType(`{"mapOf": {"id": {"leaf": "string"}}}`, nil)
+	base.FullName = "base"
+	derived := mustParseType(`{"mapOf": {"name": {"leaf": "string"}}}`, nil)
+	if err := derived.Extend(base); err != nil {
+		t.Fatal(err)
+	}
+	if len(derived.Compiled.MapOf) != 2 || derived.Extends[0] != "base" {
+		t.Fatal(derived.Compiled)
+	}
+	if err := mustParseType(`{"leaf": "string"}`, nil).Extend(base); err == nil {
+		t.Fatal("must require map")
+	}
+}
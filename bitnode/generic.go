@@ -0,0 +1,70 @@
+package bitnode
+
+import (
+	"fmt"
+	"github.com/Bitspark/go-bitnode/util"
+)
+
+// checkParameters checks that the type parameters of a generic type are valid and unique.
+func (t *RawType) checkParameters() error {
+	names := map[string]bool{}
+	for _, param := range t.Parameters {
+		if param == "" || !util.CheckString(util.CharsAlphaNum+"_", param, true) {
+			return fmt.Errorf("invalid type parameter: %q", param)
+		}
+		if names[param] {
+			return fmt.Errorf("duplicate type parameter: %s", param)
+		}
+		names[param] = true
+	}
+	return nil
+}
+
+// substitute returns a copy of t with each slot replaced by the argument bound to its type parameter. Slots without
+// a binding are kept. The name and optionality of a slot are retained.
+func (t *RawType) substitute(bindings map[string]*RawType) *RawType {
+	if t.Generic != "" {
+		arg, ok := bindings[t.Generic]
+		if !ok {
+			return t.Copy()
+		}
+		sub := arg.Copy()
+		sub.Name = t.Name
+		sub.Optional = sub.Optional || t.Optional
+		if t.Description != "" {
+			sub.Description = t.Description
+		}
+		if t.Default != nil {
+			sub.Default = t.Default
+		}
+		return sub
+	}
+	t2 := t.Copy()
+	if t.MapOf != nil {
+		t2.MapOf = map[string]*RawType{}
+		for k, v := range t.MapOf {
+			t2.MapOf[k] = v.substitute(bindings)
+		}
+	}
+	if t.ListOf != nil {
+		t2.ListOf = t.ListOf.substitute(bindings)
+	}
+	if t.DictOf != nil {
+		t2.DictOf = t.DictOf.substitute(bindings)
+	}
+	t2.TupleOf = substituteAll(t.TupleOf, bindings)
+	t2.OneOf = substituteAll(t.OneOf, bindings)
+	t2.Arguments = substituteAll(t.Arguments, bindings)
+	return t2
+}
+
+func substituteAll(ts []*RawType, bindings map[string]*RawType) []*RawType {
+	if ts == nil {
+		return nil
+	}
+	subs := make([]*RawType, len(ts))
+	for i, v := range ts {
+		subs[i] = v.substitute(bindings)
+	}
+	return subs
+}
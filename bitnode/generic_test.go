@@ -0,0 +1,141 @@
+package bitnode
+
+import (
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func genericDomain(t *testing.T) *Domain {
+	dom := NewDomain()
+	for _, typeYAML := range []string{`
+name: Page
+parameters: [T]
+mapOf:
+  items:
+    listOf:
+      generic: T
+  next:
+    generic: T
+    optional: true
+`, `
+name: Result
+parameters: [T, E]
+oneOf:
+  - generic: T
+  - generic: E
+`, `
+name: Item
+mapOf:
+  id:
+    leaf: string
+`} {
+		var tp Type
+		if err := yaml.Unmarshal([]byte(typeYAML), &tp.RawType); err != nil {
+			t.Fatal(err)
+		}
+		_ = dom.addType(&tp)
+	}
+	if err := dom.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	return dom
+}
+
+func TestGeneric__Instantiate(t *testing.T) {
+	dom := genericDomain(t)
+
+	v1 := mustParseType(`{"reference": "Page", "arguments": [{"reference": "Item"}]}`, dom)
+	items := v1.Compiled.MapOf["items"].ListOf
+	if items.MapOf == nil || items.MapOf["id"].Leaf != LeafString {
+		t.Fatal(items)
+	}
+	if next := v1.Compiled.MapOf["next"]; !next.Optional || next.Name != "next" || next.MapOf == nil {
+		t.Fatal(next)
+	}
+	if _, err := v1.Compiled.ApplyMiddlewares(nil, map[string]any{"items": []any{map[string]any{"id": "a"}}}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v1.Compiled.ApplyMiddlewares(nil, map[string]any{"items": []any{map[string]any{"id": 1}}}, true); err == nil {
+		t.Fatal("must check items against the argument")
+	}
+
+	v2 := mustParseType(`{"reference": "Result", "arguments": [{"leaf": "integer"}, {"leaf": "string"}]}`, dom)
+	if v, err := v2.Compiled.ApplyMiddlewares(nil, 1.0, true); err != nil || v != int64(1) {
+		t.Fatal(v, err)
+	}
+	if _, err := v2.Compiled.ApplyMiddlewares(nil, true, true); err == nil {
+		t.Fatal("must match one of the arguments")
+	}
+
+	// The definition itself cannot hold values.
+	page, _ := dom.GetType("Page")
+	if _, err := page.Compiled.ApplyMiddlewares(nil, map[string]any{"items": []any{"a"}}, true); err == nil {
+		t.Fatal("must not accept values for unbound type parameters")
+	}
+}
+
+func TestGeneric__Errors(t *testing.T) {
+	dom := genericDomain(t)
+
+	if _, err := parseType(`{"generic": "T"}`, dom); err == nil {
+		t.Fatal("must detect unbound type parameter")
+	}
+	if _, err := parseType(`{"reference": "Page"}`, dom); err == nil {
+		t.Fatal("must require type arguments")
+	}
+	if _, err := parseType(`{"reference": "Page", "arguments": [{"leaf": "string"}, {"leaf": "string"}]}`, dom); err == nil {
+		t.Fatal("must check number of type arguments")
+	}
+	if _, err := parseType(`{"reference": "Item", "arguments": [{"leaf": "string"}]}`, dom); err == nil {
+		t.Fatal("must not accept type arguments for non-generic types")
+	}
+	if _, err := parseType(`{"parameters": ["T", "T"], "generic": "T"}`, dom); err == nil {
+		t.Fatal("must not accept duplicate type parameters")
+	}
+}
+
+func TestGeneric__Recompile(t *testing.T) {
+	dom := genericDomain(t)
+	v1 := &Type{RawType: RawType{Name: "Items", Reference: "Page", Arguments: []*RawType{{Leaf: LeafString}}}}
+	_ = dom.addType(v1)
+	if err := v1.Compile(dom, "", true); err != nil {
+		t.Fatal(err)
+	}
+
+	page, _ := dom.GetType("Page")
+	page.MapOf["count"] = &RawType{Leaf: LeafInteger}
+	if err := page.Compile(dom, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if v1.Compiled.MapOf["count"] == nil || v1.Compiled.MapOf["items"].ListOf.Leaf != LeafString {
+		t.Fatal(v1.Compiled)
+	}
+}
+
+func TestGeneric__YAML(t *testing.T) {
+	tp := Type{}
+	if err := yaml.Unmarshal([]byte(`$Page<$Result<string, $Item>, <T> | integer>`), &tp); err != nil {
+		t.Fatal(err)
+	}
+	if tp.Reference != "Page" || len(tp.Arguments) != 2 {
+		t.Fatal(tp.RawType)
+	}
+	if arg := tp.Arguments[0]; arg.Reference != "Result" || arg.Arguments[0].Leaf != LeafString || arg.Arguments[1].Reference != "Item" {
+		t.Fatal(arg)
+	}
+	if arg := tp.Arguments[1]; len(arg.OneOf) != 2 || arg.OneOf[0].Generic != "T" || arg.OneOf[1].Leaf != LeafInteger {
+		t.Fatal(arg)
+	}
+
+	yamlBts, err := yaml.Marshal(&tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(yamlBts) != "$Page<$Result<string, $Item>, <T> | integer>\n" {
+		t.Fatal(string(yamlBts))
+	}
+
+	if err := yaml.Unmarshal([]byte(`$Page<string`), &tp); err == nil {
+		t.Fatal("must detect missing >")
+	}
+}
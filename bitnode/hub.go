@@ -1,17 +1,50 @@
 package bitnode
 
 import (
+	"context"
 	"fmt"
 	"github.com/Bitspark/go-bitnode/util"
 	"sync"
 	"time"
 )
 
+// A ValueLog is an entry in the history of a hub.
 type ValueLog struct {
+	// Seq is the sequence number of the entry, starting at 1 and increasing with every value.
+	Seq uint64
+
+	// ID of the value as passed through the hub.
+	ID string
+
+	// Time the value has been recorded.
 	Time  time.Time
 	Value any
 }
 
+// ReplayFrom selects previously recorded values which are replayed to a new subscription.
+type ReplayFrom struct {
+	// Seq replays all values with a sequence number of at least Seq.
+	Seq uint64
+
+	// Time replays all values recorded at or after Time.
+	Time time.Time
+}
+
+// IsZero returns true if nothing is to be replayed.
+func (r ReplayFrom) IsZero() bool {
+	return r.Seq == 0 && r.Time.IsZero()
+}
+
+func (r ReplayFrom) includes(l ValueLog) bool {
+	if r.Seq != 0 && l.Seq < r.Seq {
+		return false
+	}
+	if !r.Time.IsZero() && l.Time.Before(r.Time) {
+		return false
+	}
+	return true
+}
+
 type Hub interface {
 	// Name returns the name of the hub.
 	Name() string
@@ -30,20 +63,35 @@ type Hub interface {
 	// Invoke triggers an invocation of the hub.
 	Invoke(user *User, value ...HubItem) ([]HubItem, error)
 
+	// InvokeContext triggers an invocation of the hub which is cancelled when ctx is done.
+	InvokeContext(ctx context.Context, user *User, value ...HubItem) ([]HubItem, error)
+
+	// Stream opens a stream on the hub which ends when ctx is done. Requires this hub to be a stream hub.
+	Stream(ctx context.Context, user *User, value ...HubItem) (Stream, error)
+
 	// Subscribe adds a callback to this hub which is called when values are pushed into the hub.
 	Subscribe(impl SubscribeImpl) (string, error)
 
+	// SubscribeFrom adds a callback like Subscribe, but first replays recorded values selected by from.
+	SubscribeFrom(impl SubscribeImpl, from ReplayFrom) (string, error)
+
 	// Unsubscribe removes a callback previously attached via Subscribe.
 	Unsubscribe(subID string) error
 
 	// Handle sets the invocation routine.
 	Handle(impl FunctionImpl) error
 
+	// HandleStream sets the routine serving streams opened on the hub.
+	HandleStream(impl StreamImpl) error
+
 	// Set sets a set of values. Requires this hub to be a value hub.
 	Set(id string, val HubItem) error
 
 	// Get returns the current value, provided it is a value hub, returns an error otherwise.
 	Get() (HubItem, error)
+
+	// History returns the recorded values selected by from.
+	History(from ReplayFrom) []ValueLog
 }
 
 type SubscribeImpl interface {
@@ -73,31 +121,131 @@ func NewNativeSubscription(cb func(id string, creds Credentials, val HubItem)) S
 	}
 }
 
+// A SeqSubscribeImpl is a SubscribeImpl which receives the sequence numbers values have been recorded with.
+type SeqSubscribeImpl interface {
+	SubscribeImpl
+	CBSeq(seq uint64, id string, creds Credentials, val HubItem) error
+}
+
+type nativeSeqSubscription struct {
+	nativeSubscription
+	seqCB func(seq uint64, id string, creds Credentials, val HubItem)
+}
+
+func (n *nativeSeqSubscription) CBSeq(seq uint64, id string, creds Credentials, val HubItem) error {
+	n.seqCB(seq, id, creds, val)
+	return nil
+}
+
+var _ SeqSubscribeImpl = &nativeSeqSubscription{}
+
+// NewNativeSeqSubscription creates a subscription whose callback receives the sequence numbers of the values.
+func NewNativeSeqSubscription(cb func(seq uint64, id string, creds Credentials, val HubItem)) SubscribeImpl {
+	return &nativeSeqSubscription{
+		nativeSubscription: nativeSubscription{
+			cb: func(id string, creds Credentials, val HubItem) {
+				cb(0, id, creds, val)
+			},
+		},
+		seqCB: cb,
+	}
+}
+
+// hubSubscription is a subscription to a hub.
+type hubSubscription struct {
+	impl SubscribeImpl
+
+	// replayed is the sequence number of the last value recorded before the subscription, so that it is not broadcast
+	// after having been replayed.
+	replayed uint64
+
+	// mux serializes the callbacks of the subscription, so that replayed values precede broadcast values.
+	mux sync.Mutex
+}
+
+// call passes a value to the subscription. Requires mux to be locked.
+func (s *hubSubscription) call(seq uint64, id string, creds Credentials, val HubItem) {
+	if impl, ok := s.impl.(SeqSubscribeImpl); ok {
+		_ = impl.CBSeq(seq, id, creds, val)
+	} else {
+		_ = s.impl.CB(id, creds, val)
+	}
+}
+
 type FunctionImpl interface {
 	Name() string
 	CB(creds Credentials, vals ...HubItem) ([]HubItem, error)
 }
 
+// A ContextFunctionImpl is a FunctionImpl which receives the context of the invocation.
+type ContextFunctionImpl interface {
+	FunctionImpl
+	CBContext(ctx context.Context, creds Credentials, vals ...HubItem) ([]HubItem, error)
+}
+
 type nativeFunction struct {
-	cb func(creds Credentials, vals ...HubItem) ([]HubItem, error)
+	cb    func(creds Credentials, vals ...HubItem) ([]HubItem, error)
+	ctxCb func(ctx context.Context, creds Credentials, vals ...HubItem) ([]HubItem, error)
 }
 
-var _ FunctionImpl = &nativeFunction{}
+var _ ContextFunctionImpl = &nativeFunction{}
 
 func (n *nativeFunction) Name() string {
 	return "native"
 }
 
 func (n *nativeFunction) CB(creds Credentials, vals ...HubItem) ([]HubItem, error) {
+	if n.cb == nil {
+		return n.ctxCb(context.Background(), creds, vals...)
+	}
 	return n.cb(creds, vals...)
 }
 
+func (n *nativeFunction) CBContext(ctx context.Context, creds Credentials, vals ...HubItem) ([]HubItem, error) {
+	if n.ctxCb == nil {
+		return n.cb(creds, vals...)
+	}
+	return n.ctxCb(ctx, creds, vals...)
+}
+
 func NewNativeFunction(cb func(user Credentials, vals ...HubItem) ([]HubItem, error)) FunctionImpl {
 	return &nativeFunction{
 		cb: cb,
 	}
 }
 
+func NewNativeContextFunction(cb func(ctx context.Context, user Credentials, vals ...HubItem) ([]HubItem, error)) ContextFunctionImpl {
+	return &nativeFunction{
+		ctxCb: cb,
+	}
+}
+
+// An InvokeTimeoutError is returned when an invocation has not finished before its deadline.
+type InvokeTimeoutError struct {
+	Hub      string
+	Deadline time.Time
+}
+
+func (e *InvokeTimeoutError) Error() string {
+	return fmt.Sprintf("invocation of %s timed out at %s", e.Hub, e.Deadline.Format(time.RFC3339Nano))
+}
+
+func (e *InvokeTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// invokeError returns the error for an invocation of hub whose context is done.
+func invokeError(ctx context.Context, hub string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		deadline, _ := ctx.Deadline()
+		return &InvokeTimeoutError{
+			Hub:      hub,
+			Deadline: deadline,
+		}
+	}
+	return fmt.Errorf("invocation of %s cancelled: %w", hub, ctx.Err())
+}
+
 // A NativeHub is a node which accepts and distributes values.
 // It also keeps track of previously sent values and offers them to new connections.
 // values sent through a hub have a timestamp attached to them, allowing to keep the orders of values.
@@ -109,18 +257,28 @@ type NativeHub struct {
 	hubInterface *HubInterface
 
 	// subscriptions contains functions which are called when a new value is pushed.
-	subscriptions map[string]SubscribeImpl
+	subscriptions map[string]*hubSubscription
 
 	// function holds the function which is called when the hub is invoked.
 	function FunctionImpl
 
+	// stream holds the function which is called when a stream is opened on the hub.
+	stream StreamImpl
+
 	// value is the value of this hub. If this hub is not a value hub, it remains nil.
 	value HubItem
 
 	// handled contains IDs that have already been processed.
 	handled map[string]bool
 
+	// history contains previously sent values, limited by HubInterface.History.
+	history []ValueLog
+
+	// seq is the sequence number of the last recorded value.
+	seq uint64
+
 	handledMux sync.Mutex
+	historyMux sync.Mutex
 	mux        sync.Mutex
 }
 
@@ -128,9 +286,13 @@ func NewHub(parent *NativeSystem, t *HubInterface) *NativeHub {
 	p := &NativeHub{
 		parent:        parent,
 		hubInterface:  t,
-		subscriptions: map[string]SubscribeImpl{},
+		subscriptions: map[string]*hubSubscription{},
 		handled:       map[string]bool{},
 	}
+	if t != nil && t.Type == HubTypeValue && t.Value != nil && t.Value.Value != nil {
+		// Value hubs start with a valid value.
+		p.value = t.Value.Value.Compiled.DefaultValue()
+	}
 	return p
 }
 
@@ -156,16 +318,20 @@ func (p *NativeHub) Push(creds Credentials, mws Middlewares, id string, val HubI
 	if p.Interface().Type != HubTypeChannel {
 		return fmt.Errorf("require a channel hub")
 	}
+	if err := p.authorize(PermissionExtend, creds, "push"); err != nil {
+		return err
+	}
 	if val == NilItem {
 		return nil
 	}
 	if p.hubInterface == nil {
 		return fmt.Errorf("require interface")
 	}
-	if vval, err := p.hubInterface.Value.ApplyMiddlewares(mws, val, false); err != nil {
+	if vval, err := p.hubInterface.Value.applyMiddlewares(mws, val, false, "value"); err != nil {
 		return err
 	} else {
-		return p.broadcast(id, creds, vval)
+		l := p.record(id, vval)
+		return p.broadcast(l.Seq, id, creds, vval)
 	}
 }
 
@@ -176,6 +342,9 @@ func (p *NativeHub) Emit(creds Credentials, mws Middlewares, id string, val HubI
 	if p.Interface().Type != HubTypeChannel {
 		return fmt.Errorf("require a channel hub")
 	}
+	if err := p.authorize(PermissionExtend, creds, "emit"); err != nil {
+		return err
+	}
 	return p.emit(id, creds, mws, val)
 }
 
@@ -195,44 +364,122 @@ func (p *NativeHub) emit(id string, creds Credentials, mws Middlewares, val HubI
 	}
 	var interf *HubItemInterface
 	interf = p.hubInterface.Value
-	if vval, err := interf.ApplyMiddlewares(mws, val, false); err != nil {
+	if vval, err := interf.applyMiddlewares(mws, val, false, "value"); err != nil {
 		return err
 	} else {
-		if p.Interface().Type == HubTypeValue {
-			p.value = vval
-		}
-		go p.broadcast(id, creds, vval)
+		l := p.record(id, vval)
+		go p.broadcast(l.Seq, id, creds, vval)
 		return nil
 	}
 }
 
-func (p *NativeHub) broadcast(id string, creds Credentials, val HubItem) error {
+func (p *NativeHub) broadcast(seq uint64, id string, creds Credentials, val HubItem) error {
 	p.mux.Lock()
-	for _, cb := range p.subscriptions {
-		_ = cb.CB(id, creds, val)
+	subs := make([]*hubSubscription, 0, len(p.subscriptions))
+	for _, sub := range p.subscriptions {
+		subs = append(subs, sub)
 	}
 	p.mux.Unlock()
+	for _, sub := range subs {
+		sub.mux.Lock()
+		if seq > sub.replayed {
+			sub.call(seq, id, creds, val)
+		}
+		sub.mux.Unlock()
+	}
 	return nil
 }
 
+// record adds a value to the history of the hub and drops values exceeding the retention policy.
+// Value hubs take val as their current value.
+func (p *NativeHub) record(id string, val HubItem) ValueLog {
+	p.historyMux.Lock()
+	defer p.historyMux.Unlock()
+	if p.hubInterface.Type == HubTypeValue {
+		p.value = val
+	}
+	p.seq++
+	l := ValueLog{
+		Seq:   p.seq,
+		ID:    id,
+		Time:  time.Now(),
+		Value: val,
+	}
+	policy := p.hubInterface.History
+	if policy == nil {
+		return l
+	}
+	p.history = append(p.history, l)
+	drop := 0
+	if policy.Size > 0 && len(p.history) > policy.Size {
+		drop = len(p.history) - policy.Size
+	}
+	if policy.Age > 0 {
+		oldest := l.Time.Add(-time.Duration(policy.Age * float64(time.Second)))
+		for drop < len(p.history) && p.history[drop].Time.Before(oldest) {
+			drop++
+		}
+	}
+	if drop > 0 {
+		p.history = append([]ValueLog{}, p.history[drop:]...)
+	}
+	return l
+}
+
+// current returns the current value of a value hub.
+func (p *NativeHub) current() HubItem {
+	p.historyMux.Lock()
+	defer p.historyMux.Unlock()
+	return p.value
+}
+
+// History returns the recorded values selected by from.
+func (p *NativeHub) History(from ReplayFrom) []ValueLog {
+	p.historyMux.Lock()
+	defer p.historyMux.Unlock()
+	return p.historyFrom(from)
+}
+
+// historyFrom returns the recorded values selected by from. Requires historyMux to be locked.
+func (p *NativeHub) historyFrom(from ReplayFrom) []ValueLog {
+	logs := []ValueLog{}
+	for _, l := range p.history {
+		if from.includes(l) {
+			logs = append(logs, l)
+		}
+	}
+	return logs
+}
+
 func (p *NativeHub) Invoke(creds Credentials, mws Middlewares, vals ...HubItem) ([]HubItem, error) {
+	return p.InvokeContext(context.Background(), creds, mws, vals...)
+}
+
+// InvokeContext invokes the hub and returns as soon as ctx is done, even if the invocation callback is still running.
+func (p *NativeHub) InvokeContext(ctx context.Context, creds Credentials, mws Middlewares, vals ...HubItem) ([]HubItem, error) {
 	if p.Interface().Type != HubTypePipe {
 		return nil, fmt.Errorf("require a pipe hub")
 	}
 	if p.hubInterface == nil {
 		return nil, fmt.Errorf("require interface")
 	}
-	if vvals, err := p.hubInterface.Input.ApplyMiddlewares(mws, false, vals...); err != nil {
+	if err := p.authorize(PermissionExtend, creds, "invoke"); err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, invokeError(ctx, p.Name())
+	}
+	if vvals, err := p.hubInterface.Input.applyMiddlewares(mws, false, "input", vals...); err != nil {
 		return nil, err
 	} else {
 		if p.function == nil {
 			return nil, fmt.Errorf("[system %s %s] have no invoke callback for %s", p.parent.id.Hex(), p.parent.name, p.Name())
 		}
-		rets, err := p.function.CB(creds, vvals...)
+		rets, err := p.call(ctx, creds, vvals...)
 		if err != nil {
 			return nil, err
 		}
-		if vrets, err := p.hubInterface.Output.ApplyMiddlewares(mws, true, rets...); err != nil {
+		if vrets, err := p.hubInterface.Output.applyMiddlewares(mws, true, "output", rets...); err != nil {
 			return nil, err
 		} else {
 			return vrets, nil
@@ -240,10 +487,36 @@ func (p *NativeHub) Invoke(creds Credentials, mws Middlewares, vals ...HubItem)
 	}
 }
 
+// call runs the invocation callback and waits for it to return or for ctx to be done.
+func (p *NativeHub) call(ctx context.Context, creds Credentials, vals ...HubItem) ([]HubItem, error) {
+	type result struct {
+		rets []HubItem
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		if f, ok := p.function.(ContextFunctionImpl); ok {
+			r.rets, r.err = f.CBContext(ctx, creds, vals...)
+		} else {
+			r.rets, r.err = p.function.CB(creds, vals...)
+		}
+		done <- r
+	}()
+	select {
+	case r := <-done:
+		if ctx.Err() != nil {
+			return nil, invokeError(ctx, p.Name())
+		}
+		return r.rets, r.err
+	case <-ctx.Done():
+		return nil, invokeError(ctx, p.Name())
+	}
+}
+
 func (p *NativeHub) Handle(proc FunctionImpl) error {
 	if p.function != nil {
 		panic("already have handle function")
-		return nil
 	}
 	if p.Interface().Type != HubTypePipe {
 		return fmt.Errorf("require a pipe hub")
@@ -252,18 +525,95 @@ func (p *NativeHub) Handle(proc FunctionImpl) error {
 	return nil
 }
 
+// Stream opens a stream on the hub. The stream handler is cancelled when ctx is done.
+func (p *NativeHub) Stream(ctx context.Context, creds Credentials, mws Middlewares, vals ...HubItem) (Stream, error) {
+	if p.Interface().Type != HubTypeStream {
+		return nil, fmt.Errorf("require a stream hub")
+	}
+	if p.hubInterface == nil {
+		return nil, fmt.Errorf("require interface")
+	}
+	if p.hubInterface.Value == nil {
+		return nil, fmt.Errorf("stream hub %s requires value", p.Name())
+	}
+	if err := p.authorize(PermissionExtend, creds, "stream"); err != nil {
+		return nil, err
+	}
+	vvals, err := p.hubInterface.Input.applyMiddlewares(mws, false, "input", vals...)
+	if err != nil {
+		return nil, err
+	}
+	if p.stream == nil {
+		return nil, fmt.Errorf("[system %s %s] have no stream callback for %s", p.parent.id.Hex(), p.parent.name, p.Name())
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	caller, handler := NewStreamPair(sctx, StreamWindow)
+	caller.(*nativeStream).onEnd = cancel
+	go func() {
+		err := p.stream.CB(sctx, creds, handler, vvals...)
+		_ = handler.Close(err)
+	}()
+	return &typedStream{
+		Stream: caller,
+		mws:    mws,
+		recv:   p.hubInterface.Value,
+		send:   p.hubInterface.Upstream,
+	}, nil
+}
+
+func (p *NativeHub) HandleStream(impl StreamImpl) error {
+	if p.stream != nil {
+		panic("already have stream function")
+	}
+	if p.Interface().Type != HubTypeStream {
+		return fmt.Errorf("require a stream hub")
+	}
+	p.stream = impl
+	return nil
+}
+
 func (p *NativeHub) Subscribe(creds Credentials, mws Middlewares, impl SubscribeImpl) (string, error) {
+	return p.SubscribeFrom(creds, mws, impl, ReplayFrom{})
+}
+
+// SubscribeFrom adds a subscription and replays recorded values selected by from before any new values are passed.
+// If from is zero, value hubs pass their current value instead.
+func (p *NativeHub) SubscribeFrom(creds Credentials, mws Middlewares, impl SubscribeImpl, from ReplayFrom) (string, error) {
 	// TODO: mws!
 	if p.Interface().Type != HubTypeValue && p.Interface().Type != HubTypeChannel {
 		return "", fmt.Errorf("require a value or channel hub")
 	}
+	if err := p.authorize(PermissionView, creds, "subscribe"); err != nil {
+		return "", err
+	}
 	hubType := p.Interface().Type
 	subID := util.RandomString(util.CharsAlphaNum, 8)
+
+	// Values recorded from now on are broadcast to the subscription once the callbacks below have returned.
+	sub := &hubSubscription{impl: impl}
+	sub.mux.Lock()
+	defer sub.mux.Unlock()
 	p.mux.Lock()
-	p.subscriptions[subID] = impl
+	p.subscriptions[subID] = sub
 	p.mux.Unlock()
-	if hubType == HubTypeValue {
-		_ = impl.CB(util.RandomString(util.CharsAlphaNum, 8), creds, p.value)
+
+	p.historyMux.Lock()
+	sub.replayed = p.seq
+	value := p.value
+	var logs []ValueLog
+	if !from.IsZero() {
+		logs = p.historyFrom(from)
+	}
+	p.historyMux.Unlock()
+
+	if from.IsZero() {
+		if hubType == HubTypeValue {
+			sub.call(sub.replayed, util.RandomString(util.CharsAlphaNum, 8), creds, value)
+		}
+		return subID, nil
+	}
+	for _, l := range logs {
+		sub.call(l.Seq, l.ID, creds, l.Value)
 	}
 	return subID, nil
 }
@@ -289,16 +639,54 @@ func (p *NativeHub) Set(creds Credentials, mws Middlewares, id string, val HubIt
 	if p.Interface().Type != HubTypeValue {
 		return fmt.Errorf("require a value hub")
 	}
-	p.value = val
-	return p.emit(id, creds, mws, p.value)
+	if err := p.authorize(PermissionExtend, creds, "set"); err != nil {
+		return err
+	}
+	if err := p.set(creds, mws, id, val); err != nil {
+		return err
+	}
+	if p.persistent() {
+		return p.parent.persistHub(p, p.current())
+	}
+	return nil
+}
+
+// set changes the value without persisting it. The value is only changed if it is valid.
+func (p *NativeHub) set(creds Credentials, mws Middlewares, id string, val HubItem) error {
+	if id == "" {
+		id = util.RandomString(util.CharsAlphaNum, 8)
+	}
+	if err := p.emit(id, creds, mws, val); err != nil {
+		return err
+	}
+	if p.parent != nil {
+		p.parent.touch()
+	}
+	return nil
+}
+
+// persistent reveals if the value of this hub is written to the persist store of the node whenever it is set.
+func (p *NativeHub) persistent() bool {
+	return p.parent != nil && (p.hubInterface.Persistent || p.parent.Persistent())
 }
 
 func (p *NativeHub) Get(creds Credentials, mws Middlewares) (HubItem, error) {
-	// TODO: creds and mws!
+	// TODO: mws!
 	if p.Interface().Type != HubTypeValue {
 		return nil, fmt.Errorf("require a value hub")
 	}
-	return p.value, nil
+	if err := p.authorize(PermissionView, creds, "get"); err != nil {
+		return nil, err
+	}
+	return p.current(), nil
+}
+
+// authorize checks creds against the permissions of the parent system.
+func (p *NativeHub) authorize(permission string, creds Credentials, action string) error {
+	if p.parent == nil {
+		return nil
+	}
+	return p.parent.permissions.Authorize(permission, creds, fmt.Sprintf("hub %s of system %s", p.Name(), p.parent.id.Hex()), action)
 }
 
 type CredHub struct {
@@ -317,18 +705,56 @@ func (c CredHub) Emit(id string, val HubItem) error {
 	return c.NativeHub.Emit(c.creds, c.mws, id, val)
 }
 
+// userCreds returns the credentials of the hub acting on behalf of user, if provided. Acting on behalf of another
+// user requires admin permission.
+func (c CredHub) userCreds(user *User) (Credentials, error) {
+	creds := c.creds
+	if user == nil || *user == creds.User {
+		return creds, nil
+	}
+	if err := c.NativeHub.authorize(PermissionAdmin, creds, "act on behalf of "+user.ID.Hex()); err != nil {
+		return Credentials{}, err
+	}
+	creds.User = *user
+	return creds, nil
+}
+
 func (c CredHub) Invoke(user *User, value ...HubItem) ([]HubItem, error) {
-	cred := c.creds
-	if user != nil {
-		cred.User = *user
+	cred, err := c.userCreds(user)
+	if err != nil {
+		return nil, err
 	}
 	return c.NativeHub.Invoke(cred, c.mws, value...)
 }
 
+func (c CredHub) InvokeContext(ctx context.Context, user *User, value ...HubItem) ([]HubItem, error) {
+	cred, err := c.userCreds(user)
+	if err != nil {
+		return nil, err
+	}
+	return c.NativeHub.InvokeContext(ctx, cred, c.mws, value...)
+}
+
+func (c CredHub) Stream(ctx context.Context, user *User, value ...HubItem) (Stream, error) {
+	cred, err := c.userCreds(user)
+	if err != nil {
+		return nil, err
+	}
+	return c.NativeHub.Stream(ctx, cred, c.mws, value...)
+}
+
+func (c CredHub) HandleStream(impl StreamImpl) error {
+	return c.NativeHub.HandleStream(impl)
+}
+
 func (c CredHub) Subscribe(impl SubscribeImpl) (string, error) {
 	return c.NativeHub.Subscribe(c.creds, c.mws, impl)
 }
 
+func (c CredHub) SubscribeFrom(impl SubscribeImpl, from ReplayFrom) (string, error) {
+	return c.NativeHub.SubscribeFrom(c.creds, c.mws, impl, from)
+}
+
 func (c CredHub) Unsubscribe(subID string) error {
 	return c.NativeHub.Unsubscribe(subID)
 }
@@ -352,6 +778,7 @@ const (
 	HubTypeChannel = HubType("channel")
 	HubTypeValue   = HubType("value")
 	HubTypePipe    = HubType("pipe")
+	HubTypeStream  = HubType("stream")
 )
 
 const (
@@ -1,6 +1,10 @@
 package bitnode
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"testing"
 	"time"
 )
@@ -62,8 +66,9 @@ func TestPortPolicyValue(t *testing.T) {
 	creds := Credentials{}
 	mws := Middlewares{}
 
-	if val, err := p.Get(creds, mws); err != nil || val != nil {
-		t.Fatal()
+	// Value hubs start with the default value of their type.
+	if val, err := p.Get(creds, mws); err != nil || val != int64(0) {
+		t.Fatal(val, err)
 	}
 
 	if err := p.Set(creds, mws, "", 1); err != nil {
@@ -101,3 +106,323 @@ func TestPortPolicyValue(t *testing.T) {
 		t.Fatal(val)
 	}
 }
+
+func TestHubValueConcurrent(t *testing.T) {
+	vt := &Type{RawType: RawType{
+		Leaf: LeafInteger,
+	}}
+	vt.Compile(nil, "", false)
+	p := NewHub(nil, &HubInterface{
+		Value: &HubItemInterface{
+			Value: vt,
+		},
+		Type:      HubTypeValue,
+		Direction: HubDirectionBoth,
+	})
+
+	creds := Credentials{}
+	mws := Middlewares{}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 100; i++ {
+			_ = p.Set(creds, mws, fmt.Sprintf("v%d", i), i)
+		}
+	}()
+
+	// Subscriptions receive the value belonging to the sequence number they were replayed up to.
+	for i := 0; i < 20; i++ {
+		var replayed uint64
+		var replayedVal HubItem
+		p.Subscribe(creds, mws, NewNativeSeqSubscription(func(seq uint64, id string, creds Credentials, val HubItem) {
+			if replayedVal == nil {
+				replayed, replayedVal = seq, val
+			}
+		}))
+		if replayedVal != int64(replayed) {
+			t.Fatal(replayed, replayedVal)
+		}
+		if _, err := p.Get(creds, mws); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func TestHubHistory(t *testing.T) {
+	vt := &Type{RawType: RawType{
+		Leaf: LeafInteger,
+	}}
+	vt.Compile(nil, "", false)
+	p := NewHub(nil, &HubInterface{
+		Value: &HubItemInterface{
+			Value: vt,
+		},
+		Type:      HubTypeChannel,
+		Direction: HubDirectionIn,
+		History: &HubHistory{
+			Size: 3,
+		},
+	})
+	creds := Credentials{}
+	mws := Middlewares{}
+	for i := 1; i <= 5; i++ {
+		if err := p.Push(creds, mws, "", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logs := p.History(ReplayFrom{})
+	if len(logs) != 3 || logs[0].Seq != 3 || logs[0].Value != int64(3) || logs[2].Seq != 5 {
+		t.Fatal(logs)
+	}
+
+	vals1 := []any{}
+	p.SubscribeFrom(creds, mws, NewNativeSubscription(func(id string, creds Credentials, val HubItem) {
+		vals1 = append(vals1, val)
+	}), ReplayFrom{Seq: 4})
+	if len(vals1) != 2 || vals1[0] != int64(4) || vals1[1] != int64(5) {
+		t.Fatal(vals1)
+	}
+
+	p.Push(creds, mws, "", 6)
+	if len(vals1) != 3 || vals1[2] != int64(6) {
+		t.Fatal(vals1)
+	}
+
+	// Callbacks receive sequence numbers and may use the hub while values are replayed.
+	seqs := []uint64{}
+	p.SubscribeFrom(creds, mws, NewNativeSeqSubscription(func(seq uint64, id string, creds Credentials, val HubItem) {
+		seqs = append(seqs, seq)
+		if seq == 5 {
+			subID, _ := p.Subscribe(creds, mws, NewNativeSubscription(func(id string, creds Credentials, val HubItem) {}))
+			_ = p.Unsubscribe(subID)
+		}
+	}), ReplayFrom{Seq: 5})
+	p.Push(creds, mws, "", 7)
+	if len(seqs) != 3 || seqs[0] != 5 || seqs[1] != 6 || seqs[2] != 7 {
+		t.Fatal(seqs)
+	}
+}
+
+func TestHubSeq(t *testing.T) {
+	vt := &Type{RawType: RawType{
+		Leaf: LeafInteger,
+	}}
+	vt.Compile(nil, "", false)
+	p := NewHub(nil, &HubInterface{
+		Value: &HubItemInterface{
+			Value: vt,
+		},
+		Type:      HubTypeChannel,
+		Direction: HubDirectionIn,
+	})
+
+	// Values are numbered even if the hub does not record them.
+	seqs := []uint64{}
+	p.Subscribe(Credentials{}, Middlewares{}, NewNativeSeqSubscription(func(seq uint64, id string, creds Credentials, val HubItem) {
+		seqs = append(seqs, seq)
+	}))
+	for i := 1; i <= 2; i++ {
+		if err := p.Push(Credentials{}, Middlewares{}, "", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(seqs) != 2 || seqs[0] != 1 || seqs[1] != 2 {
+		t.Fatal(seqs)
+	}
+	if logs := p.History(ReplayFrom{Seq: 1}); len(logs) != 0 {
+		t.Fatal(logs)
+	}
+}
+
+func TestHubInvokeContext(t *testing.T) {
+	vt := &Type{RawType: RawType{
+		Leaf: LeafInteger,
+	}}
+	vt.Compile(nil, "", false)
+	p := NewHub(nil, &HubInterface{
+		Input: HubItemsInterface{
+			{Value: vt},
+		},
+		Output: HubItemsInterface{
+			{Value: vt},
+		},
+		Type:      HubTypePipe,
+		Direction: HubDirectionIn,
+	})
+	cancelled := make(chan bool, 1)
+	_ = p.Handle(NewNativeContextFunction(func(ctx context.Context, creds Credentials, vals ...HubItem) ([]HubItem, error) {
+		if vals[0] == int64(0) {
+			return vals, nil
+		}
+		<-ctx.Done()
+		cancelled <- true
+		return nil, ctx.Err()
+	}))
+	creds := Credentials{}
+	mws := Middlewares{}
+
+	if rets, err := p.InvokeContext(context.Background(), creds, mws, 0); err != nil || rets[0] != int64(0) {
+		t.Fatal(rets, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := p.InvokeContext(ctx, creds, mws, 1)
+	var timeoutErr *InvokeTimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("handler not cancelled")
+	}
+}
+
+func TestHubStream(t *testing.T) {
+	vt := &Type{RawType: RawType{
+		Leaf: LeafInteger,
+	}}
+	vt.Compile(nil, "", false)
+	p := NewHub(nil, &HubInterface{
+		Input: HubItemsInterface{
+			{Value: vt},
+		},
+		Value:     &HubItemInterface{Value: vt},
+		Upstream:  &HubItemInterface{Value: vt},
+		Type:      HubTypeStream,
+		Direction: HubDirectionIn,
+	})
+	_ = p.HandleStream(NewNativeStreamFunction(func(ctx context.Context, creds Credentials, stream Stream, vals ...HubItem) error {
+		n := vals[0].(int64)
+		for i := int64(0); i < n; i++ {
+			if err := stream.Send(i); err != nil {
+				return err
+			}
+		}
+		for {
+			val, err := stream.Receive()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if val == int64(-1) {
+				return fmt.Errorf("negative")
+			}
+			if err := stream.Send(val.(int64) * 2); err != nil {
+				return err
+			}
+		}
+	}))
+	creds := Credentials{}
+	mws := Middlewares{}
+
+	stream, err := p.Stream(context.Background(), creds, mws, 3*StreamWindow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 3*StreamWindow; i++ {
+		if val, err := stream.Receive(); err != nil || val != i {
+			t.Fatal(val, err)
+		}
+	}
+	if err := stream.Send(21); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := stream.Receive(); err != nil || val != int64(42) {
+		t.Fatal(val, err)
+	}
+	_ = stream.Close(nil)
+	if val, err := stream.Receive(); err != io.EOF {
+		t.Fatal(val, err)
+	}
+
+	stream, err = p.Stream(context.Background(), creds, mws, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = stream.Send(-1)
+	if _, err := stream.Receive(); err == nil || err.Error() != "negative" {
+		t.Fatal(err)
+	}
+}
+
+func TestHubPermissions(t *testing.T) {
+	vt := &Type{RawType: RawType{
+		Leaf: LeafInteger,
+	}}
+	spk := Sparkable{RawSparkable: RawSparkable{
+		Name: "Counter",
+		Permissions: &Permissions{
+			View: PermissionGroup{Public: true},
+		},
+		Interface: &Interface{RawInterface: RawInterface{
+			Hubs: &HubInterfaces{
+				{
+					Name:      "count",
+					Type:      HubTypeValue,
+					Direction: HubDirectionBoth,
+					Value:     &HubItemInterface{Value: vt},
+				},
+			},
+		}},
+	}}
+	if err := spk.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	owner := Credentials{User: User{ID: ComposeIDs(GenerateSystemID(), GenerateObjectID()), Name: "owner"}}
+	stranger := Credentials{User: User{ID: ComposeIDs(GenerateSystemID(), GenerateObjectID()), Name: "stranger"}}
+
+	var denied []AuditEntry
+	removeAuditor := AddAuditor(NewNativeAudit(func(entry AuditEntry) {
+		if entry.Creds.User.ID == stranger.User.ID {
+			denied = append(denied, entry)
+		}
+	}))
+	defer removeAuditor()
+
+	node := NewNode()
+	sys, err := node.PrepareSystem(owner, spk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	native := sys.Native()
+
+	if err := native.GetHub(owner, nil, "count").Set("", int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := native.GetHub(stranger, nil, "count").Get(); err != nil || val != int64(1) {
+		t.Fatal(val, err)
+	}
+
+	err = native.GetHub(stranger, nil, "count").Set("", int64(2))
+	var permErr *PermissionError
+	if !errors.As(err, &permErr) || permErr.Permission != PermissionExtend {
+		t.Fatal(err)
+	}
+	if err := native.Delete(stranger); err == nil {
+		t.Fatal("stranger must not delete the system")
+	}
+	if err := native.Wrap(stranger, nil).SetName("other"); !errors.As(err, &permErr) || permErr.Permission != PermissionAdmin {
+		t.Fatal(err)
+	}
+	if len(denied) != 3 || denied[0].Action != "set" || denied[1].Action != "delete" || denied[2].Action != "set name" {
+		t.Fatal(denied)
+	}
+
+	if val, _ := native.GetHub(owner, nil, "count").Get(); val != int64(1) {
+		t.Fatal(val)
+	}
+
+	// Removed auditors are not notified anymore.
+	removeAuditor()
+	_ = native.GetHub(stranger, nil, "count").Set("", int64(3))
+	if len(denied) != 3 {
+		t.Fatal(denied)
+	}
+}
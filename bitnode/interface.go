@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"log"
-	"os"
 	"strings"
 )
 
@@ -28,6 +27,9 @@ type RawInterface struct {
 	// Description of the interface.
 	Description string `json:"description,omitempty" yaml:"description,omitempty"`
 
+	// Version of the interface, a semantic version (e.g., 1.2.0) if set.
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+
 	// Permissions of this interface.
 	Permissions *Permissions `json:"permissions,omitempty" yaml:"permissions,omitempty"`
 
@@ -61,35 +63,18 @@ func NewInterface() *Interface {
 }
 
 func (i *Interface) Save(dom *Domain) error {
-	interf, err := dom.GetDomain(i.Domain)
+	interf, err := dom.lookupDomain(i.Domain)
 	if err != nil {
 		return err
 	}
-	chDefsBytes, err := os.ReadFile(interf.FilePath)
+	f, err := readDefinitionsFile(interf.FilePath)
 	if err != nil {
-		return fmt.Errorf("reading definitions from %s: %v", interf.FilePath, err)
-	}
-
-	defs := Domain{}
-	if err := yaml.Unmarshal(chDefsBytes, &defs); err != nil {
-		return fmt.Errorf("parsing definitions from %s: %v", interf.FilePath, err)
-	}
-
-	for _, inf := range defs.Interfaces {
-		if inf.Name == i.Name {
-			*inf = *i
-		}
+		return err
 	}
-
-	if yamlBts, err := yaml.Marshal(defs); err != nil {
-		return fmt.Errorf("parsing definitions from %s: %v", interf.FilePath, err)
-	} else {
-		if err := os.WriteFile(interf.FilePath, yamlBts, os.ModePerm); err != nil {
-			return err
-		}
+	if err := f.setDefinition(sectionInterfaces, i.Name, i); err != nil {
+		return fmt.Errorf("saving interface %s to %s: %v", i.Name, interf.FilePath, err)
 	}
-
-	return nil
+	return f.write()
 }
 
 // Blank returns an empty sparkable created from this interface.
@@ -111,6 +96,9 @@ func (i *Interface) Reset() {
 			if h.Value != nil {
 				h.Value.Reset()
 			}
+			if h.Upstream != nil {
+				h.Upstream.Reset()
+			}
 			for _, v := range h.Input {
 				v.Reset()
 			}
@@ -152,15 +140,19 @@ func (i *Interface) Compile(dom *Domain, domName string, resolve bool) error {
 		i.FullName = i.Domain + "." + i.Name
 	}
 
+	if err := i.CheckVersion(); err != nil {
+		return err
+	}
+
 	i.CompiledHubs = &HubInterfaces{}
 
 	if resolve && len(i.Extends) > 0 {
-		cdom, _ := dom.GetDomain(domName)
+		cdom, _ := dom.lookupDomain(domName)
 		if cdom == nil {
 			return fmt.Errorf("domain not set")
 		}
 		for _, ext := range i.Extends {
-			ri, err := cdom.GetInterface(ext)
+			ri, err := cdom.lookupInterface(ext)
 			if err != nil {
 				log.Printf("interface %s: %v", ext, err)
 			}
@@ -168,8 +160,11 @@ func (i *Interface) Compile(dom *Domain, domName string, resolve bool) error {
 				return fmt.Errorf("interface not found: %s", i.Extends)
 			}
 			if !ri.compiled {
+				if ri.compiling {
+					return &CycleError{Cycle: []string{i.FullName, ri.FullName}}
+				}
 				if err := ri.Compile(dom, ri.Domain, resolve); err != nil {
-					return err
+					return extendCycle(i.FullName, err)
 				}
 			}
 			ri.references[i] = true
@@ -214,6 +209,11 @@ func (i *Interface) Compile(dom *Domain, domName string, resolve bool) error {
 					return err
 				}
 			}
+			if hub.Upstream != nil {
+				if err := hub.Upstream.Compile(dom, domName, resolve); err != nil {
+					return err
+				}
+			}
 			*i.CompiledHubs = append(*i.CompiledHubs, hub)
 		}
 	}
@@ -246,9 +246,39 @@ func (i *Interface) Extend(base *Interface) error {
 	return nil
 }
 
+// Validate validates the values of value hubs of i given by val, a map from hub names to values.
 func (i *Interface) Validate(val HubItem) (any, error) {
-	// TODO: Implement
-	return val, nil
+	var vals map[string]HubItem
+	switch val := val.(type) {
+	case map[string]HubItem:
+		vals = val
+	case map[string]any:
+		vals = map[string]HubItem{}
+		for k, v := range val {
+			vals[k] = v
+		}
+	default:
+		return nil, fmt.Errorf("expected hub values by name, got %T", val)
+	}
+	validated := map[string]HubItem{}
+	for name, v := range vals {
+		var hub *HubInterface
+		if i.CompiledHubs != nil {
+			hub = i.CompiledHubs.GetHub(name)
+		}
+		if hub == nil {
+			return nil, fmt.Errorf("unknown hub: %s", name)
+		}
+		if hub.Type != HubTypeValue || hub.Value == nil {
+			return nil, fmt.Errorf("hub %s has no value", name)
+		}
+		vv, err := hub.Value.applyMiddlewares(nil, v, false, name)
+		if err != nil {
+			return nil, err
+		}
+		validated[name] = vv
+	}
+	return validated, nil
 }
 
 func (i *Interface) String() string {
@@ -285,7 +315,7 @@ func (i *Interface) UnmarshalJSON(data []byte) error {
 		if len(str) >= 2 && str[0] == '$' {
 			exts := str[1:]
 			if exts[0] == '[' && exts[len(exts)-1] == ']' {
-				for _, ext := range strings.Split(exts, ",") {
+				for _, ext := range strings.Split(exts[1:len(exts)-1], ",") {
 					ext = strings.TrimSpace(ext)
 					i.Extends = append(i.Extends, ext)
 				}
@@ -300,7 +330,16 @@ func (i *Interface) UnmarshalJSON(data []byte) error {
 }
 
 func (i *Interface) MarshalYAML() (interface{}, error) {
-	return i.RawInterface, nil
+	ri := i.RawInterface
+	if len(ri.Extends) > 0 && ri.Name == "" && ri.Description == "" && ri.Version == "" && ri.Permissions == nil &&
+		(ri.Hubs == nil || len(*ri.Hubs) == 0) {
+		// References to interfaces are written as $Name or $[Name1, Name2].
+		if len(ri.Extends) == 1 {
+			return "$" + ri.Extends[0], nil
+		}
+		return "$[" + strings.Join(ri.Extends, ", ") + "]", nil
+	}
+	return ri, nil
 }
 
 func (i *Interface) UnmarshalYAML(value *yaml.Node) error {
@@ -309,7 +348,7 @@ func (i *Interface) UnmarshalYAML(value *yaml.Node) error {
 		if len(str) >= 2 && str[0] == '$' {
 			exts := str[1:]
 			if exts[0] == '[' && exts[len(exts)-1] == ']' {
-				for _, ext := range strings.Split(exts, ",") {
+				for _, ext := range strings.Split(exts[1:len(exts)-1], ",") {
 					ext = strings.TrimSpace(ext)
 					i.Extends = append(i.Extends, ext)
 				}
@@ -366,11 +405,22 @@ type HubInterface struct {
 	Input       HubItemsInterface `json:"input" yaml:"input"`
 	Output      HubItemsInterface `json:"output" yaml:"output"`
 	Value       *HubItemInterface `json:"value" yaml:"value"`
-	Interface   string            `json:"interface" yaml:"-"`
+	Upstream    *HubItemInterface `json:"upstream,omitempty" yaml:"upstream,omitempty"`
+	History     *HubHistory       `json:"history,omitempty" yaml:"history,omitempty"`
+
+	// Persistent value hubs are written to the store of their node whenever they are set.
+	Persistent bool `json:"persistent,omitempty" yaml:"persistent,omitempty"`
+
+	Interface string `json:"interface" yaml:"-"`
 }
 
-func (i *HubInterface) Contains(i2 *HubInterface) error {
-	return nil
+// HubHistory is the retention policy for values recorded by value and channel hubs.
+type HubHistory struct {
+	// Size is the maximum number of values kept, unlimited if 0.
+	Size int `json:"size,omitempty" yaml:"size,omitempty"`
+
+	// Age is the maximum age of values kept in seconds, unlimited if 0.
+	Age float64 `json:"age,omitempty" yaml:"age,omitempty"`
 }
 
 func (i *HubInterface) MarshalYAML() (interface{}, error) {
@@ -389,6 +439,15 @@ func (i *HubInterface) MarshalYAML() (interface{}, error) {
 	if i.Value != nil {
 		mp["value"] = i.Value
 	}
+	if i.Upstream != nil {
+		mp["upstream"] = i.Upstream
+	}
+	if i.History != nil {
+		mp["history"] = i.History
+	}
+	if i.Persistent {
+		mp["persistent"] = i.Persistent
+	}
 	return mp, nil
 }
 
@@ -443,25 +502,9 @@ func (i *HubInterfaces) ToInterface() (any, error) {
 	return datMp, nil
 }
 
+// Contains checks whether the hubs i can replace interf without breaking its clients.
 func (i *HubInterfaces) Contains(interf *HubInterfaces) error {
-	if i == nil || len(*i) == 0 {
-		if interf != nil && len(*interf) != 0 {
-			return fmt.Errorf("hub interfaces not not match empty interfaces")
-		}
-		return nil
-	}
-	if interf != nil {
-		for _, interfHub := range *interf {
-			hub := i.GetHub(interfHub.Name)
-			if hub == nil {
-				return fmt.Errorf("missing hub: %s", interfHub.Name)
-			}
-			if interfHub.Input == nil {
-
-			}
-		}
-	}
-	return nil
+	return interf.Compare(i).Err()
 }
 
 // HubItemInterface
@@ -558,10 +601,15 @@ func (i *HubItemInterface) Contains(h2 *HubItemInterface) error {
 }
 
 func (i *HubItemInterface) ApplyMiddlewares(mws Middlewares, val HubItem, out bool) (HubItem, error) {
+	return i.applyMiddlewares(mws, val, out, "")
+}
+
+// applyMiddlewares is ApplyMiddlewares qualifying errors with path, the path of val.
+func (i *HubItemInterface) applyMiddlewares(mws Middlewares, val HubItem, out bool, path string) (HubItem, error) {
 	if i.Value == nil {
 		return nil, fmt.Errorf("empty interface")
 	}
-	return i.Value.Compiled.ApplyMiddlewares(mws, val, out)
+	return i.Value.Compiled.applyMiddlewares(mws, val, out, path)
 }
 
 type HubItemsInterface []*HubItemInterface
@@ -602,15 +650,26 @@ func (m *HubItemsInterface) Contains(h2 *HubItemsInterface) error {
 }
 
 func (m *HubItemsInterface) ApplyMiddlewares(validators Middlewares, out bool, vals ...HubItem) ([]HubItem, error) {
+	return m.applyMiddlewares(validators, out, "", vals...)
+}
+
+// applyMiddlewares is ApplyMiddlewares qualifying errors with path, the path of vals (e.g., input).
+func (m *HubItemsInterface) applyMiddlewares(validators Middlewares, out bool, path string, vals ...HubItem) ([]HubItem, error) {
 	if m == nil {
 		return nil, fmt.Errorf("require interface")
 	}
+	if !out && len(vals) < len(*m) {
+		// Missing input values are taken from the defaults.
+		if dvals, err := m.withDefaults(vals); err == nil {
+			vals = dvals
+		}
+	}
 	if len(vals) != len(*m) {
 		return nil, fmt.Errorf("lengths do not match")
 	}
 	vvals := []HubItem{}
 	for i, hi := range *m {
-		if v, err := hi.ApplyMiddlewares(validators, vals[i], out); err != nil {
+		if v, err := hi.applyMiddlewares(validators, vals[i], out, indexPath(path, i)); err != nil {
 			return nil, err
 		} else {
 			vvals = append(vvals, v)
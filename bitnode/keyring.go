@@ -0,0 +1,242 @@
+package bitnode
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnsigned         = errors.New("credentials are not signed")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrUnknownKey       = errors.New("unknown key")
+	ErrExpired          = errors.New("credentials expired")
+	ErrNotYetValid      = errors.New("credentials not valid yet")
+	ErrNoExpiry         = errors.New("credentials do not expire")
+)
+
+// DefaultCredentialsTTL is the validity of credentials issued by a Keyring without TTL.
+const DefaultCredentialsTTL = 24 * time.Hour
+
+// A SigningKey signs and verifies credentials.
+type SigningKey interface {
+	// Sign returns the signature of payload.
+	Sign(payload []byte) ([]byte, error)
+
+	// Verify determines whether sig is a valid signature of payload.
+	Verify(payload []byte, sig []byte) bool
+}
+
+type hmacKey struct {
+	secret []byte
+}
+
+var _ SigningKey = &hmacKey{}
+
+// NewHMACKey creates a key which signs using HMAC-SHA256 with a shared secret.
+func NewHMACKey(secret []byte) SigningKey {
+	return &hmacKey{secret: secret}
+}
+
+func (k *hmacKey) Sign(payload []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+func (k *hmacKey) Verify(payload []byte, sig []byte) bool {
+	exp, _ := k.Sign(payload)
+	return hmac.Equal(exp, sig)
+}
+
+type ed25519Key struct {
+	pub  ed25519.PublicKey
+	priv ed25519.PrivateKey
+}
+
+var _ SigningKey = &ed25519Key{}
+
+// NewEd25519Key creates a key which signs using Ed25519.
+func NewEd25519Key(priv ed25519.PrivateKey) SigningKey {
+	return &ed25519Key{pub: priv.Public().(ed25519.PublicKey), priv: priv}
+}
+
+// NewEd25519PublicKey creates a key which only verifies Ed25519 signatures.
+func NewEd25519PublicKey(pub ed25519.PublicKey) SigningKey {
+	return &ed25519Key{pub: pub}
+}
+
+func (k *ed25519Key) Sign(payload []byte) ([]byte, error) {
+	if k.priv == nil {
+		return nil, fmt.Errorf("require private key to sign")
+	}
+	return ed25519.Sign(k.priv, payload), nil
+}
+
+func (k *ed25519Key) Verify(payload []byte, sig []byte) bool {
+	return ed25519.Verify(k.pub, payload, sig)
+}
+
+// A Keyring issues and verifies credentials. Keys are identified by IDs, so they can be rotated without invalidating
+// credentials signed with previous keys.
+type Keyring struct {
+	// Authority is set on issued credentials. If not empty, only credentials of this authority are accepted.
+	Authority string
+
+	// TTL is the validity of issued credentials.
+	TTL time.Duration
+
+	// Leeway tolerates clock differences between nodes when checking validity times.
+	Leeway time.Duration
+
+	// keys by their IDs.
+	keys map[string]SigningKey
+
+	// signer is the ID of the key used to sign new credentials.
+	signer string
+
+	// now returns the current time.
+	now func() time.Time
+
+	mux sync.Mutex
+}
+
+func NewKeyring(authority string) *Keyring {
+	return &Keyring{
+		Authority: authority,
+		TTL:       DefaultCredentialsTTL,
+		keys:      map[string]SigningKey{},
+		now:       time.Now,
+	}
+}
+
+// AddKey adds a key to the keyring. The first key added is used for signing.
+func (k *Keyring) AddKey(id string, key SigningKey) error {
+	if id == "" {
+		return fmt.Errorf("require key id")
+	}
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("already have key %s", id)
+	}
+	k.keys[id] = key
+	if k.signer == "" {
+		k.signer = id
+	}
+	return nil
+}
+
+// RemoveKey removes a key. Credentials signed with it are not accepted anymore.
+func (k *Keyring) RemoveKey(id string) {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	delete(k.keys, id)
+	if k.signer == id {
+		k.signer = ""
+	}
+}
+
+// UseKey sets the key used to sign new credentials.
+func (k *Keyring) UseKey(id string) error {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	k.signer = id
+	return nil
+}
+
+// Issue returns a copy of creds which is valid from now on for the TTL of the keyring and signed with its current key.
+func (k *Keyring) Issue(creds Credentials) (Credentials, error) {
+	ttl := k.TTL
+	if ttl <= 0 {
+		ttl = DefaultCredentialsTTL
+	}
+	now := k.now()
+	if k.Authority != "" {
+		creds.Authority = k.Authority
+	}
+	creds.Timestamp = now.Unix()
+	creds.NotBefore = now.Unix()
+	creds.Expires = now.Add(ttl).Unix()
+	if err := k.Sign(&creds); err != nil {
+		return Credentials{}, err
+	}
+	return creds, nil
+}
+
+// Sign signs creds as they are with the current key of the keyring.
+func (k *Keyring) Sign(creds *Credentials) error {
+	k.mux.Lock()
+	id := k.signer
+	key := k.keys[id]
+	k.mux.Unlock()
+	if key == nil {
+		return fmt.Errorf("have no signing key")
+	}
+	creds.KeyID = id
+	sig, err := key.Sign(creds.payload())
+	if err != nil {
+		return err
+	}
+	creds.Signature = base64.StdEncoding.EncodeToString(sig)
+	return nil
+}
+
+// Verify checks the signature, authority and validity times of creds.
+func (k *Keyring) Verify(creds Credentials) error {
+	if creds.Signature == "" {
+		return ErrUnsigned
+	}
+	k.mux.Lock()
+	key := k.keys[creds.KeyID]
+	k.mux.Unlock()
+	if key == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, creds.KeyID)
+	}
+	sig, err := base64.StdEncoding.DecodeString(creds.Signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !key.Verify(creds.payload(), sig) {
+		return ErrInvalidSignature
+	}
+	if k.Authority != "" && creds.Authority != k.Authority {
+		return fmt.Errorf("unknown authority: %s", creds.Authority)
+	}
+	return k.CheckValidity(creds)
+}
+
+// CheckValidity checks that creds are valid at the current time without verifying their signature.
+func (k *Keyring) CheckValidity(creds Credentials) error {
+	now := k.now()
+	if creds.NotBefore != 0 && now.Add(k.Leeway).Before(time.Unix(creds.NotBefore, 0)) {
+		return ErrNotYetValid
+	}
+	if creds.Expires == 0 {
+		return ErrNoExpiry
+	}
+	if now.Add(-k.Leeway).After(time.Unix(creds.Expires, 0)) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// ParseToken parses a token created by Credentials.Tokenize and verifies the credentials.
+func (k *Keyring) ParseToken(token string) (Credentials, error) {
+	creds, err := ParseCredentials(token)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if err := k.Verify(creds); err != nil {
+		return Credentials{}, err
+	}
+	return creds, nil
+}
@@ -0,0 +1,123 @@
+package bitnode
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// The leaves time, duration, id, systemId and objectId are represented by time.Time, time.Duration, ID, SystemID and
+// ObjectID. Times are encoded as RFC 3339 strings with nanoseconds, IDs as hexadecimal strings and durations as strings
+// like "1h30m0s", see EncodeDurations. Durations given as integers of nanoseconds are accepted as well.
+
+// convertTime converts val into a time.Time.
+func convertTime(val HubItem) (HubItem, error) {
+	switch val := val.(type) {
+	case time.Time:
+		return val, nil
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, val)
+		if err != nil {
+			return nil, fmt.Errorf("not a time: %v", val)
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("not a time: %v", val)
+}
+
+// convertDuration converts val into a time.Duration.
+func convertDuration(val HubItem) (HubItem, error) {
+	switch val := val.(type) {
+	case time.Duration:
+		return val, nil
+	case string:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("not a duration: %v", val)
+		}
+		return d, nil
+	case int64:
+		return time.Duration(val), nil
+	case int:
+		return time.Duration(val), nil
+	case float64:
+		return time.Duration(val), nil
+	}
+	return nil, fmt.Errorf("not a duration: %v", val)
+}
+
+// EncodeDurations replaces the durations in val, a value with middlewares applied, by strings like "1h30m0s". Unlike
+// integers of nanoseconds, these keep their precision when decoded from JSON, which yields float64 for numbers.
+func EncodeDurations(val HubItem) HubItem {
+	switch val := val.(type) {
+	case time.Duration:
+		return val.String()
+	case []HubItem:
+		vals := make([]HubItem, len(val))
+		for i, v := range val {
+			vals[i] = EncodeDurations(v)
+		}
+		return vals
+	case map[string]HubItem:
+		vals := make(map[string]HubItem, len(val))
+		for k, v := range val {
+			vals[k] = EncodeDurations(v)
+		}
+		return vals
+	}
+	return val
+}
+
+// convertID converts val into an ID.
+func convertID(val HubItem) (HubItem, error) {
+	switch val := val.(type) {
+	case ID:
+		return val, nil
+	case string:
+		var id ID
+		if err := decodeHexID(id[:], val); err != nil {
+			return nil, fmt.Errorf("not an ID: %v", val)
+		}
+		return id, nil
+	}
+	return nil, fmt.Errorf("not an ID: %v", val)
+}
+
+// convertSystemID converts val into a SystemID.
+func convertSystemID(val HubItem) (HubItem, error) {
+	switch val := val.(type) {
+	case SystemID:
+		return val, nil
+	case string:
+		var id SystemID
+		if err := decodeHexID(id[:], val); err != nil {
+			return nil, fmt.Errorf("not a system ID: %v", val)
+		}
+		return id, nil
+	}
+	return nil, fmt.Errorf("not a system ID: %v", val)
+}
+
+// convertObjectID converts val into an ObjectID.
+func convertObjectID(val HubItem) (HubItem, error) {
+	switch val := val.(type) {
+	case ObjectID:
+		return val, nil
+	case string:
+		var id ObjectID
+		if err := decodeHexID(id[:], val); err != nil {
+			return nil, fmt.Errorf("not an object ID: %v", val)
+		}
+		return id, nil
+	}
+	return nil, fmt.Errorf("not an object ID: %v", val)
+}
+
+// decodeHexID decodes the hexadecimal representation str of an ID into dst, which it must fill exactly.
+func decodeHexID(dst []byte, str string) error {
+	if hex.DecodedLen(len(str)) != len(dst) {
+		return fmt.Errorf("expected %d hexadecimal digits", 2*len(dst))
+	}
+	_, err := hex.Decode(dst, []byte(str))
+	return err
+}
@@ -0,0 +1,134 @@
+package bitnode
+
+import (
+	"github.com/Bitspark/go-bitnode/store"
+	"gopkg.in/yaml.v3"
+	"testing"
+	"time"
+)
+
+func TestLeaf__Time(t *testing.T) {
+	v1 := mustParseType(`{"leaf": "time"}`, nil)
+	now := time.Now()
+	if v, err := v1.ApplyMiddlewares(nil, now, true); err != nil || !v.(time.Time).Equal(now) {
+		t.Fatal(v, err)
+	}
+	if v, err := v1.ApplyMiddlewares(nil, "2023-05-01T12:00:00.5Z", true); err != nil || v.(time.Time).Nanosecond() != 500000000 {
+		t.Fatal(v, err)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, "yesterday", true); err == nil {
+		t.Fatal("must only accept RFC 3339 times")
+	}
+}
+
+func TestLeaf__Duration(t *testing.T) {
+	v1 := mustParseType(`{"leaf": "duration"}`, nil)
+	for _, val := range []any{90 * time.Minute, "1h30m", int64(90 * time.Minute), float64(90 * time.Minute)} {
+		if v, err := v1.ApplyMiddlewares(nil, val, true); err != nil || v != 90*time.Minute {
+			t.Fatal(val, v, err)
+		}
+	}
+	if _, err := v1.ApplyMiddlewares(nil, "soon", true); err == nil {
+		t.Fatal("must only accept durations")
+	}
+}
+
+func TestLeaf__ID(t *testing.T) {
+	id := ComposeIDs(GenerateSystemID(), GenerateObjectID())
+
+	v1 := mustParseType(`{"leaf": "id"}`, nil)
+	if v, err := v1.ApplyMiddlewares(nil, id.Hex(), true); err != nil || v != id {
+		t.Fatal(v, err)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, id.Hex()[2:], true); err == nil {
+		t.Fatal("must check length")
+	}
+	if _, err := v1.ApplyMiddlewares(nil, id.System(), true); err == nil {
+		t.Fatal("must not accept system IDs")
+	}
+
+	v2 := mustParseType(`{"leaf": "systemId"}`, nil)
+	if v, err := v2.ApplyMiddlewares(nil, id.System().Hex(), true); err != nil || v != id.System() {
+		t.Fatal(v, err)
+	}
+	v3 := mustParseType(`{"leaf": "objectId"}`, nil)
+	if v, err := v3.ApplyMiddlewares(nil, id.Object(), true); err != nil || v != id.Object() {
+		t.Fatal(v, err)
+	}
+	if _, err := v3.ApplyMiddlewares(nil, "xyz", true); err == nil {
+		t.Fatal("must only accept hexadecimal IDs")
+	}
+}
+
+func TestLeaf__YAML(t *testing.T) {
+	for _, name := range []string{"time", "duration", "id", "systemId", "objectId"} {
+		var tp Type
+		if err := yaml.Unmarshal([]byte(name), &tp); err != nil {
+			t.Fatal(err)
+		}
+		if bts, _ := yaml.Marshal(&tp); string(bts) != name+"\n" {
+			t.Fatal(string(bts))
+		}
+	}
+}
+
+func TestLeaf__Store(t *testing.T) {
+	vt := mustParseType(`
+mapOf:
+  created:
+    leaf: time
+  timeout:
+    leaf: duration
+  owner:
+    leaf: id
+`, nil)
+	spk := Sparkable{RawSparkable: RawSparkable{
+		Name: "Session",
+		Interface: &Interface{RawInterface: RawInterface{
+			Hubs: &HubInterfaces{
+				{
+					Name:      "session",
+					Type:      HubTypeValue,
+					Direction: HubDirectionBoth,
+					Value:     &HubItemInterface{Value: vt},
+				},
+			},
+		}},
+	}}
+	if err := spk.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2023, 5, 1, 12, 0, 0, 1, time.UTC)
+	owner := ComposeIDs(GenerateSystemID(), GenerateObjectID())
+	// Exceeds the precision of float64, which JSON numbers are decoded into.
+	timeout := 100000*time.Hour + time.Nanosecond
+
+	n := NewNode()
+	sys, _ := n.PrepareSystem(Credentials{}, spk)
+	if err := sys.GetHub("session").Set("", map[string]any{
+		"created": created,
+		"timeout": timeout,
+		"owner":   owner,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	st := store.NewStore("test")
+	if err := n.Store(st); err != nil {
+		t.Fatal(err)
+	}
+	n2 := NewNode()
+	if err := n2.Load(st, nil); err != nil {
+		t.Fatal(err)
+	}
+	sys2, err := n2.GetSystemByID(Credentials{}, sys.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := sys2.GetHub("session").Get()
+	mv := v.(map[string]HubItem)
+	if !mv["created"].(time.Time).Equal(created) || mv["timeout"] != timeout || mv["owner"] != owner {
+		t.Fatal(mv)
+	}
+}
@@ -0,0 +1,459 @@
+package bitnode
+
+import (
+	"fmt"
+	"github.com/Bitspark/go-bitnode/util"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strings"
+)
+
+// Severity of a diagnostic.
+type Severity string
+
+const (
+	// SeverityError diagnostics prevent definitions from compiling or being implemented.
+	SeverityError Severity = "error"
+
+	// SeverityWarning diagnostics point to likely mistakes.
+	SeverityWarning Severity = "warning"
+)
+
+// Codes of diagnostics.
+const (
+	DiagnosticUnresolved   = "unresolved-reference"
+	DiagnosticCycle        = "cycle"
+	DiagnosticDuplicate    = "duplicate-name"
+	DiagnosticMapKey       = "invalid-map-key"
+	DiagnosticHubType      = "missing-hub-type"
+	DiagnosticHubDirection = "missing-hub-direction"
+	DiagnosticFactory      = "unknown-factory"
+	DiagnosticUnusedType   = "unused-type"
+)
+
+// Diagnostic is a problem of a definition found by Domain.Lint.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+
+	// Code identifies the kind of problem, e.g. unresolved-reference.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Object is the full name of the definition containing the problem.
+	Object string `json:"object,omitempty"`
+
+	// File, Line and Column locate the problem in the definitions file. Line and Column are 0 if unknown.
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	pos := d.File
+	if pos == "" {
+		pos = d.Object
+	} else if d.Line > 0 {
+		pos = fmt.Sprintf("%s:%d:%d", pos, d.Line, d.Column)
+	}
+	return fmt.Sprintf("%s: %s: %s [%s]", pos, d.Severity, d.Message, d.Code)
+}
+
+// Diagnostics are all problems found by Domain.Lint.
+type Diagnostics []Diagnostic
+
+// HasErrors determines whether any of the diagnostics is an error.
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns an error listing the error diagnostics, nil if there are none.
+func (ds Diagnostics) Err() error {
+	msgs := []string{}
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			msgs = append(msgs, d.String())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d errors: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// Lint validates the definitions of dom and its child domains without compiling them. Unlike Domain.Compile, it
+// does not stop at the first problem but returns all of them, located in the definitions files. Factories named by
+// sparkables are looked up in node, which may be nil to skip this check. Types are reported as unused if no
+// definition inside dom uses them.
+func (dom *Domain) Lint(node *NativeNode) Diagnostics {
+	l := &linter{
+		node:        node,
+		files:       map[*Domain]*yaml.Node{},
+		used:        map[*Type]bool{},
+		typeDefs:    map[*Type]lintDefinition{},
+		typeEdges:   map[*Type][]*Type{},
+		interfDefs:  map[*Interface]lintDefinition{},
+		interfEdges: map[*Interface][]*Interface{},
+	}
+	l.lintDomain(dom)
+
+	for _, c := range findCycles(l.types, l.typeEdges) {
+		names := make([]string, len(c))
+		for i, t := range c {
+			names[i] = t.Name
+		}
+		// Cycles are reported at their first type inside dom.
+		for _, t := range c {
+			if def, ok := l.typeDefs[t]; ok {
+				l.report(def, SeverityError, DiagnosticCycle, nil, "reference cycle: %s -> %s", strings.Join(names, " -> "), names[0])
+				break
+			}
+		}
+	}
+	for _, c := range findCycles(l.interfs, l.interfEdges) {
+		names := make([]string, len(c))
+		for i, interf := range c {
+			names[i] = interf.Name
+		}
+		for _, interf := range c {
+			if def, ok := l.interfDefs[interf]; ok {
+				l.report(def, SeverityError, DiagnosticCycle, nil, "extends cycle: %s -> %s", strings.Join(names, " -> "), names[0])
+				break
+			}
+		}
+	}
+	for _, t := range l.types {
+		if !l.used[t] {
+			l.report(l.typeDefs[t], SeverityWarning, DiagnosticUnusedType, nil, "type %s is not used", t.Name)
+		}
+	}
+
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		di, dj := l.diags[i], l.diags[j]
+		if di.File != dj.File {
+			return di.File < dj.File
+		}
+		if di.Line != dj.Line {
+			return di.Line < dj.Line
+		}
+		return di.Column < dj.Column
+	})
+	return l.diags
+}
+
+// lintDefinition locates a definition inside the definitions file of its domain.
+type lintDefinition struct {
+	dom    *Domain
+	object string
+
+	// path of the definition, consisting of mapping keys and sequence indexes.
+	path []any
+}
+
+// at returns the definition located at the path below def.
+func (def lintDefinition) at(path ...any) lintDefinition {
+	return lintDefinition{
+		dom:    def.dom,
+		object: def.object,
+		path:   append(append([]any{}, def.path...), path...),
+	}
+}
+
+type linter struct {
+	node  *NativeNode
+	diags Diagnostics
+
+	// files contains the parsed definitions files of domains, nil if they cannot be read.
+	files map[*Domain]*yaml.Node
+
+	// used contains the types used by other definitions.
+	used map[*Type]bool
+
+	// types and interfs are the domain types and interfaces in the order they have been linted.
+	types   []*Type
+	interfs []*Interface
+
+	// typeDefs and interfDefs locate types and interfaces, typeEdges and interfEdges contain the types and interfaces
+	// they reference or extend.
+	typeDefs    map[*Type]lintDefinition
+	typeEdges   map[*Type][]*Type
+	interfDefs  map[*Interface]lintDefinition
+	interfEdges map[*Interface][]*Interface
+}
+
+func (l *linter) lintDomain(dom *Domain) {
+	def := lintDefinition{dom: dom, object: dom.FullName}
+
+	names := map[string]bool{}
+	for i, t := range dom.Types {
+		tDef := lintDefinition{dom: dom, object: fullName(dom, t.Name), path: []any{sectionTypes, i}}
+		if names[t.Name] {
+			l.report(tDef.at("name"), SeverityError, DiagnosticDuplicate, nil, "duplicate type %s", t.Name)
+		}
+		names[t.Name] = true
+		l.types = append(l.types, t)
+		l.typeDefs[t] = tDef
+	}
+	names = map[string]bool{}
+	for i, interf := range dom.Interfaces {
+		iDef := lintDefinition{dom: dom, object: fullName(dom, interf.Name), path: []any{sectionInterfaces, i}}
+		if names[interf.Name] {
+			l.report(iDef.at("name"), SeverityError, DiagnosticDuplicate, nil, "duplicate interface %s", interf.Name)
+		}
+		names[interf.Name] = true
+		l.interfs = append(l.interfs, interf)
+		l.interfDefs[interf] = iDef
+	}
+	names = map[string]bool{}
+	for i, m := range dom.Sparkables {
+		mDef := lintDefinition{dom: dom, object: fullName(dom, m.Name), path: []any{sectionSparkables, i}}
+		if names[m.Name] {
+			l.report(mDef.at("name"), SeverityError, DiagnosticDuplicate, nil, "duplicate sparkable %s", m.Name)
+		}
+		names[m.Name] = true
+	}
+	names = map[string]bool{}
+	for _, d := range dom.Domains {
+		if names[d.Name] {
+			l.report(def, SeverityError, DiagnosticDuplicate, nil, "duplicate domain %s", d.Name)
+		}
+		names[d.Name] = true
+	}
+
+	for _, t := range dom.Types {
+		l.lintType(l.typeDefs[t], &t.RawType, t)
+	}
+	for _, interf := range dom.Interfaces {
+		l.lintInterface(l.interfDefs[interf], interf, interf)
+	}
+	for i, m := range dom.Sparkables {
+		l.lintSparkable(lintDefinition{dom: dom, object: fullName(dom, m.Name), path: []any{sectionSparkables, i}}, m)
+	}
+	for _, d := range dom.Domains {
+		l.lintDomain(d)
+	}
+}
+
+// lintType lints t located at def. root is the domain type t is the root of, nil for nested types.
+func (l *linter) lintType(def lintDefinition, t *RawType, root *Type) {
+	if t == nil {
+		return
+	}
+	if t.Reference != "" {
+		if rt, err := def.dom.GetType(t.Reference); err != nil {
+			l.report(def.at("reference"), SeverityError, DiagnosticUnresolved, err, "type %s not found", t.Reference)
+		} else {
+			l.used[rt] = true
+			if root != nil {
+				l.typeEdges[root] = append(l.typeEdges[root], rt)
+			}
+		}
+	}
+	for i, ext := range t.Extends {
+		if rt, err := def.dom.GetType(ext); err != nil {
+			l.report(def.at("extends", i), SeverityError, DiagnosticUnresolved, err, "type %s not found", ext)
+		} else {
+			l.used[rt] = true
+			if root != nil {
+				l.typeEdges[root] = append(l.typeEdges[root], rt)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(t.MapOf))
+	for k := range t.MapOf {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "" || k[0] < 'a' || k[0] > 'z' {
+			l.report(def.at("mapOf", k), SeverityError, DiagnosticMapKey, nil, "map key %q must start with a lower case character (a-z)", k)
+		} else if !util.IsAlphanumeric(k) {
+			l.report(def.at("mapOf", k), SeverityError, DiagnosticMapKey, nil, "map key %q must not contain special characters", k)
+		}
+		l.lintType(def.at("mapOf", k), t.MapOf[k], nil)
+	}
+	l.lintType(def.at("listOf"), t.ListOf, nil)
+	l.lintType(def.at("dictOf"), t.DictOf, nil)
+	for i, ct := range t.TupleOf {
+		l.lintType(def.at("tupleOf", i), ct, nil)
+	}
+	for i, ct := range t.OneOf {
+		l.lintType(def.at("oneOf", i), ct, nil)
+	}
+	for i, ct := range t.Arguments {
+		l.lintType(def.at("arguments", i), ct, nil)
+	}
+}
+
+// lintInterface lints interf located at def. root is the domain interface interf is, nil for inline interfaces.
+func (l *linter) lintInterface(def lintDefinition, interf *Interface, root *Interface) {
+	if interf == nil {
+		return
+	}
+	for i, ext := range interf.Extends {
+		if ri, err := def.dom.GetInterface(ext); err != nil {
+			l.report(def.at("extends", i), SeverityError, DiagnosticUnresolved, err, "interface %s not found", ext)
+		} else if root != nil {
+			l.interfEdges[root] = append(l.interfEdges[root], ri)
+		}
+	}
+	if interf.Hubs == nil {
+		return
+	}
+	names := map[string]bool{}
+	for i, hub := range *interf.Hubs {
+		hDef := def.at("hubs", i)
+		if names[hub.Name] {
+			l.report(hDef.at("name"), SeverityError, DiagnosticDuplicate, nil, "duplicate hub %s", hub.Name)
+		}
+		names[hub.Name] = true
+		if hub.Type == "" {
+			l.report(hDef, SeverityError, DiagnosticHubType, nil, "hub %s requires type", hub.Name)
+		}
+		if hub.Direction == "" {
+			l.report(hDef, SeverityError, DiagnosticHubDirection, nil, "hub %s requires direction", hub.Name)
+		}
+		l.lintItems(hDef.at("input"), hub.Input)
+		l.lintItems(hDef.at("output"), hub.Output)
+		l.lintItem(hDef.at("value"), hub.Value)
+		l.lintItem(hDef.at("upstream"), hub.Upstream)
+	}
+}
+
+func (l *linter) lintSparkable(def lintDefinition, m *Sparkable) {
+	l.lintItems(def.at("constructor"), m.Constructor)
+	l.lintInterface(def.at("interface"), m.Interface, nil)
+	if l.node == nil {
+		return
+	}
+	factories := make([]string, 0, len(m.Implementation))
+	for f := range m.Implementation {
+		factories = append(factories, f)
+	}
+	sort.Strings(factories)
+	for _, f := range factories {
+		if _, err := l.node.GetFactory(f); err != nil {
+			l.report(def.at("implementation", f), SeverityError, DiagnosticFactory, nil, "factory %s not found", f)
+		}
+	}
+}
+
+func (l *linter) lintItems(def lintDefinition, items HubItemsInterface) {
+	for i, item := range items {
+		l.lintItem(def.at(i), item)
+	}
+}
+
+func (l *linter) lintItem(def lintDefinition, item *HubItemInterface) {
+	if item == nil || item.Value == nil {
+		return
+	}
+	l.lintType(def.at("value"), &item.Value.RawType, nil)
+}
+
+// report adds a diagnostic located at def. The message is extended by err if it is not nil.
+func (l *linter) report(def lintDefinition, severity Severity, code string, err error, format string, args ...any) {
+	d := Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Object:   def.object,
+		File:     def.dom.FilePath,
+	}
+	if err != nil {
+		d.Message += ": " + err.Error()
+	}
+	if node := locateNode(l.file(def.dom), def.path); node != nil {
+		d.Line, d.Column = node.Line, node.Column
+	}
+	l.diags = append(l.diags, d)
+}
+
+// file returns the root of the parsed definitions file of dom, nil if it cannot be read.
+func (l *linter) file(dom *Domain) *yaml.Node {
+	if root, ok := l.files[dom]; ok {
+		return root
+	}
+	var root *yaml.Node
+	if f, err := readDefinitionsFile(dom.FilePath); err == nil {
+		root = f.root()
+	}
+	l.files[dom] = root
+	return root
+}
+
+// locateNode returns the node at path below node, consisting of mapping keys and sequence indexes. The key node is
+// returned for mapping keys. If path cannot be followed, the deepest node found is returned.
+func locateNode(node *yaml.Node, path []any) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	pos := node
+	for _, step := range path {
+		switch step := step.(type) {
+		case string:
+			idx := mappingIndex(node, step)
+			if idx < 0 {
+				return pos
+			}
+			pos, node = node.Content[idx], node.Content[idx+1]
+		case int:
+			if node.Kind != yaml.SequenceNode || step >= len(node.Content) {
+				return pos
+			}
+			pos, node = node.Content[step], node.Content[step]
+		}
+	}
+	return pos
+}
+
+// fullName returns the full name of the definition name inside dom.
+func fullName(dom *Domain, name string) string {
+	if dom.FullName == "" {
+		return name
+	}
+	return dom.FullName + DomSep + name
+}
+
+// findCycles returns the cycles of the graph given by nodes and edges. Each cycle starts with the node visited first.
+func findCycles[T comparable](nodes []T, edges map[T][]T) [][]T {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[T]int{}
+	stack := []T{}
+	cycles := [][]T{}
+	var visit func(n T)
+	visit = func(n T) {
+		state[n] = visiting
+		stack = append(stack, n)
+		for _, next := range edges[n] {
+			switch state[next] {
+			case unvisited:
+				visit(next)
+			case visiting:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == next {
+						cycles = append(cycles, append([]T{}, stack[i:]...))
+						break
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[n] = visited
+	}
+	for _, n := range nodes {
+		if state[n] == unvisited {
+			visit(n)
+		}
+	}
+	return cycles
+}
@@ -0,0 +1,137 @@
+package bitnode
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"testing"
+)
+
+const lintDefsYAML = `name: shop
+types:
+  - name: price
+    leaf: integer
+  - name: item
+    mapOf:
+      price: {reference: price}
+      Size: {leaf: integer}
+      color: {reference: colour}
+  - name: a
+    reference: b
+  - name: b
+    extends: [a]
+  - name: price
+    leaf: float
+  - name: unused
+    leaf: string
+interfaces:
+  - name: Catalog
+    extends: [Base]
+    hubs:
+      - name: get
+        direction: in
+        output:
+          - value: {reference: item}
+      - name: list
+        type: pipe
+        input:
+          - value: $missing
+  - name: Base
+    extends: [Catalog]
+blueprints:
+  - name: Catalog
+    interface: $Catalog
+    implementation:
+      go: []
+      unknown: []
+`
+
+func TestDomain_Lint(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "defs.yml")
+	writeDefs(t, file, lintDefsYAML)
+
+	dom := NewDomain()
+	shop, _ := dom.AddDomain("shop")
+	if err := shop.LoadFromDir(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	node := NewNode()
+	_ = node.AddFactory("go", myTestFac{})
+
+	type pos struct {
+		code   string
+		line   int
+		column int
+	}
+	expected := []pos{
+		{DiagnosticUnresolved, 9, 15},
+		{DiagnosticMapKey, 8, 7},
+		{DiagnosticCycle, 10, 5},
+		{DiagnosticDuplicate, 14, 5},
+		{DiagnosticUnusedType, 14, 5},
+		{DiagnosticUnusedType, 16, 5},
+		{DiagnosticCycle, 19, 5},
+		{DiagnosticHubType, 22, 9},
+		{DiagnosticHubDirection, 26, 9},
+		{DiagnosticUnresolved, 29, 13},
+		{DiagnosticFactory, 37, 7},
+	}
+
+	diags := shop.Lint(node)
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(expected), len(diags), diags)
+	}
+	for _, exp := range expected {
+		found := false
+		for _, d := range diags {
+			if d.Code == exp.code && d.Line == exp.line && d.Column == exp.column {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("missing %s at %d:%d: %v", exp.code, exp.line, exp.column, diags)
+		}
+	}
+	for i := 1; i < len(diags); i++ {
+		if diags[i].Line < diags[i-1].Line {
+			t.Fatal("diagnostics must be sorted by position", diags)
+		}
+	}
+
+	for _, d := range diags {
+		if d.File != file {
+			t.Fatal(d)
+		}
+		if d.Code == DiagnosticCycle && d.Line == 10 && d.Message != "reference cycle: a -> b -> a" {
+			t.Fatal(d.Message)
+		}
+		if d.Code == DiagnosticUnusedType && d.Severity != SeverityWarning {
+			t.Fatal(d)
+		}
+	}
+
+	if !diags.HasErrors() || diags.Err() == nil {
+		t.Fatal("expected errors")
+	}
+	bts, err := json.Marshal(diags[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bts), `"severity":"error"`) || !strings.Contains(string(bts), `"line":8`) {
+		t.Fatal(string(bts))
+	}
+
+	// Without a node, factories are not checked.
+	if diags := shop.Lint(nil); len(diags) != len(expected)-1 {
+		t.Fatal(diags)
+	}
+}
+
+func TestDomain_LintValid(t *testing.T) {
+	_, shop, _ := saveDomain(t)
+	if diags := shop.Lint(nil); len(diags) != 0 {
+		t.Fatal(diags)
+	}
+}
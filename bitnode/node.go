@@ -7,6 +7,7 @@ import (
 	"github.com/Bitspark/go-bitnode/util"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -69,6 +70,22 @@ type NativeNode struct {
 	systemsMux  sync.Mutex
 	factories   map[string]Factory
 	middlewares Middlewares
+
+	// persistStore receives the values of persistent hubs whenever they are set. If nil, they are only stored in
+	// snapshots taken by Store.
+	persistStore store.Store
+	persistMux   sync.Mutex
+
+	// retention determines which snapshots are kept.
+	retention SnapshotRetention
+
+	// snapshotLabel is the label of the latest snapshot taken or loaded.
+	snapshotLabel string
+
+	// snapshotRevisions contains the revisions of the systems at the latest snapshot taken or loaded.
+	snapshotRevisions map[SystemID]uint64
+
+	snapshotMux sync.Mutex
 }
 
 var _ Node = &NativeNode{}
@@ -139,6 +156,15 @@ func (h *NativeNode) Factories() []Factory {
 
 // NewSystem creates a new blank system from an interface on this node and attaches it to the node.
 func (h *NativeNode) NewSystem(creds Credentials, m Sparkable, payload ...HubItem) (System, error) {
+	if err := m.Interface.CheckVersion(); err != nil {
+		return nil, err
+	}
+
+	payload, err := m.Constructor.withDefaults(payload)
+	if err != nil {
+		return nil, fmt.Errorf("constructor: %w", err)
+	}
+
 	sys, err := h.PrepareSystem(creds, m)
 	if err != nil {
 		return nil, err
@@ -175,6 +201,11 @@ func (h *NativeNode) PrepareSystem(creds Credentials, m Sparkable) (System, erro
 		return nil, err
 	}
 
+	// The creator owns the system unless the sparkable specifies an owner.
+	if sys.permissions != nil && sys.permissions.Owner.IsNull() {
+		sys.permissions.Owner = creds.User.ID
+	}
+
 	return sys.Wrap(creds, h.middlewares), nil
 }
 
@@ -230,10 +261,17 @@ func (h *NativeNode) ImplementSystem(sys *NativeSystem, m Sparkable) error {
 	}
 
 	sys.sparkable = m
+	if m.Permissions != nil {
+		perms := *m.Permissions
+		sys.permissions = &perms
+	} else if m.Interface != nil && m.Interface.Permissions != nil {
+		perms := *m.Interface.Permissions
+		sys.permissions = &perms
+	}
 	sys.extends = append(sys.extends, m.Domain+DomSep+m.Name+"$")
 
 	// Implement the system.
-	if err := m.Implement(h, sys.Wrap(Credentials{}, h.middlewares)); err != nil {
+	if err := m.Implement(h, sys.Wrap(nodeCredentials, h.middlewares)); err != nil {
 		return err
 	}
 
@@ -245,15 +283,17 @@ func (h *NativeNode) initSystem(s *NativeSystem) error {
 		oldName := s.name
 		name := vals[0].(string)
 		s.name = name
+		s.touch()
 		log.Printf("%s name: %s", oldName, s.name)
-		return nil
+		return h.persistSystemInfo(s, "name", name)
 	}))
 
 	s.AddCallback(LifecycleStatus, NewNativeEvent(func(vals ...HubItem) error {
 		status := vals[0].(int64)
-		s.status = int(status)
-		log.Printf("%s status: %d", s.name, s.status)
-		return nil
+		s.status.Store(status)
+		s.touch()
+		log.Printf("%s status: %d", s.name, status)
+		return h.persistSystemInfo(s, "status", strconv.FormatInt(status, 10))
 	}))
 
 	s.AddCallback(LifecycleLog, NewNativeEvent(func(vals ...HubItem) error {
@@ -275,7 +315,7 @@ func (h *NativeNode) initSystem(s *NativeSystem) error {
 		delete(h.systems, s.id)
 		h.systemsMux.Unlock()
 
-		return nil
+		return h.unpersistSystem(s)
 	}))
 
 	return nil
@@ -345,8 +385,14 @@ func (h *NativeNode) Load(st store.Store, dom *Domain) error {
 	if err != nil {
 		return err
 	}
-	systemStore := systemStoreDS.Stores()
+	if err := h.loadSystems(systemStoreDS.Stores(), dom); err != nil {
+		return err
+	}
+	return h.loadNode(st)
+}
 
+// loadSystems loads the systems stored in systemStore.
+func (h *NativeNode) loadSystems(systemStore store.Stores, dom *Domain) error {
 	stSys := map[store.Store]*NativeSystem{}
 
 	for st := range systemStore.Enumerate() {
@@ -369,18 +415,28 @@ func (h *NativeNode) Load(st store.Store, dom *Domain) error {
 		}
 	}
 
+	// Systems are loaded concurrently. The node is loaded once all of them are, so that their status is final.
+	wg := sync.WaitGroup{}
 	for _, sys := range h.systems {
 		for chSysID := range sys.systems {
 			sys.systems[chSysID] = h.systems[chSysID]
 		}
 
+		wg.Add(1)
 		go func(sys *NativeSystem) {
+			defer wg.Done()
 			if err := sys.EmitEvent(LifecycleLoad); err != nil {
 				log.Printf("Error loading %s: %v", sys.Name(), err)
 			}
 		}(sys)
 	}
+	wg.Wait()
+
+	return nil
+}
 
+// loadNode loads the information about the node itself.
+func (h *NativeNode) loadNode(st store.Store) error {
 	nodeStoreDS, err := st.Ensure("node", store.DSKeyValue)
 	if err != nil {
 		return err
@@ -438,6 +494,11 @@ func (h *NativeNode) Store(st store.Store) error {
 		sys.EmitEvent(LifecycleStore)
 	}
 
+	return h.storeNode(st)
+}
+
+// storeNode stores the information about the node itself.
+func (h *NativeNode) storeNode(st store.Store) error {
 	nodeStoreDS, err := st.Ensure("node", store.DSKeyValue)
 	if err != nil {
 		return err
@@ -467,6 +528,92 @@ func (h *NativeNode) Store(st store.Store) error {
 	return nil
 }
 
+// SetPersistStore sets the store the values of persistent hubs are written to whenever they are set. The store uses
+// the layout of Store, so it can be passed to Load to restore these values.
+func (h *NativeNode) SetPersistStore(st store.Store) {
+	h.persistMux.Lock()
+	defer h.persistMux.Unlock()
+	h.persistStore = st
+}
+
+// persistedSystem returns the store of sys inside the persist store. If create is set and sys has not been persisted
+// yet, the store is created from the skeleton of sys and the values of its persistent hubs. Returns nil if the node has
+// no persist store or sys has not been persisted and create is not set.
+func (h *NativeNode) persistedSystem(sys *NativeSystem, create bool) (store.Store, error) {
+	h.persistMux.Lock()
+	defer h.persistMux.Unlock()
+	if h.persistStore == nil {
+		return nil, nil
+	}
+	systemStoreDS, err := h.persistStore.Ensure("systems", store.DSStores)
+	if err != nil {
+		return nil, err
+	}
+	systemStore := systemStoreDS.Stores()
+	if st, err := systemStore.Get(sys.ID().Hex()); err == nil {
+		return st, nil
+	} else if !create {
+		return nil, nil
+	}
+	st := store.NewStore(sys.ID().Hex())
+	sys.storeSkeleton(st)
+	hubStoreDS, err := st.Ensure("hubs", store.DSKeyValue)
+	if err != nil {
+		return nil, err
+	}
+	for _, hub := range sys.hubs {
+		if hub.Interface().Type != HubTypeValue || !hub.persistent() {
+			continue
+		}
+		val := hub.current()
+		if val == nil {
+			continue
+		}
+		valJSON, err := sys.encodeHubValue(hub, val)
+		if err != nil {
+			return nil, fmt.Errorf("persisting %s: %w", hub.Name(), err)
+		}
+		if err := hubStoreDS.KeyValue().Set(hub.Name(), valJSON); err != nil {
+			return nil, err
+		}
+	}
+	if err := systemStore.Add(st); err != nil {
+		return nil, err
+	}
+	return systemStore.Get(sys.ID().Hex())
+}
+
+// persistSystemInfo updates information about sys in the persist store if sys has been persisted.
+func (h *NativeNode) persistSystemInfo(sys *NativeSystem, key string, value string) error {
+	st, err := h.persistedSystem(sys, false)
+	if err != nil || st == nil {
+		return err
+	}
+	systemStoreDS, err := st.Ensure("system", store.DSKeyValue)
+	if err != nil {
+		return err
+	}
+	return systemStoreDS.KeyValue().Set(key, value)
+}
+
+// unpersistSystem removes sys from the persist store.
+func (h *NativeNode) unpersistSystem(sys *NativeSystem) error {
+	h.persistMux.Lock()
+	defer h.persistMux.Unlock()
+	if h.persistStore == nil {
+		return nil
+	}
+	systemStoreDS, err := h.persistStore.Ensure("systems", store.DSStores)
+	if err != nil {
+		return err
+	}
+	systemStore := systemStoreDS.Stores()
+	if _, err := systemStore.Get(sys.ID().Hex()); err != nil {
+		return nil
+	}
+	return systemStore.Remove(sys.ID().Hex())
+}
+
 func (h *NativeNode) AddMiddlewares(mws Middlewares) {
 	h.middlewares = append(h.middlewares, mws...)
 }
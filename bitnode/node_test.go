@@ -2,6 +2,7 @@ package bitnode
 
 import (
 	"github.com/Bitspark/go-bitnode/store"
+	"path"
 	"testing"
 )
 
@@ -208,3 +209,137 @@ func TestNativeNode_Store2(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestNativeNode_Store3(t *testing.T) {
+	n := NewNode()
+
+	sys, err := n.PrepareSystem(Credentials{}, Sparkable{
+		RawSparkable: RawSparkable{
+			Name:      "Blank",
+			Interface: NewInterface(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := store.OpenBoltBackend(path.Join(t.TempDir(), "node.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	st, err := store.OpenStore(backend, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Store(st); err != nil {
+		t.Fatal(err)
+	}
+
+	st2, _ := store.OpenStore(backend, "test")
+	n2 := NewNode()
+	if err := n2.Load(st2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n2.Name() != n.Name() {
+		t.Fatal(n2.Name())
+	}
+	sys2, err := n2.GetSystemByID(Credentials{}, sys.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sys2.Name() != sys.Name() {
+		t.Fatal(sys2.Name())
+	}
+}
+
+func TestNativeNode_PersistStore(t *testing.T) {
+	vt := &Type{RawType: RawType{
+		Leaf: LeafInteger,
+	}}
+	spk := Sparkable{RawSparkable: RawSparkable{
+		Name: "Counter",
+		Interface: &Interface{RawInterface: RawInterface{
+			Hubs: &HubInterfaces{
+				{
+					Name:       "count",
+					Type:       HubTypeValue,
+					Direction:  HubDirectionBoth,
+					Value:      &HubItemInterface{Value: vt},
+					Persistent: true,
+				},
+				{
+					Name:      "other",
+					Type:      HubTypeValue,
+					Direction: HubDirectionBoth,
+					Value:     &HubItemInterface{Value: vt},
+				},
+			},
+		}},
+	}}
+	if err := spk.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := store.OpenBoltBackend(path.Join(t.TempDir(), "node.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	st, _ := store.OpenStore(backend, "test")
+
+	n := NewNode()
+	n.SetPersistStore(st)
+	sys1, _ := n.PrepareSystem(Credentials{}, spk)
+	sys2, _ := n.PrepareSystem(Credentials{}, spk)
+	sys3, _ := n.PrepareSystem(Credentials{}, spk)
+
+	// Hubs which are not persistent must not be persisted along with the first persistent hub.
+	_ = sys1.GetHub("other").Set("", int64(2))
+	_ = sys1.GetHub("count").Set("", int64(1))
+	sys1.SetName("counter1")
+
+	if err := sys2.Native().SetPersistent(true); err != nil {
+		t.Fatal(err)
+	}
+	_ = sys2.GetHub("other").Set("", int64(3))
+
+	_ = sys3.GetHub("count").Set("", int64(4))
+	sys3.Delete()
+
+	// No snapshot has been taken, the node is restored from the persisted values alone.
+	n2 := NewNode()
+	if err := n2.Load(st, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sys1b, err := n2.GetSystemByID(Credentials{}, sys1.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := sys1b.GetHub("count").Get(); v != int64(1) {
+		t.Fatal(v)
+	}
+	if v, _ := sys1b.GetHub("other").Get(); v != int64(0) {
+		t.Fatal("hub must not be persisted", v)
+	}
+	if sys1b.Name() != "counter1" {
+		t.Fatal(sys1b.Name())
+	}
+
+	sys2b, err := n2.GetSystemByID(Credentials{}, sys2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sys2b.Native().Persistent() {
+		t.Fatal("system must remain persistent")
+	}
+	if v, _ := sys2b.GetHub("other").Get(); v != int64(3) {
+		t.Fatal(v)
+	}
+
+	if _, err := n2.GetSystemByID(Credentials{}, sys3.ID()); err == nil {
+		t.Fatal("deleted system must not be restored")
+	}
+}
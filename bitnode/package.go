@@ -0,0 +1,633 @@
+package bitnode
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Bitspark/go-bitnode/store"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// PackageFile is the manifest of a domain package, located next to its definitions.
+	PackageFile = "package.yml"
+
+	// PackageLockFile records the resolved dependencies of a package.
+	PackageLockFile = "package-lock.yml"
+
+	// packageVendorDir contains the dependencies extracted from archives.
+	packageVendorDir = "vendor"
+)
+
+// ErrPackageLock is returned when the resolved dependencies of a package differ from those in its lockfile.
+var ErrPackageLock = errors.New("dependencies do not match lockfile")
+
+// Package is the manifest of a domain package. The package provides the domain defined in its directory and depends
+// on the domains of other packages.
+type Package struct {
+	// Name of the package and of the domain it provides.
+	Name string `json:"name" yaml:"name"`
+
+	// Version of the package, a semantic version.
+	Version string `json:"version" yaml:"version"`
+
+	// Dependencies are the packages the definitions of this package use.
+	Dependencies []*PackageDependency `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
+
+	// Dir is the directory of the package.
+	Dir string `json:"-" yaml:"-"`
+}
+
+// PackageDependency requires a package in a version satisfying Version. It is resolved from exactly one source on
+// disk, given relative to the directory of the requiring package.
+type PackageDependency struct {
+	// Name of the required package.
+	Name string `json:"name" yaml:"name"`
+
+	// Version required, any version if empty.
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+
+	// Path is a directory containing the package.
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+
+	// Archive is a .tar, .tar.gz, .tgz or .zip file containing the package. It is extracted into the vendor directory
+	// of the root package once the resolved dependencies match the lockfile.
+	Archive string `json:"archive,omitempty" yaml:"archive,omitempty"`
+
+	// Git is a git checkout containing the package.
+	Git string `json:"git,omitempty" yaml:"git,omitempty"`
+}
+
+// PackageLock records the dependencies resolved for a package, so that changes of them are detected.
+type PackageLock struct {
+	Packages []*LockedPackage `json:"packages" yaml:"packages"`
+}
+
+// LockedPackage is a resolved dependency.
+type LockedPackage struct {
+	Name    string `json:"name" yaml:"name"`
+	Version string `json:"version" yaml:"version"`
+
+	// Path, Archive or Git is the source the package has been resolved from, relative to the root package.
+	Path    string `json:"path,omitempty" yaml:"path,omitempty"`
+	Archive string `json:"archive,omitempty" yaml:"archive,omitempty"`
+	Git     string `json:"git,omitempty" yaml:"git,omitempty"`
+
+	// Commit checked out in the git source.
+	Commit string `json:"commit,omitempty" yaml:"commit,omitempty"`
+
+	// Checksum is the SHA-256 checksum of the manifest and definitions of the package.
+	Checksum string `json:"checksum" yaml:"checksum"`
+
+	// RequiredBy contains the names of the packages depending on this package.
+	RequiredBy []string `json:"requiredBy" yaml:"requiredBy"`
+
+	// dir is the directory the package has been resolved to.
+	dir string
+}
+
+// GetPackage returns the locked package named name, nil if there is none.
+func (l *PackageLock) GetPackage(name string) *LockedPackage {
+	for _, p := range l.Packages {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// ReadPackage reads the package manifest in dir.
+func ReadPackage(dir string) (*Package, error) {
+	file := path.Join(dir, PackageFile)
+	bts, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading package from %s: %v", file, err)
+	}
+	pkg := &Package{}
+	if err := yaml.Unmarshal(bts, pkg); err != nil {
+		return nil, fmt.Errorf("parsing package from %s: %v", file, err)
+	}
+	if err := checkName(pkg.Name, 1, 12, false); err != nil {
+		return nil, fmt.Errorf("package %s: invalid name %q: %v", file, pkg.Name, err)
+	}
+	if _, err := ParseVersion(pkg.Version); err != nil {
+		return nil, fmt.Errorf("package %s: %v", pkg.Name, err)
+	}
+	for _, dep := range pkg.Dependencies {
+		if err := dep.check(); err != nil {
+			return nil, fmt.Errorf("package %s: %v", pkg.Name, err)
+		}
+	}
+	pkg.Dir = dir
+	return pkg, nil
+}
+
+func (d *PackageDependency) check() error {
+	if err := checkName(d.Name, 1, 12, false); err != nil {
+		return fmt.Errorf("dependency %q: invalid name: %v", d.Name, err)
+	}
+	if d.Version != "" {
+		if _, err := ParseVersion(d.Version); err != nil {
+			return fmt.Errorf("dependency %s: %v", d.Name, err)
+		}
+	}
+	sources := 0
+	for _, src := range []string{d.Path, d.Archive, d.Git} {
+		if src != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("dependency %s requires exactly one of path, archive and git", d.Name)
+	}
+	return nil
+}
+
+// ReadPackageLock reads the lockfile of the package in dir. Returns nil if the package has no lockfile.
+func ReadPackageLock(dir string) (*PackageLock, error) {
+	file := path.Join(dir, PackageLockFile)
+	bts, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading lockfile %s: %v", file, err)
+	}
+	lock := &PackageLock{}
+	if err := yaml.Unmarshal(bts, lock); err != nil {
+		return nil, fmt.Errorf("parsing lockfile %s: %v", file, err)
+	}
+	return lock, nil
+}
+
+// Write writes the lockfile of the package in dir.
+func (l *PackageLock) Write(dir string) error {
+	bts, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(path.Join(dir, PackageLockFile), bts)
+}
+
+// Resolve resolves the dependencies of pkg and their dependencies. Each package is resolved in a single version,
+// which must satisfy all packages requiring it. Archives are only extracted into temporary directories, so resolving
+// does not change the package.
+func (pkg *Package) Resolve() (*PackageLock, error) {
+	r := &packageResolver{
+		root:     pkg,
+		resolved: map[string]*LockedPackage{},
+		required: map[string]string{},
+	}
+	defer r.cleanup()
+	if err := r.resolve(pkg); err != nil {
+		return nil, err
+	}
+	lock := &PackageLock{Packages: []*LockedPackage{}}
+	for _, p := range r.resolved {
+		sort.Strings(p.RequiredBy)
+		lock.Packages = append(lock.Packages, p)
+	}
+	sort.Slice(lock.Packages, func(i, j int) bool {
+		return lock.Packages[i].Name < lock.Packages[j].Name
+	})
+	return lock, nil
+}
+
+// Check checks that the packages of l match those locked in locked.
+func (l *PackageLock) Check(locked *PackageLock) error {
+	for _, p := range l.Packages {
+		lp := locked.GetPackage(p.Name)
+		switch {
+		case lp == nil:
+			return fmt.Errorf("%w: package %s is not locked", ErrPackageLock, p.Name)
+		case lp.Version != p.Version:
+			return fmt.Errorf("%w: package %s has version %s, locked %s", ErrPackageLock, p.Name, p.Version, lp.Version)
+		case lp.Commit != p.Commit:
+			return fmt.Errorf("%w: package %s has commit %s, locked %s", ErrPackageLock, p.Name, p.Commit, lp.Commit)
+		case lp.Checksum != p.Checksum:
+			return fmt.Errorf("%w: package %s has been changed", ErrPackageLock, p.Name)
+		}
+	}
+	for _, lp := range locked.Packages {
+		if l.GetPackage(lp.Name) == nil {
+			return fmt.Errorf("%w: package %s is no longer required", ErrPackageLock, lp.Name)
+		}
+	}
+	return nil
+}
+
+// LoadPackage loads the package in dir and its dependencies into child domains of dom named like the packages. The
+// resolved dependencies must match the lockfile of the package, which is written if missing.
+func (dom *Domain) LoadPackage(dir string) (*PackageLock, error) {
+	pkg, err := ReadPackage(dir)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := pkg.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	locked, err := ReadPackageLock(dir)
+	if err != nil {
+		return nil, err
+	}
+	if locked == nil {
+		if err := lock.Write(dir); err != nil {
+			return nil, err
+		}
+	} else if err := lock.Check(locked); err != nil {
+		return nil, err
+	}
+	if err := lock.vendor(dir); err != nil {
+		return nil, err
+	}
+
+	for _, p := range lock.Packages {
+		if err := dom.mountPackage(p.Name, p.dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := dom.mountPackage(pkg.Name, dir); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// UpdatePackageLock resolves the dependencies of the package in dir and writes its lockfile.
+func UpdatePackageLock(dir string) (*PackageLock, error) {
+	pkg, err := ReadPackage(dir)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := pkg.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	if err := lock.vendor(dir); err != nil {
+		return nil, err
+	}
+	return lock, lock.Write(dir)
+}
+
+// vendor extracts the archived packages of l into the vendor directory of the root package in dir. The extracted
+// packages must match l.
+func (l *PackageLock) vendor(dir string) error {
+	for _, p := range l.Packages {
+		if p.Archive == "" {
+			continue
+		}
+		target, err := vendorDir(dir, p.Name)
+		if err != nil {
+			return err
+		}
+		if p.dir, err = extractPackage(path.Join(dir, filepath.FromSlash(p.Archive)), target); err != nil {
+			return err
+		}
+		checksum, err := packageChecksum(p.dir)
+		if err != nil {
+			return err
+		}
+		if checksum != p.Checksum {
+			return fmt.Errorf("%w: package %s has been changed", ErrPackageLock, p.Name)
+		}
+	}
+	return nil
+}
+
+// vendorDir returns the directory the archived package name is extracted to, which must be inside the vendor directory
+// of the root package in dir.
+func vendorDir(dir string, name string) (string, error) {
+	vendor := path.Join(dir, packageVendorDir)
+	target := path.Join(vendor, name)
+	if path.Dir(target) != vendor {
+		return "", fmt.Errorf("package %s cannot be vendored outside of %s", name, vendor)
+	}
+	return target, nil
+}
+
+// mountPackage adds the child domain name to dom and loads the definitions of the package in dir into it.
+func (dom *Domain) mountPackage(name string, dir string) error {
+	fullName := dom.FullName + DomSep + name
+	if _, err := dom.GetDomain(fullName); err == nil {
+		return fmt.Errorf("cannot mount package %s: domain %s already exists", name, name)
+	}
+	if err := dom.AddFullDomain(dir, name); err != nil {
+		return fmt.Errorf("cannot mount package %s: %v", name, err)
+	}
+	d, err := dom.GetDomain(fullName)
+	if err != nil {
+		return err
+	}
+	// The definitions file is determined when loading.
+	d.FilePath = ""
+	if err := d.LoadFromDir(dir, true); err != nil {
+		return fmt.Errorf("loading package %s from %s: %v", name, dir, err)
+	}
+	return nil
+}
+
+type packageResolver struct {
+	root *Package
+
+	// resolved contains the resolved packages by their names.
+	resolved map[string]*LockedPackage
+
+	// required contains the package each package has first been required by, for reporting conflicts.
+	required map[string]string
+
+	// tmpDirs contains the temporary directories archives have been extracted into.
+	tmpDirs []string
+}
+
+// cleanup removes the temporary directories of the resolver.
+func (r *packageResolver) cleanup() {
+	for _, dir := range r.tmpDirs {
+		_ = os.RemoveAll(dir)
+	}
+	r.tmpDirs = nil
+}
+
+// resolve resolves the dependencies of pkg.
+func (r *packageResolver) resolve(pkg *Package) error {
+	for _, dep := range pkg.Dependencies {
+		if dep.Name == r.root.Name {
+			return fmt.Errorf("package %s cannot depend on the root package %s", pkg.Name, dep.Name)
+		}
+		locked, depPkg, err := r.locate(pkg, dep)
+		if err != nil {
+			return fmt.Errorf("package %s: dependency %s: %v", pkg.Name, dep.Name, err)
+		}
+		if dep.Version != "" {
+			v, _ := ParseVersion(depPkg.Version)
+			req, _ := ParseVersion(dep.Version)
+			if !v.Satisfies(req) {
+				return fmt.Errorf("package %s requires %s %s, found %s in %s", pkg.Name, dep.Name, req, v, depPkg.Dir)
+			}
+		}
+
+		if prev, ok := r.resolved[dep.Name]; ok {
+			if prev.Version != locked.Version {
+				return fmt.Errorf("conflicting versions of package %s: %s required by %s, %s required by %s",
+					dep.Name, prev.Version, r.required[dep.Name], locked.Version, pkg.Name)
+			}
+			prev.RequiredBy = append(prev.RequiredBy, pkg.Name)
+			continue
+		}
+		locked.RequiredBy = []string{pkg.Name}
+		r.resolved[dep.Name] = locked
+		r.required[dep.Name] = pkg.Name
+		if err := r.resolve(depPkg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// locate finds the package dep of pkg on disk.
+func (r *packageResolver) locate(pkg *Package, dep *PackageDependency) (*LockedPackage, *Package, error) {
+	locked := &LockedPackage{Name: dep.Name}
+	var err error
+	switch {
+	case dep.Path != "":
+		locked.dir = path.Join(pkg.Dir, dep.Path)
+		locked.Path, err = r.relative(locked.dir)
+	case dep.Git != "":
+		locked.dir = path.Join(pkg.Dir, dep.Git)
+		if locked.Commit, err = gitCommit(locked.dir); err != nil {
+			return nil, nil, err
+		}
+		locked.Git, err = r.relative(locked.dir)
+	case dep.Archive != "":
+		archive := path.Join(pkg.Dir, dep.Archive)
+		var tmpDir string
+		if tmpDir, err = os.MkdirTemp("", "package-"+dep.Name+"-"); err != nil {
+			return nil, nil, err
+		}
+		r.tmpDirs = append(r.tmpDirs, tmpDir)
+		if locked.dir, err = extractPackage(archive, tmpDir); err != nil {
+			return nil, nil, err
+		}
+		locked.Archive, err = r.relative(archive)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	depPkg, err := ReadPackage(locked.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if depPkg.Name != dep.Name {
+		return nil, nil, fmt.Errorf("found package %s in %s", depPkg.Name, locked.dir)
+	}
+	locked.Version = depPkg.Version
+	if locked.Checksum, err = packageChecksum(locked.dir); err != nil {
+		return nil, nil, err
+	}
+	return locked, depPkg, nil
+}
+
+// relative returns file relative to the directory of the root package.
+func (r *packageResolver) relative(file string) (string, error) {
+	rel, err := filepath.Rel(r.root.Dir, file)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// packageChecksum returns the SHA-256 checksum of the manifest and definition files of the package in dir.
+func packageChecksum(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(file string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			if file != dir && skipDomainDir(path.Dir(file), e.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isDefinitionsFile(e.Name()) && e.Name() != PackageFile {
+			return nil
+		}
+		bts, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), len(bts))
+		_, _ = h.Write(bts)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// gitCommit returns the commit checked out in the git working tree dir without requiring git.
+func gitCommit(dir string) (string, error) {
+	gitDir := path.Join(dir, ".git")
+	if bts, err := os.ReadFile(gitDir); err == nil {
+		// Worktrees refer to their git directory.
+		gitDir = strings.TrimSpace(strings.TrimPrefix(string(bts), "gitdir:"))
+		if !path.IsAbs(gitDir) {
+			gitDir = path.Join(dir, gitDir)
+		}
+	}
+	head, err := os.ReadFile(path.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", fmt.Errorf("%s is not a git checkout: %v", dir, err)
+	}
+	ref, ok := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: ")
+	if !ok {
+		// Detached head.
+		return ref, nil
+	}
+	if bts, err := os.ReadFile(path.Join(gitDir, ref)); err == nil {
+		return strings.TrimSpace(string(bts)), nil
+	}
+	if bts, err := os.ReadFile(path.Join(gitDir, "packed-refs")); err == nil {
+		for _, line := range strings.Split(string(bts), "\n") {
+			if commit, name, ok := strings.Cut(strings.TrimSpace(line), " "); ok && name == ref {
+				return commit, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("cannot resolve %s in git checkout %s", ref, dir)
+}
+
+// extractPackage extracts the package archive into dir, replacing its contents. Returns the directory of the package,
+// which is the single top-level directory of the archive if it has no manifest at its root.
+func extractPackage(archive string, dir string) (string, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	var err error
+	switch {
+	case strings.HasSuffix(archive, ".zip"):
+		err = extractZip(archive, dir)
+	case strings.HasSuffix(archive, ".tar.gz"), strings.HasSuffix(archive, ".tgz"):
+		err = extractTar(archive, dir, true)
+	case strings.HasSuffix(archive, ".tar"):
+		err = extractTar(archive, dir, false)
+	default:
+		err = fmt.Errorf("unsupported archive %s", archive)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(path.Join(dir, PackageFile)); err == nil {
+		return dir, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return path.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
+
+func extractTar(archive string, dir string, gzipped bool) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gzipped {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", archive, err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading %s: %v", archive, err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = extractEntry(dir, hdr.Name, nil)
+		case tar.TypeReg:
+			err = extractEntry(dir, hdr.Name, tr)
+		}
+		if err != nil {
+			return fmt.Errorf("extracting %s: %v", archive, err)
+		}
+	}
+}
+
+func extractZip(archive string, dir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", archive, err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			err = extractEntry(dir, f.Name, nil)
+		} else if f.Mode().IsRegular() {
+			var rc io.ReadCloser
+			if rc, err = f.Open(); err == nil {
+				err = extractEntry(dir, f.Name, rc)
+				_ = rc.Close()
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("extracting %s: %v", archive, err)
+		}
+	}
+	return nil
+}
+
+// extractEntry writes the archive entry name to dir, creating a directory if r is nil. Entries outside dir are
+// rejected.
+func extractEntry(dir string, name string, r io.Reader) error {
+	clean := path.Clean(filepath.ToSlash(name))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid entry %s", name)
+	}
+	file := path.Join(dir, clean)
+	if r == nil {
+		return os.MkdirAll(file, os.ModePerm)
+	}
+	if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
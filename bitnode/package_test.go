@@ -0,0 +1,224 @@
+package bitnode
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// writePackages writes the root package shop depending on geo (directory), money (archive) and units (git checkout).
+// geo depends on units as well.
+func writePackages(t *testing.T) string {
+	dir := t.TempDir()
+
+	writeDefs(t, path.Join(dir, "shop", PackageFile), `name: shop
+version: 1.0.0
+dependencies:
+  - name: geo
+    version: 1.1.0
+    path: ../geo
+  - name: money
+    version: 0.2.0
+    archive: ../money-0.2.3.tar.gz
+  - name: units
+    version: 2.0.0
+    git: ../units
+`)
+	writeDefs(t, path.Join(dir, "shop", "shop.yml"), `name: shop
+types:
+  - name: order
+    mapOf:
+      at: {reference: geo.point}
+      total: {reference: money.amount}
+`)
+
+	writeDefs(t, path.Join(dir, "geo", PackageFile), `name: geo
+version: 1.2.0
+dependencies:
+  - name: units
+    version: 2.1.0
+    git: ../units
+`)
+	writeDefs(t, path.Join(dir, "geo", "geo.yml"), `name: geo
+types:
+  - name: point
+    mapOf:
+      lat: {reference: units.degree}
+      lon: {reference: units.degree}
+`)
+
+	writeDefs(t, path.Join(dir, "units", PackageFile), "name: units\nversion: 2.1.0\n")
+	writeDefs(t, path.Join(dir, "units", "units.yml"), "name: units\ntypes:\n  - name: degree\n    leaf: float\n")
+	writeDefs(t, path.Join(dir, "units", ".git", "HEAD"), "ref: refs/heads/main\n")
+	writeDefs(t, path.Join(dir, "units", ".git", "packed-refs"), "# pack-refs with: peeled\n0123abcd refs/heads/main\n")
+
+	f, err := os.Create(path.Join(dir, "money-0.2.3.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for name, content := range map[string]string{
+		"money-0.2.3/" + PackageFile: "name: money\nversion: 0.2.3\n",
+		"money-0.2.3/money.yml":      "name: money\ntypes:\n  - name: amount\n    leaf: integer\n",
+	} {
+		_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		_, _ = tw.Write([]byte(content))
+	}
+	_ = tw.Close()
+	_ = gw.Close()
+	_ = f.Close()
+
+	return dir
+}
+
+func TestDomain_LoadPackage(t *testing.T) {
+	dir := writePackages(t)
+	shopDir := path.Join(dir, "shop")
+
+	dom := NewDomain()
+	lock, err := dom.LoadPackage(shopDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dom.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	order, err := dom.GetType("shop.order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Compiled.MapOf["at"].MapOf["lat"].Leaf != LeafFloat {
+		t.Fatal(order.Compiled)
+	}
+
+	if len(lock.Packages) != 3 {
+		t.Fatal(lock.Packages)
+	}
+	units := lock.GetPackage("units")
+	if units.Version != "2.1.0" || units.Commit != "0123abcd" || units.Git != "../units" {
+		t.Fatal(units)
+	}
+	if strings.Join(units.RequiredBy, ",") != "geo,shop" {
+		t.Fatal(units.RequiredBy)
+	}
+	money := lock.GetPackage("money")
+	if money.Version != "0.2.3" || money.Archive != "../money-0.2.3.tar.gz" {
+		t.Fatal(money)
+	}
+	if _, err := os.Stat(path.Join(shopDir, packageVendorDir, "money", "money-0.2.3", "money.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lockfile has been written and matches when loading again. The vendor directory is no domain.
+	locked, err := ReadPackageLock(shopDir)
+	if err != nil || locked == nil {
+		t.Fatal(locked, err)
+	}
+	dom2 := NewDomain()
+	if _, err := dom2.LoadPackage(shopDir); err != nil {
+		t.Fatal(err)
+	}
+	shop, _ := dom2.GetDomain("shop")
+	if len(shop.Domains) != 0 {
+		t.Fatal(shop.Domains)
+	}
+
+	// Changed dependencies no longer match the lockfile. The vendor directory is left unchanged.
+	marker := path.Join(shopDir, packageVendorDir, "money", "marker")
+	writeDefs(t, marker, "")
+	writeDefs(t, path.Join(dir, "geo", "geo.yml"), "name: geo\ntypes:\n  - name: point\n    leaf: string\n")
+	if _, err := NewDomain().LoadPackage(shopDir); !errors.Is(err, ErrPackageLock) {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UpdatePackageLock(shopDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDomain().LoadPackage(shopDir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPackage_ResolveConflicts(t *testing.T) {
+	dir := writePackages(t)
+
+	// shop resolves units from another checkout in version 2.0.0, geo requires 2.1.0.
+	writeDefs(t, path.Join(dir, "units2", PackageFile), "name: units\nversion: 2.0.0\n")
+	writeDefs(t, path.Join(dir, "units2", "units.yml"), "name: units\n")
+	writeDefs(t, path.Join(dir, "shop", PackageFile), `name: shop
+version: 1.0.0
+dependencies:
+  - name: units
+    version: 2.0.0
+    path: ../units2
+  - name: geo
+    version: 1.1.0
+    path: ../geo
+`)
+	pkg, err := ReadPackage(path.Join(dir, "shop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pkg.Resolve(); err == nil || !strings.Contains(err.Error(), "conflicting versions of package units: 2.0.0 required by shop, 2.1.0 required by geo") {
+		t.Fatal(err)
+	}
+
+	// Versions must satisfy the requirements.
+	writeDefs(t, path.Join(dir, "shop", PackageFile), `name: shop
+version: 1.0.0
+dependencies:
+  - name: geo
+    version: 2.0.0
+    path: ../geo
+`)
+	pkg, _ = ReadPackage(path.Join(dir, "shop"))
+	if _, err := pkg.Resolve(); err == nil || !strings.Contains(err.Error(), "requires geo 2.0.0, found 1.2.0") {
+		t.Fatal(err)
+	}
+
+	// Dependencies have exactly one source.
+	writeDefs(t, path.Join(dir, "shop", PackageFile), "name: shop\nversion: 1.0.0\ndependencies:\n  - name: geo\n")
+	if _, err := ReadPackage(path.Join(dir, "shop")); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Names of packages are domain names, so that archives cannot be extracted outside of the vendor directory.
+	for _, defs := range []string{
+		"name: ../..\nversion: 1.0.0\n",
+		"name: shop\nversion: 1.0.0\ndependencies:\n  - name: ../..\n    archive: ../money-0.2.3.tar.gz\n",
+	} {
+		writeDefs(t, path.Join(dir, "shop", PackageFile), defs)
+		if _, err := ReadPackage(path.Join(dir, "shop")); err == nil || !strings.Contains(err.Error(), "invalid name") {
+			t.Fatal(defs, err)
+		}
+	}
+	if _, err := vendorDir(dir, "../.."); err == nil {
+		t.Fatal("must not vendor outside of the vendor directory")
+	}
+
+	// Resolving does not extract archives into the vendor directory.
+	writeDefs(t, path.Join(dir, "shop", PackageFile), "name: shop\nversion: 1.0.0\ndependencies:\n  - name: money\n    archive: ../money-0.2.3.tar.gz\n")
+	pkg, _ = ReadPackage(path.Join(dir, "shop"))
+	if _, err := pkg.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(dir, "shop", packageVendorDir)); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractEntry_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"../evil.yml", "a/../../evil.yml", "/etc/evil.yml"} {
+		if err := extractEntry(dir, name, strings.NewReader("")); err == nil {
+			t.Fatal(name)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package bitnode
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// referLazily makes the compiled reference t resolve to the domain type rt when validating values. rt is being
+// compiled, so that t is part of its own definition. args is the number of type arguments of the reference.
+func (t *RawType) referLazily(rt *Type, rootType *Type, args int) error {
+	if len(rt.Parameters) > 0 {
+		return fmt.Errorf("recursive references to generic type %s are not supported", rt.FullName)
+	}
+	if args > 0 {
+		return fmt.Errorf("type %s requires 0 type arguments, got %d", t.Reference, args)
+	}
+	t.TypeName = rt.FullName
+	t.lazy = rt
+	if rootType != nil && rootType != rt {
+		rt.references[rootType] = true
+	}
+	return nil
+}
+
+// resolved returns the compiled type the lazy reference t resolves to, refined by the optional flag, constraints and
+// overrides of t. Returns t if it is no lazy reference.
+func (t *RawType) resolved() (*RawType, error) {
+	if t.lazy == nil {
+		return t, nil
+	}
+	if t.lazy.Compiled == nil {
+		return nil, fmt.Errorf("type %s is not compiled", t.TypeName)
+	}
+	rt := *t.lazy.Compiled
+	rt.Optional = t.Optional
+	constraints, err := t.lazy.Compiled.Constraints.Intersect(t.Constraints)
+	if err != nil {
+		return nil, fmt.Errorf("type %s: %w", t.TypeName, err)
+	}
+	rt.Constraints = constraints
+	if len(t.Extensions) > 0 {
+		rt.Extensions = t.Extensions
+	}
+	if t.Options != nil {
+		rt.Options = t.Options
+	}
+	if t.Default != nil {
+		rt.Default = t.Default
+	}
+	return &rt, nil
+}
+
+// sameRefinements checks that the resolved references t and t2 to the same recursive type refine it equally.
+func (t *RawType) sameRefinements(t2 *RawType) error {
+	if !reflect.DeepEqual(t.Constraints, t2.Constraints) {
+		return fmt.Errorf("incompatible constraints of recursive type %s", t.TypeName)
+	}
+	if !reflect.DeepEqual(t.Options, t2.Options) {
+		return fmt.Errorf("incompatible options of recursive type %s", t.TypeName)
+	}
+	if !reflect.DeepEqual(t.Default, t2.Default) {
+		return fmt.Errorf("incompatible defaults of recursive type %s", t.TypeName)
+	}
+	return nil
+}
+
+// infinite determines whether every value of the compiled type t contains a value of the recursive domain type rt,
+// so that there are no finite values of t.
+func (t *RawType) infinite(rt *Type) bool {
+	if t == nil || t.Optional || t.Default != nil {
+		return false
+	}
+	if t.lazy != nil {
+		return t.lazy == rt
+	}
+	switch {
+	case len(t.OneOf) > 0:
+		for _, vt := range t.OneOf {
+			if !vt.infinite(rt) {
+				return false
+			}
+		}
+		return true
+	case t.TupleOf != nil:
+		for _, tt := range t.TupleOf {
+			if tt.infinite(rt) {
+				return true
+			}
+		}
+	case t.MapOf != nil:
+		for _, kt := range t.MapOf {
+			if kt.infinite(rt) {
+				return true
+			}
+		}
+	}
+	// Lists and dictionaries may be empty.
+	return false
+}
+
+// CycleError is returned when compiling interfaces which extend themselves.
+type CycleError struct {
+	// Cycle contains the full names of the interfaces in the cycle, starting and ending with the same one.
+	Cycle []string
+}
+
+func (e *CycleError) Error() string {
+	return "extends cycle: " + strings.Join(e.Cycle, " -> ")
+}
+
+// closed determines whether the cycle has been completed while returning from the compilation of its interfaces.
+func (e *CycleError) closed() bool {
+	return len(e.Cycle) > 1 && e.Cycle[0] == e.Cycle[len(e.Cycle)-1]
+}
+
+// extendCycle prepends name to the cycle err is about if it has not been completed yet.
+func extendCycle(name string, err error) error {
+	var cycleErr *CycleError
+	if errors.As(err, &cycleErr) && !cycleErr.closed() {
+		cycleErr.Cycle = append([]string{name}, cycleErr.Cycle...)
+	}
+	return err
+}
@@ -0,0 +1,207 @@
+package bitnode
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+)
+
+const recursiveDefsYAML = `name: tree
+types:
+  - name: Node
+    mapOf:
+      name: {leaf: string}
+      children: {listOf: {reference: Node}}
+  - name: Ping
+    mapOf:
+      id: {leaf: integer}
+      pong: {reference: Pong, optional: true}
+  - name: Pong
+    mapOf:
+      ping: {reference: Ping, optional: true}
+interfaces:
+  - name: Trees
+    hubs:
+      - name: root
+        type: value
+        direction: out
+        value:
+          value: $Node
+`
+
+func recursiveDomain(t *testing.T, defs string) (*Domain, *Domain, error) {
+	dir := t.TempDir()
+	writeDefs(t, path.Join(dir, "defs.yml"), defs)
+
+	dom := NewDomain()
+	tree, _ := dom.AddDomain("tree")
+	if err := tree.LoadFromDir(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	return dom, tree, dom.Compile()
+}
+
+func TestType_Recursive(t *testing.T) {
+	_, tree, err := recursiveDomain(t, recursiveDefsYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, _ := tree.GetType("Node")
+
+	val := map[string]any{
+		"name": "a",
+		"children": []any{
+			map[string]any{
+				"name":     "b",
+				"children": []any{map[string]any{"name": "c", "children": []any{}}},
+			},
+		},
+	}
+	if _, err := node.Compiled.ApplyMiddlewares(nil, val, false); err != nil {
+		t.Fatal(err)
+	}
+
+	val["children"].([]any)[0].(map[string]any)["children"].([]any)[0] = map[string]any{"children": []any{}}
+	if _, err := node.Compiled.ApplyMiddlewares(nil, val, false); err == nil {
+		t.Fatal("expected error")
+	} else if !strings.Contains(err.Error(), "children[0].children[0]") || !strings.Contains(err.Error(), "missing map entry: name") {
+		t.Fatal(err)
+	}
+
+	def := node.Compiled.DefaultValue().(map[string]HubItem)
+	if def["name"] != "" || len(def["children"].([]HubItem)) != 0 {
+		t.Fatal(def)
+	}
+
+	if ok, err := node.Accepts(node); !ok {
+		t.Fatal(err)
+	}
+
+	interf, _ := tree.GetInterface("Trees")
+	hub := interf.CompiledHubs.GetHub("root")
+	if ok, err := hub.Value.Value.Accepts(node); !ok {
+		t.Fatal(err)
+	}
+
+	s, err := node.JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts, _ := json.Marshal(s)
+	if !strings.Contains(string(bts), `"$ref":"#"`) {
+		t.Fatal(string(bts))
+	}
+}
+
+func TestType_RecursiveMutual(t *testing.T) {
+	_, tree, err := recursiveDomain(t, recursiveDefsYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ping, _ := tree.GetType("Ping")
+	val := map[string]any{"id": 1, "pong": map[string]any{"ping": map[string]any{"id": 2, "pong": nil}}}
+	if _, err := ping.Compiled.ApplyMiddlewares(nil, val, false); err != nil {
+		t.Fatal(err)
+	}
+	val = map[string]any{"id": 1, "pong": map[string]any{"ping": map[string]any{}}}
+	if _, err := ping.Compiled.ApplyMiddlewares(nil, val, false); err == nil || !strings.Contains(err.Error(), "missing map entry: id") {
+		t.Fatal(err)
+	}
+
+	// Recompiling must not recurse between the types.
+	ping.Reset()
+	if err := ping.Compile(tree, tree.FullName, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestType_RecursiveRefinements(t *testing.T) {
+	_, tree, err := recursiveDomain(t, "name: tree\ntypes:\n  - name: Nest\n    listOf: {reference: Nest}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nest, _ := tree.GetType("Nest")
+	wide := nest.Compiled.ListOf
+	maxItems := 1
+	narrow := *wide
+	narrow.Constraints = &Constraints{MaxItems: &maxItems}
+
+	// References to the same recursive type differ by their refinements.
+	if ok, err := wide.accepts(&narrow, ""); !ok {
+		t.Fatal(err)
+	}
+	if ok, _ := narrow.accepts(wide, ""); ok {
+		t.Fatal("must not accept more items")
+	}
+	if err := wide.Contains(wide); err != nil {
+		t.Fatal(err)
+	}
+	if err := wide.Contains(&narrow); err == nil {
+		t.Fatal("must not contain differently constrained reference")
+	}
+	if _, err := narrow.ApplyMiddlewares(nil, []any{[]any{}, []any{}}, false); err == nil {
+		t.Fatal("must apply the constraints of the reference")
+	}
+
+	// References cannot loosen the constraints of the recursive type.
+	_, tree, err = recursiveDomain(t, "name: tree\ntypes:\n  - name: Pair\n    listOf: {reference: Pair, constraints: {maxItems: 3}}\n    constraints: {maxItems: 2}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, _ := tree.GetType("Pair")
+	if _, err := pair.Compiled.ApplyMiddlewares(nil, []any{[]any{}, []any{}}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pair.Compiled.ApplyMiddlewares(nil, []any{[]any{[]any{}, []any{}, []any{}}}, false); err == nil {
+		t.Fatal("must not accept more items than the recursive type")
+	}
+}
+
+func TestType_RecursiveInfinite(t *testing.T) {
+	for _, defs := range []string{
+		"name: tree\ntypes:\n  - name: Loop\n    reference: Loop\n",
+		"name: tree\ntypes:\n  - name: A\n    reference: B\n  - name: B\n    reference: A\n",
+		"name: tree\ntypes:\n  - name: Chain\n    mapOf:\n      next: {reference: Chain}\n",
+	} {
+		_, _, err := recursiveDomain(t, defs)
+		if err == nil || !strings.Contains(err.Error(), "no finite values") {
+			t.Fatal(defs, err)
+		}
+	}
+}
+
+func TestInterface_ExtendsCycle(t *testing.T) {
+	defs := `name: tree
+interfaces:
+  - name: A
+    extends: [B]
+  - name: B
+    extends: [A]
+  - name: C
+    extends: [C]
+`
+	dir := t.TempDir()
+	writeDefs(t, path.Join(dir, "defs.yml"), defs)
+	dom := NewDomain()
+	tree, _ := dom.AddDomain("tree")
+	if err := tree.LoadFromDir(dir, false); err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := tree.GetInterface("A")
+	err := a.Compile(dom, tree.FullName, true)
+	var cycleErr *CycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatal(err)
+	}
+	if err.Error() != "extends cycle: tree.A -> tree.B -> tree.A" {
+		t.Fatal(err)
+	}
+
+	c, _ := tree.GetInterface("C")
+	if err := c.Compile(dom, tree.FullName, true); err == nil || err.Error() != "extends cycle: tree.C -> tree.C" {
+		t.Fatal(err)
+	}
+}
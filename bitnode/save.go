@@ -0,0 +1,351 @@
+package bitnode
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Bitspark/go-bitnode/store"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// Sections of definition files.
+const (
+	sectionTypes      = "types"
+	sectionInterfaces = "interfaces"
+	sectionSparkables = "blueprints"
+)
+
+// definitionsFile is a definitions file parsed into a YAML node tree. Changes edit the tree in place, so that comments,
+// ordering and the notation of unchanged definitions are preserved.
+type definitionsFile struct {
+	path string
+	doc  *yaml.Node
+
+	// spaced top-level fields are preceded by an empty line, which the YAML encoder does not preserve.
+	spaced map[string]bool
+}
+
+// readDefinitionsFile parses the definitions file at file, which may not exist yet.
+func readDefinitionsFile(file string) (*definitionsFile, error) {
+	if file == "" {
+		return nil, fmt.Errorf("domain has no definitions file")
+	}
+	f := &definitionsFile{
+		path: file,
+		doc: &yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		},
+		spaced: map[string]bool{},
+	}
+	bts, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading definitions from %s: %v", file, err)
+	}
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(bts, doc); err != nil {
+		return nil, fmt.Errorf("parsing definitions from %s: %v", file, err)
+	}
+	if doc.Kind == 0 {
+		// Empty file.
+		return f, nil
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("parsing definitions from %s: expected a mapping", file)
+	}
+	f.doc = doc
+	lines := strings.Split(string(bts), "\n")
+	root := f.root()
+	for i := 0; i < len(root.Content); i += 2 {
+		key := root.Content[i]
+		start := key.Line
+		if key.HeadComment != "" {
+			start -= strings.Count(key.HeadComment, "\n") + 1
+		}
+		if start >= 2 && start-2 < len(lines) && strings.TrimSpace(lines[start-2]) == "" {
+			f.spaced[key.Value] = true
+		}
+	}
+	return f, nil
+}
+
+func (f *definitionsFile) root() *yaml.Node {
+	return f.doc.Content[0]
+}
+
+// write writes the file atomically.
+func (f *definitionsFile) write() error {
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(f.doc); err != nil {
+		return fmt.Errorf("writing definitions to %s: %v", f.path, err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("writing definitions to %s: %v", f.path, err)
+	}
+	if err := os.MkdirAll(path.Dir(f.path), os.ModePerm); err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(f.path, f.space(buf.Bytes()))
+}
+
+// space inserts empty lines before the spaced top-level fields of the encoded file bts, including their comments.
+func (f *definitionsFile) space(bts []byte) []byte {
+	if len(f.spaced) == 0 {
+		return bts
+	}
+	lines := strings.Split(string(bts), "\n")
+	spaced := make([]string, 0, len(lines))
+	for _, line := range lines {
+		key, _, ok := strings.Cut(line, ":")
+		if ok && f.spaced[key] && len(spaced) > 0 {
+			// Move the empty line above the comments of the field.
+			idx := len(spaced)
+			for idx > 0 && strings.HasPrefix(spaced[idx-1], "#") {
+				idx--
+			}
+			if idx > 0 && spaced[idx-1] != "" {
+				spaced = append(spaced[:idx], append([]string{""}, spaced[idx:]...)...)
+			}
+		}
+		spaced = append(spaced, line)
+	}
+	return []byte(strings.Join(spaced, "\n"))
+}
+
+// setField sets the top-level field key to val. Fields equal to val are left untouched, empty values remove the field.
+func (f *definitionsFile) setField(key string, val any) error {
+	root := f.root()
+	idx := mappingIndex(root, key)
+	empty := isEmptyValue(val)
+	if idx < 0 {
+		if empty {
+			return nil
+		}
+		node, err := definitionNode(val)
+		if err != nil {
+			return err
+		}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, node)
+		return nil
+	}
+	if same, err := sameNode(root.Content[idx+1], val); err != nil || same {
+		return err
+	}
+	if empty {
+		root.Content = append(root.Content[:idx], root.Content[idx+2:]...)
+		return nil
+	}
+	node, err := definitionNode(val)
+	if err != nil {
+		return err
+	}
+	replaceNode(root.Content[idx+1], node)
+	return nil
+}
+
+// setDefinition sets the definition named name in section to def, which is appended if the section lacks it.
+// Definitions equal to def are left untouched.
+func (f *definitionsFile) setDefinition(section string, name string, def any) error {
+	seq := f.section(section)
+	node, err := definitionNode(def)
+	if err != nil {
+		return err
+	}
+	for _, item := range seq.Content {
+		if definitionName(item) != name {
+			continue
+		}
+		if same, err := sameNode(item, def); err != nil || same {
+			return err
+		}
+		merged, err := mergeDefinition(item, node, def)
+		if err != nil {
+			return err
+		}
+		replaceNode(item, merged)
+		return nil
+	}
+	if len(seq.Content) == 0 {
+		// Empty sections are usually written as [].
+		seq.Style = 0
+	}
+	seq.Content = append(seq.Content, node)
+	return nil
+}
+
+// setDefinitions sets the definitions of section to defs, given by their names. Definitions missing in defs are
+// removed, new ones appended.
+func (f *definitionsFile) setDefinitions(section string, names []string, defs []any) error {
+	seq := f.section(section)
+	keep := map[string]bool{}
+	for _, name := range names {
+		keep[name] = true
+	}
+	content := []*yaml.Node{}
+	for _, item := range seq.Content {
+		if keep[definitionName(item)] {
+			content = append(content, item)
+		}
+	}
+	seq.Content = content
+	for i, name := range names {
+		if err := f.setDefinition(section, name, defs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// section returns the sequence of definitions of section, adding it if missing.
+func (f *definitionsFile) section(section string) *yaml.Node {
+	root := f.root()
+	if idx := mappingIndex(root, section); idx >= 0 {
+		seq := root.Content[idx+1]
+		if seq.Kind != yaml.SequenceNode {
+			// E.g., null.
+			replaceNode(seq, &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"})
+		}
+		return seq
+	}
+	seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: section}, seq)
+	// Follow the layout of the other fields.
+	f.spaced[section] = len(f.spaced) > 0
+	return seq
+}
+
+// mappingIndex returns the index of key in the mapping node m, -1 if m lacks key.
+func mappingIndex(m *yaml.Node, key string) int {
+	if m.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// definitionName returns the name of the definition node, "" if it has none.
+func definitionName(node *yaml.Node) string {
+	if idx := mappingIndex(node, "name"); idx >= 0 {
+		return node.Content[idx+1].Value
+	}
+	return ""
+}
+
+// replaceNode replaces the contents of node by those of repl, keeping the comments of node.
+func replaceNode(node *yaml.Node, repl *yaml.Node) {
+	head, line, foot := node.HeadComment, node.LineComment, node.FootComment
+	*node = *repl
+	node.HeadComment, node.LineComment, node.FootComment = head, line, foot
+}
+
+// mergeDefinition merges the node of the changed definition def into its previous node item. Fields keep their
+// previous node, order and comments unless their value changed.
+func mergeDefinition(item *yaml.Node, node *yaml.Node, def any) (*yaml.Node, error) {
+	if item.Kind != yaml.MappingNode || node.Kind != yaml.MappingNode {
+		return node, nil
+	}
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: node.Tag, Style: item.Style}
+	// previous holds the index of the previous value of each field of merged, -1 for new ones.
+	previous := []int{}
+	for i := 0; i+1 < len(item.Content); i += 2 {
+		if idx := mappingIndex(node, item.Content[i].Value); idx >= 0 {
+			merged.Content = append(merged.Content, item.Content[i], node.Content[idx+1])
+			previous = append(previous, i+1)
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if mappingIndex(item, node.Content[i].Value) < 0 {
+			merged.Content = append(merged.Content, node.Content[i], node.Content[i+1])
+			previous = append(previous, -1)
+		}
+	}
+	for i, prev := range previous {
+		if prev < 0 {
+			continue
+		}
+		val := merged.Content[2*i+1]
+		merged.Content[2*i+1] = item.Content[prev]
+		if same, err := sameNode(merged, def); err != nil {
+			return nil, err
+		} else if !same {
+			merged.Content[2*i+1] = val
+		}
+	}
+	// Omit new fields which do not change the definition, e.g. empty lists.
+	for i := len(previous) - 1; i >= 0; i-- {
+		if previous[i] >= 0 {
+			continue
+		}
+		content := merged.Content
+		merged.Content = append(append([]*yaml.Node{}, content[:2*i]...), content[2*i+2:]...)
+		if same, err := sameNode(merged, def); err != nil {
+			return nil, err
+		} else if !same {
+			merged.Content = content
+		}
+	}
+	return merged, nil
+}
+
+// definitionNode encodes def, omitting permissions granting nothing as they are the default.
+func definitionNode(def any) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(def); err != nil {
+		return nil, err
+	}
+	if idx := mappingIndex(node, "permissions"); idx >= 0 {
+		var perms Permissions
+		if err := node.Content[idx+1].Decode(&perms); err == nil && reflect.DeepEqual(perms, Permissions{}) {
+			node.Content = append(node.Content[:idx], node.Content[idx+2:]...)
+		}
+	}
+	return node, nil
+}
+
+// sameNode determines whether node declares val, i.e. whether node decoded into the type of val encodes like val.
+func sameNode(node *yaml.Node, val any) (bool, error) {
+	decoded := reflect.New(reflect.TypeOf(val))
+	if err := node.Decode(decoded.Interface()); err != nil {
+		return false, nil
+	}
+	decodedNode, err := definitionNode(decoded.Elem().Interface())
+	if err != nil {
+		return false, err
+	}
+	valNode, err := definitionNode(val)
+	if err != nil {
+		return false, err
+	}
+	decodedBts, err := yaml.Marshal(decodedNode)
+	if err != nil {
+		return false, err
+	}
+	valBts, err := yaml.Marshal(valNode)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(decodedBts, valBts), nil
+}
+
+// isEmptyValue determines whether val is a zero value or points to one.
+func isEmptyValue(val any) bool {
+	v := reflect.ValueOf(val)
+	if !v.IsValid() || v.IsZero() {
+		return true
+	}
+	if v.Kind() == reflect.Pointer {
+		return v.Elem().IsZero()
+	}
+	return false
+}
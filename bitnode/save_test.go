@@ -0,0 +1,172 @@
+package bitnode
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const saveDefsYAML = `# Shop definitions.
+name: shop
+
+types:
+  # Prices are in cents.
+  - name: price
+    leaf: integer
+  - name: item
+    mapOf:
+      price: {reference: price} # flow style
+      sizes: {listOf: {leaf: integer}}
+
+interfaces:
+  - name: Catalog
+    hubs:
+      - name: get
+        type: pipe
+        direction: in
+        input:
+          - value: [{leaf: string}]
+        output:
+          - value: $item # shorthand reference
+
+blueprints:
+  - name: Catalog
+    interface: $Catalog
+`
+
+func saveDomain(t *testing.T) (*Domain, *Domain, string) {
+	dir := t.TempDir()
+	file := path.Join(dir, "defs.yml")
+	writeDefs(t, file, saveDefsYAML)
+
+	dom := NewDomain()
+	shop, _ := dom.AddDomain("shop")
+	if err := shop.LoadFromDir(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := dom.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	return dom, shop, file
+}
+
+func readDefs(t *testing.T, file string) string {
+	bts, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bts)
+}
+
+func TestDomain_SaveUnchanged(t *testing.T) {
+	_, shop, file := saveDomain(t)
+	if err := shop.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if defs := readDefs(t, file); defs != saveDefsYAML {
+		t.Fatal(defs)
+	}
+}
+
+func TestType_Save(t *testing.T) {
+	dom, shop, file := saveDomain(t)
+
+	price, _ := shop.getType("price")
+	price.Description = "Price in cents."
+	if err := price.Save(dom); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := readDefs(t, file)
+	for _, s := range []string{
+		"# Shop definitions.",
+		"# Prices are in cents.",
+		"price: {reference: price} # flow style",
+		"value: $item # shorthand reference",
+		"value: [{leaf: string}]",
+		"sizes: {listOf: {leaf: integer}}",
+		"interface: $Catalog",
+		"description: Price in cents.",
+	} {
+		if !strings.Contains(defs, s) {
+			t.Fatal(s, defs)
+		}
+	}
+	if strings.Index(defs, "name: price") > strings.Index(defs, "name: item") {
+		t.Fatal("definitions must keep their order", defs)
+	}
+
+	// The saved file must load again.
+	dom2 := NewDomain()
+	shop2, _ := dom2.AddDomain("shop")
+	if err := shop2.LoadFromFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := dom2.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if price2, _ := shop2.getType("price"); price2.Description != price.Description {
+		t.Fatal(price2)
+	}
+
+	entries, _ := os.ReadDir(path.Dir(file))
+	if len(entries) != 1 {
+		t.Fatal("temporary files must be removed", entries)
+	}
+}
+
+func TestDomain_SaveAppendDelete(t *testing.T) {
+	_, shop, file := saveDomain(t)
+
+	if _, err := shop.createType("tag", Permissions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shop.createInterface("Search", Permissions{}); err != nil {
+		t.Fatal(err)
+	}
+	defs := readDefs(t, file)
+	if !strings.Contains(defs, "- name: tag") || !strings.Contains(defs, "- name: Search") {
+		t.Fatal(defs)
+	}
+	if strings.Contains(defs, "permissions") {
+		t.Fatal("default permissions must be omitted", defs)
+	}
+
+	if err := shop.deleteType("price"); err != nil {
+		t.Fatal(err)
+	}
+	defs = readDefs(t, file)
+	if strings.Contains(defs, "name: price") || strings.Contains(defs, "# Prices are in cents.") {
+		t.Fatal(defs)
+	}
+	if !strings.Contains(defs, "# Shop definitions.") || !strings.Contains(defs, "value: [{leaf: string}]") {
+		t.Fatal(defs)
+	}
+}
+
+func TestSparkable_Save(t *testing.T) {
+	dom, shop, file := saveDomain(t)
+
+	spk, _ := shop.getSparkable("Catalog")
+	spk.Description = "The catalog."
+	if err := spk.Save(dom); err != nil {
+		t.Fatal(err)
+	}
+	interf, _ := shop.getInterface("Catalog")
+	interf.Version = "1.0.0"
+	if err := interf.Save(dom); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := readDefs(t, file)
+	if !strings.Contains(defs, "description: The catalog.") || !strings.Contains(defs, "version: 1.0.0") {
+		t.Fatal(defs)
+	}
+	if !strings.Contains(defs, "interface: $Catalog") || !strings.Contains(defs, "value: $item # shorthand reference") {
+		t.Fatal(defs)
+	}
+	if strings.Contains(defs, "constructor") {
+		t.Fatal("unchanged empty fields must not be added", defs)
+	}
+}
@@ -0,0 +1,760 @@
+package bitnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/exp/slices"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// JSONSchemaDialect is the version of JSON Schema types are exported to.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Formats of the leaves JSON Schema has no counterpart for.
+const (
+	formatDuration = "bitnode-duration"
+	formatID       = "bitnode-id"
+	formatSystemID = "bitnode-system-id"
+	formatObjectID = "bitnode-object-id"
+)
+
+// JSONSchema is a JSON Schema restricted to the keywords types are exported to and imported from.
+type JSONSchema struct {
+	// Bool is set for the boolean schemas, true accepting any value and false accepting none.
+	Bool *bool `json:"-"`
+
+	Schema      string                 `json:"$schema,omitempty"`
+	Ref         string                 `json:"$ref,omitempty"`
+	Defs        map[string]*JSONSchema `json:"$defs,omitempty"`
+	Title       string                 `json:"title,omitempty"`
+	Description string                 `json:"description,omitempty"`
+
+	// Type is either a type name or a list of type names.
+	Type            any    `json:"type,omitempty"`
+	Format          string `json:"format,omitempty"`
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+	Enum            []any  `json:"enum,omitempty"`
+	Const           any    `json:"const,omitempty"`
+	Default         any    `json:"default,omitempty"`
+
+	Minimum   *float64 `json:"minimum,omitempty"`
+	Maximum   *float64 `json:"maximum,omitempty"`
+	MinLength *int     `json:"minLength,omitempty"`
+	MaxLength *int     `json:"maxLength,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+
+	Items       *JSONSchema   `json:"items,omitempty"`
+	PrefixItems []*JSONSchema `json:"prefixItems,omitempty"`
+	MinItems    *int          `json:"minItems,omitempty"`
+	MaxItems    *int          `json:"maxItems,omitempty"`
+
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	PropertyNames        *JSONSchema            `json:"propertyNames,omitempty"`
+	MinProperties        *int                   `json:"minProperties,omitempty"`
+	MaxProperties        *int                   `json:"maxProperties,omitempty"`
+
+	OneOf []*JSONSchema `json:"oneOf,omitempty"`
+	AnyOf []*JSONSchema `json:"anyOf,omitempty"`
+	AllOf []*JSONSchema `json:"allOf,omitempty"`
+}
+
+type jsonSchema JSONSchema
+
+func (s *JSONSchema) MarshalJSON() ([]byte, error) {
+	if s.Bool != nil {
+		return json.Marshal(*s.Bool)
+	}
+	return json.Marshal((*jsonSchema)(s))
+}
+
+func (s *JSONSchema) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*s = JSONSchema{Bool: &b}
+		return nil
+	}
+	return json.Unmarshal(data, (*jsonSchema)(s))
+}
+
+// types returns the type names of s.
+func (s *JSONSchema) types() []string {
+	switch tp := s.Type.(type) {
+	case string:
+		return []string{tp}
+	case []string:
+		return tp
+	case []any:
+		types := []string{}
+		for _, t := range tp {
+			if ts, ok := t.(string); ok {
+				types = append(types, ts)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func boolSchema(b bool) *JSONSchema {
+	return &JSONSchema{Bool: &b}
+}
+
+// EXPORT
+
+// JSONSchema exports the compiled type t as JSON Schema. Domain types t is composed of are exported as definitions
+// named by their full names.
+func (t *Type) JSONSchema() (*JSONSchema, error) {
+	if t.Compiled == nil {
+		return nil, fmt.Errorf("type %s is not compiled", t.FullName)
+	}
+	s, err := t.Compiled.JSONSchema()
+	if err != nil {
+		return nil, err
+	}
+	s.Title = t.FullName
+	return s, nil
+}
+
+// JSONSchema exports the compiled type t as JSON Schema. Domain types t is composed of are exported as definitions
+// named by their full names. Map types are exported with the fields they inherit.
+func (t *RawType) JSONSchema() (*JSONSchema, error) {
+	e := &schemaExporter{
+		root: t.TypeName,
+		defs: map[string]*JSONSchema{},
+	}
+	s, err := e.exportStructure(t)
+	if err != nil {
+		return nil, err
+	}
+	if t.Optional {
+		s = nullable(s)
+	}
+	s.Schema = JSONSchemaDialect
+	if len(e.defs) > 0 {
+		s.Defs = e.defs
+	}
+	return s, nil
+}
+
+type schemaExporter struct {
+	// root is the full name of the exported type.
+	root string
+
+	// defs contains the schemas of the domain types by their full names.
+	defs map[string]*JSONSchema
+}
+
+// export returns the schema of t, referencing the definition of the domain type t has been compiled from.
+func (e *schemaExporter) export(t *RawType) (*JSONSchema, error) {
+	if t.lazy != nil {
+		return e.exportLazy(t)
+	}
+	s, err := e.exportStructure(t)
+	if err != nil {
+		return nil, err
+	}
+	if t.TypeName != "" && t.TypeName != e.root {
+		// Types differing from their domain types, e.g. due to overridden constraints, are exported inline.
+		if def, ok := e.defs[t.TypeName]; !ok {
+			e.defs[t.TypeName] = s
+			s = &JSONSchema{Ref: "#/$defs/" + t.TypeName}
+		} else if reflect.DeepEqual(def, s) {
+			s = &JSONSchema{Ref: "#/$defs/" + t.TypeName}
+		}
+	}
+	if t.Optional {
+		s = nullable(s)
+	}
+	return s, nil
+}
+
+// exportLazy returns a reference to the schema of the recursive domain type the lazy reference t resolves to.
+func (e *schemaExporter) exportLazy(t *RawType) (*JSONSchema, error) {
+	s := &JSONSchema{Ref: "#"}
+	if t.TypeName != e.root {
+		if _, ok := e.defs[t.TypeName]; !ok {
+			if t.lazy.Compiled == nil {
+				return nil, fmt.Errorf("type %s is not compiled", t.TypeName)
+			}
+			// Reserve the definition, the type references it again.
+			e.defs[t.TypeName] = &JSONSchema{}
+			def, err := e.exportStructure(t.lazy.Compiled)
+			if err != nil {
+				return nil, err
+			}
+			e.defs[t.TypeName] = def
+		}
+		s = &JSONSchema{Ref: "#/$defs/" + t.TypeName}
+	}
+	if t.Optional {
+		s = nullable(s)
+	}
+	return s, nil
+}
+
+// exportStructure returns the schema of t without considering whether it is optional.
+func (e *schemaExporter) exportStructure(t *RawType) (*JSONSchema, error) {
+	if t.Generic != "" {
+		return nil, fmt.Errorf("unbound type parameter: %s", t.Generic)
+	}
+	if len(t.Parameters) > 0 {
+		return nil, fmt.Errorf("generic types must be instantiated")
+	}
+
+	s := &JSONSchema{
+		Description: t.Description,
+		Default:     t.Default,
+	}
+	if len(t.Options) > 0 {
+		s.Enum = t.Options
+	}
+
+	var err error
+	switch {
+	case t.Reference != "":
+		return nil, fmt.Errorf("unresolved reference: %s", t.Reference)
+	case len(t.OneOf) > 0:
+		err = e.exportVariants(t, s)
+	case t.Leaf != 0:
+		exportLeaf(t.Leaf, s)
+	case t.TupleOf != nil:
+		s.Type = "array"
+		s.PrefixItems = []*JSONSchema{}
+		for _, tt := range t.TupleOf {
+			ts, err := e.export(tt)
+			if err != nil {
+				return nil, err
+			}
+			s.PrefixItems = append(s.PrefixItems, ts)
+		}
+		s.Items = boolSchema(false)
+		n := len(t.TupleOf)
+		s.MinItems = &n
+		s.MaxItems = &n
+	case t.ListOf != nil:
+		s.Type = "array"
+		s.Items, err = e.export(t.ListOf)
+	case len(t.MapOf) > 0:
+		s.Type = "object"
+		s.Properties = map[string]*JSONSchema{}
+		for k, kt := range t.MapOf {
+			ks, err := e.export(kt)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			s.Properties[k] = ks
+			if !kt.Optional {
+				s.Required = append(s.Required, k)
+			}
+		}
+		sort.Strings(s.Required)
+	case t.DictOf != nil:
+		s.Type = "object"
+		s.AdditionalProperties, err = e.export(t.DictOf)
+		if t.KeyPattern != "" {
+			s.PropertyNames = &JSONSchema{Pattern: t.KeyPattern}
+		}
+	case t.MapOf != nil:
+		s.Type = "object"
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c := t.Constraints; c != nil {
+		s.Minimum = c.Minimum
+		s.Maximum = c.Maximum
+		s.Pattern = c.Pattern
+		for _, pattern := range c.Patterns {
+			s.AllOf = append(s.AllOf, &JSONSchema{Pattern: pattern})
+		}
+		if t.Leaf == LeafString {
+			s.MinLength = c.MinLength
+			s.MaxLength = c.MaxLength
+		}
+		if t.DictOf != nil {
+			s.MinProperties = c.MinItems
+			s.MaxProperties = c.MaxItems
+		} else if t.ListOf != nil {
+			s.MinItems = c.MinItems
+			s.MaxItems = c.MaxItems
+		}
+	}
+
+	return s, nil
+}
+
+// exportVariants exports the variants of the union t into s. Discriminated variants are exported inline, with the
+// discriminator restricted to the name of the variant.
+func (e *schemaExporter) exportVariants(t *RawType, s *JSONSchema) error {
+	variants := []*JSONSchema{}
+	for _, v := range t.OneOf {
+		if t.Discriminator == "" {
+			vs, err := e.export(v)
+			if err != nil {
+				return err
+			}
+			variants = append(variants, vs)
+			continue
+		}
+		vs, err := e.exportStructure(v)
+		if err != nil {
+			return err
+		}
+		if vs.Properties == nil {
+			vs.Properties = map[string]*JSONSchema{}
+		}
+		vs.Type = "object"
+		vs.Title = v.Name
+		vs.Properties[t.Discriminator] = &JSONSchema{Type: "string", Const: v.Name}
+		vs.Required = append(vs.Required, t.Discriminator)
+		sort.Strings(vs.Required)
+		variants = append(variants, vs)
+	}
+	if t.Discriminator == "" {
+		// The first variant accepting a value is chosen, so values may be accepted by several variants.
+		s.AnyOf = variants
+	} else {
+		s.OneOf = variants
+	}
+	return nil
+}
+
+func exportLeaf(leaf LeafType, s *JSONSchema) {
+	switch leaf {
+	case LeafString:
+		s.Type = "string"
+	case LeafFloat:
+		s.Type = "number"
+	case LeafInteger:
+		s.Type = "integer"
+	case LeafBoolean:
+		s.Type = "boolean"
+	case LeafRaw:
+		s.Type = "string"
+		s.ContentEncoding = "base64"
+	case LeafTime:
+		s.Type = "string"
+		s.Format = "date-time"
+	case LeafDuration:
+		s.Type = []any{"string", "integer"}
+		s.Format = formatDuration
+	case LeafID:
+		s.Type = "string"
+		s.Format = formatID
+		s.Pattern = fmt.Sprintf("^[0-9a-f]{%d}$", 2*(SystemIDSize+ObjectIDSize))
+	case LeafSystemID:
+		s.Type = "string"
+		s.Format = formatSystemID
+		s.Pattern = fmt.Sprintf("^[0-9a-f]{%d}$", 2*SystemIDSize)
+	case LeafObjectID:
+		s.Type = "string"
+		s.Format = formatObjectID
+		s.Pattern = fmt.Sprintf("^[0-9a-f]{%d}$", 2*ObjectIDSize)
+	}
+}
+
+// nullable returns a schema accepting null in addition to the values accepted by s.
+func nullable(s *JSONSchema) *JSONSchema {
+	if s.Enum == nil && s.Const == nil {
+		switch tp := s.Type.(type) {
+		case string:
+			s.Type = []any{tp, "null"}
+			return s
+		case []any:
+			s.Type = append(tp, "null")
+			return s
+		}
+	}
+	return &JSONSchema{AnyOf: []*JSONSchema{s, {Type: "null"}}}
+}
+
+// IMPORT
+
+// ImportJSONSchema adds the type name defined by schema to dom, along with the types defined in the definitions of
+// schema. Definitions named by full names are added to the respective domains, which are created if missing.
+func (dom *Domain) ImportJSONSchema(name string, schema *JSONSchema) (*Type, error) {
+	dom.lock()
+	defer dom.unlock()
+
+	added := []*Type{}
+	rollback := func() {
+		for _, tp := range added {
+			if d, err := dom.lookupDomain(tp.Domain); err == nil {
+				if i := slices.Index(d.Types, tp); i >= 0 {
+					d.Types = slices.Delete(d.Types, i, i+1)
+				}
+			}
+		}
+	}
+
+	defNames := []string{}
+	for defName := range schema.Defs {
+		defNames = append(defNames, defName)
+	}
+	sort.Strings(defNames)
+
+	root := *schema
+	root.Defs = nil
+	schemas := map[string]*JSONSchema{name: &root}
+	for _, defName := range defNames {
+		schemas[defName] = schema.Defs[defName]
+	}
+
+	for _, typeName := range append(defNames, name) {
+		d, shortName, err := dom.importDomain(typeName)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		if err := checkName(shortName, 1, 24, false); err != nil {
+			rollback()
+			return nil, fmt.Errorf("type %s: %v", typeName, err)
+		}
+		if _, err := d.getType(shortName); err == nil {
+			rollback()
+			return nil, fmt.Errorf("type %s already exists", typeName)
+		}
+		rt, err := importSchema(schemas[typeName])
+		if err != nil {
+			rollback()
+			return nil, fmt.Errorf("type %s: %w", typeName, err)
+		}
+		tp := &Type{RawType: *rt}
+		tp.Name = shortName
+		_ = d.addType(tp)
+		added = append(added, tp)
+	}
+
+	for _, tp := range added {
+		if tp.Compiled != nil {
+			// Compiled as a dependency of another type.
+			continue
+		}
+		if err := tp.Compile(dom, tp.Domain, true); err != nil {
+			rollback()
+			return nil, fmt.Errorf("compile type %s: %w", tp.Name, err)
+		}
+	}
+
+	return added[len(added)-1], nil
+}
+
+// importDomain returns the domain a type named typeName is added to and the name of the type within it.
+func (dom *Domain) importDomain(typeName string) (*Domain, string, error) {
+	frags := strings.Split(typeName, DomSep)
+	d := dom
+	if len(frags) > 1 {
+		d = dom.Root()
+		for _, frag := range frags[:len(frags)-1] {
+			sd, err := d.getSubDomain([]string{frag})
+			if err != nil {
+				if sd, err = d.addDomain(frag); err != nil {
+					return nil, "", err
+				}
+			}
+			d = sd
+		}
+	}
+	return d, frags[len(frags)-1], nil
+}
+
+// importSchema converts s into a type. References must point to definitions.
+func importSchema(s *JSONSchema) (*RawType, error) {
+	if s == nil {
+		return &RawType{Leaf: LeafAny}, nil
+	}
+	if s.Bool != nil {
+		if !*s.Bool {
+			return nil, fmt.Errorf("cannot import schema accepting no values")
+		}
+		return &RawType{Leaf: LeafAny}, nil
+	}
+
+	types := []string{}
+	optional := false
+	for _, tp := range s.types() {
+		if tp == "null" {
+			optional = true
+			continue
+		}
+		types = append(types, tp)
+	}
+
+	var t *RawType
+	var err error
+	switch {
+	case s.Ref != "":
+		t, err = importRef(s.Ref)
+	case len(s.AllOf) > 0:
+		t, err = importAllOf(s)
+	case len(s.OneOf) > 0:
+		t, err = importVariants(s.OneOf)
+	case len(s.AnyOf) > 0:
+		t, err = importVariants(s.AnyOf)
+	case s.Format == formatDuration:
+		t = &RawType{Leaf: LeafDuration}
+	case len(types) > 1:
+		t = &RawType{OneOf: []*RawType{}}
+		for _, tp := range types {
+			ts := *s
+			ts.Type = tp
+			vt, err := importSchema(&ts)
+			if err != nil {
+				return nil, err
+			}
+			vt.Description = ""
+			vt.Default = nil
+			t.OneOf = append(t.OneOf, vt)
+		}
+	default:
+		tp := ""
+		if len(types) == 1 {
+			tp = types[0]
+		}
+		t, err = importTyped(s, tp)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.Optional = t.Optional || optional
+	if s.Description != "" {
+		t.Description = s.Description
+	}
+	if s.Default != nil {
+		t.Default = s.Default
+	}
+	if s.Enum != nil {
+		t.Options = s.Enum
+	} else if s.Const != nil {
+		t.Options = []any{s.Const}
+	}
+	return t, nil
+}
+
+// importTyped converts s into a type given its type name tp, which is inferred if empty.
+func importTyped(s *JSONSchema, tp string) (*RawType, error) {
+	if tp == "" {
+		switch {
+		case s.Properties != nil || s.AdditionalProperties != nil:
+			tp = "object"
+		case s.Items != nil || s.PrefixItems != nil:
+			tp = "array"
+		case s.Const != nil:
+			tp = jsonType(s.Const)
+		case len(s.Enum) > 0:
+			tp = jsonType(s.Enum[0])
+		}
+	}
+
+	t := &RawType{}
+	c := &Constraints{
+		Minimum: s.Minimum,
+		Maximum: s.Maximum,
+	}
+	switch tp {
+	case "string":
+		t.Leaf = LeafString
+		c.MinLength = s.MinLength
+		c.MaxLength = s.MaxLength
+		c.Pattern = s.Pattern
+		switch {
+		case s.Format == "date-time":
+			t.Leaf = LeafTime
+		case s.Format == formatID:
+			t.Leaf = LeafID
+		case s.Format == formatSystemID:
+			t.Leaf = LeafSystemID
+		case s.Format == formatObjectID:
+			t.Leaf = LeafObjectID
+		case s.ContentEncoding == "base64":
+			t.Leaf = LeafRaw
+		}
+		if t.Leaf != LeafString {
+			// The pattern of IDs is implied by their leaf.
+			c = &Constraints{}
+		}
+	case "number":
+		t.Leaf = LeafFloat
+	case "integer":
+		t.Leaf = LeafInteger
+	case "boolean":
+		t.Leaf = LeafBoolean
+	case "array":
+		if s.PrefixItems != nil {
+			t.TupleOf = []*RawType{}
+			for i, is := range s.PrefixItems {
+				it, err := importSchema(is)
+				if err != nil {
+					return nil, fmt.Errorf("item %d: %w", i, err)
+				}
+				t.TupleOf = append(t.TupleOf, it)
+			}
+			break
+		}
+		it, err := importSchema(s.Items)
+		if err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+		t.ListOf = it
+		c.MinItems = s.MinItems
+		c.MaxItems = s.MaxItems
+	case "object":
+		if s.Properties == nil && s.AdditionalProperties != nil && s.AdditionalProperties.Bool == nil {
+			vt, err := importSchema(s.AdditionalProperties)
+			if err != nil {
+				return nil, fmt.Errorf("additional properties: %w", err)
+			}
+			t.DictOf = vt
+			if s.PropertyNames != nil {
+				t.KeyPattern = s.PropertyNames.Pattern
+			}
+			c.MinItems = s.MinProperties
+			c.MaxItems = s.MaxProperties
+			break
+		}
+		t.MapOf = map[string]*RawType{}
+		for k, ks := range s.Properties {
+			kt, err := importSchema(ks)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			if !slices.Contains(s.Required, k) {
+				kt.Optional = true
+			}
+			t.MapOf[k] = kt
+		}
+	case "":
+		t.Leaf = LeafAny
+	default:
+		return nil, fmt.Errorf("unsupported type: %s", tp)
+	}
+	if !reflect.DeepEqual(c, &Constraints{}) {
+		t.Constraints = c
+	}
+	return t, nil
+}
+
+// importRef converts a reference to a definition into a type reference.
+func importRef(ref string) (*RawType, error) {
+	for _, prefix := range []string{"#/$defs/", "#/definitions/"} {
+		if strings.HasPrefix(ref, prefix) {
+			return &RawType{Reference: ref[len(prefix):]}, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported reference: %s", ref)
+}
+
+// importAllOf converts the conjunction of references to map types and a map type into a map type extending them.
+func importAllOf(s *JSONSchema) (*RawType, error) {
+	t := &RawType{MapOf: map[string]*RawType{}}
+	for i, as := range s.AllOf {
+		if as.Ref != "" {
+			rt, err := importRef(as.Ref)
+			if err != nil {
+				return nil, err
+			}
+			t.Extends = append(t.Extends, rt.Reference)
+			continue
+		}
+		at, err := importSchema(as)
+		if err != nil {
+			return nil, fmt.Errorf("allOf %d: %w", i, err)
+		}
+		if at.MapOf == nil {
+			return nil, fmt.Errorf("allOf %d: only objects can be combined", i)
+		}
+		for k, kt := range at.MapOf {
+			t.MapOf[k] = kt
+		}
+	}
+	return t, nil
+}
+
+// importVariants converts variants into a union. Null variants make the union optional, unions of objects with a
+// common property restricted to distinct strings are discriminated by that property.
+func importVariants(variants []*JSONSchema) (*RawType, error) {
+	optional := false
+	nonNull := []*JSONSchema{}
+	for _, v := range variants {
+		if v.Type == "null" {
+			optional = true
+			continue
+		}
+		nonNull = append(nonNull, v)
+	}
+	if len(nonNull) == 1 {
+		t, err := importSchema(nonNull[0])
+		if err != nil {
+			return nil, err
+		}
+		t.Optional = t.Optional || optional
+		return t, nil
+	}
+
+	discriminator := variantsDiscriminator(nonNull)
+	t := &RawType{OneOf: []*RawType{}, Discriminator: discriminator, Optional: optional}
+	for i, v := range nonNull {
+		vt, err := importSchema(v)
+		if err != nil {
+			return nil, fmt.Errorf("variant %d: %w", i, err)
+		}
+		if discriminator != "" {
+			vt.Name = v.Properties[discriminator].Const.(string)
+			delete(vt.MapOf, discriminator)
+		}
+		t.OneOf = append(t.OneOf, vt)
+	}
+	return t, nil
+}
+
+// variantsDiscriminator returns the property of the object schemas variants restricted to a distinct string each.
+func variantsDiscriminator(variants []*JSONSchema) string {
+	if len(variants) == 0 || variants[0].Properties == nil {
+		return ""
+	}
+	candidates := []string{}
+	for k := range variants[0].Properties {
+		candidates = append(candidates, k)
+	}
+	sort.Strings(candidates)
+	for _, k := range candidates {
+		names := map[string]bool{}
+		for _, v := range variants {
+			if v.Properties == nil || v.Properties[k] == nil {
+				break
+			}
+			name, ok := v.Properties[k].Const.(string)
+			if !ok || names[name] {
+				break
+			}
+			names[name] = true
+		}
+		if len(names) == len(variants) {
+			return k
+		}
+	}
+	return ""
+}
+
+// jsonType returns the JSON Schema type name of the decoded JSON value val.
+func jsonType(val any) string {
+	switch val.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return ""
+}
@@ -0,0 +1,216 @@
+package bitnode
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func domainTypes(dom *Domain) []*Type {
+	types := append([]*Type{}, dom.Types...)
+	for _, d := range dom.Domains {
+		types = append(types, domainTypes(d)...)
+	}
+	return types
+}
+
+func schemaDomain(t *testing.T) *Domain {
+	dom := NewDomain()
+	people, _ := dom.AddDomain("people")
+	if err := people.LoadFromDir("./test/schema1/people", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := dom.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	return dom
+}
+
+func exportJSON(t *testing.T, tp *Type) []byte {
+	s, err := tp.JSONSchema()
+	if err != nil {
+		t.Fatal(tp.FullName, err)
+	}
+	bts, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(tp.FullName, err)
+	}
+	return bts
+}
+
+func TestJSONSchema_Export(t *testing.T) {
+	dom := schemaDomain(t)
+
+	person, _ := dom.GetType("people.person")
+	var s map[string]any
+	if err := json.Unmarshal(exportJSON(t, person), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s["$schema"] != JSONSchemaDialect || s["title"] != "people.person" || s["type"] != "object" {
+		t.Fatal(s)
+	}
+	props := s["properties"].(map[string]any)
+	if props["address"].(map[string]any)["$ref"] != "#/$defs/people.address" {
+		t.Fatal(props["address"])
+	}
+	if _, ok := s["$defs"].(map[string]any)["people.address"]; !ok {
+		t.Fatal(s["$defs"])
+	}
+	if tp := props["age"].(map[string]any)["type"].([]any); len(tp) != 2 || tp[1] != "null" {
+		t.Fatal(props["age"])
+	}
+	if loc := props["location"].(map[string]any); len(loc["prefixItems"].([]any)) != 2 || loc["items"] != false {
+		t.Fatal(loc)
+	}
+	if props["settings"].(map[string]any)["propertyNames"].(map[string]any)["pattern"] != "^[a-z]+$" {
+		t.Fatal(props["settings"])
+	}
+	if props["born"].(map[string]any)["format"] != "date-time" {
+		t.Fatal(props["born"])
+	}
+	if props["owner"].(map[string]any)["pattern"] != "^[0-9a-f]{28}$" {
+		t.Fatal(props["owner"])
+	}
+	required := s["required"].([]any)
+	if len(required) != 7 || required[0] != "address" {
+		t.Fatal(required)
+	}
+
+	shape, _ := dom.GetType("people.shape")
+	if err := json.Unmarshal(exportJSON(t, shape), &s); err != nil {
+		t.Fatal(err)
+	}
+	variants := s["oneOf"].([]any)
+	if len(variants) != 2 {
+		t.Fatal(s)
+	}
+	kind := variants[1].(map[string]any)["properties"].(map[string]any)["kind"].(map[string]any)
+	if kind["const"] != "rect" {
+		t.Fatal(kind)
+	}
+}
+
+func TestJSONSchema_RoundTrip(t *testing.T) {
+	_, typesDom := testNode(t, "./test/types1")
+	for _, dom := range []*Domain{typesDom, schemaDomain(t)} {
+		for _, tp := range domainTypes(dom) {
+			bts := exportJSON(t, tp)
+
+			var s JSONSchema
+			if err := json.Unmarshal(bts, &s); err != nil {
+				t.Fatal(err)
+			}
+			imported, err := NewDomain().ImportJSONSchema(tp.FullName, &s)
+			if err != nil {
+				t.Fatal(tp.FullName, err)
+			}
+			if bts2 := exportJSON(t, imported); string(bts2) != string(bts) {
+				t.Fatalf("%s:\n%s\n%s", tp.FullName, bts, bts2)
+			}
+			if ok, err := tp.Compiled.accepts(imported.Compiled, ""); !ok {
+				t.Fatal(tp.FullName, err)
+			}
+			if ok, err := imported.Compiled.accepts(tp.Compiled, ""); !ok {
+				t.Fatal(tp.FullName, err)
+			}
+		}
+	}
+}
+
+const importSchemaJSON = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$defs": {
+    "base": {
+      "type": "object",
+      "properties": {"id": {"type": "string"}},
+      "required": ["id"]
+    },
+    "store.item": {
+      "type": "object",
+      "properties": {
+        "price": {"type": "number", "minimum": 0},
+        "note": {"anyOf": [{"type": "string"}, {"type": "null"}]}
+      },
+      "required": ["price"]
+    }
+  },
+  "allOf": [
+    {"$ref": "#/$defs/base"},
+    {
+      "type": "object",
+      "properties": {
+        "items": {"type": "array", "items": {"$ref": "#/$defs/store.item"}},
+        "status": {"enum": ["open", "closed"], "default": "open"},
+        "code": {"type": ["integer", "string"]}
+      },
+      "required": ["items", "code"]
+    }
+  ]
+}`
+
+func TestJSONSchema_Import(t *testing.T) {
+	var s JSONSchema
+	if err := json.Unmarshal([]byte(importSchemaJSON), &s); err != nil {
+		t.Fatal(err)
+	}
+	dom := NewDomain()
+	order, err := dom.ImportJSONSchema("order", &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dom.GetType("store.item"); err != nil {
+		t.Fatal(err)
+	}
+	if !order.Compiled.extends("base") {
+		t.Fatal(order.Compiled.CompiledExtends)
+	}
+	if len(order.Compiled.MapOf["code"].OneOf) != 2 {
+		t.Fatal(order.Compiled.MapOf["code"])
+	}
+
+	val, err := order.Compiled.ApplyMiddlewares(nil, map[string]any{
+		"id":    "o1",
+		"items": []any{map[string]any{"price": 1.5}},
+		"code":  int64(3),
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.(map[string]HubItem)["status"] != "open" {
+		t.Fatal(val)
+	}
+	if _, err := order.Compiled.ApplyMiddlewares(nil, map[string]any{
+		"id":    "o1",
+		"items": []any{map[string]any{"price": -1.0}},
+		"code":  "x",
+	}, false); err == nil {
+		t.Fatal("must respect constraints of referenced types")
+	}
+
+	if _, err := dom.ImportJSONSchema("order", &s); err == nil {
+		t.Fatal("must not replace existing types")
+	}
+	if _, err := dom.GetType("order"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSONSchema_Errors(t *testing.T) {
+	generic := &Type{RawType: RawType{Parameters: []string{"T"}, Generic: "T"}}
+	if err := generic.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generic.JSONSchema(); err == nil {
+		t.Fatal("must not export generic types")
+	}
+
+	f := false
+	if _, err := NewDomain().ImportJSONSchema("never", &JSONSchema{Bool: &f}); err == nil {
+		t.Fatal("must not import false schemas")
+	}
+	if _, err := NewDomain().ImportJSONSchema("ref", &JSONSchema{Ref: "https://example.com/schema"}); err == nil {
+		t.Fatal("must not import external references")
+	}
+	if _, err := NewDomain().ImportJSONSchema("ref", &JSONSchema{Ref: "#/$defs/missing"}); err == nil {
+		t.Fatal("must not import dangling references")
+	}
+}
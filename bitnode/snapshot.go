@@ -0,0 +1,379 @@
+package bitnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Bitspark/go-bitnode/store"
+	"github.com/Bitspark/go-bitnode/util"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A snapshot is a restore point of a node stored in the data structure "snapshots" of a node store. It contains only
+// the systems which changed since the previous snapshot and an index pointing to the snapshot holding the state of
+// each system at the time of the snapshot.
+
+// SnapshotInfo describes a snapshot.
+type SnapshotInfo struct {
+	// Label identifying the snapshot.
+	Label string `json:"label" yaml:"label"`
+
+	// Seq is the position of the snapshot, ascending with each snapshot taken.
+	Seq uint64 `json:"seq" yaml:"seq"`
+
+	// Created is the time the snapshot has been taken.
+	Created time.Time `json:"created" yaml:"created"`
+
+	// Systems is the number of systems written to this snapshot.
+	Systems int `json:"systems" yaml:"systems"`
+}
+
+// SnapshotRetention determines which snapshots are kept. The latest snapshot is always kept.
+type SnapshotRetention struct {
+	// Count is the maximum number of snapshots kept, unlimited if 0.
+	Count int `json:"count,omitempty" yaml:"count,omitempty"`
+
+	// Age is the maximum age of snapshots kept, unlimited if 0.
+	Age time.Duration `json:"age,omitempty" yaml:"age,omitempty"`
+}
+
+// SetSnapshotRetention sets the policy determining which snapshots are kept when a new snapshot is taken.
+func (h *NativeNode) SetSnapshotRetention(retention SnapshotRetention) {
+	h.snapshotMux.Lock()
+	defer h.snapshotMux.Unlock()
+	h.retention = retention
+}
+
+// Snapshot stores a restore point labelled label in st. Only systems which changed since the latest snapshot in st
+// are written, provided that snapshot has been taken or loaded by this node.
+func (h *NativeNode) Snapshot(st store.Store, label string) (*SnapshotInfo, error) {
+	if label == "" || !util.CheckString(util.CharsAlphaNum+"-_", label, true) {
+		return nil, fmt.Errorf("snapshot label must only contain alphanumeric characters, dashes and underscores")
+	}
+
+	h.snapshotMux.Lock()
+	defer h.snapshotMux.Unlock()
+
+	snapshots, err := snapshotStores(st)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := snapshots.Get(label); err == nil {
+		return nil, fmt.Errorf("already have snapshot %s", label)
+	}
+	infos, err := readSnapshots(snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &SnapshotInfo{
+		Label:   label,
+		Seq:     1,
+		Created: time.Now(),
+	}
+	prevIndex := map[string]string{}
+	if len(infos) > 0 {
+		latest := infos[len(infos)-1]
+		info.Seq = latest.Seq + 1
+		if latest.Label == h.snapshotLabel {
+			prevSt, _ := snapshots.Get(latest.Label)
+			if prevIndex, err = readSnapshotIndex(prevSt); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	snap := store.NewStore(label)
+	systemsDS, err := snap.Ensure("systems", store.DSStores)
+	if err != nil {
+		return nil, err
+	}
+	indexDS, err := snap.Ensure("index", store.DSKeyValue)
+	if err != nil {
+		return nil, err
+	}
+
+	h.systemsMux.Lock()
+	systems := make([]*NativeSystem, 0, len(h.systems))
+	for _, sys := range h.systems {
+		if sys != nil {
+			systems = append(systems, sys)
+		}
+	}
+	h.systemsMux.Unlock()
+
+	revisions := map[SystemID]uint64{}
+	changed := []*NativeSystem{}
+	for _, sys := range systems {
+		// Read the revision first, so changes while storing are contained in the next snapshot as well.
+		rev := sys.revision.Load()
+		revisions[sys.id] = rev
+		if prevLabel, ok := prevIndex[sys.id.Hex()]; ok {
+			if prevRev, ok := h.snapshotRevisions[sys.id]; ok && prevRev == rev {
+				if err := indexDS.KeyValue().Set(sys.id.Hex(), prevLabel); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		sysSt := store.NewStore(sys.id.Hex())
+		if err := sys.Store(sysSt); err != nil {
+			return nil, err
+		}
+		if err := systemsDS.Stores().Add(sysSt); err != nil {
+			return nil, err
+		}
+		if err := indexDS.KeyValue().Set(sys.id.Hex(), label); err != nil {
+			return nil, err
+		}
+		changed = append(changed, sys)
+	}
+	info.Systems = len(changed)
+
+	if err := h.storeNode(snap); err != nil {
+		return nil, err
+	}
+	if err := writeSnapshotInfo(snap, info); err != nil {
+		return nil, err
+	}
+	if err := snapshots.Add(snap); err != nil {
+		return nil, err
+	}
+
+	h.snapshotLabel = label
+	h.snapshotRevisions = revisions
+
+	for _, sys := range changed {
+		// Trigger storing.
+		sys.EmitEvent(LifecycleStore)
+	}
+
+	if err := h.pruneSnapshots(snapshots, append(infos, *info)); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// Snapshots returns the snapshots stored in st, the oldest first.
+func (h *NativeNode) Snapshots(st store.Store) ([]SnapshotInfo, error) {
+	snapshots, err := snapshotStores(st)
+	if err != nil {
+		return nil, err
+	}
+	return readSnapshots(snapshots)
+}
+
+// LoadSnapshot loads the node state of the snapshot labelled label in st. If label is empty, the latest snapshot is
+// loaded.
+func (h *NativeNode) LoadSnapshot(st store.Store, dom *Domain, label string) error {
+	h.snapshotMux.Lock()
+	defer h.snapshotMux.Unlock()
+
+	snapshots, err := snapshotStores(st)
+	if err != nil {
+		return err
+	}
+	if label == "" {
+		infos, err := readSnapshots(snapshots)
+		if err != nil {
+			return err
+		}
+		if len(infos) == 0 {
+			return fmt.Errorf("have no snapshots")
+		}
+		label = infos[len(infos)-1].Label
+	}
+	snap, err := snapshots.Get(label)
+	if err != nil {
+		return fmt.Errorf("snapshot %s not found", label)
+	}
+	index, err := readSnapshotIndex(snap)
+	if err != nil {
+		return err
+	}
+
+	// Collect the latest state of each system from the snapshots holding it.
+	collected := store.NewStore(label)
+	systemsDS, err := collected.Ensure("systems", store.DSStores)
+	if err != nil {
+		return err
+	}
+	for idStr, sysLabel := range index {
+		sysSnap, err := snapshots.Get(sysLabel)
+		if err != nil {
+			return fmt.Errorf("snapshot %s of system %s not found", sysLabel, idStr)
+		}
+		sysSnapSystemsDS, err := sysSnap.Get("systems")
+		if err != nil {
+			return err
+		}
+		sysSt, err := sysSnapSystemsDS.Stores().Get(idStr)
+		if err != nil {
+			return fmt.Errorf("system %s not found in snapshot %s", idStr, sysLabel)
+		}
+		if err := systemsDS.Stores().Add(sysSt); err != nil {
+			return err
+		}
+	}
+
+	if err := h.loadSystems(systemsDS.Stores(), dom); err != nil {
+		return err
+	}
+	if err := h.loadNode(snap); err != nil {
+		return err
+	}
+
+	h.snapshotLabel = label
+	h.snapshotRevisions = map[SystemID]uint64{}
+	h.systemsMux.Lock()
+	for id, sys := range h.systems {
+		if sys != nil {
+			h.snapshotRevisions[id] = sys.revision.Load()
+		}
+	}
+	h.systemsMux.Unlock()
+
+	return nil
+}
+
+// pruneSnapshots removes the oldest snapshots violating the retention policy. infos must be sorted by age.
+func (h *NativeNode) pruneSnapshots(snapshots store.Stores, infos []SnapshotInfo) error {
+	for len(infos) > 1 {
+		expired := h.retention.Age > 0 && time.Since(infos[0].Created) > h.retention.Age
+		if !expired && (h.retention.Count <= 0 || len(infos) <= h.retention.Count) {
+			break
+		}
+		if err := removeSnapshot(snapshots, infos[0], infos[1:]); err != nil {
+			return err
+		}
+		infos = infos[1:]
+	}
+	return nil
+}
+
+// removeSnapshot removes the snapshot old, moving the systems still referenced by the later snapshots into the next
+// snapshot.
+func removeSnapshot(snapshots store.Stores, old SnapshotInfo, later []SnapshotInfo) error {
+	oldSt, err := snapshots.Get(old.Label)
+	if err != nil {
+		return err
+	}
+	oldSystemsDS, err := oldSt.Ensure("systems", store.DSStores)
+	if err != nil {
+		return err
+	}
+	next := later[0].Label
+	for i, l := range later {
+		lSt, err := snapshots.Get(l.Label)
+		if err != nil {
+			return err
+		}
+		index, err := readSnapshotIndex(lSt)
+		if err != nil {
+			return err
+		}
+		indexDS, err := lSt.Ensure("index", store.DSKeyValue)
+		if err != nil {
+			return err
+		}
+		for idStr, sysLabel := range index {
+			if sysLabel != old.Label {
+				continue
+			}
+			if i == 0 {
+				sysSt, err := oldSystemsDS.Stores().Get(idStr)
+				if err != nil {
+					return err
+				}
+				systemsDS, err := lSt.Ensure("systems", store.DSStores)
+				if err != nil {
+					return err
+				}
+				if err := systemsDS.Stores().Add(sysSt); err != nil {
+					return err
+				}
+			}
+			if err := indexDS.KeyValue().Set(idStr, next); err != nil {
+				return err
+			}
+		}
+	}
+	return snapshots.Remove(old.Label)
+}
+
+func snapshotStores(st store.Store) (store.Stores, error) {
+	snapshotsDS, err := st.Ensure("snapshots", store.DSStores)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotsDS.Stores(), nil
+}
+
+// readSnapshots returns the information about all snapshots sorted by their sequence numbers.
+func readSnapshots(snapshots store.Stores) ([]SnapshotInfo, error) {
+	infos := []SnapshotInfo{}
+	var err error
+	for snap := range snapshots.Enumerate() {
+		if err != nil {
+			continue
+		}
+		var info SnapshotInfo
+		info, err = readSnapshotInfo(snap)
+		infos = append(infos, info)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Seq < infos[j].Seq
+	})
+	return infos, nil
+}
+
+func writeSnapshotInfo(snap store.Store, info *SnapshotInfo) error {
+	snapshotDS, err := snap.Ensure("snapshot", store.DSKeyValue)
+	if err != nil {
+		return err
+	}
+	snapshotStore := snapshotDS.KeyValue()
+	created, _ := json.Marshal(info.Created)
+	_ = snapshotStore.Set("label", info.Label)
+	_ = snapshotStore.Set("seq", strconv.FormatUint(info.Seq, 10))
+	_ = snapshotStore.Set("created", string(created))
+	_ = snapshotStore.Set("systems", strconv.Itoa(info.Systems))
+	return nil
+}
+
+func readSnapshotInfo(snap store.Store) (SnapshotInfo, error) {
+	info := SnapshotInfo{}
+	snapshotDS, err := snap.Get("snapshot")
+	if err != nil {
+		return info, fmt.Errorf("snapshot %s: %w", snap.Name(), err)
+	}
+	snapshotStore := snapshotDS.KeyValue()
+	info.Label, _ = snapshotStore.Get("label")
+	seq, _ := snapshotStore.Get("seq")
+	if info.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return info, fmt.Errorf("snapshot %s: invalid sequence number: %w", snap.Name(), err)
+	}
+	created, _ := snapshotStore.Get("created")
+	_ = json.Unmarshal([]byte(created), &info.Created)
+	systems, _ := snapshotStore.Get("systems")
+	info.Systems, _ = strconv.Atoi(systems)
+	return info, nil
+}
+
+// readSnapshotIndex returns the labels of the snapshots holding the state of each system by system ID.
+func readSnapshotIndex(snap store.Store) (map[string]string, error) {
+	indexDS, err := snap.Ensure("index", store.DSKeyValue)
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]string{}
+	for idStr := range indexDS.KeyValue().EnumerateKeys() {
+		index[idStr], _ = indexDS.KeyValue().Get(idStr)
+	}
+	return index, nil
+}
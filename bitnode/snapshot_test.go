@@ -0,0 +1,195 @@
+package bitnode
+
+import (
+	"github.com/Bitspark/go-bitnode/store"
+	"path"
+	"testing"
+)
+
+func snapshotSparkable(t *testing.T) Sparkable {
+	spk := Sparkable{RawSparkable: RawSparkable{
+		Name: "Counter",
+		Interface: &Interface{RawInterface: RawInterface{
+			Hubs: &HubInterfaces{
+				{
+					Name:      "count",
+					Type:      HubTypeValue,
+					Direction: HubDirectionBoth,
+					Value:     &HubItemInterface{Value: &Type{RawType: RawType{Leaf: LeafInteger}}},
+				},
+			},
+		}},
+	}}
+	if err := spk.Compile(nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	return spk
+}
+
+func TestNativeNode_Snapshot1(t *testing.T) {
+	spk := snapshotSparkable(t)
+
+	n := NewNode()
+	sys1, _ := n.PrepareSystem(Credentials{}, spk)
+	sys2, _ := n.PrepareSystem(Credentials{}, spk)
+	_ = sys1.GetHub("count").Set("", int64(1))
+	_ = sys2.GetHub("count").Set("", int64(2))
+
+	st := store.NewStore("test")
+
+	info, err := n.Snapshot(st, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Systems != 2 {
+		t.Fatal(info.Systems)
+	}
+
+	_ = sys2.GetHub("count").Set("", int64(3))
+	info, err = n.Snapshot(st, "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Systems != 1 {
+		t.Fatal("must only write changed systems", info.Systems)
+	}
+
+	sys2.SetName("renamed")
+	if info, _ = n.Snapshot(st, "v3"); info.Systems != 1 {
+		t.Fatal(info.Systems)
+	}
+	if info, _ = n.Snapshot(st, "v4"); info.Systems != 0 {
+		t.Fatal(info.Systems)
+	}
+	if _, err := n.Snapshot(st, "v4"); err == nil {
+		t.Fatal("labels must be unique")
+	}
+	if _, err := n.Snapshot(st, "v/5"); err == nil {
+		t.Fatal("invalid label")
+	}
+
+	infos, err := n.Snapshots(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 4 || infos[0].Label != "v1" || infos[3].Label != "v4" {
+		t.Fatal(infos)
+	}
+
+	n2 := NewNode()
+	if err := n2.LoadSnapshot(st, nil, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	sys2b, err := n2.GetSystemByID(Credentials{}, sys2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := sys2b.GetHub("count").Get(); v != int64(2) {
+		t.Fatal(v)
+	}
+
+	n3 := NewNode()
+	if err := n3.LoadSnapshot(st, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	sys1c, _ := n3.GetSystemByID(Credentials{}, sys1.ID())
+	if v, _ := sys1c.GetHub("count").Get(); v != int64(1) {
+		t.Fatal(v)
+	}
+	sys2c, _ := n3.GetSystemByID(Credentials{}, sys2.ID())
+	if v, _ := sys2c.GetHub("count").Get(); v != int64(3) {
+		t.Fatal(v)
+	}
+	if sys2c.Name() != "renamed" {
+		t.Fatal(sys2c.Name())
+	}
+
+	// Snapshots of a loaded node are incremental as well.
+	if info, _ = n3.Snapshot(st, "v5"); info.Systems != 0 {
+		t.Fatal(info.Systems)
+	}
+}
+
+func TestNativeNode_SnapshotStatus(t *testing.T) {
+	spk := snapshotSparkable(t)
+
+	n := NewNode()
+	sys, _ := n.PrepareSystem(Credentials{}, spk)
+
+	st := store.NewStore("test")
+	if _, err := n.Snapshot(st, "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing only the status of a system makes it part of the next snapshot.
+	sys.SetStatus(sys.Status() | SystemStatusRunning)
+	if info, _ := n.Snapshot(st, "v2"); info.Systems != 1 {
+		t.Fatal(info.Systems)
+	}
+
+	n2 := NewNode()
+	if err := n2.LoadSnapshot(st, nil, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	sys2, err := n2.GetSystemByID(Credentials{}, sys.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sys2.Status()&SystemStatusRunning == 0 {
+		t.Fatal(sys2.Status())
+	}
+	if info, _ := n2.Snapshot(st, "v3"); info.Systems != 0 {
+		t.Fatal(info.Systems)
+	}
+}
+
+func TestNativeNode_SnapshotRetention(t *testing.T) {
+	spk := snapshotSparkable(t)
+
+	backend, err := store.OpenBoltBackend(path.Join(t.TempDir(), "node.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	st, _ := store.OpenStore(backend, "test")
+
+	n := NewNode()
+	n.SetSnapshotRetention(SnapshotRetention{Count: 2})
+	sys1, _ := n.PrepareSystem(Credentials{}, spk)
+	sys2, _ := n.PrepareSystem(Credentials{}, spk)
+	_ = sys1.GetHub("count").Set("", int64(1))
+
+	if _, err := n.Snapshot(st, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	_ = sys2.GetHub("count").Set("", int64(2))
+	if _, err := n.Snapshot(st, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	_ = sys2.GetHub("count").Set("", int64(3))
+	if _, err := n.Snapshot(st, "v3"); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, _ := n.Snapshots(st)
+	if len(infos) != 2 || infos[0].Label != "v2" {
+		t.Fatal(infos)
+	}
+
+	// The state of sys1 has only been written to the removed snapshot v1.
+	n2 := NewNode()
+	if err := n2.LoadSnapshot(st, nil, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	sys1b, err := n2.GetSystemByID(Credentials{}, sys1.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := sys1b.GetHub("count").Get(); v != int64(1) {
+		t.Fatal(v)
+	}
+	sys2b, _ := n2.GetSystemByID(Credentials{}, sys2.ID())
+	if v, _ := sys2b.GetHub("count").Get(); v != int64(2) {
+		t.Fatal(v)
+	}
+}
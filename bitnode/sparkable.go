@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"gopkg.in/yaml.v3"
-	"os"
 )
 
 // A Sparkable for systems.
@@ -52,35 +51,18 @@ type ChildImpl struct {
 }
 
 func (m *Sparkable) Save(dom *Domain) error {
-	dom, err := dom.GetDomain(m.Domain)
+	dom, err := dom.lookupDomain(m.Domain)
 	if err != nil {
 		return err
 	}
-	chDefsBytes, err := os.ReadFile(dom.FilePath)
+	f, err := readDefinitionsFile(dom.FilePath)
 	if err != nil {
-		return fmt.Errorf("reading definitions from %s: %v", dom.FilePath, err)
-	}
-
-	defs := Domain{}
-	if err := yaml.Unmarshal(chDefsBytes, &defs); err != nil {
-		return fmt.Errorf("parsing definitions from %s: %v", dom.FilePath, err)
+		return err
 	}
-
-	for _, bp := range defs.Sparkables {
-		if bp.Name == m.Name {
-			*bp = *m
-		}
+	if err := f.setDefinition(sectionSparkables, m.Name, m); err != nil {
+		return fmt.Errorf("saving sparkable %s to %s: %v", m.Name, dom.FilePath, err)
 	}
-
-	if yamlBts, err := yaml.Marshal(defs); err != nil {
-		return fmt.Errorf("parsing definitions from %s: %v", dom.FilePath, err)
-	} else {
-		if err := os.WriteFile(dom.FilePath, yamlBts, os.ModePerm); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return f.write()
 }
 
 func (m *Sparkable) Reset() {
@@ -142,7 +124,9 @@ var NilItem = HubItem(nil)
 
 // Implement adds this implementation to the system sys.
 func (m *Sparkable) Implement(node *NativeNode, sys System) error {
-	sys.SetStatus(SystemStatusImplementing)
+	if err := sys.SetStatus(SystemStatusImplementing); err != nil {
+		return err
+	}
 
 	// Add implementations.
 	for fName, implDatas := range m.Implementation {
@@ -163,7 +147,9 @@ func (m *Sparkable) Implement(node *NativeNode, sys System) error {
 		}
 	}
 
-	sys.SetStatus((sys.Status() & ^SystemStatusImplementing) | SystemStatusImplemented)
+	if err := sys.SetStatus((sys.Status() & ^SystemStatusImplementing) | SystemStatusImplemented); err != nil {
+		return err
+	}
 
 	// Successful.
 	return nil
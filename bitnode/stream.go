@@ -0,0 +1,182 @@
+package bitnode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// StreamWindow is the number of items a stream buffers before a sender has to wait for the receiver.
+const StreamWindow = 16
+
+// A Stream is a sequence of items passed through a stream hub.
+type Stream interface {
+	// Send sends an item to the other end of the stream.
+	Send(val HubItem) error
+
+	// Receive returns the next item of the stream. Returns io.EOF at the end of the stream.
+	Receive() (HubItem, error)
+
+	// Close ends the sending direction of the stream. If err is not nil, the other end receives it as error frame.
+	Close(err error) error
+}
+
+// A StreamImpl handles streams opened on a stream hub.
+type StreamImpl interface {
+	Name() string
+	CB(ctx context.Context, creds Credentials, stream Stream, vals ...HubItem) error
+}
+
+type nativeStreamFunction struct {
+	cb func(ctx context.Context, creds Credentials, stream Stream, vals ...HubItem) error
+}
+
+var _ StreamImpl = &nativeStreamFunction{}
+
+func (n *nativeStreamFunction) Name() string {
+	return "native"
+}
+
+func (n *nativeStreamFunction) CB(ctx context.Context, creds Credentials, stream Stream, vals ...HubItem) error {
+	return n.cb(ctx, creds, stream, vals...)
+}
+
+func NewNativeStreamFunction(cb func(ctx context.Context, creds Credentials, stream Stream, vals ...HubItem) error) StreamImpl {
+	return &nativeStreamFunction{
+		cb: cb,
+	}
+}
+
+// streamFrame is either an item, an error or the end of a stream.
+type streamFrame struct {
+	val HubItem
+	err error
+	end bool
+}
+
+// streamPipe is one direction of a stream.
+type streamPipe struct {
+	ch     chan streamFrame
+	closed bool
+	mux    sync.Mutex
+}
+
+func newStreamPipe(window int) *streamPipe {
+	return &streamPipe{
+		ch: make(chan streamFrame, window),
+	}
+}
+
+// nativeStream is one end of a stream between two parties on the same node.
+type nativeStream struct {
+	ctx context.Context
+	in  *streamPipe
+	out *streamPipe
+
+	// done is set once the incoming direction has ended.
+	done bool
+
+	// onEnd is called once the incoming direction has ended.
+	onEnd func()
+}
+
+var _ Stream = &nativeStream{}
+
+// NewStreamPair creates two connected ends of a stream. Items sent on one end are received on the other one.
+func NewStreamPair(ctx context.Context, window int) (Stream, Stream) {
+	a := newStreamPipe(window)
+	b := newStreamPipe(window)
+	return &nativeStream{ctx: ctx, in: a, out: b}, &nativeStream{ctx: ctx, in: b, out: a}
+}
+
+func (s *nativeStream) Send(val HubItem) error {
+	return s.send(streamFrame{val: val})
+}
+
+func (s *nativeStream) send(f streamFrame) error {
+	s.out.mux.Lock()
+	if s.out.closed {
+		s.out.mux.Unlock()
+		return fmt.Errorf("stream closed")
+	}
+	if f.end {
+		s.out.closed = true
+	}
+	s.out.mux.Unlock()
+	select {
+	case s.out.ch <- f:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *nativeStream) Receive() (HubItem, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	select {
+	case f := <-s.in.ch:
+		if f.end {
+			s.done = true
+			if s.onEnd != nil {
+				s.onEnd()
+			}
+			if f.err != nil {
+				return nil, f.err
+			}
+			return nil, io.EOF
+		}
+		return f.val, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func (s *nativeStream) Close(err error) error {
+	return s.send(streamFrame{err: err, end: true})
+}
+
+// typedStream applies middlewares to the items passed through a stream.
+type typedStream struct {
+	Stream
+	mws  Middlewares
+	recv *HubItemInterface
+	send *HubItemInterface
+}
+
+func (s *typedStream) Send(val HubItem) error {
+	if s.send == nil {
+		return fmt.Errorf("stream does not accept items")
+	}
+	vval, err := s.send.ApplyMiddlewares(s.mws, val, false)
+	if err != nil {
+		return err
+	}
+	return s.Stream.Send(vval)
+}
+
+func (s *typedStream) Receive() (HubItem, error) {
+	val, err := s.Stream.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return s.recv.ApplyMiddlewares(s.mws, val, true)
+}
+
+// PipeStream forwards all items received from src to dst until src ends. Returns the error src ended with, if any.
+// The caller is responsible for closing dst.
+func PipeStream(src Stream, dst Stream) error {
+	for {
+		val, err := src.Receive()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := dst.Send(val); err != nil {
+			return err
+		}
+	}
+}
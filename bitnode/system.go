@@ -1,13 +1,15 @@
 package bitnode
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/Bitspark/go-bitnode/store"
 	"github.com/Bitspark/go-bitnode/util"
-	"log"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -86,19 +88,19 @@ type System interface {
 	Status() int
 
 	// Stop stops the system.
-	Stop(timeout float64)
+	Stop(timeout float64) error
 
 	// Start starts the system.
-	Start()
+	Start() error
 
 	// Delete deletes the system and kills it if necessary.
-	Delete()
+	Delete() error
 
 	// SetName changes the name of the system.
-	SetName(name string)
+	SetName(name string) error
 
 	// SetStatus sets the provided status on top of the current status. Negative values are unset.
-	SetStatus(status int)
+	SetStatus(status int) error
 
 	// GetHub returns a hub of this system.
 	GetHub(hubName string) Hub
@@ -211,6 +213,9 @@ type NativeSystem struct {
 	// sparkable this system has been created from.
 	sparkable Sparkable
 
+	// permissions required to access this system and its hubs. If nil, access is unrestricted.
+	permissions *Permissions
+
 	// The parent system of this system.
 	parent System
 
@@ -238,12 +243,20 @@ type NativeSystem struct {
 
 	extensions []FactoryExtension
 
-	status int
+	// status is changed by lifecycle events, which may be emitted concurrently with snapshots.
+	status atomic.Int64
 
 	remoteID SystemID
 
 	remoteNode string
 
+	// persistent systems write all their value hubs to the persist store of their node whenever they are set.
+	persistent    bool
+	persistentMux sync.Mutex
+
+	// revision is incremented whenever state recorded in snapshots changes.
+	revision atomic.Uint64
+
 	eventsMux sync.Mutex
 
 	implMux sync.Mutex
@@ -271,27 +284,147 @@ func (s *NativeSystem) Name() string {
 }
 
 func (s *NativeSystem) Status() int {
-	return s.status
+	return int(s.status.Load())
 }
 
-func (s *NativeSystem) Stop(creds Credentials, timeout float64) {
-	_ = s.EmitEvent(LifecycleStop, timeout)
+func (s *NativeSystem) Stop(creds Credentials, timeout float64) error {
+	if err := s.Authorize(PermissionAdmin, creds, "stop"); err != nil {
+		return err
+	}
+	return s.EmitEvent(LifecycleStop, timeout)
 }
 
-func (s *NativeSystem) Start(creds Credentials) {
-	_ = s.EmitEvent(LifecycleStart)
+func (s *NativeSystem) Start(creds Credentials) error {
+	if err := s.Authorize(PermissionAdmin, creds, "start"); err != nil {
+		return err
+	}
+	return s.EmitEvent(LifecycleStart)
+}
+
+func (s *NativeSystem) Delete(creds Credentials) error {
+	if err := s.Authorize(PermissionAdmin, creds, "delete"); err != nil {
+		return err
+	}
+	return s.EmitEvent(LifecycleDelete)
+}
+
+func (s *NativeSystem) SetName(creds Credentials, name string) error {
+	if err := s.Authorize(PermissionAdmin, creds, "set name"); err != nil {
+		return err
+	}
+	return s.EmitEvent(LifecycleName, name)
+}
+
+func (s *NativeSystem) SetStatus(creds Credentials, status int) error {
+	if err := s.Authorize(PermissionAdmin, creds, "set status"); err != nil {
+		return err
+	}
+	return s.EmitEvent(LifecycleStatus, int64(status))
+}
+
+// Permissions returns the permissions of this system.
+func (s *NativeSystem) Permissions() *Permissions {
+	return s.permissions
+}
+
+// SetPermissions sets the permissions of this system. If nil, access is unrestricted.
+func (s *NativeSystem) SetPermissions(perms *Permissions) {
+	s.permissions = perms
+}
+
+// Persistent reveals if all value hubs of this system are persisted whenever they are set.
+func (s *NativeSystem) Persistent() bool {
+	s.persistentMux.Lock()
+	defer s.persistentMux.Unlock()
+	return s.persistent
+}
+
+// SetPersistent determines whether all value hubs of this system are persisted whenever they are set. When enabled,
+// the current values are persisted immediately.
+func (s *NativeSystem) SetPersistent(persistent bool) error {
+	s.persistentMux.Lock()
+	s.persistent = persistent
+	s.persistentMux.Unlock()
+	if s.node == nil {
+		return nil
+	}
+	st, err := s.node.persistedSystem(s, true)
+	if err != nil || st == nil {
+		return err
+	}
+	systemStoreDS, err := st.Ensure("system", store.DSKeyValue)
+	if err != nil {
+		return err
+	}
+	if err := systemStoreDS.KeyValue().Set("persistent", strconv.FormatBool(persistent)); err != nil {
+		return err
+	}
+	if !persistent {
+		return nil
+	}
+	for _, hub := range s.hubs {
+		if hub.Interface().Type != HubTypeValue {
+			continue
+		}
+		val := hub.current()
+		if val == nil {
+			continue
+		}
+		if err := s.persistHub(hub, val); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *NativeSystem) Delete(creds Credentials) {
-	_ = s.EmitEvent(LifecycleDelete)
+// persistHub writes val to the persist store of the node if the node has one.
+func (s *NativeSystem) persistHub(hub *NativeHub, val HubItem) error {
+	if s.node == nil {
+		return nil
+	}
+	st, err := s.node.persistedSystem(s, true)
+	if err != nil || st == nil {
+		return err
+	}
+	hubStoreDS, err := st.Ensure("hubs", store.DSKeyValue)
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		return hubStoreDS.KeyValue().Set(hub.Name(), "null")
+	}
+	valJSON, err := s.encodeHubValue(hub, val)
+	if err != nil {
+		return fmt.Errorf("persisting %s: %w", hub.Name(), err)
+	}
+	return hubStoreDS.KeyValue().Set(hub.Name(), valJSON)
 }
 
-func (s *NativeSystem) SetName(creds Credentials, name string) {
-	_ = s.EmitEvent(LifecycleName, name)
+// encodeHubValue converts the value of a value hub into the JSON representation used in stores.
+func (s *NativeSystem) encodeHubValue(hub *NativeHub, val HubItem) (string, error) {
+	vval, err := hub.Interface().Value.ApplyMiddlewares(Middlewares{systemWrapper{h: s.node}, idWrapper{h: s.node}}, val, true)
+	if err != nil {
+		return "", err
+	}
+	valBts, err := json.Marshal(EncodeDurations(vval))
+	if err != nil {
+		return "", err
+	}
+	return string(valBts), nil
 }
 
-func (s *NativeSystem) SetStatus(creds Credentials, status int) {
-	_ = s.EmitEvent(LifecycleStatus, int64(status))
+// decodeHubValue converts the JSON representation of a value hub used in stores into its value.
+func (s *NativeSystem) decodeHubValue(hub *NativeHub, valJSON string) (HubItem, error) {
+	var val HubItem
+	if err := json.Unmarshal([]byte(valJSON), &val); err != nil {
+		return nil, err
+	}
+	return hub.Interface().Value.ApplyMiddlewares(Middlewares{systemWrapper{h: s.node}, idWrapper{h: s.node}}, val, false)
+}
+
+// Authorize returns a PermissionError if creds lack permission on this system and audits the denial.
+func (s *NativeSystem) Authorize(permission string, creds Credentials, action string) error {
+	return s.permissions.Authorize(permission, creds, "system "+s.id.Hex(), action)
 }
 
 func (s *NativeSystem) Constructor() HubItemsInterface {
@@ -341,6 +474,10 @@ func (s *NativeSystem) Interface() *Interface {
 	}
 	interf := NewInterface()
 	interf.Domain = s.sparkable.Domain
+	if s.sparkable.Interface != nil {
+		interf.Name = s.sparkable.Interface.Name
+		interf.Version = s.sparkable.Interface.Version
+	}
 	for _, hub := range s.hubs {
 		hubInterf := hub.Interface()
 		_ = interf.Hubs.AddHub(hubInterf)
@@ -403,6 +540,7 @@ func (s *NativeSystem) origin(path []string) *NativeSystem {
 }
 
 func (s *NativeSystem) AddOrigin(name string, origin *NativeSystem) {
+	s.touch()
 	s.origins[name] = origin
 	origin.parents = append(origin.parents, NativeLink{
 		Name:   name,
@@ -471,7 +609,9 @@ func (s *NativeSystem) EmitEvent(name string, args ...HubItem) error {
 	oldStatus := s.Status()
 
 	if preStatus != SystemStatusUndefined {
-		s.SetStatus(Credentials{}, oldStatus|preStatus)
+		if err := s.SetStatus(nodeCredentials, oldStatus|preStatus); err != nil {
+			return err
+		}
 	}
 
 	s.eventsMux.Lock()
@@ -481,7 +621,7 @@ func (s *NativeSystem) EmitEvent(name string, args ...HubItem) error {
 		for _, cb := range events.Callbacks {
 			if err := cb.CB(args...); err != nil {
 				if preStatus != SystemStatusUndefined {
-					s.SetStatus(Credentials{}, oldStatus & ^preStatus)
+					_ = s.SetStatus(nodeCredentials, oldStatus & ^preStatus)
 				}
 				return err
 			}
@@ -490,10 +630,9 @@ func (s *NativeSystem) EmitEvent(name string, args ...HubItem) error {
 
 	if preStatus != SystemStatusUndefined || postStatus != SystemStatusUndefined {
 		if postStatus >= 0 {
-			s.SetStatus(Credentials{}, (s.Status() & ^preStatus)|postStatus)
-		} else {
-			s.SetStatus(Credentials{}, (s.Status() & ^preStatus) & ^(-postStatus))
+			return s.SetStatus(nodeCredentials, (s.Status() & ^preStatus)|postStatus)
 		}
+		return s.SetStatus(nodeCredentials, (s.Status() & ^preStatus) & ^(-postStatus))
 	}
 
 	return nil
@@ -513,9 +652,15 @@ func (s *NativeSystem) AddSystem(sys *NativeSystem) error {
 		return fmt.Errorf("already have child with name %s", sys.Name())
 	}
 	s.systems[sys.ID()] = sys
+	s.touch()
 	return nil
 }
 
+// touch marks the system as changed since the latest snapshot.
+func (s *NativeSystem) touch() {
+	s.revision.Add(1)
+}
+
 func (s *NativeSystem) Connected() bool {
 	return true
 }
@@ -530,23 +675,12 @@ type origSt struct {
 }
 
 func (s *NativeSystem) Store(st store.Store) error {
-	systemStoreDS, _ := st.Ensure("system", store.DSKeyValue)
-	systemStore := systemStoreDS.KeyValue()
-
-	_ = systemStore.Set("id", s.id.Hex())
-	_ = systemStore.Set("name", s.name)
-	_ = systemStore.Set("extends", strings.Join(s.extends, ","))
-	_ = systemStore.Set("remoteNode", s.remoteNode)
-	_ = systemStore.Set("remoteID", s.remoteID.Hex())
-
-	bp, _ := s.Sparkable()
-	bpJSON, _ := json.Marshal(bp)
-	_ = systemStore.Set("sparkable", string(bpJSON))
+	systemStore := s.storeSkeleton(st)
 
 	hubStoreDS, _ := st.Ensure("hubs", store.DSKeyValue)
 	hubStore := hubStoreDS.KeyValue()
 
-	creds := Credentials{}
+	creds := nodeCredentials
 
 	for _, hub := range s.hubs {
 		hubInterf := hub.Interface()
@@ -554,15 +688,19 @@ func (s *NativeSystem) Store(st store.Store) error {
 		case HubTypeValue:
 			val, err := hub.Get(creds, s.node.middlewares)
 			if err != nil {
-				log.Printf("error getting value %s: %v", hub.Name(), err)
-				continue
+				return fmt.Errorf("getting value %s: %w", hub.Name(), err)
 			}
 			if hub.Interface().Value == nil {
 				panic(hub.Name())
 			}
-			vval, _ := hub.Interface().Value.ApplyMiddlewares(Middlewares{systemWrapper{h: s.node}, idWrapper{h: s.node}}, val, true)
-			valBts, _ := json.Marshal(vval)
-			_ = hubStore.Set(hub.Name(), string(valBts))
+			if val == nil {
+				continue
+			}
+			valJSON, err := s.encodeHubValue(hub, val)
+			if err != nil {
+				return fmt.Errorf("encoding value %s: %w", hub.Name(), err)
+			}
+			_ = hubStore.Set(hub.Name(), valJSON)
 		}
 	}
 
@@ -586,6 +724,34 @@ func (s *NativeSystem) Store(st store.Store) error {
 	return nil
 }
 
+// storeSkeleton writes the information required to recreate the system without its hub values, children and origins.
+func (s *NativeSystem) storeSkeleton(st store.Store) store.KeyValue {
+	systemStoreDS, _ := st.Ensure("system", store.DSKeyValue)
+	systemStore := systemStoreDS.KeyValue()
+
+	_ = systemStore.Set("id", s.id.Hex())
+	_ = systemStore.Set("name", s.name)
+	_ = systemStore.Set("status", strconv.Itoa(s.Status()))
+	_ = systemStore.Set("extends", strings.Join(s.extends, ","))
+	_ = systemStore.Set("remoteNode", s.remoteNode)
+	_ = systemStore.Set("remoteID", s.remoteID.Hex())
+
+	bp, _ := s.Sparkable()
+	bpJSON, _ := json.Marshal(bp)
+	_ = systemStore.Set("sparkable", string(bpJSON))
+
+	if s.permissions != nil {
+		permsJSON, _ := json.Marshal(s.permissions)
+		_ = systemStore.Set("permissions", string(permsJSON))
+	}
+
+	if s.Persistent() {
+		_ = systemStore.Set("persistent", "true")
+	}
+
+	return systemStore
+}
+
 // LoadInit loads all information which does not require other systems.
 func (s *NativeSystem) LoadInit(node *NativeNode, st store.Store) error {
 	s.node = node
@@ -610,6 +776,17 @@ func (s *NativeSystem) LoadInit(node *NativeNode, st store.Store) error {
 	extends, _ := systemStore.Get("extends")
 	s.extends = strings.Split(extends, ",")
 
+	if permsJSON, err := systemStore.Get("permissions"); err == nil && permsJSON != "" {
+		perms := &Permissions{}
+		if err := json.Unmarshal([]byte(permsJSON), perms); err != nil {
+			return err
+		}
+		s.permissions = perms
+	}
+
+	persistent, _ := systemStore.Get("persistent")
+	s.persistent = persistent == "true"
+
 	return nil
 }
 
@@ -641,25 +818,37 @@ func (s *NativeSystem) Load(node *NativeNode, dom *Domain, st store.Store) error
 		}
 	}
 
-	if err := s.sparkable.Implement(node, s.Wrap(Credentials{}, node.middlewares)); err != nil {
+	if err := s.sparkable.Implement(node, s.Wrap(nodeCredentials, node.middlewares)); err != nil {
 		return err
 	}
 
+	// Implementing the system resets its status.
+	if statusStr, _ := systemStore.Get("status"); statusStr != "" {
+		status, err := strconv.Atoi(statusStr)
+		if err != nil {
+			return fmt.Errorf("invalid status: %v", err)
+		}
+		s.status.Store(int64(status))
+	}
+
 	hubStoreDS, _ := st.Ensure("hubs", store.DSKeyValue)
 	hubStore := hubStoreDS.KeyValue()
 
-	creds := Credentials{}
+	creds := nodeCredentials
 
 	for hubName := range hubStore.EnumerateKeys() {
 		hub := s.getHub(hubName)
 		hubInterf := hub.Interface()
 		switch hubInterf.Type {
 		case HubTypeValue:
-			hubValBts, _ := hubStore.Get(hubName)
-			var val HubItem
-			_ = json.Unmarshal([]byte(hubValBts), &val)
-			vval, _ := hub.Interface().Value.ApplyMiddlewares(Middlewares{systemWrapper{h: s.node}, idWrapper{h: s.node}}, val, false)
-			_ = hub.Set(creds, node.middlewares, "", vval)
+			hubValJSON, _ := hubStore.Get(hubName)
+			vval, err := s.decodeHubValue(hub, hubValJSON)
+			if err != nil {
+				return fmt.Errorf("decoding value %s: %w", hubName, err)
+			}
+			if err := hub.set(creds, node.middlewares, "", vval); err != nil {
+				return fmt.Errorf("setting value %s: %w", hubName, err)
+			}
 		}
 	}
 
@@ -675,7 +864,7 @@ func (s *NativeSystem) Load(node *NativeNode, dom *Domain, st store.Store) error
 	origs := []origSt{}
 	_ = json.Unmarshal([]byte(origins), &origs)
 	for _, o := range origs {
-		orig, err := node.GetSystemByID(Credentials{}, o.Origin)
+		orig, err := node.GetSystemByID(nodeCredentials, o.Origin)
 		if err != nil {
 			return err
 		}
@@ -757,10 +946,10 @@ func (s *NativeSystem) RemoteNode() string {
 }
 
 func (s *NativeSystem) RedirectFrom(origin *NativeSystem) {
-	for _, origHub := range origin.Hubs(Credentials{}) {
+	for _, origHub := range origin.Hubs(nodeCredentials) {
 		func(origHub *NativeHub) {
 			hubInterf := origHub.Interface()
-			hub := s.GetHub(Credentials{}, nil, hubInterf.Name)
+			hub := s.GetHub(nodeCredentials, nil, hubInterf.Name)
 			if hub == nil {
 				nativeHub := &NativeHub{
 					parent:       s,
@@ -773,23 +962,37 @@ func (s *NativeSystem) RedirectFrom(origin *NativeSystem) {
 
 			switch hubInterf.Type {
 			case HubTypePipe:
-				_ = nativeHub.Handle(NewNativeFunction(func(user Credentials, vals ...HubItem) ([]HubItem, error) {
-					return origHub.Invoke(user, nil, vals...)
+				_ = nativeHub.Handle(NewNativeContextFunction(func(ctx context.Context, user Credentials, vals ...HubItem) ([]HubItem, error) {
+					return origHub.InvokeContext(ctx, user, nil, vals...)
+				}))
+
+			case HubTypeStream:
+				_ = nativeHub.HandleStream(NewNativeStreamFunction(func(ctx context.Context, user Credentials, stream Stream, vals ...HubItem) error {
+					origStream, err := origHub.Stream(ctx, user, nil, vals...)
+					if err != nil {
+						return err
+					}
+					if hubInterf.Upstream != nil {
+						go func() {
+							_ = origStream.Close(PipeStream(stream, origStream))
+						}()
+					}
+					return PipeStream(origStream, stream)
 				}))
 
 			case HubTypeValue:
-				_, _ = nativeHub.Subscribe(Credentials{}, nil, NewNativeSubscription(func(id string, creds Credentials, val HubItem) {
+				_, _ = nativeHub.Subscribe(nodeCredentials, nil, NewNativeSubscription(func(id string, creds Credentials, val HubItem) {
 					_ = origHub.Set(creds, nil, id, val)
 				}))
-				_, _ = origHub.Subscribe(Credentials{}, nil, NewNativeSubscription(func(id string, creds Credentials, val HubItem) {
+				_, _ = origHub.Subscribe(nodeCredentials, nil, NewNativeSubscription(func(id string, creds Credentials, val HubItem) {
 					_ = nativeHub.Set(creds, nil, id, val)
 				}))
 
 			case HubTypeChannel:
-				_, _ = nativeHub.Subscribe(Credentials{}, nil, NewNativeSubscription(func(id string, creds Credentials, val HubItem) {
+				_, _ = nativeHub.Subscribe(nodeCredentials, nil, NewNativeSubscription(func(id string, creds Credentials, val HubItem) {
 					_ = origHub.Emit(creds, nil, id, val)
 				}))
-				_, _ = origHub.Subscribe(Credentials{}, nil, NewNativeSubscription(func(id string, creds Credentials, val HubItem) {
+				_, _ = origHub.Subscribe(nodeCredentials, nil, NewNativeSubscription(func(id string, creds Credentials, val HubItem) {
 					_ = nativeHub.Emit(creds, nil, id, val)
 				}))
 			}
@@ -805,24 +1008,24 @@ type CredSystem struct {
 
 var _ System = &CredSystem{}
 
-func (s *CredSystem) Stop(timeout float64) {
-	s.NativeSystem.Stop(s.creds, timeout)
+func (s *CredSystem) Stop(timeout float64) error {
+	return s.NativeSystem.Stop(s.creds, timeout)
 }
 
-func (s *CredSystem) Start() {
-	s.NativeSystem.Start(s.creds)
+func (s *CredSystem) Start() error {
+	return s.NativeSystem.Start(s.creds)
 }
 
-func (s *CredSystem) Delete() {
-	s.NativeSystem.Delete(s.creds)
+func (s *CredSystem) Delete() error {
+	return s.NativeSystem.Delete(s.creds)
 }
 
-func (s *CredSystem) SetName(name string) {
-	s.NativeSystem.SetName(s.creds, name)
+func (s *CredSystem) SetName(name string) error {
+	return s.NativeSystem.SetName(s.creds, name)
 }
 
-func (s *CredSystem) SetStatus(status int) {
-	s.NativeSystem.SetStatus(s.creds, status)
+func (s *CredSystem) SetStatus(status int) error {
+	return s.NativeSystem.SetStatus(s.creds, status)
 }
 
 func (s *CredSystem) GetHub(hubName string) Hub {
@@ -91,6 +91,36 @@ func TestNativeSystem_Store2(t *testing.T) {
 	}
 }
 
+func TestNativeSystem_Store3(t *testing.T) {
+	h := NewNode()
+
+	sys, err := h.PrepareSystem(Credentials{}, snapshotSparkable(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sys.GetHub("count").Set("", int64(1))
+
+	st := store.NewStore("test")
+
+	if err := sys.Native().Store(st); err != nil {
+		t.Fatal(err)
+	}
+
+	hubStoreDS, _ := st.Ensure("hubs", store.DSKeyValue)
+	_ = hubStoreDS.KeyValue().Set("count", "{")
+
+	h2 := NewNode()
+
+	sys2 := &NativeSystem{}
+
+	if err := sys2.LoadInit(h2, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := sys2.Load(h2, nil, st); err == nil {
+		t.Fatal("invalid hub values must not be ignored")
+	}
+}
+
 func TestNativeSystem_Origin1(t *testing.T) {
 	h := NewNode()
 
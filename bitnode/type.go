@@ -4,10 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Bitspark/go-bitnode/util"
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 	"log"
-	"os"
 	"reflect"
+	"strings"
 )
 
 // Get type
@@ -20,8 +21,15 @@ type Type struct {
 	// FullName of this type, can be used to reference it from elsewhere.
 	FullName string `json:"fullName,omitempty" yaml:"-"`
 
+	// compiling indicates that the type itself is being compiled, references to it are resolved lazily meanwhile.
 	compiling bool
 
+	// compilingReferences indicates that the types referencing this type are being compiled.
+	compilingReferences bool
+
+	// resetting indicates that the type is being reset to avoid a stack overflow for recursive types.
+	resetting bool
+
 	references map[Compilable]bool
 }
 
@@ -29,6 +37,14 @@ var _ Compilable = &Type{}
 var _ Savable = &Type{}
 
 func (t *Type) Reset() {
+	if t.resetting {
+		return
+	}
+	t.resetting = true
+	defer func() {
+		t.resetting = false
+	}()
+
 	t.Compiled = nil
 
 	// Reset references.
@@ -47,17 +63,37 @@ func (t *Type) Compile(dom *Domain, domName string, resolve bool) error {
 		t.references = map[Compilable]bool{}
 	}
 
+	t.compiling = true
 	var err error
 	t.Compiled, err = t.RawType.Compile(dom, domName, resolve, t)
+	t.compiling = false
+	if t.Compiled != nil && t.Name != "" && len(t.Parameters) == 0 {
+		t.Compiled.TypeName = t.FullName
+	}
+	if err != nil {
+		// Dependent types would fail to resolve t and compile it again.
+		return err
+	}
+	if t.Compiled.infinite(t) {
+		t.Compiled = nil
+		return fmt.Errorf("recursive type %s has no finite values, it must reference itself through a list, dictionary or optional value", t.FullName)
+	}
 
-	// Compile references.
+	// Compile references. Types which are compiling themselves or their references already use t.
+	t.compilingReferences = true
+	defer func() {
+		t.compilingReferences = false
+	}()
 	for rt := range t.references {
+		if rtt, ok := rt.(*Type); ok && (rtt.compiling || rtt.compilingReferences) {
+			continue
+		}
 		if err := rt.Compile(dom, rt.FullDomain(), true); err != nil {
 			return err
 		}
 	}
 
-	return err
+	return nil
 }
 
 func (t *Type) FullDomain() string {
@@ -83,6 +119,12 @@ type RawType struct {
 	// Extends contains types this type extends.
 	Extends []string `json:"extends" yaml:"extends,omitempty"`
 
+	// CompiledExtends contains the full names of all types this type has been derived from.
+	CompiledExtends []string `json:"compiledExtends,omitempty" yaml:"-"`
+
+	// TypeName is the full name of the domain type this type has been compiled from.
+	TypeName string `json:"typeName,omitempty" yaml:"-"`
+
 	// ListOf creates a list type from that type.
 	ListOf *RawType `json:"listOf,omitempty" yaml:"listOf,omitempty"`
 
@@ -92,65 +134,92 @@ type RawType struct {
 	// MapOf creates a map type from these types.
 	MapOf map[string]*RawType `json:"mapOf" yaml:"mapOf,omitempty"`
 
+	// DictOf creates a dictionary type with arbitrary keys whose values have that type.
+	DictOf *RawType `json:"dictOf,omitempty" yaml:"dictOf,omitempty"`
+
+	// KeyPattern is a regular expression the keys of a dictionary must match.
+	KeyPattern string `json:"keyPattern,omitempty" yaml:"keyPattern,omitempty"`
+
+	// OneOf creates a union type whose values have one of these types.
+	OneOf []*RawType `json:"oneOf,omitempty" yaml:"oneOf,omitempty"`
+
+	// Discriminator is the map entry naming the variant of a union of map types. Each variant must have a name.
+	Discriminator string `json:"discriminator,omitempty" yaml:"discriminator,omitempty"`
+
 	// Optional is true when the value can be nil.
 	Optional bool `json:"optional,omitempty" yaml:"optional,omitempty"`
 
+	// Default is the value used for a missing input value or map entry.
+	Default any `json:"default,omitempty" yaml:"default,omitempty"`
+
 	//// IDOf is an ID of a model object.
 	//IDOf *IDType `json:"idOf,omitempty" yaml:"idOf,omitempty"`
 
-	//// Generic represents a slot in the value to be replaced by another value
-	//Generic string `json:"generic,omitempty" yaml:"generic,omitempty"`
-	//
-	//// GenericMap contains types for generic slots
-	//GenericMap GenericTypeMap `json:"genericMap,omitempty" yaml:"genericMap,omitempty"`
+	// Parameters are the names of the type parameters of a generic type, e.g. T for Page<T>.
+	Parameters []string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+
+	// Generic represents a slot in the value to be replaced by the argument for this type parameter.
+	Generic string `json:"generic,omitempty" yaml:"generic,omitempty"`
+
+	// Arguments for the type parameters of the referenced generic type.
+	Arguments []*RawType `json:"arguments,omitempty" yaml:"arguments,omitempty"`
 
 	// Options contains valid options for this type. They each must have this type. Has no impact if not specified.
 	Options []any `json:"options,omitempty" yaml:"options,omitempty"`
 
+	// Constraints restrict the values of this type, e.g. to a range or pattern.
+	Constraints *Constraints `json:"constraints,omitempty" yaml:"constraints,omitempty"`
+
 	// Extensions can contain additional constraints about the type, particularly in combination with Reference.
 	Extensions map[string]any `json:"extensions,omitempty" yaml:"extensions,omitempty"`
 
 	// Domain this type resides in.
 	Domain string `json:"domain,omitempty" yaml:"-"`
+
+	// lazy is the recursive domain type a compiled reference resolves to when validating values.
+	lazy *Type
 }
 
 type TypeExtension any
 
 func (t *RawType) Save(dom *Domain) error {
-	tp, err := dom.GetDomain(t.Domain)
+	tp, err := dom.lookupDomain(t.Domain)
 	if err != nil {
 		return err
 	}
-	chDefsBytes, err := os.ReadFile(tp.FilePath)
+	f, err := readDefinitionsFile(tp.FilePath)
 	if err != nil {
-		return fmt.Errorf("reading definitions from %s: %v", tp.FilePath, err)
-	}
-
-	defs := Domain{}
-	if err := yaml.Unmarshal(chDefsBytes, &defs); err != nil {
-		return fmt.Errorf("parsing definitions from %s: %v", tp.FilePath, err)
-	}
-
-	for _, tp2 := range defs.Types {
-		if tp2.Name == t.Name {
-			tp2.RawType = *t
-		}
+		return err
 	}
-
-	if yamlBts, err := yaml.Marshal(defs); err != nil {
-		return fmt.Errorf("parsing definitions from %s: %v", tp.FilePath, err)
-	} else {
-		if err := os.WriteFile(tp.FilePath, yamlBts, os.ModePerm); err != nil {
-			return err
-		}
+	if err := f.setDefinition(sectionTypes, t.Name, &Type{RawType: *t}); err != nil {
+		return fmt.Errorf("saving type %s to %s: %v", t.Name, tp.FilePath, err)
 	}
-
-	return nil
+	return f.write()
 }
 
 func (t *RawType) Compile(dom *Domain, domName string, resolve bool, rootType *Type) (*RawType, error) {
 	compiled := t.Copy()
 
+	if err := t.checkParameters(); err != nil {
+		return nil, err
+	}
+	if t.Generic != "" && (rootType == nil || !slices.Contains(rootType.Parameters, t.Generic)) {
+		return nil, fmt.Errorf("unbound type parameter: %s", t.Generic)
+	}
+	if t.Arguments != nil {
+		if t.Reference == "" {
+			return nil, fmt.Errorf("type arguments require a reference")
+		}
+		compiled.Arguments = make([]*RawType, len(t.Arguments))
+		for i, ct := range t.Arguments {
+			if ctt, err := ct.Compile(dom, domName, resolve, rootType); err != nil {
+				return nil, err
+			} else {
+				compiled.Arguments[i] = ctt
+			}
+		}
+	}
+
 	// Recursive compile
 
 	if t.MapOf != nil {
@@ -177,6 +246,21 @@ func (t *RawType) Compile(dom *Domain, domName string, resolve bool, rootType *T
 			compiled.ListOf = cl
 		}
 	}
+	if t.DictOf != nil {
+		if cd, err := t.DictOf.Compile(dom, domName, resolve, rootType); err != nil {
+			return nil, err
+		} else {
+			compiled.DictOf = cd
+		}
+	}
+	if t.KeyPattern != "" {
+		if t.DictOf == nil {
+			return nil, fmt.Errorf("key pattern requires a dictionary")
+		}
+		if _, err := compilePattern(t.KeyPattern); err != nil {
+			return nil, fmt.Errorf("invalid key pattern: %v", err)
+		}
+	}
 	if t.TupleOf != nil {
 		compiled.TupleOf = make([]*RawType, len(t.TupleOf))
 		for i, ct := range t.TupleOf {
@@ -188,29 +272,73 @@ func (t *RawType) Compile(dom *Domain, domName string, resolve bool, rootType *T
 		}
 	}
 
+	if resolve && len(t.Extends) > 0 {
+		if err := t.compileExtends(compiled, dom, domName, rootType); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.OneOf != nil {
+		compiled.OneOf = make([]*RawType, len(t.OneOf))
+		for i, ct := range t.OneOf {
+			if ctt, err := ct.Compile(dom, domName, resolve, rootType); err != nil {
+				return nil, err
+			} else {
+				compiled.OneOf[i] = ctt
+			}
+		}
+		if err := compiled.checkVariants(); err != nil {
+			return nil, err
+		}
+	}
+
 	compiled.Domain = domName
 
+	if err := t.Constraints.Validate(); err != nil {
+		return nil, err
+	}
+
 	if resolve && compiled.Reference != "" {
-		dom, _ := dom.GetDomain(domName)
+		dom, _ := dom.lookupDomain(domName)
 		if dom == nil {
 			return nil, fmt.Errorf("domain not set")
 		}
-		rt, err := dom.GetType(compiled.Reference)
+		rt, err := dom.lookupType(compiled.Reference)
 		if err != nil {
 			log.Printf("type %s: %v", t.Reference, err)
 		}
 		if rt == nil {
 			return nil, fmt.Errorf("type not found: %s", t.Reference)
 		}
+		if rt.compiling {
+			// rt references itself, e.g. through a list of its children.
+			if err := compiled.referLazily(rt, rootType, len(t.Arguments)); err != nil {
+				return nil, err
+			}
+			return compiled, nil
+		}
 		if rt.Compiled == nil {
 			if err := rt.Compile(dom, rt.Domain, resolve); err != nil {
 				return nil, err
 			}
 		}
+		if len(rt.Parameters) != len(t.Arguments) {
+			return nil, fmt.Errorf("type %s requires %d type arguments, got %d", t.Reference, len(rt.Parameters), len(t.Arguments))
+		}
 		tcpy := *t
+		args := compiled.Arguments
 		rt.references[rootType] = true
 		optional := compiled.Optional
 		*compiled = *rt.Compiled
+		if len(rt.Parameters) > 0 {
+			bindings := map[string]*RawType{}
+			for i, param := range rt.Parameters {
+				bindings[param] = args[i]
+			}
+			*compiled = *compiled.substitute(bindings)
+			compiled.Parameters = nil
+			compiled.TypeName = ""
+		}
 		compiled.Optional = optional
 		if tcpy.Extensions != nil {
 			// For now, we override extensions
@@ -220,7 +348,20 @@ func (t *RawType) Compile(dom *Domain, domName string, resolve bool, rootType *T
 			// TODO: Check if tcpy.Options are contained in t.Options
 			compiled.Options = tcpy.Options
 		}
-		// TODO: Handle generics
+		constraints, err := rt.Compiled.Constraints.Intersect(tcpy.Constraints)
+		if err != nil {
+			return nil, fmt.Errorf("type %s: %w", t.Reference, err)
+		}
+		compiled.Constraints = constraints
+		if tcpy.Default != nil {
+			compiled.Default = tcpy.Default
+		}
+	}
+
+	if compiled.Default != nil && compiled.Generic == "" {
+		if _, err := compiled.applyMiddlewares(nil, compiled.Default, false, "default"); err != nil {
+			return nil, err
+		}
 	}
 
 	return compiled, nil
@@ -235,6 +376,30 @@ func (t *RawType) String() string {
 }
 
 func (t *RawType) Contains(t2 *RawType) error {
+	if t.lazy != nil || t2.lazy != nil {
+		rt, err := t.resolved()
+		if err != nil {
+			return err
+		}
+		rt2, err := t2.resolved()
+		if err != nil {
+			return err
+		}
+		if t.lazy != nil && t2.lazy != nil {
+			if t.TypeName != t2.TypeName {
+				return fmt.Errorf("incompatible recursive types: %s, %s", t.TypeName, t2.TypeName)
+			}
+			// Both refer to the same type, so they can only differ by their refinements.
+			return rt.sameRefinements(rt2)
+		}
+		return rt.Contains(rt2)
+	}
+	if t.OneOf != nil || t2.OneOf != nil {
+		return t.containsVariants(t2)
+	}
+	if t.Generic != t2.Generic {
+		return fmt.Errorf("incompatible type parameters")
+	}
 	if t.Leaf != t2.Leaf {
 		return fmt.Errorf("incompatible leaf types")
 	} else if t.Leaf != 0 {
@@ -246,6 +411,20 @@ func (t *RawType) Contains(t2 *RawType) error {
 		}
 		return t.ListOf.Contains(t2.ListOf)
 	}
+	if t.TupleOf != nil {
+		if t2.TupleOf == nil {
+			return fmt.Errorf("incompatible tuple types")
+		}
+		if len(t.TupleOf) != len(t2.TupleOf) {
+			return fmt.Errorf("incompatible tuple lengths: %d != %d", len(t.TupleOf), len(t2.TupleOf))
+		}
+		for i, tt := range t.TupleOf {
+			if err := tt.Contains(t2.TupleOf[i]); err != nil {
+				return fmt.Errorf("tuple item %d: %w", i, err)
+			}
+		}
+		return nil
+	}
 	if t.MapOf != nil {
 		if t2.MapOf == nil {
 			return fmt.Errorf("incompatible map types")
@@ -259,6 +438,15 @@ func (t *RawType) Contains(t2 *RawType) error {
 		}
 		return nil
 	}
+	if t.DictOf != nil {
+		if t2.DictOf == nil {
+			return fmt.Errorf("incompatible dictionary types")
+		}
+		if t.KeyPattern != t2.KeyPattern {
+			return fmt.Errorf("incompatible key patterns")
+		}
+		return t.DictOf.Contains(t2.DictOf)
+	}
 	ts := t.String()
 	t2s := t2.String()
 	if ts == t2s {
@@ -268,6 +456,15 @@ func (t *RawType) Contains(t2 *RawType) error {
 }
 
 func (t *RawType) ApplyMiddlewares(mws Middlewares, val HubItem, out bool) (any, error) {
+	return t.applyMiddlewares(mws, val, out, "")
+}
+
+// applyMiddlewares validates val and applies the middlewares to it. Errors are qualified with path, the path of val.
+func (t *RawType) applyMiddlewares(mws Middlewares, val HubItem, out bool, path string) (any, error) {
+	if val == nil && !out && t.Default != nil {
+		val = copyValue(t.Default)
+	}
+
 	validated := false
 	for f, ext := range t.Extensions {
 		for i := 0; i < len(mws); i++ {
@@ -281,7 +478,7 @@ func (t *RawType) ApplyMiddlewares(mws Middlewares, val HubItem, out bool) (any,
 				var err error
 				val, err = vs.Middleware(ext, val, out)
 				if err != nil {
-					return nil, err
+					return nil, prefixValueError(path, err)
 				}
 				validated = true
 			}
@@ -295,86 +492,66 @@ func (t *RawType) ApplyMiddlewares(mws Middlewares, val HubItem, out bool) (any,
 		return nil, nil
 	}
 
+	if t.lazy != nil {
+		rt, err := t.resolved()
+		if err != nil {
+			return nil, valueErrorf(path, "%v", err)
+		}
+		return rt.applyMiddlewares(mws, val, out, path)
+	}
+
+	if t.Generic != "" {
+		return nil, valueErrorf(path, "unbound type parameter: %s", t.Generic)
+	}
+
+	if t.OneOf != nil {
+		return t.applyVariant(mws, val, out, path)
+	}
+
 	if t.Leaf != 0 {
-		switch t.Leaf {
-		case LeafString:
-			if val, ok := val.(string); ok {
-				return val, nil
-			}
-			return nil, fmt.Errorf("not a string: %v", val)
-		case LeafInteger:
-			if val, ok := val.(int64); ok {
-				return val, nil
-			}
-			if val, ok := val.(uint64); ok {
-				return int64(val), nil
-			}
-			if val, ok := val.(int32); ok {
-				return int64(val), nil
-			}
-			if val, ok := val.(uint32); ok {
-				return int64(val), nil
-			}
-			if val, ok := val.(int16); ok {
-				return int64(val), nil
-			}
-			if val, ok := val.(uint16); ok {
-				return int64(val), nil
-			}
-			if val, ok := val.(int); ok {
-				return int64(val), nil
-			}
-			if val, ok := val.(uint); ok {
-				return int64(val), nil
-			}
-			if val, ok := val.(float64); ok {
-				return int64(val), nil
-			}
-			return nil, fmt.Errorf("not an integer: %v", val)
-		case LeafFloat:
-			if val, ok := val.(int64); ok {
-				return float64(val), nil
-			}
-			if val, ok := val.(uint64); ok {
-				return float64(val), nil
-			}
-			if val, ok := val.(int32); ok {
-				return float64(val), nil
-			}
-			if val, ok := val.(uint32); ok {
-				return float64(val), nil
-			}
-			if val, ok := val.(int16); ok {
-				return float64(val), nil
-			}
-			if val, ok := val.(uint16); ok {
-				return float64(val), nil
-			}
-			if val, ok := val.(int); ok {
-				return float64(val), nil
-			}
-			if val, ok := val.(uint); ok {
-				return float64(val), nil
-			}
-			if val, ok := val.(float64); ok {
-				return val, nil
-			}
-			return nil, fmt.Errorf("not a float: %v", val)
-		case LeafBoolean:
-			if val, ok := val.(bool); ok {
-				return val, nil
+		lval, err := convertLeaf(t.Leaf, val)
+		if err != nil {
+			return nil, prefixValueError(path, err)
+		}
+		switch lval := lval.(type) {
+		case int64:
+			err = t.Constraints.checkNumber(path, float64(lval))
+		case float64:
+			err = t.Constraints.checkNumber(path, lval)
+		case string:
+			err = t.Constraints.checkString(path, lval)
+		case []byte:
+			err = t.Constraints.checkLength(path, len(lval))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := t.checkOptions(path, lval); err != nil {
+			return nil, err
+		}
+		return lval, nil
+	}
+
+	if t.TupleOf != nil {
+		n := 0
+		if val != nil {
+			if reflect.TypeOf(val).Kind() != reflect.Slice {
+				return nil, valueErrorf(path, "not a valid tuple")
 			}
-			return nil, fmt.Errorf("not a boolean: %v", val)
-		case LeafRaw:
-			if val, ok := val.([]byte); ok {
-				return val, nil
+			n = reflect.ValueOf(val).Len()
+		}
+		if n != len(t.TupleOf) {
+			return nil, valueErrorf(path, "tuple requires %d items, got %d", len(t.TupleOf), n)
+		}
+		vals := []HubItem{}
+		for i, tt := range t.TupleOf {
+			val2, err := tt.applyMiddlewares(mws, reflect.ValueOf(val).Index(i).Interface(), out, indexPath(path, i))
+			if err != nil {
+				return nil, err
 			}
-			return nil, fmt.Errorf("not raw bytes: %v", val)
-		case LeafAny:
-			return val, nil
-		default:
-			return nil, fmt.Errorf("invalid leaf type: %d", t.Leaf)
+			vals = append(vals, val2)
 		}
+		return vals, nil
 	}
 
 	if t.ListOf != nil {
@@ -383,16 +560,19 @@ func (t *RawType) ApplyMiddlewares(mws Middlewares, val HubItem, out bool) (any,
 			if reflect.TypeOf(val).Kind() == reflect.Slice {
 				s := reflect.ValueOf(val)
 				for i := 0; i < s.Len(); i++ {
-					val2, err := t.ListOf.ApplyMiddlewares(mws, s.Index(i).Interface(), out)
+					val2, err := t.ListOf.applyMiddlewares(mws, s.Index(i).Interface(), out, indexPath(path, i))
 					if err != nil {
 						return nil, err
 					}
 					vals = append(vals, val2)
 				}
 			} else {
-				return nil, fmt.Errorf("not a valid slice")
+				return nil, valueErrorf(path, "not a valid slice")
 			}
 		}
+		if err := t.Constraints.checkItems(path, len(vals)); err != nil {
+			return nil, err
+		}
 		return vals, nil
 	}
 
@@ -400,12 +580,12 @@ func (t *RawType) ApplyMiddlewares(mws Middlewares, val HubItem, out bool) (any,
 		vals := map[string]HubItem{}
 		if mval, ok := val.(map[string]HubItem); ok {
 			for k, kt := range t.MapOf {
-				if kv, ok := mval[k]; !ok {
+				if kv, ok := mval[k]; !ok && (out || kt.Default == nil) {
 					if !kt.Optional {
-						return nil, fmt.Errorf("missing map entry: %s", k)
+						return nil, valueErrorf(path, "missing map entry: %s", k)
 					}
 				} else {
-					kv, err := kt.ApplyMiddlewares(mws, kv, out)
+					kv, err := kt.applyMiddlewares(mws, kv, out, keyPath(path, k))
 					if err != nil {
 						return nil, err
 					}
@@ -414,12 +594,12 @@ func (t *RawType) ApplyMiddlewares(mws Middlewares, val HubItem, out bool) (any,
 			}
 		} else if mval, ok := val.(map[string]any); ok {
 			for k, kt := range t.MapOf {
-				if kv, ok := mval[k]; !ok {
+				if kv, ok := mval[k]; !ok && (out || kt.Default == nil) {
 					if !kt.Optional {
-						return nil, fmt.Errorf("missing map entry: %s", k)
+						return nil, valueErrorf(path, "missing map entry: %s", k)
 					}
 				} else {
-					kv, err := kt.ApplyMiddlewares(mws, kv, out)
+					kv, err := kt.applyMiddlewares(mws, kv, out, keyPath(path, k))
 					if err != nil {
 						return nil, err
 					}
@@ -430,6 +610,10 @@ func (t *RawType) ApplyMiddlewares(mws Middlewares, val HubItem, out bool) (any,
 		return vals, nil
 	}
 
+	if t.DictOf != nil {
+		return t.applyDict(mws, val, out, path)
+	}
+
 	//if t.IDOf != nil {
 	//	if val, ok := val.(string); !ok {
 	//		return nil, fmt.Errorf("not an ID")
@@ -447,32 +631,131 @@ func (t *RawType) ApplyMiddlewares(mws Middlewares, val HubItem, out bool) (any,
 	return val, nil
 }
 
+// convertLeaf converts val into the Go type representing leaf.
+func convertLeaf(leaf LeafType, val HubItem) (HubItem, error) {
+	switch leaf {
+	case LeafString:
+		if val, ok := val.(string); ok {
+			return val, nil
+		}
+		return nil, fmt.Errorf("not a string: %v", val)
+	case LeafInteger:
+		if val, ok := val.(int64); ok {
+			return val, nil
+		}
+		if val, ok := val.(uint64); ok {
+			return int64(val), nil
+		}
+		if val, ok := val.(int32); ok {
+			return int64(val), nil
+		}
+		if val, ok := val.(uint32); ok {
+			return int64(val), nil
+		}
+		if val, ok := val.(int16); ok {
+			return int64(val), nil
+		}
+		if val, ok := val.(uint16); ok {
+			return int64(val), nil
+		}
+		if val, ok := val.(int); ok {
+			return int64(val), nil
+		}
+		if val, ok := val.(uint); ok {
+			return int64(val), nil
+		}
+		if val, ok := val.(float64); ok {
+			return int64(val), nil
+		}
+		return nil, fmt.Errorf("not an integer: %v", val)
+	case LeafFloat:
+		if val, ok := val.(int64); ok {
+			return float64(val), nil
+		}
+		if val, ok := val.(uint64); ok {
+			return float64(val), nil
+		}
+		if val, ok := val.(int32); ok {
+			return float64(val), nil
+		}
+		if val, ok := val.(uint32); ok {
+			return float64(val), nil
+		}
+		if val, ok := val.(int16); ok {
+			return float64(val), nil
+		}
+		if val, ok := val.(uint16); ok {
+			return float64(val), nil
+		}
+		if val, ok := val.(int); ok {
+			return float64(val), nil
+		}
+		if val, ok := val.(uint); ok {
+			return float64(val), nil
+		}
+		if val, ok := val.(float64); ok {
+			return val, nil
+		}
+		return nil, fmt.Errorf("not a float: %v", val)
+	case LeafBoolean:
+		if val, ok := val.(bool); ok {
+			return val, nil
+		}
+		return nil, fmt.Errorf("not a boolean: %v", val)
+	case LeafRaw:
+		if val, ok := val.([]byte); ok {
+			return val, nil
+		}
+		return nil, fmt.Errorf("not raw bytes: %v", val)
+	case LeafAny:
+		return val, nil
+	case LeafTime:
+		return convertTime(val)
+	case LeafDuration:
+		return convertDuration(val)
+	case LeafID:
+		return convertID(val)
+	case LeafSystemID:
+		return convertSystemID(val)
+	case LeafObjectID:
+		return convertObjectID(val)
+	default:
+		return nil, fmt.Errorf("invalid leaf type: %d", leaf)
+	}
+}
+
 func (t *Type) Accepts(src *Type) (bool, error) {
 	return t.Compiled.accepts(src.Compiled, "")
 }
 
+// Extend adds the fields of the compiled map type base to the compiled type t, as if t had been declared to extend it.
 func (t *Type) Extend(base *Type) error {
 	if base == nil {
 		return nil
 	}
-	if base.MapOf != nil {
-		if t.Compiled.MapOf == nil {
-			return fmt.Errorf("base is a map, require map")
-		}
-		// TODO
-		return nil
-	} else if base.ListOf != nil {
-		if t.Compiled.ListOf == nil {
-			return fmt.Errorf("base is a list, require list")
-		}
-
-	} else if base.TupleOf != nil {
-		if t.Compiled.TupleOf == nil {
-			return fmt.Errorf("base is a tuple, require tuple")
-		}
-
+	if t.Compiled == nil || base.Compiled == nil {
+		return fmt.Errorf("types must be compiled")
 	}
-	return fmt.Errorf("cannot extend this type")
+	if base.Compiled.MapOf == nil {
+		return fmt.Errorf("base is not a map")
+	}
+	if t.Compiled.MapOf == nil && (t.Compiled.Leaf != 0 || t.Compiled.ListOf != nil || t.Compiled.TupleOf != nil ||
+		t.Compiled.DictOf != nil || t.Compiled.OneOf != nil) {
+		return fmt.Errorf("base is a map, require map")
+	}
+	compiled := t.Compiled.Copy()
+	if err := compiled.inherit([]*Type{base}); err != nil {
+		return err
+	}
+	t.Compiled = compiled
+	if base.FullName != "" && !slices.Contains(t.Extends, base.FullName) {
+		t.Extends = append(t.Extends, base.FullName)
+	}
+	if base.references == nil {
+		base.references = map[Compilable]bool{}
+	}
+	base.references[t] = true
+	return nil
 }
 
 func (t *Type) MarshalYAML() (interface{}, error) {
@@ -481,21 +764,31 @@ func (t *Type) MarshalYAML() (interface{}, error) {
 		rt.ListOf = nil
 		rt.TupleOf = nil
 		rt.MapOf = nil
+		rt.DictOf = nil
 		rt.Leaf = 0
 		rt.Extensions = nil
 	}
-	if rt.Name != "" || rt.Description != "" || len(rt.Options) != 0 {
+	if rt.Name != "" || rt.Description != "" || len(rt.Options) != 0 || rt.Constraints != nil || rt.Default != nil {
 		return rt, nil
 	}
 	if rt.Leaf != 0 {
 		return rt.Leaf, nil
-	} else if rt.Reference != "" {
-		return "$" + rt.Reference, nil
+	} else if rt.Reference != "" || rt.Generic != "" {
+		if short, ok := typeString(&rt); ok {
+			return short, nil
+		}
+		return rt, nil
 	} else if rt.MapOf != nil {
 		return rt.MapOf, nil
 	} else if rt.ListOf != nil {
 		return []*RawType{rt.ListOf}, nil
-	} else if rt.TupleOf != nil {
+	} else if rt.OneOf != nil && rt.Discriminator == "" {
+		if short, ok := typeString(&rt); ok {
+			return short, nil
+		}
+		return rt, nil
+	} else if rt.TupleOf != nil && len(rt.TupleOf) != 1 {
+		// Tuples with a single item are written in full as the short form denotes a list.
 		return rt.TupleOf, nil
 	}
 	//if len(t.Extensions) == 0 {
@@ -516,18 +809,18 @@ func (t *Type) UnmarshalYAML(value *yaml.Node) error {
 	}
 	var str string
 	if err := value.Decode(&str); err == nil {
-		if len(str) >= 2 && str[0] == '$' {
-			t.Reference = str[1:]
-			return nil
-		}
-		if len(str) >= 3 && str[0] == '<' && str[len(str)-1] == '>' {
-			t.Reference = str[1 : len(str)-1]
+		if strings.ContainsAny(str, "$<|") {
+			rt, err := parseTypeString(str)
+			if err != nil {
+				return err
+			}
+			t.RawType = *rt
 			return nil
 		}
 		return fmt.Errorf("expected type reference or generic: %s", str)
 	}
 	var mp map[string]*RawType
-	if err := value.Decode(&mp); mp["mapOf"] == nil && mp["listOf"] == nil && mp["leaf"] == nil && err == nil {
+	if err := value.Decode(&mp); mp["mapOf"] == nil && mp["listOf"] == nil && mp["dictOf"] == nil && mp["leaf"] == nil && err == nil {
 		t.MapOf = mp
 		return nil
 	}
@@ -581,6 +874,15 @@ func (t *RawType) Copy() *RawType {
 	for k, v := range t.Extensions {
 		t2.Extensions[k] = v
 	}
+	if t.CompiledExtends != nil {
+		t2.CompiledExtends = append([]string{}, t.CompiledExtends...)
+	}
+	if t.Arguments != nil {
+		t2.Arguments = []*RawType{}
+		for _, v := range t.Arguments {
+			t2.Arguments = append(t2.Arguments, v.Copy())
+		}
+	}
 	if t.ListOf != nil {
 		t2.ListOf = t.ListOf.Copy()
 	} else if t.MapOf != nil {
@@ -588,11 +890,18 @@ func (t *RawType) Copy() *RawType {
 		for k, v := range t.MapOf {
 			t2.MapOf[k] = v.Copy()
 		}
+	} else if t.DictOf != nil {
+		t2.DictOf = t.DictOf.Copy()
 	} else if t.TupleOf != nil {
 		t2.TupleOf = []*RawType{}
 		for _, v := range t.TupleOf {
 			t2.TupleOf = append(t2.TupleOf, v.Copy())
 		}
+	} else if t.OneOf != nil {
+		t2.OneOf = []*RawType{}
+		for _, v := range t.OneOf {
+			t2.OneOf = append(t2.OneOf, v.Copy())
+		}
 	} /*else if t.IDOf != nil {
 		t2.IDOf = &IDType{}
 		*t2.IDOf = *t.IDOf
@@ -609,16 +918,61 @@ func (t *RawType) accepts(src *RawType, path string) (bool, error) {
 }
 
 func (t *RawType) acceptsNonOptional(src *RawType, path string) (bool, error) {
-	// TODO: Consider options
 	if src == nil {
 		// Check for optional, once implemented
 		return true, nil
 	}
 
+	if t.lazy != nil || src.lazy != nil {
+		rt, err := t.resolved()
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", path, err)
+		}
+		rsrc, err := src.resolved()
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", path, err)
+		}
+		if t.lazy != nil && src.lazy != nil {
+			if t.TypeName != src.TypeName {
+				return false, fmt.Errorf("%s: differing recursive types: %s != %s", path, t.TypeName, src.TypeName)
+			}
+			// Both refer to the same type, so only their refinements need to be compared.
+			if err := rt.Constraints.accepts(rsrc.Constraints, path); err != nil {
+				return false, err
+			}
+			if err := rt.acceptsOptions(rsrc, path); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+		return rt.acceptsNonOptional(rsrc, path)
+	}
+
+	if err := t.Constraints.accepts(src.Constraints, path); err != nil {
+		return false, err
+	}
+	if err := t.acceptsOptions(src, path); err != nil {
+		return false, err
+	}
+
+	if t.OneOf != nil || src.OneOf != nil {
+		return t.acceptsVariants(src, path)
+	}
+
+	if t.Generic != "" || src.Generic != "" {
+		if t.Generic != src.Generic {
+			return false, fmt.Errorf("%s: differing type parameters: %s != %s", path, t.Generic, src.Generic)
+		}
+		return true, nil
+	}
+
 	if t.MapOf != nil {
 		if src.MapOf == nil {
 			return false, fmt.Errorf("%s: source should be a map", path)
 		}
+		if src.extends(t.TypeName) {
+			return t.acceptsDerived(src, path)
+		}
 		for kSrc, vSrc := range src.MapOf {
 			if vSelf, ok := t.MapOf[kSrc]; !ok {
 				return false, fmt.Errorf("%s: source has additional map entry: %s", path, kSrc)
@@ -631,6 +985,10 @@ func (t *RawType) acceptsNonOptional(src *RawType, path string) (bool, error) {
 		return true, nil
 	}
 
+	if t.DictOf != nil {
+		return t.acceptsDict(src, path)
+	}
+
 	if t.ListOf != nil {
 		if src.ListOf == nil {
 			return false, fmt.Errorf("%s: source should be a list", path)
@@ -646,7 +1004,7 @@ func (t *RawType) acceptsNonOptional(src *RawType, path string) (bool, error) {
 			return false, fmt.Errorf("%s: source tuple should have same length", path)
 		}
 		for i, tt := range t.TupleOf {
-			if ok, err := tt.accepts(src.TupleOf[i], ""); err != nil || !ok {
+			if ok, err := tt.accepts(src.TupleOf[i], indexPath(path, i)); err != nil || !ok {
 				return false, err
 			}
 		}
@@ -696,10 +1054,6 @@ func parseType(typeJSON string, dom *Domain) (*Type, error) {
 	return vtt, nil
 }
 
-// Generic map
-
-type GenericTypeMap map[string]*Type
-
 // A Middleware validates and potentially transforms a HubItem into another HubItem.
 type Middleware interface {
 	Name() string
@@ -735,6 +1089,11 @@ const (
 	LeafBoolean
 	LeafRaw
 	LeafAny
+	LeafTime
+	LeafDuration
+	LeafID
+	LeafSystemID
+	LeafObjectID
 )
 
 func (tb *LeafType) String() string {
@@ -755,6 +1114,16 @@ func (tb *LeafType) String() string {
 		str = "raw"
 	case LeafAny:
 		str = "any"
+	case LeafTime:
+		str = "time"
+	case LeafDuration:
+		str = "duration"
+	case LeafID:
+		str = "id"
+	case LeafSystemID:
+		str = "systemId"
+	case LeafObjectID:
+		str = "objectId"
 	}
 	return str
 }
@@ -773,6 +1142,16 @@ func (tb *LeafType) FromString(str string) error {
 		*tb = LeafRaw
 	case "any":
 		*tb = LeafAny
+	case "time":
+		*tb = LeafTime
+	case "duration":
+		*tb = LeafDuration
+	case "id":
+		*tb = LeafID
+	case "systemId":
+		*tb = LeafSystemID
+	case "objectId":
+		*tb = LeafObjectID
 	default:
 		return fmt.Errorf("unknown leaf type: %s", str)
 	}
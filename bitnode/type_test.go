@@ -1,6 +1,10 @@
 package bitnode
 
-import "testing"
+import (
+	"errors"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
 
 func TestValueType_Accepts__Incompatible1(t *testing.T) {
 	v1 := mustParseType(`{"listOf": {"leaf": "string"}}`, nil)
@@ -387,3 +391,80 @@ func (tmw testMW) Name() string {
 func (tmw testMW) Middleware(ext any, val HubItem, in bool) (HubItem, error) {
 	return val, nil
 }
+
+func TestValueApplyMiddlewares__TupleOf1(t *testing.T) {
+	v1 := mustParseType(`{"tupleOf": [{ "leaf": "string" }, { "leaf": "integer" }]}`, nil)
+	v, err := v1.ApplyMiddlewares(nil, []any{"a", 1.0}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vs := v.([]HubItem); len(vs) != 2 || vs[0] != "a" || vs[1] != int64(1) {
+		t.Fatal(v)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, []any{"a"}, true); err == nil {
+		t.Fatal("must check arity")
+	}
+	if _, err := v1.ApplyMiddlewares(nil, nil, true); err == nil {
+		t.Fatal("must check arity")
+	}
+	if _, err := v1.ApplyMiddlewares(nil, "a", true); err == nil {
+		t.Fatal("must require a slice")
+	}
+	_, err = v1.ApplyMiddlewares(nil, []any{"a", "b"}, true)
+	var valErr *ValueError
+	if !errors.As(err, &valErr) || valErr.Path != "[1]" {
+		t.Fatal(err)
+	}
+}
+
+func TestValueType_Accepts__Tuple1(t *testing.T) {
+	v1 := mustParseType(`{"tupleOf": [{ "leaf": "string" }, { "leaf": "integer" }]}`, nil)
+	v2 := mustParseType(`{"tupleOf": [{ "leaf": "string" }, { "leaf": "string" }]}`, nil)
+	v3 := mustParseType(`{"tupleOf": [{ "leaf": "string" }]}`, nil)
+	if ok, err := v1.Accepts(v1); !ok || err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := v1.Accepts(v2); ok || err == nil || err.Error() != "[1]: differing leaves: 3 != 1" {
+		t.Fatal(err)
+	}
+	if ok, err := v1.Accepts(v3); ok || err == nil {
+		t.Fatal()
+	}
+}
+
+func TestValueType_Contains__Tuple1(t *testing.T) {
+	v1 := mustParseType(`{"tupleOf": [{ "leaf": "string" }, { "leaf": "integer" }]}`, nil)
+	v2 := mustParseType(`{"tupleOf": [{ "leaf": "string" }, { "leaf": "string" }]}`, nil)
+	v3 := mustParseType(`{"listOf": { "leaf": "string" }}`, nil)
+	if err := v1.Compiled.Contains(v1.Compiled); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1.Compiled.Contains(v2.Compiled); err == nil {
+		t.Fatal()
+	}
+	if err := v1.Compiled.Contains(v3.Compiled); err == nil {
+		t.Fatal()
+	}
+}
+
+func TestValueType_YAML__Tuple(t *testing.T) {
+	for _, v := range []*Type{
+		{RawType: RawType{TupleOf: []*RawType{{Leaf: LeafString}, {ListOf: &RawType{Leaf: LeafInteger}}}}},
+		{RawType: RawType{TupleOf: []*RawType{{Leaf: LeafString}}}},
+	} {
+		bts, err := yaml.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v2 := &Type{}
+		if err := yaml.Unmarshal(bts, v2); err != nil {
+			t.Fatal(err)
+		}
+		if v2.ListOf != nil || len(v2.TupleOf) != len(v.TupleOf) {
+			t.Fatal(string(bts))
+		}
+		if err := v.RawType.Contains(&v2.RawType); err != nil {
+			t.Fatal(err, string(bts))
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package bitnode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// typeString returns the short form of t, e.g. "string", "<T>", "$page<$item>" or "string | $result", if t and all
+// types it consists of can be written in short form.
+func typeString(t *RawType) (string, bool) {
+	if t.Name != "" || t.Description != "" || t.Optional || t.Options != nil || t.Constraints != nil ||
+		t.Default != nil || len(t.Extensions) != 0 || t.Parameters != nil {
+		return "", false
+	}
+	if t.Generic != "" {
+		return "<" + t.Generic + ">", true
+	}
+	if t.Reference != "" {
+		if len(t.Arguments) == 0 {
+			return "$" + t.Reference, true
+		}
+		args, ok := typeStrings(t.Arguments)
+		if !ok {
+			return "", false
+		}
+		return "$" + t.Reference + "<" + strings.Join(args, ", ") + ">", true
+	}
+	if t.Leaf != 0 && t.MapOf == nil && t.ListOf == nil && t.TupleOf == nil && t.OneOf == nil {
+		return t.Leaf.String(), true
+	}
+	if t.OneOf != nil && t.Discriminator == "" {
+		variants, ok := typeStrings(t.OneOf)
+		if !ok {
+			return "", false
+		}
+		return strings.Join(variants, " | "), true
+	}
+	return "", false
+}
+
+func typeStrings(ts []*RawType) ([]string, bool) {
+	strs := []string{}
+	for _, v := range ts {
+		str, ok := typeString(v)
+		if !ok {
+			return nil, false
+		}
+		strs = append(strs, str)
+	}
+	return strs, true
+}
+
+// parseTypeString parses the short form of a type, e.g. "<T>", "$page<string>" or "string | $result".
+func parseTypeString(str string) (*RawType, error) {
+	p := &typeParser{str: str}
+	t, err := p.union()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.str) {
+		return nil, fmt.Errorf("type %s: unexpected %q at %d", str, p.str[p.pos], p.pos)
+	}
+	return t, nil
+}
+
+// typeParser is a recursive descent parser for the short form of types:
+//
+//	union := term ('|' term)*
+//	term  := '$' name ['<' union (',' union)* '>'] | '<' name '>' | leaf
+type typeParser struct {
+	str string
+	pos int
+}
+
+func (p *typeParser) union() (*RawType, error) {
+	variants := []*RawType{}
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, t)
+		if !p.consume('|') {
+			break
+		}
+	}
+	if len(variants) == 1 {
+		return variants[0], nil
+	}
+	return &RawType{OneOf: variants}, nil
+}
+
+func (p *typeParser) term() (*RawType, error) {
+	switch {
+	case p.consume('$'):
+		ref := p.name()
+		if ref == "" {
+			return nil, fmt.Errorf("type %s: expected reference at %d", p.str, p.pos)
+		}
+		t := &RawType{Reference: ref}
+		if p.consume('<') {
+			t.Arguments = []*RawType{}
+			for {
+				arg, err := p.union()
+				if err != nil {
+					return nil, err
+				}
+				t.Arguments = append(t.Arguments, arg)
+				if p.consume('>') {
+					break
+				}
+				if !p.consume(',') {
+					return nil, fmt.Errorf("type %s: expected , or > at %d", p.str, p.pos)
+				}
+			}
+		}
+		return t, nil
+	case p.consume('<'):
+		param := p.name()
+		if param == "" || !p.consume('>') {
+			return nil, fmt.Errorf("type %s: expected type parameter at %d", p.str, p.pos)
+		}
+		return &RawType{Generic: param}, nil
+	default:
+		name := p.name()
+		var leaf LeafType
+		if err := leaf.FromString(name); err != nil {
+			return nil, fmt.Errorf("type %s: %v", p.str, err)
+		}
+		return &RawType{Leaf: leaf}, nil
+	}
+}
+
+// name reads an identifier, which may be qualified by a domain.
+func (p *typeParser) name() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.str) && !strings.ContainsRune(" <>,|$", rune(p.str[p.pos])) {
+		p.pos++
+	}
+	return p.str[start:p.pos]
+}
+
+// consume skips whitespace and reads c if it is next.
+func (p *typeParser) consume(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.str) && p.str[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *typeParser) skipSpace() {
+	for p.pos < len(p.str) && p.str[p.pos] == ' ' {
+		p.pos++
+	}
+}
@@ -0,0 +1,173 @@
+package bitnode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkVariants checks that the variants of a union with a discriminator are named maps.
+func (t *RawType) checkVariants() error {
+	if len(t.OneOf) == 0 {
+		return fmt.Errorf("union requires variants")
+	}
+	if t.Discriminator == "" {
+		return nil
+	}
+	names := map[string]bool{}
+	for i, v := range t.OneOf {
+		if v.Reference != "" && v.MapOf == nil {
+			// Not resolved yet.
+			continue
+		}
+		if v.MapOf == nil {
+			return fmt.Errorf("variant %d: discriminated variants must be maps", i)
+		}
+		if v.Name == "" {
+			return fmt.Errorf("variant %d: discriminated variants require a name", i)
+		}
+		if names[v.Name] {
+			return fmt.Errorf("variant %d: duplicate variant name %s", i, v.Name)
+		}
+		names[v.Name] = true
+	}
+	return nil
+}
+
+// variant returns the variant named name.
+func (t *RawType) variant(name string) *RawType {
+	for _, v := range t.OneOf {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// applyVariant applies the middlewares of the variant val belongs to. Without a discriminator, the first variant
+// accepting val is chosen.
+func (t *RawType) applyVariant(mws Middlewares, val HubItem, out bool, path string) (any, error) {
+	if t.Discriminator != "" {
+		dval, ok := mapEntry(val, t.Discriminator)
+		if !ok {
+			return nil, valueErrorf(path, "missing discriminator %s", t.Discriminator)
+		}
+		name, ok := dval.(string)
+		if !ok {
+			return nil, valueErrorf(keyPath(path, t.Discriminator), "discriminator is not a string: %v", dval)
+		}
+		v := t.variant(name)
+		if v == nil {
+			return nil, valueErrorf(keyPath(path, t.Discriminator), "unknown variant %s", name)
+		}
+		vval, err := v.applyMiddlewares(mws, val, out, path)
+		if err != nil {
+			return nil, err
+		}
+		if mval, ok := vval.(map[string]HubItem); ok {
+			mval[t.Discriminator] = name
+		}
+		return vval, nil
+	}
+	errs := []string{}
+	for i, v := range t.OneOf {
+		vval, err := v.applyMiddlewares(mws, val, out, "")
+		if err == nil {
+			return vval, nil
+		}
+		errs = append(errs, fmt.Sprintf("variant %d: %v", i, err))
+	}
+	return nil, valueErrorf(path, "matches none of the variants (%s)", strings.Join(errs, "; "))
+}
+
+// acceptsVariants determines whether t accepts src if at least one of them is a union. Each variant of src must be
+// accepted by a variant of t.
+func (t *RawType) acceptsVariants(src *RawType, path string) (bool, error) {
+	if t.OneOf == nil {
+		for i, sv := range src.OneOf {
+			if ok, err := t.accepts(sv, fmt.Sprintf("%s|%d", path, i)); !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	if t.Discriminator != "" {
+		if src.OneOf == nil || src.Discriminator != t.Discriminator {
+			return false, fmt.Errorf("%s: source should be a union discriminated by %s", path, t.Discriminator)
+		}
+		for _, sv := range src.OneOf {
+			v := t.variant(sv.Name)
+			if v == nil {
+				return false, fmt.Errorf("%s: source has additional variant %s", path, sv.Name)
+			}
+			if ok, err := v.accepts(sv, path+"|"+sv.Name); !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	srcVariants := src.OneOf
+	if srcVariants == nil {
+		srcVariants = []*RawType{src}
+	}
+	for i, sv := range srcVariants {
+		accepted := false
+		for _, v := range t.OneOf {
+			if ok, _ := v.accepts(sv, path); ok {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return false, fmt.Errorf("%s: source variant %d is not accepted by any variant", path, i)
+		}
+	}
+	return true, nil
+}
+
+// containsVariants determines whether t contains t2 if at least one of them is a union.
+func (t *RawType) containsVariants(t2 *RawType) error {
+	if t.OneOf == nil {
+		for i, v2 := range t2.OneOf {
+			if err := t.Contains(v2); err != nil {
+				return fmt.Errorf("variant %d: %w", i, err)
+			}
+		}
+		return nil
+	}
+	if t.Discriminator != t2.Discriminator {
+		return fmt.Errorf("incompatible discriminators: %s != %s", t.Discriminator, t2.Discriminator)
+	}
+	variants2 := t2.OneOf
+	if variants2 == nil {
+		variants2 = []*RawType{t2}
+	}
+	for i, v2 := range variants2 {
+		contained := false
+		for _, v := range t.OneOf {
+			if t.Discriminator != "" && v.Name != v2.Name {
+				continue
+			}
+			if v.Contains(v2) == nil {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			return fmt.Errorf("variant %d is not contained in any variant", i)
+		}
+	}
+	return nil
+}
+
+// mapEntry returns the entry key of the map val.
+func mapEntry(val HubItem, key string) (HubItem, bool) {
+	if mval, ok := val.(map[string]HubItem); ok {
+		v, ok := mval[key]
+		return v, ok
+	}
+	if mval, ok := val.(map[string]any); ok {
+		v, ok := mval[key]
+		return v, ok
+	}
+	return nil, false
+}
@@ -0,0 +1,114 @@
+package bitnode
+
+import (
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestUnion__Apply(t *testing.T) {
+	v1 := mustParseType(`{"oneOf": [{"leaf": "integer"}, {"leaf": "string"}]}`, nil)
+	if v, err := v1.ApplyMiddlewares(nil, 1, true); err != nil || v != int64(1) {
+		t.Fatal(v, err)
+	}
+	if v, err := v1.ApplyMiddlewares(nil, "a", true); err != nil || v != "a" {
+		t.Fatal(v, err)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, true, true); err == nil {
+		t.Fatal("must match a variant")
+	}
+}
+
+func TestUnion__Discriminator(t *testing.T) {
+	v1 := mustParseType(`
+discriminator: kind
+oneOf:
+  - name: error
+    mapOf:
+      message:
+        leaf: string
+  - name: result
+    mapOf:
+      value:
+        leaf: integer
+`, nil)
+	v, err := v1.ApplyMiddlewares(nil, map[string]any{"kind": "result", "value": 2.0}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mv := v.(map[string]HubItem); mv["kind"] != "result" || mv["value"] != int64(2) {
+		t.Fatal(v)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, map[string]any{"kind": "error", "value": 2.0}, true); err == nil || err.Error() != "missing map entry: message" {
+		t.Fatal(err)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, map[string]any{"kind": "other"}, true); err == nil || err.Error() != "kind: unknown variant other" {
+		t.Fatal(err)
+	}
+	if _, err := v1.ApplyMiddlewares(nil, map[string]any{"value": 2.0}, true); err == nil {
+		t.Fatal("must require discriminator")
+	}
+
+	if _, err := parseType(`{"discriminator": "kind", "oneOf": [{"leaf": "string"}]}`, nil); err == nil {
+		t.Fatal("discriminated variants must be maps")
+	}
+	if _, err := parseType(`{"discriminator": "kind", "oneOf": [{"mapOf": {}}]}`, nil); err == nil {
+		t.Fatal("discriminated variants must have names")
+	}
+}
+
+func TestUnion__Middlewares(t *testing.T) {
+	v1 := mustParseType(`{"oneOf": [{"leaf": "integer"}, {"leaf": "string", "extensions": {"testf": {}}}]}`, nil)
+	if v, err := v1.ApplyMiddlewares(Middlewares{testMW{}}, "a", true); err != nil || v != "a" {
+		t.Fatal(v, err)
+	}
+}
+
+func TestUnion__Accepts(t *testing.T) {
+	v1 := mustParseType(`{"oneOf": [{"leaf": "integer"}, {"leaf": "string"}]}`, nil)
+	v2 := mustParseType(`{"leaf": "string"}`, nil)
+	v3 := mustParseType(`{"oneOf": [{"leaf": "boolean"}, {"leaf": "string"}]}`, nil)
+	if ok, err := v1.Accepts(v2); !ok || err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := v2.Accepts(v1); ok || err == nil {
+		t.Fatal("must not accept union with other variants")
+	}
+	if ok, err := v1.Accepts(v3); ok || err == nil {
+		t.Fatal("must not accept additional variant")
+	}
+	if err := v1.Compiled.Contains(v2.Compiled); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1.Compiled.Contains(v3.Compiled); err == nil {
+		t.Fatal()
+	}
+
+	d1 := mustParseType(`{"discriminator": "kind", "oneOf": [{"name": "a", "mapOf": {"x": {"leaf": "string"}}}, {"name": "b", "mapOf": {}}]}`, nil)
+	d2 := mustParseType(`{"discriminator": "kind", "oneOf": [{"name": "a", "mapOf": {"x": {"leaf": "string"}}}]}`, nil)
+	if ok, err := d1.Accepts(d2); !ok || err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := d2.Accepts(d1); ok || err == nil {
+		t.Fatal("must not accept additional variant")
+	}
+}
+
+func TestUnion__YAML(t *testing.T) {
+	v := &Type{}
+	if err := yaml.Unmarshal([]byte(`"string | $result"`), v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.OneOf) != 2 || v.OneOf[0].Leaf != LeafString || v.OneOf[1].Reference != "result" {
+		t.Fatal(v.OneOf)
+	}
+	bts, err := yaml.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bts) != "string | $result\n" {
+		t.Fatal(string(bts))
+	}
+	if err := yaml.Unmarshal([]byte(`"string | nothing"`), &Type{}); err == nil {
+		t.Fatal("must reject unknown variant")
+	}
+}
@@ -0,0 +1,69 @@
+package bitnode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version MAJOR.MINOR.PATCH. New major versions contain breaking changes, new minor versions
+// additive ones.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion parses a version like 1.2.0, optionally prefixed by v.
+func ParseVersion(str string) (Version, error) {
+	frags := strings.Split(strings.TrimPrefix(str, "v"), ".")
+	if len(frags) != 3 {
+		return Version{}, fmt.Errorf("invalid version %s: require MAJOR.MINOR.PATCH", str)
+	}
+	nums := [3]int{}
+	for i, frag := range frags {
+		num, err := strconv.Atoi(frag)
+		if err != nil || num < 0 || (len(frag) > 1 && frag[0] == '0') {
+			return Version{}, fmt.Errorf("invalid version %s: %s is not a number", str, frag)
+		}
+		nums[i] = num
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1 if v is older than v2, 1 if it is newer and 0 if they are equal.
+func (v Version) Compare(v2 Version) int {
+	for _, d := range []int{v.Major - v2.Major, v.Minor - v2.Minor, v.Patch - v2.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Satisfies determines whether clients of version req can use version v, i.e. whether v is not older and has the same
+// major version. Before 1.0.0, minor versions are treated as major ones.
+func (v Version) Satisfies(req Version) bool {
+	if v.Major != req.Major || v.Compare(req) < 0 {
+		return false
+	}
+	return v.Major > 0 || v.Minor == req.Minor
+}
+
+// bump returns the kind of change the step from v to next announces.
+func (v Version) bump(next Version) ChangeKind {
+	switch {
+	case next.Major != v.Major || (v.Major == 0 && next.Minor != v.Minor):
+		return ChangeBreaking
+	case next.Minor != v.Minor:
+		return ChangeAdditive
+	}
+	return ChangeCompatible
+}
@@ -0,0 +1,39 @@
+package bitnode
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("v1.12.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != (Version{Major: 1, Minor: 12, Patch: 3}) || v.String() != "1.12.3" {
+		t.Fatal(v)
+	}
+	for _, str := range []string{"", "1.2", "1.2.3.4", "1.x.0", "01.2.3", "1.-2.3"} {
+		if _, err := ParseVersion(str); err == nil {
+			t.Fatal("must reject", str)
+		}
+	}
+}
+
+func TestVersion_Satisfies(t *testing.T) {
+	cases := []struct {
+		v, req string
+		ok     bool
+	}{
+		{"1.2.0", "1.2.0", true},
+		{"1.3.1", "1.2.5", true},
+		{"1.2.0", "1.3.0", false},
+		{"2.0.0", "1.9.0", false},
+		{"0.2.1", "0.2.0", true},
+		{"0.3.0", "0.2.0", false},
+	}
+	for _, c := range cases {
+		v, _ := ParseVersion(c.v)
+		req, _ := ParseVersion(c.req)
+		if v.Satisfies(req) != c.ok {
+			t.Fatal(c)
+		}
+	}
+}
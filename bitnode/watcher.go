@@ -0,0 +1,538 @@
+package bitnode
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DomainWatcher reloads the definitions of a domain loaded from a directory tree when files of the tree change.
+// Changed definitions are updated in place and recompiled along with the definitions depending on them.
+type DomainWatcher struct {
+	dom *Domain
+	dir string
+
+	// files contains the hashes of the definition files by their paths relative to dir.
+	files map[string][sha256.Size]byte
+
+	mux  sync.Mutex
+	stop chan struct{}
+
+	// stopped is closed once polling has stopped.
+	stopped chan struct{}
+}
+
+// DomainReload reports the changes of a reload.
+type DomainReload struct {
+	// Files are the paths of the changed, added and removed files.
+	Files []string
+
+	// Types, Interfaces and Sparkables contain the full names of recompiled definitions, including those depending on
+	// changed ones.
+	Types      []string
+	Interfaces []string
+	Sparkables []string
+
+	// Removed contains the full names of removed definitions.
+	Removed []string
+
+	// Errors contains errors of definitions which could not be recompiled.
+	Errors []error
+}
+
+// OutOfSyncSystem is a system whose sparkable has been changed or removed since its creation.
+type OutOfSyncSystem struct {
+	System    *NativeSystem
+	Sparkable string
+
+	// Removed indicates that the sparkable no longer exists.
+	Removed bool
+
+	// Changes are the changes from the interface of the system to that of its sparkable.
+	Changes InterfaceChanges
+}
+
+// NewDomainWatcher creates a watcher for dom, which has been loaded from dir by LoadFromDir.
+func NewDomainWatcher(dom *Domain, dir string) (*DomainWatcher, error) {
+	w := &DomainWatcher{
+		dom: dom,
+		dir: dir,
+	}
+	files, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	w.files = files
+	return w, nil
+}
+
+// Start polls the directory tree for changes every interval. handle is called for every reload with changes.
+func (w *DomainWatcher) Start(interval time.Duration, handle func(reload *DomainReload, err error)) {
+	w.mux.Lock()
+	if w.stop != nil {
+		w.mux.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	w.stop = stop
+	w.stopped = stopped
+	w.mux.Unlock()
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				reload, err := w.Poll()
+				if err != nil || len(reload.Files) > 0 {
+					handle(reload, err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops polling and waits for a running reload to finish.
+func (w *DomainWatcher) Stop() {
+	w.mux.Lock()
+	if w.stop == nil {
+		w.mux.Unlock()
+		return
+	}
+	close(w.stop)
+	stopped := w.stopped
+	w.stop = nil
+	w.stopped = nil
+	w.mux.Unlock()
+	<-stopped
+}
+
+// Poll reloads the files which have changed since the last poll. Lookups and compilation through the domain wait for
+// the reload to finish.
+func (w *DomainWatcher) Poll() (*DomainReload, error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	files, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	for file, hash := range files {
+		if oldHash, ok := w.files[file]; !ok || oldHash != hash {
+			changed = append(changed, file)
+		}
+	}
+	for file := range w.files {
+		if _, ok := files[file]; !ok {
+			changed = append(changed, file)
+		}
+	}
+	// Parent domains are reloaded before their children.
+	sort.Strings(changed)
+
+	reload := &DomainReload{Files: changed}
+	if len(changed) == 0 {
+		return reload, nil
+	}
+
+	w.dom.lock()
+	defer w.dom.unlock()
+	for _, file := range changed {
+		_, exists := files[file]
+		if err := w.reloadFile(file, exists, reload); err != nil {
+			reload.Errors = append(reload.Errors, fmt.Errorf("%s: %w", file, err))
+			// Retry at the next poll.
+			if oldHash, ok := w.files[file]; ok {
+				files[file] = oldHash
+			} else {
+				delete(files, file)
+			}
+		}
+	}
+	w.files = files
+
+	w.dom.recompile(reload)
+	return reload, nil
+}
+
+// scan returns the hashes of the definition files in the directory tree, skipping the same directories as LoadFromDir.
+func (w *DomainWatcher) scan() (map[string][sha256.Size]byte, error) {
+	files := map[string][sha256.Size]byte{}
+	var scanDir func(rel string) error
+	scanDir = func(rel string) error {
+		entries, err := os.ReadDir(path.Join(w.dir, rel))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			chRel := path.Join(rel, e.Name())
+			if e.IsDir() {
+				if skipDomainDir(path.Join(w.dir, rel), e.Name()) {
+					continue
+				}
+				if err := scanDir(chRel); err != nil {
+					return err
+				}
+				continue
+			}
+			if !isDefinitionsFile(e.Name()) {
+				continue
+			}
+			bts, err := os.ReadFile(path.Join(w.dir, chRel))
+			if err != nil {
+				return err
+			}
+			files[chRel] = sha256.Sum256(bts)
+		}
+		return nil
+	}
+	return files, scanDir("")
+}
+
+// reloadFile replaces the definitions of the domain of file, the path of a definition file relative to the watched
+// directory, by those of the file. If the file no longer exists, the definitions are removed.
+func (w *DomainWatcher) reloadFile(file string, exists bool, reload *DomainReload) error {
+	frags := []string{}
+	if dir := path.Dir(file); dir != "." {
+		frags = strings.Split(dir, "/")
+	}
+
+	d := w.dom
+	for _, frag := range frags {
+		var sub *Domain
+		for _, sd := range d.Domains {
+			if sd.Name == frag {
+				sub = sd
+				break
+			}
+		}
+		if sub == nil {
+			if !exists {
+				return nil
+			}
+			sub = &Domain{
+				Parent: d,
+				Name:   frag,
+			}
+			if d.FullName != "" {
+				sub.FullName = d.FullName + DomSep + frag
+			} else {
+				sub.FullName = frag
+			}
+			d.Domains = append(d.Domains, sub)
+		}
+		d = sub
+	}
+
+	defs := Domain{}
+	if exists {
+		filePath := path.Join(w.dir, file)
+		bts, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(bts, &defs); err != nil {
+			return fmt.Errorf("parsing definitions: %v", err)
+		}
+		if defs.Name == "" {
+			return fmt.Errorf("require domain contents name")
+		}
+		if d.Name == "" {
+			d.Name = defs.Name
+			d.FullName = defs.Name
+		} else if d.Name != defs.Name {
+			return fmt.Errorf("contents for domain %s cannot be added to domain %s", defs.Name, d.Name)
+		}
+		d.Description = defs.Description
+		if defs.Permissions != nil {
+			d.Permissions = defs.Permissions
+		}
+		d.FilePath = filePath
+	} else {
+		d.FilePath = ""
+	}
+
+	d.replaceDefinitions(&defs, reload)
+
+	if !exists && d.Parent != nil {
+		if _, err := os.Stat(path.Join(w.dir, path.Dir(file))); os.IsNotExist(err) {
+			for i, sd := range d.Parent.Domains {
+				if sd == d {
+					d.Parent.Domains = append(d.Parent.Domains[:i], d.Parent.Domains[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// replaceDefinitions replaces the definitions of dom by those of defs. Changed definitions are updated in place, so
+// that references to them remain valid, and reset along with the definitions depending on them.
+func (dom *Domain) replaceDefinitions(defs *Domain, reload *DomainReload) {
+	types := []*Type{}
+	for _, t := range dom.Types {
+		var nt *Type
+		for _, dt := range defs.Types {
+			if dt.Name == t.Name {
+				nt = dt
+				break
+			}
+		}
+		if nt == nil {
+			reload.Removed = append(reload.Removed, t.FullName)
+			t.Reset()
+			continue
+		}
+		types = append(types, t)
+		nt.Domain = t.Domain
+		if nt.Permissions == nil {
+			nt.Permissions = t.Permissions
+		}
+		if !sameDefinition(t, nt) {
+			t.RawType = nt.RawType
+			t.Reset()
+		}
+	}
+	dom.Types = types
+	for _, dt := range defs.Types {
+		if _, err := dom.getType(dt.Name); err != nil {
+			_ = dom.addType(dt)
+		}
+	}
+
+	interfaces := []*Interface{}
+	for _, i := range dom.Interfaces {
+		var ni *Interface
+		for _, di := range defs.Interfaces {
+			if di.Name == i.Name {
+				ni = di
+				break
+			}
+		}
+		if ni == nil {
+			reload.Removed = append(reload.Removed, i.FullName)
+			i.Reset()
+			continue
+		}
+		interfaces = append(interfaces, i)
+		if ni.Permissions == nil {
+			ni.Permissions = i.Permissions
+		}
+		if !sameDefinition(i, ni) {
+			i.RawInterface = ni.RawInterface
+			i.Domain = dom.FullName
+			i.Reset()
+		}
+	}
+	dom.Interfaces = interfaces
+	for _, di := range defs.Interfaces {
+		if _, err := dom.getInterface(di.Name); err != nil {
+			_ = dom.addInterface(di)
+		}
+	}
+
+	sparkables := []*Sparkable{}
+	for _, s := range dom.Sparkables {
+		var ns *Sparkable
+		for _, ds := range defs.Sparkables {
+			if ds.Name == s.Name {
+				ns = ds
+				break
+			}
+		}
+		if ns == nil {
+			reload.Removed = append(reload.Removed, sparkableName(s))
+			s.Reset()
+			continue
+		}
+		sparkables = append(sparkables, s)
+		if ns.Permissions == nil {
+			ns.Permissions = s.Permissions
+		}
+		if !sameDefinition(s, ns) {
+			s.RawSparkable = ns.RawSparkable
+			s.Domain = dom.FullName
+			s.Reset()
+		}
+	}
+	dom.Sparkables = sparkables
+	for _, ds := range defs.Sparkables {
+		if _, err := dom.getSparkable(ds.Name); err != nil {
+			_ = dom.addSparkable(ds)
+		}
+	}
+}
+
+// recompile compiles the definitions of the domain tree of dom which have been reset and adds them to reload.
+// Interfaces and sparkables using reset types are reset and recompiled too.
+func (dom *Domain) recompile(reload *DomainReload) {
+	root := dom.Root()
+	var walk func(d *Domain, visit func(d *Domain))
+	walk = func(d *Domain, visit func(d *Domain)) {
+		visit(d)
+		for _, sd := range d.Domains {
+			walk(sd, visit)
+		}
+	}
+
+	// Compiling types compiles the types depending on them, so dirty definitions are determined beforehand.
+	dirtyTypes := map[*Type]bool{}
+	dirtyInterfaces := map[*Interface]bool{}
+	dirtySparkables := map[*Sparkable]bool{}
+	walk(root, func(d *Domain) {
+		for _, t := range d.Types {
+			dirtyTypes[t] = t.Compiled == nil
+		}
+		for _, i := range d.Interfaces {
+			dirtyInterfaces[i] = i.dirty()
+		}
+		for _, s := range d.Sparkables {
+			dirtySparkables[s] = !s.compiled || s.Interface.dirty() || s.Constructor.dirty()
+		}
+	})
+
+	walk(root, func(d *Domain) {
+		for _, t := range d.Types {
+			if !dirtyTypes[t] {
+				continue
+			}
+			if t.Compiled == nil {
+				if err := t.Compile(root, d.FullName, true); err != nil {
+					reload.Errors = append(reload.Errors, fmt.Errorf("compile type %s: %v", t.Name, err))
+				}
+			}
+			reload.Types = append(reload.Types, t.FullName)
+		}
+	})
+	walk(root, func(d *Domain) {
+		for _, i := range d.Interfaces {
+			if !dirtyInterfaces[i] {
+				continue
+			}
+			i.Reset()
+			if err := i.Compile(root, d.FullName, true); err != nil {
+				reload.Errors = append(reload.Errors, fmt.Errorf("compile interface %s: %v", i.Name, err))
+			}
+			reload.Interfaces = append(reload.Interfaces, i.FullName)
+		}
+	})
+	walk(root, func(d *Domain) {
+		for _, s := range d.Sparkables {
+			if !dirtySparkables[s] {
+				continue
+			}
+			s.Reset()
+			if err := s.Compile(root, d.FullName, true); err != nil {
+				reload.Errors = append(reload.Errors, fmt.Errorf("compile sparkable %s: %v", s.Name, err))
+			}
+			reload.Sparkables = append(reload.Sparkables, sparkableName(s))
+		}
+	})
+}
+
+// dirty determines whether i must be recompiled as it or a type of one of its hubs has been reset.
+func (i *Interface) dirty() bool {
+	if i == nil {
+		return false
+	}
+	if !i.compiled {
+		return true
+	}
+	if i.CompiledHubs == nil {
+		return false
+	}
+	for _, hub := range *i.CompiledHubs {
+		if hub.Input.dirty() || hub.Output.dirty() || hub.Value.dirty() || hub.Upstream.dirty() {
+			return true
+		}
+	}
+	return false
+}
+
+// dirty determines whether the type of i has been reset.
+func (i *HubItemInterface) dirty() bool {
+	return i != nil && i.Value != nil && i.Value.Compiled == nil
+}
+
+// dirty determines whether the type of an item of m has been reset.
+func (m HubItemsInterface) dirty() bool {
+	for _, hi := range m {
+		if hi.dirty() {
+			return true
+		}
+	}
+	return false
+}
+
+// sameDefinition determines whether the definitions def and def2 are declared equally, ignoring what has been
+// compiled.
+func sameDefinition(def any, def2 any) bool {
+	bts, err := yaml.Marshal(def)
+	if err != nil {
+		return false
+	}
+	bts2, err := yaml.Marshal(def2)
+	return err == nil && string(bts) == string(bts2)
+}
+
+// sparkableName returns the full name of s.
+func sparkableName(s *Sparkable) string {
+	if s.Domain == "" {
+		return s.Name
+	}
+	return s.Domain + DomSep + s.Name
+}
+
+// OutOfSync returns the systems of node created from sparkables which have been recompiled or removed by the reload.
+func (r *DomainReload) OutOfSync(node *NativeNode, dom *Domain) []OutOfSyncSystem {
+	changed := map[string]bool{}
+	for _, name := range append(r.Sparkables, r.Removed...) {
+		changed[name] = true
+	}
+
+	node.systemsMux.Lock()
+	systems := []*NativeSystem{}
+	for _, sys := range node.systems {
+		systems = append(systems, sys)
+	}
+	node.systemsMux.Unlock()
+	sort.Slice(systems, func(i, j int) bool {
+		return systems[i].id.Hex() < systems[j].id.Hex()
+	})
+
+	outOfSync := []OutOfSyncSystem{}
+	for _, sys := range systems {
+		if sys.sparkable.Name == "" {
+			continue
+		}
+		name := sparkableName(&sys.sparkable)
+		if !changed[name] {
+			continue
+		}
+		oos := OutOfSyncSystem{
+			System:    sys,
+			Sparkable: name,
+		}
+		if spk, err := dom.GetSparkable(name); err != nil {
+			oos.Removed = true
+		} else if spk.Interface != nil {
+			oos.Changes = sys.Interface().Compare(spk.Interface)
+		}
+		outOfSync = append(outOfSync, oos)
+	}
+	return outOfSync
+}
@@ -0,0 +1,215 @@
+package bitnode
+
+import (
+	"fmt"
+	"golang.org/x/exp/slices"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+const watcherDefsYAML = `name: shop
+
+types:
+  - name: price
+    leaf: %s
+  - name: item
+    mapOf:
+      price:
+        reference: price
+
+interfaces:
+  - name: Catalog
+    hubs:
+      - name: get
+        type: pipe
+        direction: in
+        input: []
+        output:
+          - value:
+              reference: item
+%s
+blueprints:
+  - name: Catalog
+    interface: $Catalog
+`
+
+func writeDefs(t *testing.T, file string, defs string) {
+	if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(defs), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDomainWatcher(t *testing.T) {
+	dir := t.TempDir()
+	writeDefs(t, path.Join(dir, "defs.yml"), fmt.Sprintf(watcherDefsYAML, "float", ""))
+
+	dom := NewDomain()
+	shop, _ := dom.AddDomain("shop")
+	if err := shop.LoadFromDir(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := dom.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewDomainWatcher(shop, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spk, _ := dom.GetSparkable("shop.Catalog")
+	n := NewNode()
+	sys, err := n.NewSystem(Credentials{}, *spk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reload, err := w.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reload.Files) != 0 || len(reload.Types) != 0 {
+		t.Fatal(reload)
+	}
+
+	// Changed type, recompiling the types, interfaces and sparkables depending on it.
+	item, _ := dom.GetType("shop.item")
+	writeDefs(t, path.Join(dir, "defs.yml"), fmt.Sprintf(watcherDefsYAML, "integer", ""))
+	reload, err = w.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reload.Errors) != 0 {
+		t.Fatal(reload.Errors)
+	}
+	if !slices.Contains(reload.Types, "shop.price") || !slices.Contains(reload.Types, "shop.item") {
+		t.Fatal(reload.Types)
+	}
+	if !slices.Contains(reload.Interfaces, "shop.Catalog") || !slices.Contains(reload.Sparkables, "shop.Catalog") {
+		t.Fatal(reload.Interfaces, reload.Sparkables)
+	}
+	if item2, _ := dom.GetType("shop.item"); item2 != item || item.Compiled.MapOf["price"].Leaf != LeafInteger {
+		t.Fatal("types must be updated in place")
+	}
+	if out := spk.Interface.CompiledHubs.GetHub("get").Output[0].Value.Compiled; out.MapOf["price"].Leaf != LeafInteger {
+		t.Fatal(out)
+	}
+
+	outOfSync := reload.OutOfSync(n, dom)
+	if len(outOfSync) != 1 || outOfSync[0].System != sys.Native() || outOfSync[0].Sparkable != "shop.Catalog" {
+		t.Fatal(outOfSync)
+	}
+
+	// Changed interface.
+	writeDefs(t, path.Join(dir, "defs.yml"), fmt.Sprintf(watcherDefsYAML, "integer", `
+      - name: count
+        type: value
+        direction: out
+        value:
+          value: integer
+`))
+	reload, _ = w.Poll()
+	if len(reload.Types) != 0 || !slices.Contains(reload.Interfaces, "shop.Catalog") {
+		t.Fatal(reload)
+	}
+	outOfSync = reload.OutOfSync(n, dom)
+	if len(outOfSync) != 1 || outOfSync[0].Changes.Kind() != ChangeAdditive {
+		t.Fatal(outOfSync)
+	}
+
+	// Added domain.
+	writeDefs(t, path.Join(dir, "extra", "defs.yml"), "name: extra\ntypes:\n  - name: tag\n    leaf: string\n")
+	reload, _ = w.Poll()
+	if len(reload.Files) != 1 || !slices.Contains(reload.Types, "shop.extra.tag") {
+		t.Fatal(reload)
+	}
+	if len(reload.OutOfSync(n, dom)) != 0 {
+		t.Fatal("unrelated changes must not affect systems")
+	}
+
+	// Removed type.
+	writeDefs(t, path.Join(dir, "defs.yml"), `name: shop
+types:
+  - name: item
+    mapOf:
+      price:
+        reference: price
+`)
+	reload, _ = w.Poll()
+	if !slices.Contains(reload.Removed, "shop.price") || !slices.Contains(reload.Removed, "shop.Catalog") {
+		t.Fatal(reload.Removed)
+	}
+	if len(reload.Errors) == 0 {
+		t.Fatal("missing reference must be reported")
+	}
+	if outOfSync := reload.OutOfSync(n, dom); len(outOfSync) != 1 || !outOfSync[0].Removed {
+		t.Fatal(outOfSync)
+	}
+
+	// Removed domain.
+	if err := os.RemoveAll(path.Join(dir, "extra")); err != nil {
+		t.Fatal(err)
+	}
+	reload, _ = w.Poll()
+	if !slices.Contains(reload.Removed, "shop.extra.tag") {
+		t.Fatal(reload.Removed)
+	}
+	if _, err := dom.GetDomain("shop.extra"); err == nil {
+		t.Fatal("domain must be removed")
+	}
+}
+
+func TestDomainWatcher_Start(t *testing.T) {
+	dir := t.TempDir()
+	writeDefs(t, path.Join(dir, "defs.yml"), fmt.Sprintf(watcherDefsYAML, "float", ""))
+
+	dom := NewDomain()
+	shop, _ := dom.AddDomain("shop")
+	if err := shop.LoadFromDir(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := dom.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewDomainWatcher(shop, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := make(chan *DomainReload, 1)
+	w.Start(time.Millisecond, func(reload *DomainReload, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		select {
+		case reloads <- reload:
+		default:
+		}
+	})
+	defer w.Stop()
+
+	writeDefs(t, path.Join(dir, "defs.yml"), fmt.Sprintf(watcherDefsYAML, "integer", ""))
+
+	// Lookups and compilation are serialised with reloads.
+	for {
+		if _, err := dom.GetType("shop.item"); err != nil {
+			t.Fatal(err)
+		}
+		if err := dom.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case reload := <-reloads:
+			if !slices.Contains(reload.Types, "shop.price") {
+				t.Fatal(reload.Types)
+			}
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
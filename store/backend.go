@@ -0,0 +1,481 @@
+package store
+
+import (
+	"fmt"
+	"github.com/Bitspark/go-bitnode/util"
+	"golang.org/x/exp/slices"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// A Backend persists stores. Contents are organized in nested buckets, each containing keys and buckets.
+type Backend interface {
+	// Update runs fn in a read-write transaction. If fn returns an error, no changes are persisted.
+	Update(fn func(tx Tx) error) error
+
+	// View runs fn in a read-only transaction.
+	View(fn func(tx Tx) error) error
+
+	// Close releases the resources held by the backend.
+	Close() error
+}
+
+// A Tx is a transaction on a Backend. Buckets are identified by their path from the root.
+type Tx interface {
+	// Get returns the value of key in bucket. The bucket must exist.
+	Get(bucket []string, key string) (string, bool, error)
+
+	// Put sets the value of key in bucket. The bucket must exist.
+	Put(bucket []string, key string, value string) error
+
+	// Delete removes key from bucket.
+	Delete(bucket []string, key string) error
+
+	// Keys returns the keys in bucket in ascending order.
+	Keys(bucket []string) ([]string, error)
+
+	// Buckets returns the names of the buckets in bucket in ascending order.
+	Buckets(bucket []string) ([]string, error)
+
+	// CreateBucket creates bucket and all its parents unless they exist.
+	CreateBucket(bucket []string) error
+
+	// DeleteBucket removes bucket with all its contents.
+	DeleteBucket(bucket []string) error
+
+	// HasBucket determines whether bucket exists.
+	HasBucket(bucket []string) bool
+}
+
+// dsBucket is the bucket inside a store bucket which contains the types of its data structures.
+const dsBucket = "_ds"
+
+// OpenStore opens the store name persisted in backend. It is created if it does not exist yet.
+// All changes of the store and its data structures are written to the backend immediately.
+func OpenStore(backend Backend, name string) (Store, error) {
+	st := &backendStore{
+		backend: backend,
+		name:    name,
+		path:    []string{name},
+	}
+	if err := backend.Update(func(tx Tx) error {
+		return tx.CreateBucket(st.dsPath())
+	}); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func childPath(path []string, names ...string) []string {
+	p := make([]string, 0, len(path)+len(names))
+	p = append(p, path...)
+	return append(p, names...)
+}
+
+// STORE
+
+type backendStore struct {
+	backend Backend
+	name    string
+	path    []string
+}
+
+var _ Store = &backendStore{}
+
+func (s *backendStore) dsPath() []string {
+	return childPath(s.path, dsBucket)
+}
+
+func (s *backendStore) Name() string {
+	return s.name
+}
+
+func (s *backendStore) Create(name string, dsType string) (*DataStructure, error) {
+	if !util.CheckString(util.CharsAlphaLowerNum, name, false) {
+		return nil, fmt.Errorf("data structure name must only contain alphanumeric characters")
+	}
+	switch dsType {
+	case DSKeyValue, DSStores, DSList, DSLog, DSCounter, DSBlob:
+	default:
+		return nil, fmt.Errorf("unknown data structure type: %s", dsType)
+	}
+	if err := s.backend.Update(func(tx Tx) error {
+		if _, ok, err := tx.Get(s.dsPath(), name); err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("data structure %s already exists", name)
+		}
+		if err := tx.Put(s.dsPath(), name, dsType); err != nil {
+			return err
+		}
+		return tx.CreateBucket(childPath(s.path, name))
+	}); err != nil {
+		return nil, err
+	}
+	return s.dataStructure(name, dsType), nil
+}
+
+func (s *backendStore) Get(name string) (*DataStructure, error) {
+	var dsType string
+	if err := s.backend.View(func(tx Tx) error {
+		t, ok, err := tx.Get(s.dsPath(), name)
+		if err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("data structure %s does not exist", name)
+		}
+		dsType = t
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return s.dataStructure(name, dsType), nil
+}
+
+func (s *backendStore) Ensure(name string, dsType string) (*DataStructure, error) {
+	ds, err := s.Get(name)
+	if err == nil {
+		if ds.Type != dsType {
+			return nil, fmt.Errorf("data structure has type %s instead of %s", ds.Type, dsType)
+		}
+		return ds, nil
+	}
+	return s.Create(name, dsType)
+}
+
+func (s *backendStore) Delete(name string) error {
+	return s.backend.Update(func(tx Tx) error {
+		if _, ok, err := tx.Get(s.dsPath(), name); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("data structure %s does not exist", name)
+		}
+		if err := tx.Delete(s.dsPath(), name); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(childPath(s.path, name))
+	})
+}
+
+func (s *backendStore) DataStructures() []*DataStructure {
+	dss := []*DataStructure{}
+	_ = s.backend.View(func(tx Tx) error {
+		names, err := tx.Keys(s.dsPath())
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			dsType, _, _ := tx.Get(s.dsPath(), name)
+			dss = append(dss, s.dataStructure(name, dsType))
+		}
+		return nil
+	})
+	return dss
+}
+
+// Write does nothing as all changes have already been persisted.
+func (s *backendStore) Write(path string) error {
+	return nil
+}
+
+// Read does nothing as the contents are read from the backend on demand.
+func (s *backendStore) Read(path string) error {
+	return nil
+}
+
+func (s *backendStore) dataStructure(name string, dsType string) *DataStructure {
+	ds := &DataStructure{
+		Name: name,
+		Type: dsType,
+	}
+	switch dsType {
+	case DSKeyValue:
+		ds.Structure = &backendKeyValue{backend: s.backend, path: childPath(s.path, name)}
+	case DSStores:
+		ds.Structure = &backendStores{backend: s.backend, path: childPath(s.path, name), stores: map[string]*backendStore{}}
+	case DSList:
+		ds.Structure = &backendList{backend: s.backend, path: childPath(s.path, name)}
+	case DSLog:
+		ds.Structure = &backendLog{backend: s.backend, path: childPath(s.path, name)}
+	case DSCounter:
+		ds.Structure = &backendCounter{backend: s.backend, path: childPath(s.path, name)}
+	case DSBlob:
+		ds.Structure = &backendBlob{backend: s.backend, path: childPath(s.path, name)}
+	}
+	return ds
+}
+
+// KEY VALUE
+
+type backendKeyValue struct {
+	backend Backend
+	path    []string
+}
+
+var _ KeyValue = &backendKeyValue{}
+
+func (k *backendKeyValue) Get(key string) (string, error) {
+	var value string
+	err := k.backend.View(func(tx Tx) error {
+		v, ok, err := tx.Get(k.path, key)
+		if err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("entry does not exist")
+		}
+		value = v
+		return nil
+	})
+	return value, err
+}
+
+func (k *backendKeyValue) Set(key string, value string) error {
+	return k.backend.Update(func(tx Tx) error {
+		return tx.Put(k.path, key, value)
+	})
+}
+
+func (k *backendKeyValue) EnumerateKeys() chan string {
+	var keys []string
+	_ = k.backend.View(func(tx Tx) error {
+		var err error
+		keys, err = tx.Keys(k.path)
+		return err
+	})
+	c := make(chan string)
+	go func() {
+		for _, key := range keys {
+			c <- key
+		}
+		close(c)
+	}()
+	return c
+}
+
+func (k *backendKeyValue) Write(dir string, name string) error {
+	return nil
+}
+
+func (k *backendKeyValue) Read(dir string, name string) error {
+	return nil
+}
+
+// STORES
+
+type backendStores struct {
+	backend Backend
+	path    []string
+
+	// stores contains the stores handed out so far, so the same store is returned for the same name.
+	stores map[string]*backendStore
+
+	mux sync.Mutex
+}
+
+var _ Stores = &backendStores{}
+
+func (k *backendStores) Get(name string) (Store, error) {
+	found := false
+	_ = k.backend.View(func(tx Tx) error {
+		found = tx.HasBucket(childPath(k.path, name))
+		return nil
+	})
+	if !found {
+		return nil, fmt.Errorf("entry does not exist")
+	}
+	return k.store(name), nil
+}
+
+// Add persists a copy of value, replacing a previous store of the same name.
+func (k *backendStores) Add(value Store) error {
+	if st, ok := value.(*backendStore); ok && st.backend == k.backend {
+		if slices.Equal(st.path, childPath(k.path, st.name)) {
+			return nil
+		}
+		// Take a snapshot as the backend cannot be read while being updated.
+		value = cloneStore(st)
+	}
+	return k.backend.Update(func(tx Tx) error {
+		path := childPath(k.path, value.Name())
+		if tx.HasBucket(path) {
+			if err := tx.DeleteBucket(path); err != nil {
+				return err
+			}
+		}
+		return copyStore(tx, path, value)
+	})
+}
+
+func (k *backendStores) Remove(name string) error {
+	if err := k.backend.Update(func(tx Tx) error {
+		path := childPath(k.path, name)
+		if !tx.HasBucket(path) {
+			return fmt.Errorf("entry does not exist")
+		}
+		return tx.DeleteBucket(path)
+	}); err != nil {
+		return err
+	}
+	k.mux.Lock()
+	delete(k.stores, name)
+	k.mux.Unlock()
+	return nil
+}
+
+func (k *backendStores) Enumerate() chan Store {
+	var names []string
+	_ = k.backend.View(func(tx Tx) error {
+		var err error
+		names, err = tx.Buckets(k.path)
+		return err
+	})
+	c := make(chan Store)
+	go func() {
+		for _, name := range names {
+			c <- k.store(name)
+		}
+		close(c)
+	}()
+	return c
+}
+
+func (k *backendStores) Write(dir string, name string) error {
+	return nil
+}
+
+func (k *backendStores) Read(dir string, name string) error {
+	return nil
+}
+
+func (k *backendStores) store(name string) *backendStore {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if st, ok := k.stores[name]; ok {
+		return st
+	}
+	st := &backendStore{
+		backend: k.backend,
+		name:    name,
+		path:    childPath(k.path, name),
+	}
+	k.stores[name] = st
+	return st
+}
+
+// copyStore writes the contents of st into the bucket at path.
+func copyStore(tx Tx, path []string, st Store) error {
+	dsPath := childPath(path, dsBucket)
+	if err := tx.CreateBucket(dsPath); err != nil {
+		return err
+	}
+	dss := st.DataStructures()
+	sort.Slice(dss, func(i, j int) bool { return dss[i].Name < dss[j].Name })
+	for _, ds := range dss {
+		if err := tx.Put(dsPath, ds.Name, ds.Type); err != nil {
+			return err
+		}
+		structPath := childPath(path, ds.Name)
+		if err := tx.CreateBucket(structPath); err != nil {
+			return err
+		}
+		switch ds.Type {
+		case DSKeyValue:
+			kv := ds.KeyValue()
+			keys := []string{}
+			for key := range kv.EnumerateKeys() {
+				keys = append(keys, key)
+			}
+			for _, key := range keys {
+				value, err := kv.Get(key)
+				if err != nil {
+					return err
+				}
+				if err := tx.Put(structPath, key, value); err != nil {
+					return err
+				}
+			}
+		case DSStores:
+			children := []Store{}
+			for child := range ds.Stores().Enumerate() {
+				children = append(children, child)
+			}
+			for _, child := range children {
+				if err := copyStore(tx, childPath(structPath, child.Name()), child); err != nil {
+					return err
+				}
+			}
+		case DSList:
+			for i, item := range ds.List().Items() {
+				if err := tx.Put(structPath, seqKey(uint64(i)), item); err != nil {
+					return err
+				}
+			}
+		case DSLog:
+			l := ds.Log()
+			entries, err := l.Range(0, 0)
+			if err != nil {
+				return err
+			}
+			if err := tx.CreateBucket(childPath(structPath, "entries")); err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if err := tx.Put(childPath(structPath, "entries"), seqKey(e.Seq), e.Value); err != nil {
+					return err
+				}
+			}
+			if err := tx.Put(structPath, "first", strconv.FormatUint(l.First(), 10)); err != nil {
+				return err
+			}
+			if err := tx.Put(structPath, "next", strconv.FormatUint(l.Next(), 10)); err != nil {
+				return err
+			}
+		case DSCounter:
+			if err := tx.Put(structPath, "value", strconv.FormatInt(ds.Counter().Get(), 10)); err != nil {
+				return err
+			}
+		case DSBlob:
+			data, err := ds.Blob().Get()
+			if err != nil {
+				return err
+			}
+			if err := tx.Put(structPath, "data", string(data)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cloneStore copies st into a new in-memory store.
+func cloneStore(st Store) Store {
+	cl := NewStore(st.Name())
+	for _, ds := range st.DataStructures() {
+		clDS, _ := cl.Create(ds.Name, ds.Type)
+		switch ds.Type {
+		case DSKeyValue:
+			for key := range ds.KeyValue().EnumerateKeys() {
+				value, _ := ds.KeyValue().Get(key)
+				_ = clDS.KeyValue().Set(key, value)
+			}
+		case DSStores:
+			for child := range ds.Stores().Enumerate() {
+				_ = clDS.Stores().Add(cloneStore(child))
+			}
+		case DSList:
+			_ = clDS.List().Append(ds.List().Items()...)
+		case DSLog:
+			entries, _ := ds.Log().Range(0, 0)
+			clLog := clDS.Structure.(*appendLog)
+			clLog.Start = ds.Log().First()
+			clLog.Entries = entries
+		case DSCounter:
+			_ = clDS.Counter().Set(ds.Counter().Get())
+		case DSBlob:
+			data, _ := ds.Blob().Get()
+			_ = clDS.Blob().Set(data)
+		}
+	}
+	return cl
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"fmt"
+	"path"
+	"testing"
+)
+
+func testBackend(t *testing.T, backend Backend) {
+	st, err := OpenStore(backend, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kvDS, err := st.Ensure("kv1", DSKeyValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kvDS.KeyValue().Set("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Ensure("kv1", DSStores); err == nil {
+		t.Fatal("must not change type")
+	}
+
+	child := NewStore("child")
+	childDS, _ := child.Create("kv2", DSKeyValue)
+	_ = childDS.KeyValue().Set("b", "2")
+
+	stsDS, err := st.Ensure("sts1", DSStores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stsDS.Stores().Add(child); err != nil {
+		t.Fatal(err)
+	}
+	_ = childDS.KeyValue().Set("b", "3")
+
+	st2, err := OpenStore(backend, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st2.DataStructures()) != 2 {
+		t.Fatal(st2.DataStructures())
+	}
+	kvDS2, err := st2.Get("kv1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := kvDS2.KeyValue().Get("a"); err != nil || v != "1" {
+		t.Fatal(v, err)
+	}
+	stsDS2, _ := st2.Get("sts1")
+	child2, err := stsDS2.Stores().Get("child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	childDS2, _ := child2.Get("kv2")
+	if v, _ := childDS2.KeyValue().Get("b"); v != "2" {
+		t.Fatal("added store must be copied", v)
+	}
+	n := 0
+	for range stsDS2.Stores().Enumerate() {
+		n++
+	}
+	if n != 1 {
+		t.Fatal(n)
+	}
+
+	if err := st2.Delete("kv1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get("kv1"); err == nil {
+		t.Fatal("must be deleted")
+	}
+}
+
+func TestMemoryBackend1(t *testing.T) {
+	testBackend(t, NewMemoryBackend())
+}
+
+func TestBoltBackend1(t *testing.T) {
+	file := path.Join(t.TempDir(), "test.db")
+	backend, err := OpenBoltBackend(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, backend)
+
+	kvDS, _ := func() (*DataStructure, error) {
+		st, _ := OpenStore(backend, "test")
+		return st.Ensure("kv3", DSKeyValue)
+	}()
+	_ = kvDS.KeyValue().Set("c", "4")
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err = OpenBoltBackend(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	st, _ := OpenStore(backend, "test")
+	kvDS, err = st.Get("kv3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := kvDS.KeyValue().Get("c"); v != "4" {
+		t.Fatal(v)
+	}
+}
+
+func TestBackend__Rollback(t *testing.T) {
+	backend := NewMemoryBackend()
+	st, _ := OpenStore(backend, "test")
+	kvDS, _ := st.Create("kv1", DSKeyValue)
+
+	err := backend.Update(func(tx Tx) error {
+		if err := tx.Put([]string{"test", "kv1"}, "a", "1"); err != nil {
+			return err
+		}
+		return fmt.Errorf("abort")
+	})
+	if err == nil {
+		t.Fatal()
+	}
+	if _, err := kvDS.KeyValue().Get("a"); err == nil {
+		t.Fatal("must not persist aborted transaction")
+	}
+}
+
+func TestBackend__Structures(t *testing.T) {
+	backend, err := OpenBoltBackend(path.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	st, _ := OpenStore(backend, "test")
+	fillStructures(t, st)
+	checkStructures(t, st)
+
+	// Copy an in-memory store and a store of the same backend.
+	mem := NewStore("mem")
+	fillStructures(t, mem)
+	sts, _ := st.Create("sts1", DSStores)
+	if err := sts.Stores().Add(mem); err != nil {
+		t.Fatal(err)
+	}
+	memCopy, _ := sts.Stores().Get("mem")
+	checkStructures(t, memCopy)
+
+	other, _ := OpenStore(backend, "other")
+	fillStructures(t, other)
+	if err := sts.Stores().Add(other); err != nil {
+		t.Fatal(err)
+	}
+	otherCopy, _ := sts.Stores().Get("other")
+	checkStructures(t, otherCopy)
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"os"
+	"path"
+	"sync"
+)
+
+// BLOB
+
+// A Blob holds binary data. It is stored in a file of its own rather than inside the YAML files of the store.
+type Blob interface {
+	Structure
+	Get() ([]byte, error)
+	Set(data []byte) error
+	Size() int
+}
+
+type blob struct {
+	data []byte
+
+	mux *sync.Mutex
+}
+
+var _ Blob = &blob{}
+
+func newBlob() Blob {
+	return &blob{
+		data: []byte{},
+
+		mux: &sync.Mutex{},
+	}
+}
+
+func (b *blob) Get() ([]byte, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	data := make([]byte, len(b.data))
+	copy(data, b.data)
+	return data, nil
+}
+
+func (b *blob) Set(data []byte) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.data = make([]byte, len(data))
+	copy(b.data, data)
+	return nil
+}
+
+func (b *blob) Size() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return len(b.data)
+}
+
+func (b *blob) Write(dir string, name string) error {
+	return b.write(dir, name, nil)
+}
+
+func (b *blob) write(dir string, name string, m *manifest) error {
+	b.mux.Lock()
+	data := b.data
+	b.mux.Unlock()
+	return m.writeFile(path.Join(dir, name+".bin"), data)
+}
+
+func (b *blob) Read(dir string, name string) error {
+	data, err := os.ReadFile(path.Join(dir, name+".bin"))
+	if err != nil {
+		return err
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.data = data
+	return nil
+}
+
+// BACKEND BLOB
+
+type backendBlob struct {
+	backend Backend
+	path    []string
+}
+
+var _ Blob = &backendBlob{}
+
+func (b *backendBlob) Get() ([]byte, error) {
+	var data []byte
+	err := b.backend.View(func(tx Tx) error {
+		v, _, err := tx.Get(b.path, "data")
+		data = []byte(v)
+		return err
+	})
+	return data, err
+}
+
+func (b *backendBlob) Set(data []byte) error {
+	return b.backend.Update(func(tx Tx) error {
+		return tx.Put(b.path, "data", string(data))
+	})
+}
+
+func (b *backendBlob) Size() int {
+	size := 0
+	_ = b.backend.View(func(tx Tx) error {
+		v, _, err := tx.Get(b.path, "data")
+		size = len(v)
+		return err
+	})
+	return size
+}
+
+func (b *backendBlob) Write(dir string, name string) error {
+	return nil
+}
+
+func (b *backendBlob) Read(dir string, name string) error {
+	return nil
+}
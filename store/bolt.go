@@ -0,0 +1,156 @@
+package store
+
+import (
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+// boltBackend persists stores in a single bbolt database file.
+type boltBackend struct {
+	db *bolt.DB
+}
+
+var _ Backend = &boltBackend{}
+
+// OpenBoltBackend opens or creates a bbolt database at file.
+func OpenBoltBackend(file string) (Backend, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", file, err)
+	}
+	return &boltBackend{db: db}, nil
+}
+
+func (b *boltBackend) Update(fn func(tx Tx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+func (b *boltBackend) View(fn func(tx Tx) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+func (b *boltBackend) Close() error {
+	return b.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+var _ Tx = &boltTx{}
+
+func (t *boltTx) bucket(path []string) (*bolt.Bucket, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("require bucket")
+	}
+	b := t.tx.Bucket([]byte(path[0]))
+	if b == nil {
+		return nil, fmt.Errorf("bucket %s does not exist", path[0])
+	}
+	for _, name := range path[1:] {
+		b = b.Bucket([]byte(name))
+		if b == nil {
+			return nil, fmt.Errorf("bucket %s does not exist", name)
+		}
+	}
+	return b, nil
+}
+
+func (t *boltTx) Get(bucket []string, key string) (string, bool, error) {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return "", false, err
+	}
+	v := b.Get([]byte(key))
+	if v == nil || b.Bucket([]byte(key)) != nil {
+		return "", false, nil
+	}
+	return string(v), true, nil
+}
+
+func (t *boltTx) Put(bucket []string, key string, value string) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), []byte(value))
+}
+
+func (t *boltTx) Delete(bucket []string, key string) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return b.Delete([]byte(key))
+}
+
+func (t *boltTx) Keys(bucket []string) ([]string, error) {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	err = b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (t *boltTx) Buckets(bucket []string) ([]string, error) {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	err = b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			names = append(names, string(k))
+		}
+		return nil
+	})
+	return names, err
+}
+
+func (t *boltTx) CreateBucket(bucket []string) error {
+	if len(bucket) == 0 {
+		return fmt.Errorf("require bucket")
+	}
+	b, err := t.tx.CreateBucketIfNotExists([]byte(bucket[0]))
+	if err != nil {
+		return err
+	}
+	for _, name := range bucket[1:] {
+		b, err = b.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *boltTx) DeleteBucket(bucket []string) error {
+	if len(bucket) == 0 {
+		return fmt.Errorf("require bucket")
+	}
+	if len(bucket) == 1 {
+		return t.tx.DeleteBucket([]byte(bucket[0]))
+	}
+	b, err := t.bucket(bucket[:len(bucket)-1])
+	if err != nil {
+		return err
+	}
+	return b.DeleteBucket([]byte(bucket[len(bucket)-1]))
+}
+
+func (t *boltTx) HasBucket(bucket []string) bool {
+	_, err := t.bucket(bucket)
+	return err == nil
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+)
+
+// COUNTER
+
+// A Counter is an integer which is changed atomically.
+type Counter interface {
+	Structure
+	Get() int64
+
+	// Add adds delta to the counter and returns the new value.
+	Add(delta int64) (int64, error)
+
+	Set(value int64) error
+}
+
+type counter struct {
+	Value int64 `yaml:"value"`
+
+	mux *sync.Mutex
+}
+
+var _ Counter = &counter{}
+
+func newCounter() Counter {
+	return &counter{
+		mux: &sync.Mutex{},
+	}
+}
+
+func (c *counter) Get() int64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.Value
+}
+
+func (c *counter) Add(delta int64) (int64, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Value += delta
+	return c.Value, nil
+}
+
+func (c *counter) Set(value int64) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Value = value
+	return nil
+}
+
+func (c *counter) Write(dir string, name string) error {
+	return c.write(dir, name, nil)
+}
+
+func (c *counter) write(dir string, name string, m *manifest) error {
+	c.mux.Lock()
+	stBts, err := yaml.Marshal(c)
+	c.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	return m.writeFile(path.Join(dir, name+".yml"), stBts)
+}
+
+func (c *counter) Read(dir string, name string) error {
+	stBts, err := os.ReadFile(path.Join(dir, name+".yml"))
+	if err != nil {
+		return err
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return yaml.Unmarshal(stBts, c)
+}
+
+// BACKEND COUNTER
+
+type backendCounter struct {
+	backend Backend
+	path    []string
+}
+
+var _ Counter = &backendCounter{}
+
+func (c *backendCounter) get(tx Tx) (int64, error) {
+	v, ok, err := tx.Get(c.path, "value")
+	if err != nil || !ok {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+func (c *backendCounter) Get() int64 {
+	var value int64
+	_ = c.backend.View(func(tx Tx) error {
+		var err error
+		value, err = c.get(tx)
+		return err
+	})
+	return value
+}
+
+func (c *backendCounter) Add(delta int64) (int64, error) {
+	var value int64
+	err := c.backend.Update(func(tx Tx) error {
+		v, err := c.get(tx)
+		if err != nil {
+			return err
+		}
+		value = v + delta
+		return tx.Put(c.path, "value", strconv.FormatInt(value, 10))
+	})
+	return value, err
+}
+
+func (c *backendCounter) Set(value int64) error {
+	return c.backend.Update(func(tx Tx) error {
+		return tx.Put(c.path, "value", strconv.FormatInt(value, 10))
+	})
+}
+
+func (c *backendCounter) Write(dir string, name string) error {
+	return nil
+}
+
+func (c *backendCounter) Read(dir string, name string) error {
+	return nil
+}
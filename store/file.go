@@ -0,0 +1,248 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// manifestFile lists all files of a store tree written by Store.Write together with their checksums.
+const manifestFile = "_manifest.yml"
+
+// ErrIncomplete is returned when reading a store tree which has only been written partially.
+var ErrIncomplete = errors.New("store tree is incomplete")
+
+// afterRename is called after a temporary file has replaced file. Tests use it to simulate crashes.
+var afterRename func(file string) error
+
+// WriteFileAtomic writes data to a temporary file, syncs it and renames it to file, so that file either contains
+// its previous or its new contents.
+func WriteFileAtomic(file string, data []byte) error {
+	dir := path.Dir(file)
+	tmp, err := os.CreateTemp(dir, "."+path.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, file); err != nil {
+		return err
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	if afterRename != nil {
+		return afterRename(file)
+	}
+	return nil
+}
+
+// syncDir makes a rename inside dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
+
+// checksum returns the hex encoded SHA-256 checksum of data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// backupFile returns the name of the backup of file kept for generation gen.
+func backupFile(file string, gen uint64) string {
+	return path.Join(path.Dir(file), "."+path.Base(file)+".gen"+strconv.FormatUint(gen, 10))
+}
+
+// manifest describes a generation of a store tree.
+type manifest struct {
+	Generation uint64            `yaml:"generation"`
+	Files      map[string]string `yaml:"files"`
+
+	// root directory of the store tree.
+	root string
+
+	// prev is the manifest of the previous generation, nil if there is none.
+	prev *manifest
+
+	// backups contains the backups of files of the previous generation replaced by this generation.
+	backups []string
+}
+
+// newManifest creates the manifest for the next generation of the store tree in root.
+func newManifest(root string) (*manifest, error) {
+	m := &manifest{
+		Files: map[string]string{},
+		root:  root,
+	}
+	prev, err := readManifest(root)
+	if err != nil && !errors.Is(err, ErrIncomplete) {
+		return nil, err
+	}
+	if prev != nil {
+		m.Generation = prev.Generation
+		m.prev = prev
+	}
+	m.Generation++
+	return m, nil
+}
+
+// readManifest reads the manifest of the store tree in root. Returns nil if the tree has no manifest.
+func readManifest(root string) (*manifest, error) {
+	bts, err := os.ReadFile(path.Join(root, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	m := &manifest{root: root}
+	if err := yaml.Unmarshal(bts, m); err != nil {
+		return nil, fmt.Errorf("%w: invalid manifest: %v", ErrIncomplete, err)
+	}
+	return m, nil
+}
+
+// writeFile writes a file of the store tree and records it in the manifest. m may be nil.
+func (m *manifest) writeFile(file string, data []byte) error {
+	if m == nil {
+		return WriteFileAtomic(file, data)
+	}
+	rel, err := filepath.Rel(m.root, file)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	if err := m.backup(file, rel); err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(file, data); err != nil {
+		return err
+	}
+	m.Files[rel] = checksum(data)
+	return nil
+}
+
+// backup keeps the contents file has in the previous generation until this generation is complete, so that the
+// previous generation can be restored if writing this generation is interrupted.
+func (m *manifest) backup(file string, rel string) error {
+	if m.prev == nil {
+		return nil
+	}
+	if _, ok := m.prev.Files[rel]; !ok {
+		return nil
+	}
+	bak := backupFile(file, m.prev.Generation)
+	if _, err := os.Lstat(bak); err == nil {
+		// Kept by an interrupted attempt to write this generation, file may already have been replaced.
+		m.backups = append(m.backups, bak)
+		return nil
+	}
+	if err := os.Link(file, bak); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		// The file system does not support hard links.
+		bts, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := WriteFileAtomic(bak, bts); err != nil {
+			return err
+		}
+	}
+	m.backups = append(m.backups, bak)
+	return syncDir(path.Dir(file))
+}
+
+// save writes the manifest, completing the generation. The backups of the previous generation are removed.
+func (m *manifest) save() error {
+	bts, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(path.Join(m.root, manifestFile), bts); err != nil {
+		return err
+	}
+	for _, bak := range m.backups {
+		if err := os.Remove(bak); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore restores the files of the generation which have been replaced by a later, incomplete generation.
+func (m *manifest) restore() error {
+	for file, sum := range m.Files {
+		name := path.Join(m.root, filepath.FromSlash(file))
+		bak := backupFile(name, m.Generation)
+		bts, err := os.ReadFile(bak)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if checksum(bts) != sum {
+			if err := os.Remove(bak); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Rename(bak, name); err != nil {
+			return err
+		}
+		if err := syncDir(path.Dir(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify checks that all files of the generation are present and unchanged.
+func (m *manifest) verify() error {
+	files := make([]string, 0, len(m.Files))
+	for file := range m.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		bts, err := os.ReadFile(path.Join(m.root, filepath.FromSlash(file)))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: generation %d misses %s", ErrIncomplete, m.Generation, file)
+		} else if err != nil {
+			return err
+		}
+		if checksum(bts) != m.Files[file] {
+			return fmt.Errorf("%w: %s does not belong to generation %d", ErrIncomplete, file, m.Generation)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package store
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"sync"
+)
+
+// LIST
+
+// A List is an ordered sequence of values.
+type List interface {
+	Structure
+	Len() int
+	Get(index int) (string, error)
+	Set(index int, value string) error
+	Append(values ...string) error
+	Insert(index int, value string) error
+	Remove(index int) error
+	Items() []string
+}
+
+type list struct {
+	Entries []string `yaml:"entries"`
+
+	mux *sync.Mutex
+}
+
+var _ List = &list{}
+
+func newList() List {
+	return &list{
+		Entries: []string{},
+
+		mux: &sync.Mutex{},
+	}
+}
+
+func (l *list) Len() int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return len(l.Entries)
+}
+
+func (l *list) Get(index int) (string, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if index < 0 || index >= len(l.Entries) {
+		return "", fmt.Errorf("index %d out of range", index)
+	}
+	return l.Entries[index], nil
+}
+
+func (l *list) Set(index int, value string) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if index < 0 || index >= len(l.Entries) {
+		return fmt.Errorf("index %d out of range", index)
+	}
+	l.Entries[index] = value
+	return nil
+}
+
+func (l *list) Append(values ...string) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.Entries = append(l.Entries, values...)
+	return nil
+}
+
+func (l *list) Insert(index int, value string) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if index < 0 || index > len(l.Entries) {
+		return fmt.Errorf("index %d out of range", index)
+	}
+	l.Entries = append(l.Entries, "")
+	copy(l.Entries[index+1:], l.Entries[index:])
+	l.Entries[index] = value
+	return nil
+}
+
+func (l *list) Remove(index int) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if index < 0 || index >= len(l.Entries) {
+		return fmt.Errorf("index %d out of range", index)
+	}
+	l.Entries = append(l.Entries[:index], l.Entries[index+1:]...)
+	return nil
+}
+
+func (l *list) Items() []string {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	items := make([]string, len(l.Entries))
+	copy(items, l.Entries)
+	return items
+}
+
+func (l *list) Write(dir string, name string) error {
+	return l.write(dir, name, nil)
+}
+
+func (l *list) write(dir string, name string, m *manifest) error {
+	l.mux.Lock()
+	stBts, err := yaml.Marshal(l)
+	l.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	return m.writeFile(path.Join(dir, name+".yml"), stBts)
+}
+
+func (l *list) Read(dir string, name string) error {
+	stBts, err := os.ReadFile(path.Join(dir, name+".yml"))
+	if err != nil {
+		return err
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return yaml.Unmarshal(stBts, l)
+}
+
+// BACKEND LIST
+
+// backendList stores each entry under its index.
+type backendList struct {
+	backend Backend
+	path    []string
+}
+
+var _ List = &backendList{}
+
+func (l *backendList) items(tx Tx) ([]string, error) {
+	keys, err := tx.Keys(l.path)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]string, 0, len(keys))
+	for _, key := range keys {
+		v, _, err := tx.Get(l.path, key)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+func (l *backendList) putItems(tx Tx, items []string, from int, prevLen int) error {
+	for i := from; i < len(items); i++ {
+		if err := tx.Put(l.path, seqKey(uint64(i)), items[i]); err != nil {
+			return err
+		}
+	}
+	for i := len(items); i < prevLen; i++ {
+		if err := tx.Delete(l.path, seqKey(uint64(i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *backendList) Len() int {
+	n := 0
+	_ = l.backend.View(func(tx Tx) error {
+		keys, err := tx.Keys(l.path)
+		n = len(keys)
+		return err
+	})
+	return n
+}
+
+func (l *backendList) Get(index int) (string, error) {
+	var value string
+	err := l.backend.View(func(tx Tx) error {
+		v, ok, err := tx.Get(l.path, seqKey(uint64(index)))
+		if err != nil {
+			return err
+		} else if !ok || index < 0 {
+			return fmt.Errorf("index %d out of range", index)
+		}
+		value = v
+		return nil
+	})
+	return value, err
+}
+
+func (l *backendList) Set(index int, value string) error {
+	return l.backend.Update(func(tx Tx) error {
+		if _, ok, err := tx.Get(l.path, seqKey(uint64(index))); err != nil {
+			return err
+		} else if !ok || index < 0 {
+			return fmt.Errorf("index %d out of range", index)
+		}
+		return tx.Put(l.path, seqKey(uint64(index)), value)
+	})
+}
+
+func (l *backendList) Append(values ...string) error {
+	return l.backend.Update(func(tx Tx) error {
+		keys, err := tx.Keys(l.path)
+		if err != nil {
+			return err
+		}
+		for i, v := range values {
+			if err := tx.Put(l.path, seqKey(uint64(len(keys)+i)), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (l *backendList) Insert(index int, value string) error {
+	return l.backend.Update(func(tx Tx) error {
+		items, err := l.items(tx)
+		if err != nil {
+			return err
+		}
+		if index < 0 || index > len(items) {
+			return fmt.Errorf("index %d out of range", index)
+		}
+		prevLen := len(items)
+		items = append(items, "")
+		copy(items[index+1:], items[index:])
+		items[index] = value
+		return l.putItems(tx, items, index, prevLen)
+	})
+}
+
+func (l *backendList) Remove(index int) error {
+	return l.backend.Update(func(tx Tx) error {
+		items, err := l.items(tx)
+		if err != nil {
+			return err
+		}
+		if index < 0 || index >= len(items) {
+			return fmt.Errorf("index %d out of range", index)
+		}
+		prevLen := len(items)
+		items = append(items[:index], items[index+1:]...)
+		return l.putItems(tx, items, index, prevLen)
+	})
+}
+
+func (l *backendList) Items() []string {
+	var items []string
+	_ = l.backend.View(func(tx Tx) error {
+		var err error
+		items, err = l.items(tx)
+		return err
+	})
+	return items
+}
+
+func (l *backendList) Write(dir string, name string) error {
+	return nil
+}
+
+func (l *backendList) Read(dir string, name string) error {
+	return nil
+}
+
+// seqKey returns a key which sorts in the order of n.
+func seqKey(n uint64) string {
+	return fmt.Sprintf("%020d", n)
+}
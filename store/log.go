@@ -0,0 +1,270 @@
+package store
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+)
+
+// LOG
+
+// A LogEntry is an entry of a Log.
+type LogEntry struct {
+	Seq   uint64 `yaml:"seq"`
+	Value string `yaml:"value"`
+}
+
+// A Log is an append-only sequence of entries identified by ascending sequence numbers, starting at 1.
+type Log interface {
+	Structure
+
+	// Append adds an entry and returns its sequence number.
+	Append(value string) (uint64, error)
+
+	// Range returns the entries with sequence numbers in [from, to). If to is 0, all entries from from on are returned.
+	Range(from uint64, to uint64) ([]LogEntry, error)
+
+	// First returns the sequence number of the first entry which has not been truncated.
+	First() uint64
+
+	// Next returns the sequence number the next appended entry will get.
+	Next() uint64
+
+	// Truncate removes all entries with sequence numbers before before.
+	Truncate(before uint64) error
+}
+
+type appendLog struct {
+	Start   uint64     `yaml:"first"`
+	Entries []LogEntry `yaml:"entries"`
+
+	mux *sync.Mutex
+}
+
+var _ Log = &appendLog{}
+
+func newLog() Log {
+	return &appendLog{
+		Start:   1,
+		Entries: []LogEntry{},
+
+		mux: &sync.Mutex{},
+	}
+}
+
+func (l *appendLog) next() uint64 {
+	return l.Start + uint64(len(l.Entries))
+}
+
+func (l *appendLog) Append(value string) (uint64, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	seq := l.next()
+	l.Entries = append(l.Entries, LogEntry{Seq: seq, Value: value})
+	return seq, nil
+}
+
+func (l *appendLog) Range(from uint64, to uint64) ([]LogEntry, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if from < l.Start {
+		from = l.Start
+	}
+	if to == 0 || to > l.next() {
+		to = l.next()
+	}
+	if from >= to {
+		return []LogEntry{}, nil
+	}
+	entries := make([]LogEntry, to-from)
+	copy(entries, l.Entries[from-l.Start:to-l.Start])
+	return entries, nil
+}
+
+func (l *appendLog) First() uint64 {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.Start
+}
+
+func (l *appendLog) Next() uint64 {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.next()
+}
+
+func (l *appendLog) Truncate(before uint64) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if before <= l.Start {
+		return nil
+	}
+	if before > l.next() {
+		before = l.next()
+	}
+	l.Entries = append([]LogEntry{}, l.Entries[before-l.Start:]...)
+	l.Start = before
+	return nil
+}
+
+func (l *appendLog) Write(dir string, name string) error {
+	return l.write(dir, name, nil)
+}
+
+func (l *appendLog) write(dir string, name string, m *manifest) error {
+	l.mux.Lock()
+	stBts, err := yaml.Marshal(l)
+	l.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	return m.writeFile(path.Join(dir, name+".yml"), stBts)
+}
+
+func (l *appendLog) Read(dir string, name string) error {
+	stBts, err := os.ReadFile(path.Join(dir, name+".yml"))
+	if err != nil {
+		return err
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if err := yaml.Unmarshal(stBts, l); err != nil {
+		return err
+	}
+	for i, e := range l.Entries {
+		if e.Seq != l.Start+uint64(i) {
+			return fmt.Errorf("log %s: unexpected sequence number %d", name, e.Seq)
+		}
+	}
+	return nil
+}
+
+// BACKEND LOG
+
+// backendLog stores entries in the bucket entries under their sequence numbers and the first sequence number in the key
+// first.
+type backendLog struct {
+	backend Backend
+	path    []string
+}
+
+var _ Log = &backendLog{}
+
+func (l *backendLog) entriesPath() []string {
+	return childPath(l.path, "entries")
+}
+
+func (l *backendLog) bounds(tx Tx) (uint64, uint64, error) {
+	first := uint64(1)
+	if v, ok, err := tx.Get(l.path, "first"); err != nil {
+		return 0, 0, err
+	} else if ok {
+		first, _ = strconv.ParseUint(v, 10, 64)
+	}
+	next := first
+	if v, ok, err := tx.Get(l.path, "next"); err != nil {
+		return 0, 0, err
+	} else if ok {
+		next, _ = strconv.ParseUint(v, 10, 64)
+	}
+	return first, next, nil
+}
+
+func (l *backendLog) Append(value string) (uint64, error) {
+	var seq uint64
+	err := l.backend.Update(func(tx Tx) error {
+		_, next, err := l.bounds(tx)
+		if err != nil {
+			return err
+		}
+		if err := tx.CreateBucket(l.entriesPath()); err != nil {
+			return err
+		}
+		if err := tx.Put(l.entriesPath(), seqKey(next), value); err != nil {
+			return err
+		}
+		seq = next
+		return tx.Put(l.path, "next", strconv.FormatUint(next+1, 10))
+	})
+	return seq, err
+}
+
+func (l *backendLog) Range(from uint64, to uint64) ([]LogEntry, error) {
+	entries := []LogEntry{}
+	err := l.backend.View(func(tx Tx) error {
+		first, next, err := l.bounds(tx)
+		if err != nil {
+			return err
+		}
+		if from < first {
+			from = first
+		}
+		if to == 0 || to > next {
+			to = next
+		}
+		for seq := from; seq < to; seq++ {
+			v, _, err := tx.Get(l.entriesPath(), seqKey(seq))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, LogEntry{Seq: seq, Value: v})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (l *backendLog) First() uint64 {
+	var first uint64
+	_ = l.backend.View(func(tx Tx) error {
+		var err error
+		first, _, err = l.bounds(tx)
+		return err
+	})
+	return first
+}
+
+func (l *backendLog) Next() uint64 {
+	var next uint64
+	_ = l.backend.View(func(tx Tx) error {
+		var err error
+		_, next, err = l.bounds(tx)
+		return err
+	})
+	return next
+}
+
+func (l *backendLog) Truncate(before uint64) error {
+	return l.backend.Update(func(tx Tx) error {
+		first, next, err := l.bounds(tx)
+		if err != nil {
+			return err
+		}
+		if before <= first {
+			return nil
+		}
+		if before > next {
+			before = next
+		}
+		for seq := first; seq < before; seq++ {
+			if err := tx.Delete(l.entriesPath(), seqKey(seq)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Put(l.path, "first", strconv.FormatUint(before, 10)); err != nil {
+			return err
+		}
+		return tx.Put(l.path, "next", strconv.FormatUint(next, 10))
+	})
+}
+
+func (l *backendLog) Write(dir string, name string) error {
+	return nil
+}
+
+func (l *backendLog) Read(dir string, name string) error {
+	return nil
+}
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// memoryBucket is a bucket of a memoryBackend.
+type memoryBucket struct {
+	keys    map[string]string
+	buckets map[string]*memoryBucket
+}
+
+func newMemoryBucket() *memoryBucket {
+	return &memoryBucket{
+		keys:    map[string]string{},
+		buckets: map[string]*memoryBucket{},
+	}
+}
+
+func (b *memoryBucket) copy() *memoryBucket {
+	cp := newMemoryBucket()
+	for k, v := range b.keys {
+		cp.keys[k] = v
+	}
+	for k, v := range b.buckets {
+		cp.buckets[k] = v.copy()
+	}
+	return cp
+}
+
+// memoryBackend keeps all contents in memory. Updates operate on a copy which replaces the contents on success.
+type memoryBackend struct {
+	root *memoryBucket
+	mux  sync.RWMutex
+}
+
+var _ Backend = &memoryBackend{}
+
+// NewMemoryBackend creates a backend which keeps all contents in memory.
+func NewMemoryBackend() Backend {
+	return &memoryBackend{
+		root: newMemoryBucket(),
+	}
+}
+
+func (m *memoryBackend) Update(fn func(tx Tx) error) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	tx := &memoryTx{root: m.root.copy(), writable: true}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	m.root = tx.root
+	return nil
+}
+
+func (m *memoryBackend) View(fn func(tx Tx) error) error {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return fn(&memoryTx{root: m.root})
+}
+
+func (m *memoryBackend) Close() error {
+	return nil
+}
+
+type memoryTx struct {
+	root     *memoryBucket
+	writable bool
+}
+
+var _ Tx = &memoryTx{}
+
+func (t *memoryTx) bucket(path []string) (*memoryBucket, error) {
+	b := t.root
+	for _, name := range path {
+		child, ok := b.buckets[name]
+		if !ok {
+			return nil, fmt.Errorf("bucket %s does not exist", name)
+		}
+		b = child
+	}
+	return b, nil
+}
+
+func (t *memoryTx) Get(bucket []string, key string) (string, bool, error) {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return "", false, err
+	}
+	v, ok := b.keys[key]
+	return v, ok, nil
+}
+
+func (t *memoryTx) Put(bucket []string, key string, value string) error {
+	if !t.writable {
+		return fmt.Errorf("transaction is read-only")
+	}
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	if _, ok := b.buckets[key]; ok {
+		return fmt.Errorf("%s is a bucket", key)
+	}
+	b.keys[key] = value
+	return nil
+}
+
+func (t *memoryTx) Delete(bucket []string, key string) error {
+	if !t.writable {
+		return fmt.Errorf("transaction is read-only")
+	}
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	delete(b.keys, key)
+	return nil
+}
+
+func (t *memoryTx) Keys(bucket []string) ([]string, error) {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for k := range b.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (t *memoryTx) Buckets(bucket []string) ([]string, error) {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for k := range b.buckets {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (t *memoryTx) CreateBucket(bucket []string) error {
+	if !t.writable {
+		return fmt.Errorf("transaction is read-only")
+	}
+	b := t.root
+	for _, name := range bucket {
+		child, ok := b.buckets[name]
+		if !ok {
+			if _, ok := b.keys[name]; ok {
+				return fmt.Errorf("%s is a key", name)
+			}
+			child = newMemoryBucket()
+			b.buckets[name] = child
+		}
+		b = child
+	}
+	return nil
+}
+
+func (t *memoryTx) DeleteBucket(bucket []string) error {
+	if !t.writable {
+		return fmt.Errorf("transaction is read-only")
+	}
+	if len(bucket) == 0 {
+		return fmt.Errorf("cannot delete root bucket")
+	}
+	b, err := t.bucket(bucket[:len(bucket)-1])
+	if err != nil {
+		return err
+	}
+	name := bucket[len(bucket)-1]
+	if _, ok := b.buckets[name]; !ok {
+		return fmt.Errorf("bucket %s does not exist", name)
+	}
+	delete(b.buckets, name)
+	return nil
+}
+
+func (t *memoryTx) HasBucket(bucket []string) bool {
+	_, err := t.bucket(bucket)
+	return err == nil
+}
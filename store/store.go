@@ -6,12 +6,17 @@ import (
 	"gopkg.in/yaml.v3"
 	"os"
 	"path"
+	"sort"
 	"sync"
 )
 
 const (
 	DSKeyValue = "keyvalue"
 	DSStores   = "stores"
+	DSList     = "list"
+	DSLog      = "log"
+	DSCounter  = "counter"
+	DSBlob     = "blob"
 )
 
 type DataStructure struct {
@@ -34,6 +39,34 @@ func (ds *DataStructure) Stores() Stores {
 	return ds.Structure.(Stores)
 }
 
+func (ds *DataStructure) List() List {
+	if ds.Type != DSList {
+		panic("not a list structure")
+	}
+	return ds.Structure.(List)
+}
+
+func (ds *DataStructure) Log() Log {
+	if ds.Type != DSLog {
+		panic("not a log structure")
+	}
+	return ds.Structure.(Log)
+}
+
+func (ds *DataStructure) Counter() Counter {
+	if ds.Type != DSCounter {
+		panic("not a counter structure")
+	}
+	return ds.Structure.(Counter)
+}
+
+func (ds *DataStructure) Blob() Blob {
+	if ds.Type != DSBlob {
+		panic("not a blob structure")
+	}
+	return ds.Structure.(Blob)
+}
+
 func (ds *DataStructure) Write(dir string) error {
 	return ds.Structure.Write(dir, ds.Name)
 }
@@ -52,6 +85,9 @@ type Store interface {
 	Ensure(name string, dsType string) (*DataStructure, error)
 	Delete(name string) error
 
+	// DataStructures returns all data structures of the store.
+	DataStructures() []*DataStructure
+
 	Write(path string) error
 	Read(path string) error
 }
@@ -81,9 +117,6 @@ func (s *store) Create(name string, dsType string) (*DataStructure, error) {
 		return nil, fmt.Errorf("data structure name must only contain alphanumeric characters")
 	}
 
-	if _, ok := s.dataStructures[name]; ok {
-		return nil, fmt.Errorf("data structure %s already exists", name)
-	}
 	ds := &DataStructure{
 		Name: name,
 		Type: dsType,
@@ -93,10 +126,23 @@ func (s *store) Create(name string, dsType string) (*DataStructure, error) {
 		ds.Structure = newKeyValue()
 	case DSStores:
 		ds.Structure = newStores()
+	case DSList:
+		ds.Structure = newList()
+	case DSLog:
+		ds.Structure = newLog()
+	case DSCounter:
+		ds.Structure = newCounter()
+	case DSBlob:
+		ds.Structure = newBlob()
+	default:
+		return nil, fmt.Errorf("unknown data structure type: %s", dsType)
 	}
 	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.dataStructures[name]; ok {
+		return nil, fmt.Errorf("data structure %s already exists", name)
+	}
 	s.dataStructures[name] = ds
-	s.mux.Unlock()
 	return ds, nil
 }
 
@@ -132,21 +178,51 @@ func (s *store) Delete(name string) error {
 	}
 }
 
+func (s *store) DataStructures() []*DataStructure {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	dss := []*DataStructure{}
+	for _, ds := range s.dataStructures {
+		dss = append(dss, ds)
+	}
+	return dss
+}
+
 // storeStruct used for writing and reading
 type storeStruct struct {
 	DataStructures map[string]string `yaml:"dataStructures"`
 }
 
+// Write writes the store tree to dir. Each file is replaced atomically and a manifest is written last, so that
+// Read detects a tree which has only been written partially. The files of the previous generation are kept until
+// the manifest has been written.
 func (s *store) Write(dir string) error {
+	if err := os.MkdirAll(path.Join(dir, s.name), os.ModePerm); err != nil {
+		return err
+	}
+	m, err := newManifest(path.Join(dir, s.name))
+	if err != nil {
+		return err
+	}
+	if err := s.write(dir, m); err != nil {
+		return err
+	}
+	return m.save()
+}
+
+func (s *store) write(dir string, m *manifest) error {
 	dir = path.Join(dir, s.name)
 
-	_ = os.MkdirAll(dir, os.ModePerm)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
 
 	ss := storeStruct{
 		DataStructures: map[string]string{},
 	}
 
-	for _, v := range s.dataStructures {
+	dss := s.DataStructures()
+	for _, v := range dss {
 		ss.DataStructures[v.Name] = v.Type
 	}
 
@@ -155,12 +231,16 @@ func (s *store) Write(dir string) error {
 		return err
 	}
 
-	if err := os.WriteFile(path.Join(dir, "_store.yml"), stBts, os.ModePerm); err != nil {
+	if err := m.writeFile(path.Join(dir, "_store.yml"), stBts); err != nil {
 		return err
 	}
 
-	for _, v := range s.dataStructures {
-		if err := v.Write(dir); err != nil {
+	for _, v := range dss {
+		if ms, ok := v.Structure.(manifestStructure); ok {
+			if err := ms.write(dir, v.Name, m); err != nil {
+				return err
+			}
+		} else if err := v.Write(dir); err != nil {
 			return err
 		}
 	}
@@ -168,7 +248,25 @@ func (s *store) Write(dir string) error {
 	return nil
 }
 
+// Read reads the store tree from dir. If writing the tree has been interrupted, the previous generation is restored
+// and read. Returns ErrIncomplete if the tree is incomplete and cannot be restored.
 func (s *store) Read(dir string) error {
+	m, err := readManifest(path.Join(dir, s.name))
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		if err := m.restore(); err != nil {
+			return err
+		}
+		if err := m.verify(); err != nil {
+			return err
+		}
+	}
+	return s.read(dir)
+}
+
+func (s *store) read(dir string) error {
 	dir = path.Join(dir, s.name)
 
 	ss := storeStruct{}
@@ -199,6 +297,11 @@ type Structure interface {
 	Read(dir string, name string) error
 }
 
+// manifestStructure is a Structure which records the files it writes in a manifest.
+type manifestStructure interface {
+	write(dir string, name string, m *manifest) error
+}
+
 // KEY VALUE
 
 type KeyValue interface {
@@ -253,15 +356,27 @@ func (k *keyValue) EnumerateKeys() chan string {
 }
 
 func (k *keyValue) Write(dir string, name string) error {
-	stBts, _ := yaml.Marshal(k)
-	_ = os.WriteFile(path.Join(dir, name+".yml"), stBts, os.ModePerm)
-	return nil
+	return k.write(dir, name, nil)
+}
+
+func (k *keyValue) write(dir string, name string, m *manifest) error {
+	k.mux.Lock()
+	stBts, err := yaml.Marshal(k)
+	k.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	return m.writeFile(path.Join(dir, name+".yml"), stBts)
 }
 
 func (k *keyValue) Read(dir string, name string) error {
-	stBts, _ := os.ReadFile(path.Join(dir, name+".yml"))
-	_ = yaml.Unmarshal(stBts, k)
-	return nil
+	stBts, err := os.ReadFile(path.Join(dir, name+".yml"))
+	if err != nil {
+		return err
+	}
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	return yaml.Unmarshal(stBts, k)
 }
 
 // STORES
@@ -270,6 +385,10 @@ type Stores interface {
 	Structure
 	Get(name string) (Store, error)
 	Add(value Store) error
+
+	// Remove removes the store name.
+	Remove(name string) error
+
 	Enumerate() chan Store
 }
 
@@ -306,6 +425,16 @@ func (k *stores) Add(value Store) error {
 	return nil
 }
 
+func (k *stores) Remove(name string) error {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if _, ok := k.Entries[name]; !ok {
+		return fmt.Errorf("entry does not exist")
+	}
+	delete(k.Entries, name)
+	return nil
+}
+
 func (k *stores) Enumerate() chan Store {
 	c := make(chan Store)
 	go func() {
@@ -318,28 +447,58 @@ func (k *stores) Enumerate() chan Store {
 }
 
 func (k *stores) Write(dir string, name string) error {
-	sts := []string{}
+	return k.write(dir, name, nil)
+}
 
+func (k *stores) write(dir string, name string, m *manifest) error {
+	if err := os.MkdirAll(path.Join(dir, name), os.ModePerm); err != nil {
+		return err
+	}
+
+	k.mux.Lock()
+	entries := map[string]Store{}
 	for n, st := range k.Entries {
+		entries[n] = st
+	}
+	k.mux.Unlock()
+
+	sts := []string{}
+
+	for n, st := range entries {
 		sts = append(sts, n)
-		if err := st.Write(path.Join(dir, name)); err != nil {
+		if fst, ok := st.(*store); ok {
+			if err := fst.write(path.Join(dir, name), m); err != nil {
+				return err
+			}
+		} else if err := st.Write(path.Join(dir, name)); err != nil {
 			return err
 		}
 	}
+	sort.Strings(sts)
 
-	stBts, _ := yaml.Marshal(sts)
-	_ = os.WriteFile(path.Join(dir, name, "_stores.yml"), stBts, os.ModePerm)
-	return nil
+	stBts, err := yaml.Marshal(sts)
+	if err != nil {
+		return err
+	}
+	return m.writeFile(path.Join(dir, name, "_stores.yml"), stBts)
 }
 
 func (k *stores) Read(dir string, name string) error {
 	sts := []string{}
-	stBts, _ := os.ReadFile(path.Join(dir, name, "_stores.yml"))
-	_ = yaml.Unmarshal(stBts, &sts)
+	stBts, err := os.ReadFile(path.Join(dir, name, "_stores.yml"))
+	if os.IsNotExist(err) {
+		// Stores without entries have not been written by earlier versions.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(stBts, &sts); err != nil {
+		return err
+	}
 
 	for _, n := range sts {
-		st := NewStore(n)
-		if err := st.Read(path.Join(dir, name)); err != nil {
+		st := NewStore(n).(*store)
+		if err := st.read(path.Join(dir, name)); err != nil {
 			return err
 		}
 		_ = k.Add(st)
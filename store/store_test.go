@@ -1,7 +1,11 @@
 package store
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path"
+	"strings"
 	"testing"
 )
 
@@ -22,7 +26,7 @@ func TestKeyValue1(t *testing.T) {
 }
 
 func TestKeyValue2(t *testing.T) {
-	_ = os.MkdirAll("./test", os.ModePerm)
+	dir := t.TempDir()
 
 	kv1 := newKeyValue()
 
@@ -30,13 +34,13 @@ func TestKeyValue2(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := kv1.Write("./test", "TestKeyValue1"); err != nil {
+	if err := kv1.Write(dir, "TestKeyValue1"); err != nil {
 		t.Fatal(err)
 	}
 
 	kv2 := newKeyValue()
 
-	if err := kv2.Read("./test", "TestKeyValue1"); err != nil {
+	if err := kv2.Read(dir, "TestKeyValue1"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -106,6 +110,7 @@ func TestKeyStore1(t *testing.T) {
 }
 
 func TestKeyStore2(t *testing.T) {
+	dir := t.TempDir()
 	st1 := NewStore("test2")
 
 	kv1, err := st1.Create("kv1", DSKeyValue)
@@ -119,13 +124,13 @@ func TestKeyStore2(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := st1.Write("./test"); err != nil {
+	if err := st1.Write(dir); err != nil {
 		t.Fatal(err)
 	}
 
 	st2 := NewStore("test2")
 
-	if err := st2.Read("./test"); err != nil {
+	if err := st2.Read(dir); err != nil {
 		t.Fatal(err)
 	}
 
@@ -149,6 +154,7 @@ func TestKeyStore2(t *testing.T) {
 }
 
 func TestKeyStore3(t *testing.T) {
+	dir := t.TempDir()
 	st1 := NewStore("test3")
 
 	kv1, err := st1.Create("kv1", DSStores)
@@ -162,13 +168,13 @@ func TestKeyStore3(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := st1.Write("./test"); err != nil {
+	if err := st1.Write(dir); err != nil {
 		t.Fatal(err)
 	}
 
 	st2 := NewStore("test3")
 
-	if err := st2.Read("./test"); err != nil {
+	if err := st2.Read(dir); err != nil {
 		t.Fatal(err)
 	}
 
@@ -190,3 +196,271 @@ func TestKeyStore3(t *testing.T) {
 		t.Fatal(v)
 	}
 }
+
+func TestKeyStore__Legacy(t *testing.T) {
+	// Trees written before manifests were introduced are read without verification.
+	st := NewStore("test3")
+	if err := st.Read("./test"); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := st.Get("kv1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Stores().Get("a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStoreTree(gen string) Store {
+	st := NewStore("tree")
+	kvDS, _ := st.Create("kv1", DSKeyValue)
+	_ = kvDS.KeyValue().Set("gen", gen)
+	stsDS, _ := st.Create("sts1", DSStores)
+	for _, name := range []string{"a", "b"} {
+		child := NewStore(name)
+		childDS, _ := child.Create("kv2", DSKeyValue)
+		_ = childDS.KeyValue().Set("gen", gen)
+		_ = stsDS.Stores().Add(child)
+	}
+	return st
+}
+
+func TestKeyStore__PartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	if err := testStoreTree("1").Write(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash right after the file of store a of the second generation has replaced the file of the first generation.
+	crashFile := path.Join(dir, "tree", "sts1", "a", "kv2.yml")
+	afterRename = func(file string) error {
+		if file == crashFile {
+			return fmt.Errorf("crash")
+		}
+		return nil
+	}
+	err := testStoreTree("2").Write(dir)
+	afterRename = nil
+	if err == nil || err.Error() != "crash" {
+		t.Fatal(err)
+	}
+	if bts, _ := os.ReadFile(crashFile); !strings.Contains(string(bts), "\"2\"") {
+		t.Fatal(string(bts))
+	}
+
+	// The first generation is still read.
+	st := NewStore("tree")
+	if err := st.Read(dir); err != nil {
+		t.Fatal(err)
+	}
+	kvDS, _ := st.Get("kv1")
+	if v, _ := kvDS.KeyValue().Get("gen"); v != "1" {
+		t.Fatal(v)
+	}
+	stsDS, _ := st.Get("sts1")
+	for _, name := range []string{"a", "b"} {
+		child, _ := stsDS.Stores().Get(name)
+		childDS, _ := child.Get("kv2")
+		if v, _ := childDS.KeyValue().Get("gen"); v != "1" {
+			t.Fatal(name, v)
+		}
+	}
+
+	// Neither temporary files nor backups are left behind.
+	for _, d := range []string{path.Join(dir, "tree"), path.Join(dir, "tree", "sts1", "a")} {
+		entries, _ := os.ReadDir(d)
+		for _, e := range entries {
+			if e.Name()[0] == '.' {
+				t.Fatal(e.Name())
+			}
+		}
+	}
+
+	// Writing the next generation completely replaces the first one.
+	if err := testStoreTree("3").Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	st = NewStore("tree")
+	if err := st.Read(dir); err != nil {
+		t.Fatal(err)
+	}
+	kvDS, _ = st.Get("kv1")
+	if v, _ := kvDS.KeyValue().Get("gen"); v != "3" {
+		t.Fatal(v)
+	}
+	// The crashed generation has never been completed.
+	m, _ := readManifest(path.Join(dir, "tree"))
+	if m.Generation != 2 {
+		t.Fatal(m.Generation)
+	}
+	entries, _ := os.ReadDir(path.Join(dir, "tree", "sts1", "a"))
+	for _, e := range entries {
+		if e.Name()[0] == '.' {
+			t.Fatal(e.Name())
+		}
+	}
+}
+
+func TestKeyStore__TruncatedFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := testStoreTree("1").Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(dir, "tree", "sts1", "a", "kv2.yml")
+	if err := os.WriteFile(file, []byte("entries:\n  ge"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewStore("tree").Read(dir); !errors.Is(err, ErrIncomplete) {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyStore__WriteError(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "file")
+	_ = os.WriteFile(file, nil, 0644)
+	if err := testStoreTree("1").Write(file); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func fillStructures(t *testing.T, st Store) {
+	listDS, err := st.Create("list1", DSList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := listDS.List()
+	if err := l.Append("a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Insert(1, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Insert(4, "x"); err == nil {
+		t.Fatal("must not insert out of range")
+	}
+	if err := l.Append("d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Remove(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Set(0, "A"); err != nil {
+		t.Fatal(err)
+	}
+
+	logDS, err := st.Create("log1", DSLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg := logDS.Log()
+	for _, v := range []string{"a", "b", "c", "d"} {
+		if _, err := lg.Append(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lg.Truncate(2); err != nil {
+		t.Fatal(err)
+	}
+
+	counterDS, err := st.Create("counter1", DSCounter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := counterDS.Counter().Add(5); err != nil || v != 5 {
+		t.Fatal(v, err)
+	}
+	if v, err := counterDS.Counter().Add(-2); err != nil || v != 3 {
+		t.Fatal(v, err)
+	}
+
+	blobDS, err := st.Create("blob1", DSBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobDS.Blob().Set([]byte{0, 1, 2, 255}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.Create("unknown1", "unknown"); err == nil {
+		t.Fatal("must not create unknown data structure")
+	}
+}
+
+func checkStructures(t *testing.T, st Store) {
+	listDS, err := st.Get("list1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := listDS.List().Items(); fmt.Sprint(items) != "[A b c]" {
+		t.Fatal(items)
+	}
+	if v, err := listDS.List().Get(1); err != nil || v != "b" {
+		t.Fatal(v, err)
+	}
+	if _, err := listDS.List().Get(3); err == nil {
+		t.Fatal("must not get out of range")
+	}
+
+	logDS, err := st.Get("log1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg := logDS.Log()
+	if lg.First() != 2 || lg.Next() != 5 {
+		t.Fatal(lg.First(), lg.Next())
+	}
+	entries, err := lg.Range(0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(entries) != "[{2 b} {3 c}]" {
+		t.Fatal(entries)
+	}
+	if seq, err := lg.Append("e"); err != nil || seq != 5 {
+		t.Fatal(seq, err)
+	}
+
+	counterDS, err := st.Get("counter1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := counterDS.Counter().Get(); v != 3 {
+		t.Fatal(v)
+	}
+
+	blobDS, err := st.Get("blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := blobDS.Blob().Get(); err != nil || fmt.Sprint(data) != "[0 1 2 255]" {
+		t.Fatal(data, err)
+	}
+	if blobDS.Blob().Size() != 4 {
+		t.Fatal(blobDS.Blob().Size())
+	}
+}
+
+func TestKeyStore__Structures(t *testing.T) {
+	dir := t.TempDir()
+
+	st1 := NewStore("test")
+	fillStructures(t, st1)
+	checkStructures(t, st1)
+
+	st1 = NewStore("test")
+	fillStructures(t, st1)
+	if err := st1.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(dir, "test", "blob1.bin")); err != nil {
+		t.Fatal("blob must be stored in a file of its own", err)
+	}
+
+	st2 := NewStore("test")
+	if err := st2.Read(dir); err != nil {
+		t.Fatal(err)
+	}
+	checkStructures(t, st2)
+}